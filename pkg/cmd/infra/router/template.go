@@ -3,12 +3,16 @@ package router
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -21,6 +25,7 @@ import (
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apiserver/pkg/apis/apiserver"
 	"k8s.io/apiserver/pkg/authentication/authenticatorfactory"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
@@ -29,6 +34,7 @@ import (
 	authoptions "k8s.io/apiserver/pkg/server/options"
 	authenticationclient "k8s.io/client-go/kubernetes/typed/authentication/v1"
 	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	"k8s.io/client-go/util/cert"
 
 	configv1 "github.com/openshift/api/config/v1"
 	routev1 "github.com/openshift/api/route/v1"
@@ -40,17 +46,27 @@ import (
 	"github.com/openshift/library-go/pkg/route/secretmanager"
 
 	"github.com/openshift/router/pkg/router"
+	"github.com/openshift/router/pkg/router/accesslog"
+	"github.com/openshift/router/pkg/router/acme"
 	"github.com/openshift/router/pkg/router/client"
 	"github.com/openshift/router/pkg/router/controller"
+	"github.com/openshift/router/pkg/router/defaultcert"
 	"github.com/openshift/router/pkg/router/metrics"
 	"github.com/openshift/router/pkg/router/metrics/haproxy"
+	"github.com/openshift/router/pkg/router/routeapihelpers"
 	"github.com/openshift/router/pkg/router/shutdown"
 	templateplugin "github.com/openshift/router/pkg/router/template"
 	haproxyconfigmanager "github.com/openshift/router/pkg/router/template/configmanager/haproxy"
+	"github.com/openshift/router/pkg/router/ticketkeys"
+	"github.com/openshift/router/pkg/router/tracing"
 	"github.com/openshift/router/pkg/router/writerlease"
 	"github.com/openshift/router/pkg/version"
 )
 
+// defaultACMERenewBefore is how long (in seconds) before its expiry an ACME
+// certificate is renewed.
+const defaultACMERenewBefore = 30 * 24 * 60 * 60
+
 // defaultReloadInterval is how often to do reloads in seconds.
 const defaultReloadInterval = 5
 
@@ -58,6 +74,14 @@ const defaultReloadInterval = 5
 // router changes made using the dynamic configuration manager.
 const defaultCommitInterval = 60 * 60
 
+// defaultReconcileInterval is how often (in seconds) to verify the changes
+// made using the dynamic configuration manager against the router state.
+const defaultReconcileInterval = 5 * 60
+
+// defaultCertificateExpiryWarningWindow is how long (in seconds) before the
+// expiry of a certificate the route status warns about it.
+const defaultCertificateExpiryWarningWindow = 30 * 24 * 60 * 60
+
 var routerLong = heredoc.Doc(`
 	Start a router
 
@@ -115,7 +139,22 @@ type TemplateRouter struct {
 	DefaultCertificate                  string
 	DefaultCertificatePath              string
 	DefaultCertificateDir               string
+	DefaultCertificatesPathString       string
+	DefaultCertificates                 []defaultcert.Certificate
+	CertificateChainIntermediatesPath   string
+	CertificateChainIntermediates       []*x509.Certificate
+	CertificateChainWarnings            bool
 	DefaultDestinationCAPath            string
+	CertificateExpiryWarningWindow      time.Duration
+	ACMEIssuersString                   string
+	ACMEIssuers                         map[string]string
+	ACMEEmail                           string
+	ACMEAccountKeyPath                  string
+	ACMECABundlePath                    string
+	ACMEChallengeAddress                string
+	ACMERenewBefore                     time.Duration
+	TLSTicketKeySeedPath                string
+	TLSTicketKeyRotationInterval        time.Duration
 	BindPortsAfterSync                  bool
 	MaxConnections                      string
 	Ciphers                             string
@@ -139,7 +178,9 @@ type TemplateRouter struct {
 
 type TemplateRouterConfigManager struct {
 	UseHAProxyConfigManager     bool
+	UseHAProxyDynamicEndpoints  bool
 	CommitInterval              time.Duration
+	ReconcileInterval           time.Duration
 	BlueprintRouteNamespace     string
 	BlueprintRouteLabelSelector string
 	BlueprintRoutePoolSize      int
@@ -169,7 +210,19 @@ func (o *TemplateRouter) Bind(flag *pflag.FlagSet) {
 	flag.StringVar(&o.DefaultCertificate, "default-certificate", env("DEFAULT_CERTIFICATE", ""), "The contents of a default certificate to use for routes that don't expose a TLS server cert; in PEM format")
 	flag.StringVar(&o.DefaultCertificatePath, "default-certificate-path", env("DEFAULT_CERTIFICATE_PATH", ""), "A path to default certificate to use for routes that don't expose a TLS server cert; in PEM format")
 	flag.StringVar(&o.DefaultCertificateDir, "default-certificate-dir", env("DEFAULT_CERTIFICATE_DIR", ""), "A path to a directory that contains a file named tls.crt. If tls.crt is not a PEM file which also contains a private key, it is first combined with a file named tls.key in the same directory. The PEM-format contents are then used as the default certificate. Only used if default-certificate and default-certificate-path are not specified.")
+	flag.StringVar(&o.DefaultCertificatesPathString, "default-certificates-path", env("DEFAULT_CERTIFICATES_PATH", ""), "A comma-separated list of PEM files or directories of PEM files, each holding a certificate, its key and its chain. The edge and reencrypt routes that don't expose a TLS server cert are served the first of these certificates whose DNS names match their host, an exact name being preferred over a wildcard. The routes that match none of them keep the default certificate and get a DefaultCertificateMismatch condition.")
+	flag.StringVar(&o.CertificateChainIntermediatesPath, "certificate-chain-intermediates-path", env("ROUTER_CERTIFICATE_CHAIN_INTERMEDIATES_PATH", ""), "A path to a PEM file of intermediate CA certificates. The certificate chains of the edge and reencrypt routes which lack one of these intermediates are completed with it. The self-signed certificates of the file are never served: they are trusted roots, in addition to the system ones.")
+	flag.BoolVar(&o.CertificateChainWarnings, "certificate-chain-warnings", isTrue(env("ROUTER_CERTIFICATE_CHAIN_WARNINGS", "")), "Add a CertificateWarning condition to the status of the edge and reencrypt routes whose certificate chain is incomplete, was completed, or whose certificate is not valid for their host. The chains are verified against the system roots and the certificate chain intermediates.")
 	flag.StringVar(&o.DefaultDestinationCAPath, "default-destination-ca-path", env("DEFAULT_DESTINATION_CA_PATH", ""), "A path to a PEM file containing the default CA bundle to use with re-encrypt routes. This CA should sign for certificates in the Kubernetes DNS space (service.namespace.svc).")
+	flag.DurationVar(&o.CertificateExpiryWarningWindow, "certificate-expiry-warning-window", getIntervalFromEnv("ROUTER_CERTIFICATE_EXPIRY_WARNING_WINDOW", defaultCertificateExpiryWarningWindow), "Controls how long before the expiry of a certificate served for a route a CertificateExpiring condition is added to the route status. Expired certificates are always reported.")
+	flag.StringVar(&o.ACMEIssuersString, "acme-issuers", env("ROUTER_ACME_ISSUERS", ""), "A comma-separated list of name=directoryURL ACME issuers. The edge and reencrypt routes annotated with "+controller.ACMEIssuerAnnotation+"=<name> get a certificate from the issuer over the HTTP-01 challenge, stored in the secret referenced by spec.tls.externalCertificate and renewed before it expires. Requires allow-external-certificates.")
+	flag.StringVar(&o.ACMEEmail, "acme-email", env("ROUTER_ACME_EMAIL", ""), "The contact email of the ACME account.")
+	flag.StringVar(&o.ACMEAccountKeyPath, "acme-account-key-path", env("ROUTER_ACME_ACCOUNT_KEY_PATH", ""), "A path to the PEM encoded P-256 key of the ACME account, required with acme-issuers. The replicas of the router share the account key to answer the HTTP-01 challenges of each other.")
+	flag.StringVar(&o.ACMECABundlePath, "acme-ca-bundle-path", env("ROUTER_ACME_CA_BUNDLE_PATH", ""), "A path to a PEM file of CA certificates trusted, in addition to the system ones, for the connections to the ACME servers.")
+	flag.StringVar(&o.ACMEChallengeAddress, "acme-challenge-address", env("ROUTER_ACME_CHALLENGE_ADDRESS", "127.0.0.1:10089"), "The local address the router serves the ACME HTTP-01 challenges on, which haproxy forwards the requests for /.well-known/acme-challenge/ to.")
+	flag.DurationVar(&o.ACMERenewBefore, "acme-renew-before", getIntervalFromEnv("ROUTER_ACME_RENEW_BEFORE", defaultACMERenewBefore), "Controls how long before its expiry an ACME certificate is renewed.")
+	flag.StringVar(&o.TLSTicketKeySeedPath, "tls-ticket-key-seed-path", env("ROUTER_TLS_TICKET_KEY_SEED_PATH", ""), "A path to a file of at least 32 random bytes, usually mounted from a Secret shared by the router replicas, which the TLS session ticket keys are derived from. The replicas then resume the TLS sessions of each other, and the keys are rotated without a reload. If empty, each haproxy process generates its own keys.")
+	flag.DurationVar(&o.TLSTicketKeyRotationInterval, "tls-ticket-key-rotation-interval", getIntervalFromEnv("ROUTER_TLS_TICKET_KEY_ROTATION_INTERVAL", int(ticketkeys.DefaultRotationInterval/time.Second)), "Controls how often the TLS session ticket keys derived from tls-ticket-key-seed-path are rotated. Must be the same on all the router replicas.")
 	flag.StringVar(&o.TemplateFile, "template", env("TEMPLATE_FILE", ""), "The path to the template file to use")
 	flag.StringVar(&o.ReloadScript, "reload", env("RELOAD_SCRIPT", ""), "The path to the reload script to use")
 	flag.DurationVar(&o.ReloadInterval, "interval", getIntervalFromEnv("RELOAD_INTERVAL", defaultReloadInterval), "Controls how often router reloads are invoked. Mutiple router reload requests are coalesced for the duration of this interval since the last reload time.")
@@ -179,7 +232,9 @@ func (o *TemplateRouter) Bind(flag *pflag.FlagSet) {
 	flag.BoolVar(&o.StrictSNI, "strict-sni", isTrue(env("ROUTER_STRICT_SNI", "")), "Use strict-sni bind processing (do not use default cert).")
 	flag.StringVar(&o.MetricsType, "metrics-type", env("ROUTER_METRICS_TYPE", ""), "Specifies the type of metrics to gather. Supports 'haproxy'.")
 	flag.BoolVar(&o.UseHAProxyConfigManager, "haproxy-config-manager", isTrue(env("ROUTER_HAPROXY_CONFIG_MANAGER", "")), "Use the the haproxy config manager (and dynamic configuration API) to configure route and endpoint changes. Reduces the number of haproxy reloads needed on configuration changes.")
+	flag.BoolVar(&o.UseHAProxyDynamicEndpoints, "haproxy-dynamic-endpoints", isTrue(env("ROUTER_HAPROXY_DYNAMIC_ENDPOINTS", "")), "Use the haproxy dynamic configuration API to configure endpoint changes on existing backends only. Route changes still reload haproxy and no blueprint routes are needed. Ignored if the haproxy config manager is used.")
 	flag.DurationVar(&o.CommitInterval, "commit-interval", getIntervalFromEnv("COMMIT_INTERVAL", defaultCommitInterval), "Controls how often to commit (to the actual config) all the changes made using the router specific dynamic configuration manager.")
+	flag.DurationVar(&o.ReconcileInterval, "reconcile-interval", getIntervalFromEnv("ROUTER_HAPROXY_CONFIG_MANAGER_RECONCILE_INTERVAL", defaultReconcileInterval), "Controls how often to verify (and repair) the changes made using the router specific dynamic configuration manager against the router state. Zero disables it.")
 	flag.StringVar(&o.BlueprintRouteNamespace, "blueprint-route-namespace", env("ROUTER_BLUEPRINT_ROUTE_NAMESPACE", ""), "Specifies the namespace which contains the routes that serve as blueprints for the dynamic configuration manager.")
 	flag.StringVar(&o.BlueprintRouteLabelSelector, "blueprint-route-labels", env("ROUTER_BLUEPRINT_ROUTE_LABELS", ""), "A label selector to apply to the routes in the blueprint route namespace. These selected routes will serve as blueprints for the dynamic dynamic configuration manager.")
 	flag.IntVar(&o.BlueprintRoutePoolSize, "blueprint-route-pool-size", int(envInt("ROUTER_BLUEPRINT_ROUTE_POOL_SIZE", 10, 0)), "Specifies the size of the pre-allocated pool for each route blueprint managed by the router specific dynamic configuration manager. This can be overriden by an annotation router.openshift.io/pool-size on an individual route.")
@@ -491,6 +546,10 @@ func (o *TemplateRouterOptions) Complete() error {
 		return fmt.Errorf("invalid dynamic configuration manager commit interval: %v - must be a positive duration", nsecs)
 	}
 
+	if o.ReconcileInterval < 0 {
+		return fmt.Errorf("invalid dynamic configuration manager reconcile interval: %v - must not be a negative duration", o.ReconcileInterval)
+	}
+
 	captureHTTPRequestHeaders, err := parseCaptureHeaders(o.CaptureHTTPRequestHeadersString)
 	if err != nil {
 		return err
@@ -525,6 +584,28 @@ func (o *TemplateRouterOptions) Complete() error {
 	}
 	o.CaptureHTTPCookie = captureHTTPCookie
 
+	if len(o.DefaultCertificatesPathString) != 0 {
+		defaultCertificates, err := defaultcert.Load(strings.Split(o.DefaultCertificatesPathString, ","))
+		if err != nil {
+			return fmt.Errorf("default-certificates-path is not valid: %v", err)
+		}
+		o.DefaultCertificates = defaultCertificates
+	}
+
+	if len(o.CertificateChainIntermediatesPath) != 0 {
+		intermediates, err := cert.CertsFromFile(o.CertificateChainIntermediatesPath)
+		if err != nil {
+			return fmt.Errorf("certificate-chain-intermediates-path is not valid: %v", err)
+		}
+		o.CertificateChainIntermediates = intermediates
+	}
+
+	acmeIssuers, err := parseACMEIssuers(o.ACMEIssuersString)
+	if err != nil {
+		return err
+	}
+	o.ACMEIssuers = acmeIssuers
+
 	httpHeaderNameCaseAdjustments, err := parseHTTPHeaderNameCaseAdjustments(o.HTTPHeaderNameCaseAdjustmentsString)
 	if err != nil {
 		return err
@@ -555,6 +636,26 @@ func (o *TemplateRouterOptions) Validate() error {
 	if len(o.ReloadScript) == 0 {
 		return errors.New("reload script must be specified")
 	}
+	if o.CertificateExpiryWarningWindow < 0 {
+		return errors.New("certificate expiry warning window must not be negative")
+	}
+	if len(o.ACMEIssuers) != 0 {
+		if !o.AllowExternalCertificates {
+			return errors.New("ACME issuers require allow-external-certificates")
+		}
+		if len(o.ACMEAccountKeyPath) == 0 {
+			return errors.New("ACME issuers require acme-account-key-path")
+		}
+		if o.ACMERenewBefore <= 0 {
+			return errors.New("ACME renew before must be a positive duration")
+		}
+	}
+	if len(o.TLSTicketKeySeedPath) != 0 && (o.TLSTicketKeyRotationInterval < time.Second || o.TLSTicketKeyRotationInterval%time.Second != 0) {
+		return errors.New("TLS ticket key rotation interval must be a positive whole number of seconds")
+	}
+	if format := env("ROUTER_ACCESS_LOG_FORMAT", ""); len(format) > 0 && format != accesslog.FormatJSON {
+		return fmt.Errorf("ROUTER_ACCESS_LOG_FORMAT must be empty or %q", accesslog.FormatJSON)
+	}
 	return nil
 }
 
@@ -569,6 +670,44 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 	defer cancel()
 
 	adminSocketURL := &url.URL{Scheme: "unix", Path: "/var/lib/haproxy/run/haproxy.sock"}
+
+	// The lines of the JSON access logs which the router process can't parse
+	// are counted rather than silently dropped.
+	if env("ROUTER_ACCESS_LOG_FORMAT", "") == accesslog.FormatJSON {
+		accesslog.RegisterMetrics()
+	}
+
+	// haproxy propagates the trace context of the requests and logs them to
+	// the exporter when a collector is set, see the haproxy template.
+	if endpoint := env("ROUTER_TRACING_OTLP_ENDPOINT", ""); len(endpoint) > 0 {
+		exporter, err := tracing.NewListeningExporter(tracing.Options{
+			Endpoint:         endpoint,
+			Insecure:         isTrue(env("ROUTER_TRACING_OTLP_INSECURE", "")),
+			AccessLogAddress: env("ROUTER_TRACING_ACCESS_LOG_ADDRESS", "/var/lib/haproxy/run/tracing.sock"),
+			ServiceName:      env("ROUTER_TRACING_SERVICE_NAME", "openshift-router"),
+		})
+		if err != nil {
+			return err
+		}
+		defer exporter.Close()
+	}
+
+	// haproxy sends the access logs to the router process which writes them
+	// to its standard output or to a file when a sink is set, see the haproxy
+	// template.
+	if path := env("ROUTER_ACCESS_LOG_SINK", ""); len(path) > 0 {
+		sink, err := accesslog.NewListeningSink(accesslog.SinkOptions{
+			Address:  env("ROUTER_ACCESS_LOG_SINK_ADDRESS", "/var/lib/haproxy/run/access-log.sock"),
+			Path:     path,
+			MaxSize:  int64(envInt("ROUTER_ACCESS_LOG_SINK_MAX_SIZE_MB", 100, 1)) * 1024 * 1024,
+			MaxFiles: int(envInt("ROUTER_ACCESS_LOG_SINK_MAX_FILES", 5, 0)),
+		})
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+	}
+
 	statsPort := o.StatsPort
 	switch {
 	case o.MetricsType == "haproxy" && statsPort != 0:
@@ -602,21 +741,29 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 		// Exposed to allow tuning in production if this becomes an issue
 		var exported []int
 		if t := env("ROUTER_METRICS_HAPROXY_EXPORTED", ""); len(t) > 0 {
-			for _, s := range strings.Split(t, ",") {
-				i, err := strconv.Atoi(s)
-				if err != nil {
-					return errors.New("ROUTER_METRICS_HAPROXY_EXPORTED must be a comma delimited list of column numbers to extract from the HAProxy configuration")
-				}
-				exported = append(exported, i)
+			columns, err := haproxy.ParseMetricSelection(strings.Split(t, ","))
+			if err != nil {
+				return fmt.Errorf("ROUTER_METRICS_HAPROXY_EXPORTED must be a comma delimited list of column numbers or field names to extract from the HAProxy statistics: %v", err)
 			}
+			exported = columns
+		}
+
+		// The route labels to add to the backend and server metrics.
+		var routeLabels []string
+		if t := env("ROUTER_METRICS_HAPROXY_ROUTE_LABELS", ""); len(t) > 0 {
+			routeLabels = strings.Split(t, ",")
 		}
 
 		adminUnixSocket := os.Getenv("ROUTER_HAPROXY_ADMIN_UNIX_SOCKET")
 		hasHAProxySidecar := len(adminUnixSocket) > 0
 
 		pidFn := haproxyPidEmbedded
+		// The old workers draining connections after a reload are scraped
+		// through the master CLI of the sidecar.
+		var masterURI string
 		if hasHAProxySidecar {
 			pidFn = haproxyPidSidecar(ctx, adminUnixSocket)
+			masterURI = "unix://" + adminUnixSocket
 		}
 		collector, err := haproxy.NewPrometheusCollector(haproxy.PrometheusOptions{
 			// Only template router customizers who alter the image should need this
@@ -626,11 +773,29 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 			ServerThreshold:    serverThreshold,
 			BaseScrapeInterval: baseScrapeInterval,
 			ExportedMetrics:    exported,
+			ScrapeMode:         env("ROUTER_METRICS_HAPROXY_SCRAPE_MODE", haproxy.ScrapeModeCSV),
+			MasterURI:          masterURI,
+			RouteLookup:        backendRouteLookup(&ptrTemplatePlugin),
+			RouteLabels:        routeLabels,
 		})
 		if err != nil {
 			return err
 		}
 
+		// The access logs are sent by haproxy to this address when set, to
+		// observe the latency distribution of the routes.
+		if address := env("ROUTER_METRICS_ACCESS_LOG_ADDRESS", ""); len(address) > 0 {
+			accessLogCollector, err := haproxy.NewPrometheusAccessLogCollector(haproxy.AccessLogOptions{
+				Address:          address,
+				MaxRoutes:        int(envInt("ROUTER_METRICS_ACCESS_LOG_MAX_ROUTES", 1000, 1)),
+				RouteIdleTimeout: getIntervalFromEnv("ROUTER_METRICS_ACCESS_LOG_ROUTE_IDLE_TIMEOUT", 3600),
+			})
+			if err != nil {
+				return err
+			}
+			defer accessLogCollector.Close()
+		}
+
 		// Metrics will handle healthz on the stats port, and instruct the template router to disable stats completely.
 		// The underlying router must provide a custom health check if customized which will be called into.
 		statsPort = -1
@@ -647,6 +812,16 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 		if err != nil {
 			return err
 		}
+		checkConfig, err := metrics.ConfigLoaded(&ptrTemplatePlugin, adminSocketURL)
+		if err != nil {
+			return err
+		}
+		readyChecks := []healthz.HealthChecker{checkBackend, checkSync, checkConfig, metrics.ProcessRunning(stopCh)}
+		if isTrue(env("ROUTER_MUTUAL_TLS_AUTH_CRL_READINESS_CHECK", "")) {
+			// Fail readiness while HAProxy rejects the clients of a CA
+			// because its CRLs are missing or expired.
+			readyChecks = append(readyChecks, metrics.CRLsAvailable())
+		}
 		checkController := metrics.ControllerLive()
 		checkSocket := metrics.AdminSocketAvailable(adminSocketURL)
 		liveChecks := []healthz.HealthChecker{checkController}
@@ -706,7 +881,7 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 				Name:            o.RouterName,
 			},
 			LiveChecks:  liveChecks,
-			ReadyChecks: []healthz.HealthChecker{checkBackend, checkSync, metrics.ProcessRunning(stopCh)},
+			ReadyChecks: readyChecks,
 		}
 
 		if tlsConfig, err := makeTLSConfig(30 * time.Second); err != nil {
@@ -744,14 +919,20 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 
 	var cfgManager templateplugin.ConfigManager
 	var blueprintPlugin router.Plugin
-	if o.UseHAProxyConfigManager {
-		blueprintRoutes, err := o.blueprintRoutes(routeclient)
-		if err != nil {
-			return err
+	if o.UseHAProxyConfigManager || o.UseHAProxyDynamicEndpoints {
+		dynamicEndpointsOnly := !o.UseHAProxyConfigManager
+		var blueprintRoutes []*routev1.Route
+		if !dynamicEndpointsOnly {
+			blueprintRoutes, err = o.blueprintRoutes(routeclient)
+			if err != nil {
+				return err
+			}
 		}
 		cmopts := templateplugin.ConfigManagerOptions{
 			ConnectionInfo:         adminSocketURL.String(),
+			DynamicEndpointsOnly:   dynamicEndpointsOnly,
 			CommitInterval:         o.CommitInterval,
+			ReconcileInterval:      o.ReconcileInterval,
 			BlueprintRoutes:        blueprintRoutes,
 			BlueprintRoutePoolSize: o.BlueprintRoutePoolSize,
 			WildcardRoutesAllowed:  o.AllowWildcardRoutes,
@@ -760,7 +941,7 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 			DefaultDestinationCA:   o.DefaultDestinationCAPath,
 		}
 		cfgManager = haproxyconfigmanager.NewHAProxyConfigManager(cmopts)
-		if len(o.BlueprintRouteNamespace) > 0 {
+		if !dynamicEndpointsOnly && len(o.BlueprintRouteNamespace) > 0 {
 			blueprintPlugin = haproxyconfigmanager.NewBlueprintPlugin(cfgManager)
 		}
 	}
@@ -772,6 +953,36 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 
 	secretManager := secretmanager.NewManager(kc, nil)
 
+	var acmeAccountKey *ecdsa.PrivateKey
+	var acmeChallengeAddress string
+	if len(o.ACMEIssuers) != 0 {
+		key, err := acme.LoadAccountKey(o.ACMEAccountKeyPath)
+		if err != nil {
+			return err
+		}
+		challenges, err := acme.NewChallengeServer(key)
+		if err != nil {
+			return err
+		}
+		if err := challenges.Listen(o.ACMEChallengeAddress); err != nil {
+			return err
+		}
+		acmeAccountKey = key
+		acmeChallengeAddress = o.ACMEChallengeAddress
+	}
+
+	var ticketKeys *ticketkeys.Manager
+	var tlsTicketKeysPath string
+	if len(o.TLSTicketKeySeedPath) != 0 {
+		// haproxy reads the keys file when it starts, so it must exist
+		// before the first reload.
+		ticketKeys = ticketkeys.NewManager(o.TLSTicketKeySeedPath, filepath.Join(o.WorkingDir, "conf", "tls-ticket-keys"), adminSocketURL.String(), o.TLSTicketKeyRotationInterval)
+		if err := ticketKeys.WriteKeys(); err != nil {
+			return fmt.Errorf("unable to write the TLS ticket keys: %v", err)
+		}
+		tlsTicketKeysPath = ticketKeys.KeysPath()
+	}
+
 	pluginCfg := templateplugin.TemplatePluginConfig{
 		AppCtx:                        ctx,
 		WorkingDir:                    o.WorkingDir,
@@ -782,7 +993,10 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 		DefaultCertificate:            o.DefaultCertificate,
 		DefaultCertificatePath:        o.DefaultCertificatePath,
 		DefaultCertificateDir:         o.DefaultCertificateDir,
+		DefaultCertificates:           o.DefaultCertificates,
 		DefaultDestinationCAPath:      o.DefaultDestinationCAPath,
+		ACMEChallengeAddress:          acmeChallengeAddress,
+		TLSTicketKeysPath:             tlsTicketKeysPath,
 		StatsPort:                     statsPort,
 		StatsUsername:                 statsUsername,
 		StatsPassword:                 statsPassword,
@@ -811,8 +1025,16 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 	factory := o.RouterSelection.NewFactory(routeclient, projectclient.ProjectV1().Projects(), kc)
 	factory.RouteModifierFn = o.RouteUpdate
 
-	var plugin router.Plugin = templatePlugin
-	var recorder controller.RouteStatusRecorder = controller.LogRejections
+	// Each instrumented plugin wraps the one instrumented before it, whose
+	// time is excluded from its own.
+	var instrumented *controller.InstrumentedPlugin
+	instrument := func(name string, plugin router.Plugin) router.Plugin {
+		instrumented = controller.NewInstrumentedPlugin(name, plugin, instrumented)
+		return instrumented
+	}
+
+	var plugin router.Plugin = instrument("TemplatePlugin", controller.NewAdmittedRouteMetrics(templatePlugin))
+	var recorder controller.RouteStatusRecorder = controller.NewMetricsRecorder(controller.LogRejections)
 	informer := factory.CreateRoutesSharedInformer()
 	routeLister := routelisters.NewRouteLister(informer.GetIndexer())
 	if o.UpdateStatus {
@@ -822,22 +1044,52 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 		tracker.SetConflictMessage(fmt.Sprintf("The router detected another process is writing conflicting updates to route status with name %q. Please ensure that the configuration of all routers is consistent. Route status will not be updated as long as conflicts are detected.", o.RouterName))
 		go tracker.Run(stopCh)
 		status := controller.NewStatusAdmitter(plugin, routeclient.RouteV1(), routeLister, o.RouterName, o.RouterCanonicalHostname, lease, tracker)
-		recorder = status
-		plugin = status
+		recorder = controller.NewMetricsRecorder(status)
+		plugin = instrument("StatusAdmitter", status)
+	}
+	plugin = instrument("CertificateExpiryMonitor", controller.NewCertificateExpiryMonitor(plugin, recorder, o.CertificateExpiryWarningWindow, templatePlugin.DefaultCertificatePath(), o.DefaultDestinationCAPath, o.DefaultCertificates))
+	if len(o.CertificateChainIntermediates) != 0 || o.CertificateChainWarnings {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			log.Error(err, "unable to load the system root certificates, the certificate chains are only verified against the intermediates")
+			roots = x509.NewCertPool()
+		}
+		// The certificate expiry monitor tracks the completed certificate chains.
+		plugin = instrument("CertificateChainValidator", controller.NewCertificateChainValidator(plugin, recorder, &routeapihelpers.ChainBuilder{Intermediates: o.CertificateChainIntermediates, Roots: roots}, o.CertificateChainWarnings))
+	}
+	if len(o.DefaultCertificates) != 0 {
+		plugin = instrument("DefaultCertificateMatcher", controller.NewDefaultCertificateMatcher(plugin, recorder, o.DefaultCertificates))
 	}
 	if o.UpgradeValidation {
-		plugin = controller.NewUpgradeValidation(plugin, recorder, o.UpgradeValidationForceAddCondition, o.UpgradeValidationForceRemoveCondition)
+		plugin = instrument("UpgradeValidation", controller.NewUpgradeValidation(plugin, recorder, o.UpgradeValidationForceAddCondition, o.UpgradeValidationForceRemoveCondition))
 	}
-	plugin = controller.NewExtendedValidator(plugin, recorder, o.ExtendedValidation)
+	plugin = instrument("ExtendedValidator", controller.NewExtendedValidator(plugin, recorder, o.ExtendedValidation))
 	if o.AllowExternalCertificates {
-		plugin = controller.NewRouteSecretManager(plugin, recorder, secretManager, o.RouterName, kc.CoreV1(), routeLister, authorizationClient.SubjectAccessReviews())
+		plugin = instrument("RouteSecretManager", controller.NewRouteSecretManager(plugin, recorder, secretManager, o.RouterName, kc.CoreV1(), routeLister, authorizationClient.SubjectAccessReviews()))
 	}
-	plugin = controller.NewUniqueHost(plugin, o.RouterSelection.DisableNamespaceOwnershipCheck, recorder)
-	plugin = controller.NewHostAdmitter(plugin, o.RouteAdmissionFunc(), o.AllowWildcardRoutes, o.RouterSelection.DisableNamespaceOwnershipCheck, recorder)
+	if acmeAccountKey != nil {
+		issuers, err := o.acmeIssuers(acmeAccountKey)
+		if err != nil {
+			return err
+		}
+		// The followers wait at least twice as long as the longest request
+		// for the certificates requested by the leader.
+		lease := writerlease.NewWithBackoff("acme", time.Hour, time.Minute, wait.Backoff{Duration: 5 * time.Minute, Factor: 2, Steps: 3, Jitter: 0.5})
+		go lease.Run(stopCh)
+		provisioner := controller.NewACMEProvisioner(plugin, recorder, templatePlugin, lease, issuers, o.ACMERenewBefore, o.RouterName, kc.CoreV1(), routeLister)
+		go provisioner.Run(stopCh)
+		plugin = instrument("ACMEProvisioner", provisioner)
+	}
+	plugin = instrument("UniqueHost", controller.NewUniqueHost(plugin, o.RouterSelection.DisableNamespaceOwnershipCheck, recorder))
+	plugin = instrument("HostAdmitter", controller.NewHostAdmitter(plugin, o.RouteAdmissionFunc(), o.AllowWildcardRoutes, o.RouterSelection.DisableNamespaceOwnershipCheck, recorder))
 
 	controller := factory.Create(plugin, false, stopCh)
 	controller.Run()
 
+	if ticketKeys != nil {
+		go ticketKeys.Run(stopCh)
+	}
+
 	if blueprintPlugin != nil {
 		// f is like factory but filters the routes based on the
 		// blueprint route namespace and label selector (if any).
@@ -869,6 +1121,60 @@ func (o *TemplateRouterOptions) Run(stopCh <-chan struct{}) error {
 	return nil
 }
 
+// parseACMEIssuers parses a comma-separated list of name=directoryURL ACME
+// issuers.
+func parseACMEIssuers(in string) (map[string]string, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	issuers := make(map[string]string)
+	for _, issuer := range strings.Split(in, ",") {
+		name, directoryURL, ok := strings.Cut(issuer, "=")
+		if !ok || len(name) == 0 {
+			return nil, fmt.Errorf("invalid ACME issuer %q: expected name=directoryURL", issuer)
+		}
+		if _, ok := issuers[name]; ok {
+			return nil, fmt.Errorf("duplicate ACME issuer %q", name)
+		}
+		if u, err := url.Parse(directoryURL); err != nil || u.Scheme != "https" || len(u.Host) == 0 {
+			return nil, fmt.Errorf("invalid directory URL of ACME issuer %q: an https URL is required", name)
+		}
+		issuers[name] = directoryURL
+	}
+	return issuers, nil
+}
+
+// acmeIssuers returns the clients of the ACME issuers, by name, which share
+// the account key.
+func (o *TemplateRouterOptions) acmeIssuers(key *ecdsa.PrivateKey) (map[string]controller.ACMEIssuer, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(o.ACMECABundlePath) != 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(o.ACMECABundlePath)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no CA certificate in %s", o.ACMECABundlePath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	httpClient := &http.Client{Timeout: time.Minute, Transport: transport}
+
+	issuers := make(map[string]controller.ACMEIssuer)
+	for name, directoryURL := range o.ACMEIssuers {
+		issuer, err := acme.NewClient(directoryURL, key, o.ACMEEmail, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		issuers[name] = issuer
+	}
+	return issuers, nil
+}
+
 // blueprintRoutes returns all the routes in the blueprint namespace.
 func (o *TemplateRouterOptions) blueprintRoutes(routeclient *routeclientset.Clientset) ([]*routev1.Route, error) {
 	blueprints := make([]*routev1.Route, 0)
@@ -1097,3 +1403,24 @@ func haproxyPidSidecar(ctx context.Context, unixSocket string) func() (int, erro
 		return pid, nil
 	}
 }
+
+// backendRouteLookup returns the route lookup of the haproxy metrics, from
+// the state of the template plugin once it is created.
+func backendRouteLookup(pluginPtr **templateplugin.TemplatePlugin) func(backend string) (haproxy.RouteInfo, bool) {
+	return func(backend string) (haproxy.RouteInfo, bool) {
+		if *pluginPtr == nil {
+			return haproxy.RouteInfo{}, false
+		}
+		route, ok := (*pluginPtr).BackendRoute(backend)
+		if !ok {
+			return haproxy.RouteInfo{}, false
+		}
+		return haproxy.RouteInfo{
+			Namespace:   route.Namespace,
+			Name:        route.Name,
+			Service:     route.Service,
+			Termination: string(route.Termination),
+			Labels:      route.Labels,
+		}, true
+	}
+}
@@ -246,3 +246,57 @@ func TestParseCurvePreferences(t *testing.T) {
 		})
 	}
 }
+
+func TestParseACMEIssuers(t *testing.T) {
+	testCases := []struct {
+		description  string
+		inputValue   string
+		expected     map[string]string
+		errSubstring string
+	}{
+		{
+			description: "empty",
+		},
+		{
+			description: "several issuers",
+			inputValue:  "letsencrypt=https://acme-v02.api.letsencrypt.org/directory,pebble=https://pebble:14000/dir",
+			expected: map[string]string{
+				"letsencrypt": "https://acme-v02.api.letsencrypt.org/directory",
+				"pebble":      "https://pebble:14000/dir",
+			},
+		},
+		{
+			description:  "missing name",
+			inputValue:   "https://pebble:14000/dir",
+			errSubstring: "expected name=directoryURL",
+		},
+		{
+			description:  "duplicate name",
+			inputValue:   "pebble=https://pebble:14000/dir,pebble=https://pebble:15000/dir",
+			errSubstring: "duplicate ACME issuer",
+		},
+		{
+			description:  "plain http directory",
+			inputValue:   "pebble=http://pebble:14000/dir",
+			errSubstring: "an https URL is required",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			actual, err := parseACMEIssuers(tc.inputValue)
+			if len(tc.errSubstring) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.errSubstring) {
+					t.Fatalf("expected error containing %q, got %v", tc.errSubstring, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("unexpected issuers (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Package accesslog receives the haproxy access logs in the router process
+// and defines the JSON access log format.
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	logf "github.com/openshift/router/log"
+)
+
+var log = logf.Logger.WithName("accesslog")
+
+// maxLineLength is the maximum length of an access log datagram, haproxy
+// truncates longer lines to the "len" of the log target.
+const maxLineLength = 64 * 1024
+
+// Listen receives the access logs sent to address, either a host:port UDP
+// address or the path of a unix datagram socket. Each line is passed to
+// handle in a goroutine until the returned connection is closed.
+func Listen(address string, handle func(line string)) (net.PacketConn, error) {
+	network := "udp"
+	if strings.HasPrefix(address, "/") {
+		network = "unixgram"
+		// Remove the socket left behind by a previous router process.
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("can't receive access logs on %s: %v", address, err)
+	}
+
+	go func() {
+		buf := make([]byte, maxLineLength)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Error(err, "can't read access log, no longer receiving access logs", "address", address)
+				}
+				return
+			}
+			handle(string(buf[:n]))
+		}
+	}()
+	return conn, nil
+}
+
+// Message returns the message of a syslog line sent by haproxy, without
+// the priority, timestamp, hostname and tag header, e.g.
+//
+//	<134>Oct 18 10:01:02 haproxy[42]: message
+//
+// A line without header is returned as is.
+func Message(line string) string {
+	line = strings.TrimRight(line, "\r\n\x00")
+	if !strings.HasPrefix(line, "<") {
+		return line
+	}
+	if _, message, ok := strings.Cut(line, "]: "); ok {
+		return message
+	}
+	return line
+}
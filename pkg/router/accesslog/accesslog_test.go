@@ -0,0 +1,140 @@
+package accesslog
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	validationfield "k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestMessage(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected string
+	}{
+		{
+			line:     "<134>Oct 18 10:01:02 haproxy[42]: {\"status\":200}\n",
+			expected: `{"status":200}`,
+		},
+		{
+			line:     "<134>Oct 18 10:01:02 router-1 haproxy[42]: 10.0.0.1:51234 [18/Oct/2026:10:01:02.120] public be_http:ns:web/pod 0/0/1/2/3 200",
+			expected: "10.0.0.1:51234 [18/Oct/2026:10:01:02.120] public be_http:ns:web/pod 0/0/1/2/3 200",
+		},
+		{
+			line:     "no syslog header",
+			expected: "no syslog header",
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, Message(tc.line))
+	}
+}
+
+func TestHTTPLogFormat(t *testing.T) {
+	format := HTTPLogFormat(FormatOptions{
+		RequestHeaders:  []string{"Host"},
+		ResponseHeaders: []string{"Content-Type"},
+		TraceCaptures:   true,
+	})
+
+	assert.Regexp(t, `^"\{.*\}"$`, format)
+	assert.Contains(t, format, `\"namespace\":\"%[be_name,field(2,:)]\"`)
+	assert.Contains(t, format, `\"status\":%ST`)
+	assert.Contains(t, format, `\"trace_context\":\"%[capture.req.hdr(1),json(utf8s)]\"`)
+	// The header captures follow the traceparent captures.
+	assert.Contains(t, format, `\"request_headers\":{\"host\":\"%[capture.req.hdr(2),json(utf8s)]\"}`)
+	assert.Contains(t, format, `\"response_headers\":{\"content-type\":\"%[capture.res.hdr(0),json(utf8s)]\"}`)
+
+	assert.NotContains(t, TCPLogFormat(), "status")
+}
+
+func TestParseJSON(t *testing.T) {
+	entry, ok := ParseJSON(`<134>Oct 18 10:01:02 haproxy[42]: {"accept_time_ms":1760781662120,"client_ip":"10.0.0.1","client_port":"51234","frontend":"fe_sni","backend":"be_edge_http:ns:web","server":"pod:web","namespace":"ns","route":"web","tls_version":"TLSv1.3","sni":"web.example.com","method":"GET","path":"/a\"b","version":"HTTP/1.1","status":200,"bytes_read":1234,"bytes_uploaded":0,"request_ms":1,"queue_ms":0,"connect_ms":2,"response_ms":30,"active_ms":35,"total_ms":35,"termination_state":"--","request_headers":{"host":"web.example.com"}}`, "test")
+	require.True(t, ok)
+	assert.Equal(t, "be_edge_http:ns:web", entry.Backend)
+	assert.Equal(t, "TLSv1.3", entry.TLSVersion)
+	assert.Equal(t, `/a"b`, entry.Path)
+	assert.Equal(t, int64(30), entry.ResponseMS)
+	assert.Equal(t, "web.example.com", entry.RequestHeaders["host"])
+
+	// The timers missing from the tcp logs are unknown.
+	entry, ok = ParseJSON(`{"backend":"be_tcp:ns:db","queue_ms":0,"connect_ms":1,"total_ms":500}`, "test")
+	require.True(t, ok)
+	assert.Equal(t, int64(-1), entry.ResponseMS)
+	assert.Equal(t, int64(500), entry.TotalMS)
+
+	_, ok = ParseJSON("10.0.0.1:51234 [18/Oct/2026:10:01:02.120] public be_http:ns:web/pod 0/0/1/2/3 200", "test")
+	assert.False(t, ok)
+	assert.Zero(t, testutil.ToFloat64(metricJSONParseFailures.WithLabelValues("test")))
+
+	// A truncated line is counted as a parse failure.
+	_, ok = ParseJSON(`{"status":`, "test")
+	assert.False(t, ok)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metricJSONParseFailures.WithLabelValues("test")))
+}
+
+func TestListen(t *testing.T) {
+	address := t.TempDir() + "/access-log.sock"
+	lines := make(chan string, 1)
+	conn, err := Listen(address, func(line string) { lines <- line })
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client, err := net.Dial("unixgram", address)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("<134>Oct 18 10:01:02 haproxy[42]: message"))
+	require.NoError(t, err)
+
+	select {
+	case line := <-lines:
+		assert.Equal(t, "<134>Oct 18 10:01:02 haproxy[42]: message", line)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no access log received")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		errors      int
+	}{
+		{
+			name: "no annotations",
+		},
+		{
+			name: "valid annotations",
+			annotations: map[string]string{
+				RouteAnnotation:         "true",
+				SamplePercentAnnotation: "12.5",
+				ErrorsOnlyAnnotation:    "false",
+			},
+		},
+		{
+			name:        "invalid enablement",
+			annotations: map[string]string{RouteAnnotation: "yes please"},
+			errors:      1,
+		},
+		{
+			name:        "percentage out of range",
+			annotations: map[string]string{SamplePercentAnnotation: "150"},
+			errors:      1,
+		},
+		{
+			name:        "percentage not a number",
+			annotations: map[string]string{SamplePercentAnnotation: "NaN", ErrorsOnlyAnnotation: "sometimes"},
+			errors:      2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := Validate(tc.annotations, validationfield.NewPath("metadata", "annotations"))
+			assert.Len(t, errs, tc.errors)
+		})
+	}
+}
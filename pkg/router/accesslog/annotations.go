@@ -0,0 +1,48 @@
+package accesslog
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	validationfield "k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// RouteAnnotation disables or enables the access logs of a route.
+	RouteAnnotation = "haproxy.router.openshift.io/access-log"
+	// SamplePercentAnnotation is the percentage of the requests of a route
+	// that are logged.
+	SamplePercentAnnotation = "haproxy.router.openshift.io/access-log-sample-percent"
+	// ErrorsOnlyAnnotation restricts the access logs of a route to the failed
+	// requests.
+	ErrorsOnlyAnnotation = "haproxy.router.openshift.io/access-log-errors-only"
+)
+
+// ParseSamplePercent parses the value of the SamplePercentAnnotation, a
+// number between 0 and 100.
+func ParseSamplePercent(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(percent) || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("must be a number between 0 and 100")
+	}
+	return percent, nil
+}
+
+// Validate returns the errors of the access log annotations of a route.
+func Validate(annotations map[string]string, fldPath *validationfield.Path) validationfield.ErrorList {
+	errs := validationfield.ErrorList{}
+	for _, annotation := range []string{RouteAnnotation, ErrorsOnlyAnnotation} {
+		if value, ok := annotations[annotation]; ok {
+			if _, err := strconv.ParseBool(value); err != nil {
+				errs = append(errs, validationfield.Invalid(fldPath.Key(annotation), value, "must be true or false"))
+			}
+		}
+	}
+	if value, ok := annotations[SamplePercentAnnotation]; ok {
+		if _, err := ParseSamplePercent(value); err != nil {
+			errs = append(errs, validationfield.Invalid(fldPath.Key(SamplePercentAnnotation), value, err.Error()))
+		}
+	}
+	return errs
+}
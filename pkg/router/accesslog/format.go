@@ -0,0 +1,188 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FormatJSON is the name of the JSON access log format.
+const FormatJSON = "json"
+
+// field is a field of the JSON access log format.
+type field struct {
+	name string
+	// value is the haproxy log-format expression of the field.
+	value string
+	// quoted is true for the string fields.
+	quoted bool
+}
+
+// stringField returns a string field, escaping the value of a sample
+// expression.
+func stringField(name, sample string) field {
+	return field{name: name, value: "%[" + sample + ",json(utf8s)]", quoted: true}
+}
+
+// commonFields are the fields of both the http and tcp access logs. The
+// route namespace and name are the segments of the backend name, e.g.
+// "be_http:namespace:name".
+var commonFields = []field{
+	{name: "accept_time_ms", value: "%Ts%ms"},
+	{name: "client_ip", value: "%ci", quoted: true},
+	{name: "client_port", value: "%cp", quoted: true},
+	{name: "frontend", value: "%f", quoted: true},
+	{name: "backend", value: "%b", quoted: true},
+	{name: "server", value: "%s", quoted: true},
+	{name: "namespace", value: "%[be_name,field(2,:)]", quoted: true},
+	{name: "route", value: "%[be_name,field(3,:)]", quoted: true},
+}
+
+// httpFields are the fields of the http access logs.
+var httpFields = []field{
+	{name: "tls_version", value: "%sslv", quoted: true},
+	stringField("sni", "ssl_fc_sni"),
+	stringField("method", "capture.req.method"),
+	stringField("path", "capture.req.uri,field(1,?)"),
+	{name: "version", value: "%[capture.req.ver]", quoted: true},
+	{name: "status", value: "%ST"},
+	{name: "bytes_read", value: "%B"},
+	{name: "bytes_uploaded", value: "%U"},
+	{name: "request_ms", value: "%TR"},
+	{name: "queue_ms", value: "%Tw"},
+	{name: "connect_ms", value: "%Tc"},
+	{name: "response_ms", value: "%Tr"},
+	{name: "active_ms", value: "%Ta"},
+	{name: "total_ms", value: "%Tt"},
+	{name: "termination_state", value: "%ts", quoted: true},
+}
+
+// tcpFields are the fields of the tcp access logs.
+var tcpFields = []field{
+	{name: "bytes_read", value: "%B"},
+	{name: "queue_ms", value: "%Tw"},
+	{name: "connect_ms", value: "%Tc"},
+	{name: "total_ms", value: "%Tt"},
+	{name: "termination_state", value: "%ts", quoted: true},
+}
+
+// FormatOptions are the options of the http JSON access log format.
+type FormatOptions struct {
+	// RequestHeaders and ResponseHeaders are the names of the captured
+	// headers, in the order of their declaration in the frontends.
+	RequestHeaders  []string
+	ResponseHeaders []string
+	// TraceCaptures is true when the first two request captures are the
+	// received and forwarded traceparent headers.
+	TraceCaptures bool
+}
+
+// HTTPLogFormat returns the quoted haproxy log-format of the JSON access
+// logs of the http proxies.
+func HTTPLogFormat(opts FormatOptions) string {
+	fields := append(append([]field(nil), commonFields...), httpFields...)
+
+	offset := 0
+	if opts.TraceCaptures {
+		fields = append(fields,
+			stringField("trace_parent", "capture.req.hdr(0)"),
+			stringField("trace_context", "capture.req.hdr(1)"),
+		)
+		offset = 2
+	}
+	members := writeMembers(fields)
+	if headers := captureFields(opts.RequestHeaders, "capture.req.hdr", offset); len(headers) > 0 {
+		members = append(members, `"request_headers":`+writeObject(headers))
+	}
+	if headers := captureFields(opts.ResponseHeaders, "capture.res.hdr", 0); len(headers) > 0 {
+		members = append(members, `"response_headers":`+writeObject(headers))
+	}
+	return quote("{" + strings.Join(members, ",") + "}")
+}
+
+// TCPLogFormat returns the quoted haproxy log-format of the JSON access
+// logs of the tcp proxies.
+func TCPLogFormat() string {
+	return quote(writeObject(append(append([]field(nil), commonFields...), tcpFields...)))
+}
+
+// captureFields returns the fields of the captured headers.
+func captureFields(names []string, fetch string, offset int) []field {
+	fields := make([]field, 0, len(names))
+	for i, name := range names {
+		fields = append(fields, stringField(strings.ToLower(name), fmt.Sprintf("%s(%d)", fetch, offset+i)))
+	}
+	return fields
+}
+
+// writeObject returns the JSON object template of the fields.
+func writeObject(fields []field) string {
+	return "{" + strings.Join(writeMembers(fields), ",") + "}"
+}
+
+// writeMembers returns the JSON object members template of the fields.
+func writeMembers(fields []field) []string {
+	members := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.quoted {
+			members = append(members, fmt.Sprintf(`"%s":"%s"`, f.name, f.value))
+		} else {
+			members = append(members, fmt.Sprintf(`"%s":%s`, f.name, f.value))
+		}
+	}
+	return members
+}
+
+// quote quotes a log-format for the haproxy configuration.
+func quote(format string) string {
+	return `"` + strings.ReplaceAll(format, `"`, `\"`) + `"`
+}
+
+// Entry is a JSON access log line. The timers are in milliseconds,
+// negative when the step was not reached or not logged.
+type Entry struct {
+	AcceptTimeMS     int64  `json:"accept_time_ms"`
+	ClientIP         string `json:"client_ip"`
+	ClientPort       string `json:"client_port"`
+	Frontend         string `json:"frontend"`
+	Backend          string `json:"backend"`
+	Server           string `json:"server"`
+	Namespace        string `json:"namespace"`
+	Route            string `json:"route"`
+	TLSVersion       string `json:"tls_version"`
+	SNI              string `json:"sni"`
+	Method           string `json:"method"`
+	Path             string `json:"path"`
+	Version          string `json:"version"`
+	Status           int    `json:"status"`
+	BytesRead        int64  `json:"bytes_read"`
+	BytesUploaded    int64  `json:"bytes_uploaded"`
+	RequestMS        int64  `json:"request_ms"`
+	QueueMS          int64  `json:"queue_ms"`
+	ConnectMS        int64  `json:"connect_ms"`
+	ResponseMS       int64  `json:"response_ms"`
+	ActiveMS         int64  `json:"active_ms"`
+	TotalMS          int64  `json:"total_ms"`
+	TerminationState string `json:"termination_state"`
+	TraceParent      string `json:"trace_parent"`
+	TraceContext     string `json:"trace_context"`
+
+	RequestHeaders  map[string]string `json:"request_headers"`
+	ResponseHeaders map[string]string `json:"response_headers"`
+}
+
+// ParseJSON parses a JSON access log line, possibly prefixed with a syslog
+// header. The lines that look like JSON but can't be parsed, e.g. because
+// haproxy truncated them, are counted as parse failures of consumer.
+func ParseJSON(line, consumer string) (Entry, bool) {
+	message := Message(line)
+	if !strings.HasPrefix(message, "{") {
+		return Entry{}, false
+	}
+	entry := Entry{RequestMS: -1, QueueMS: -1, ConnectMS: -1, ResponseMS: -1, ActiveMS: -1, TotalMS: -1}
+	if err := json.Unmarshal([]byte(message), &entry); err != nil {
+		metricJSONParseFailures.WithLabelValues(consumer).Inc()
+		return Entry{}, false
+	}
+	return entry, true
+}
@@ -0,0 +1,28 @@
+package accesslog
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// metricJSONParseFailures is the number of JSON access log lines that
+	// each consumer couldn't parse, e.g. because haproxy truncated them to
+	// the "len" of their log target.
+	metricJSONParseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "router",
+		Subsystem: "access_log",
+		Name:      "json_parse_failures_total",
+		Help:      "Number of JSON access log lines that could not be parsed, by consumer.",
+	}, []string{"consumer"})
+
+	registerMetricsOnce sync.Once
+)
+
+// RegisterMetrics registers the access log metrics.
+func RegisterMetrics() {
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(metricJSONParseFailures)
+	})
+}
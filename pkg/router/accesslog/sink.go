@@ -0,0 +1,159 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// SinkStdout is the sink path writing the access logs to the router
+// standard output.
+const SinkStdout = "stdout"
+
+// SinkOptions are the options of the access log sink.
+type SinkOptions struct {
+	// Address is the address haproxy sends the access logs to, either a
+	// host:port UDP address or the path of a unix datagram socket.
+	Address string
+	// Path is the file the access logs are written to, or SinkStdout.
+	Path string
+	// MaxSize is the size in bytes beyond which the file is rotated.
+	MaxSize int64
+	// MaxFiles is the number of rotated files kept, e.g. path.1 to
+	// path.<MaxFiles>.
+	MaxFiles int
+}
+
+// Sink receives the haproxy access logs and writes their messages to the
+// router standard output or to a rotated file, one per line, in place of a
+// syslog sidecar.
+type Sink struct {
+	opts SinkOptions
+	conn net.PacketConn
+
+	lock sync.Mutex
+	// out is the writer of the access logs, file is the file it writes
+	// to, if any, and size is the current size of that file.
+	out  io.Writer
+	file *os.File
+	size int64
+}
+
+// NewSink returns a Sink writing to the configured path.
+func NewSink(opts SinkOptions) (*Sink, error) {
+	s := &Sink{opts: opts}
+	if len(opts.Path) == 0 || opts.Path == SinkStdout {
+		s.out = os.Stdout
+		return s, nil
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the access log file for appending. Must be called while
+// holding s.lock or before the sink is used.
+func (s *Sink) open() error {
+	file, err := os.OpenFile(s.opts.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("can't open the access log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("can't open the access log file: %v", err)
+	}
+	s.out, s.file, s.size = file, file, info.Size()
+	return nil
+}
+
+// rotate renames the access log file to path.1, shifting the previously
+// rotated files up to path.<MaxFiles>, and opens a new file. The file is
+// reopened even if the renaming fails, and the access logs are written to the
+// router standard output if it can't be. Must be called while holding s.lock.
+func (s *Sink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		log.Error(err, "can't close the access log file", "path", s.opts.Path)
+	}
+	err := s.shift()
+	if openErr := s.open(); openErr != nil {
+		s.out, s.file, s.size = os.Stdout, nil, 0
+		return errors.Join(err, openErr, errors.New("writing the access logs to the standard output"))
+	}
+	return err
+}
+
+// shift renames the access log file and the rotated files, removing the
+// oldest one.
+func (s *Sink) shift() error {
+	if s.opts.MaxFiles <= 0 {
+		return os.Remove(s.opts.Path)
+	}
+	for i := s.opts.MaxFiles - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", s.opts.Path, i), fmt.Sprintf("%s.%d", s.opts.Path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.opts.Path, s.opts.Path+".1")
+}
+
+// Listen starts receiving the access logs on the configured address. The
+// datagrams are written in a goroutine until Close is called.
+func (s *Sink) Listen() error {
+	conn, err := Listen(s.opts.Address, s.Write)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// Write writes the message of an access log line.
+func (s *Sink) Write(line string) {
+	message := Message(line) + "\n"
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file != nil && s.opts.MaxSize > 0 && s.size > 0 && s.size+int64(len(message)) > s.opts.MaxSize {
+		if err := s.rotate(); err != nil {
+			log.Error(err, "can't rotate the access log file", "path", s.opts.Path)
+		}
+	}
+	n, err := io.WriteString(s.out, message)
+	s.size += int64(n)
+	if err != nil {
+		log.Error(err, "can't write access log", "path", s.opts.Path)
+	}
+}
+
+// Close stops receiving the access logs and closes the access log file.
+func (s *Sink) Close() error {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
+
+// NewListeningSink returns a Sink receiving the haproxy access logs on the
+// configured address.
+func NewListeningSink(opts SinkOptions) (*Sink, error) {
+	sink, err := NewSink(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := sink.Listen(); err != nil {
+		sink.Close()
+		return nil, err
+	}
+	return sink, nil
+}
@@ -0,0 +1,75 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSinkRotation tests that the access log file is rotated beyond its
+// maximum size, keeping the configured number of rotated files.
+func TestSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	sink, err := NewSink(SinkOptions{Path: path, MaxSize: 20, MaxFiles: 2})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	for _, message := range []string{"first line", "second line", "third line", "fourth line"} {
+		sink.Write("<134>Oct 18 10:01:02 haproxy[42]: " + message)
+	}
+
+	for file, expected := range map[string]string{
+		path:        "fourth line\n",
+		path + ".1": "third line\n",
+		path + ".2": "second line\n",
+	} {
+		content, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content), file)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "only 2 rotated files are kept")
+}
+
+// TestSinkAppend tests that an existing access log file is appended to and
+// accounted for in its size.
+func TestSinkAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.WriteFile(path, []byte("previous router\n"), 0644))
+
+	sink, err := NewSink(SinkOptions{Path: path, MaxSize: 30, MaxFiles: 1})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	sink.Write("next router")
+	sink.Write("rotated")
+
+	content, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "previous router\nnext router\n", string(content))
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "rotated\n", string(content))
+}
+
+// TestSinkReopenFailure tests that the access logs are written to the
+// standard output rather than to the closed file when the access log file
+// can't be reopened after its rotation.
+func TestSinkReopenFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	sink, err := NewSink(SinkOptions{Path: filepath.Join(dir, "access.log"), MaxSize: 20, MaxFiles: 1})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	sink.Write("first line")
+	require.NoError(t, os.RemoveAll(dir))
+	sink.Write("second line, rotated")
+
+	assert.Nil(t, sink.file)
+	assert.Equal(t, os.Stdout, sink.out)
+	assert.NoError(t, sink.Close(), "the closed file is not closed again")
+}
@@ -0,0 +1,89 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	logf "github.com/openshift/router/log"
+	"github.com/openshift/router/pkg/router/shutdown"
+)
+
+var log = logf.Logger.WithName("acme")
+
+// ChallengePath is the path prefix of the HTTP-01 challenges, which the HTTP
+// frontend of haproxy forwards to the ChallengeServer.
+const ChallengePath = "/.well-known/acme-challenge/"
+
+// ChallengeServer serves the key authorizations of the HTTP-01 challenges of
+// an ACME account. A key authorization only depends on the token of the
+// challenge and on the account key (RFC 8555 section 8.1): the replicas of the
+// router which share the account key answer the challenges of each other,
+// whichever replica requested the certificate.
+type ChallengeServer struct {
+	// thumbprint is the JWK thumbprint of the account key.
+	thumbprint string
+}
+
+// NewChallengeServer returns a ChallengeServer of the account of the P-256
+// key.
+func NewChallengeServer(key *ecdsa.PrivateKey) (*ChallengeServer, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("the ACME account key must be a P-256 key")
+	}
+	thumbprint, err := thumbprint(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ChallengeServer{thumbprint: thumbprint}, nil
+}
+
+// ServeHTTP responds to the requests for the challenge tokens with their key
+// authorization, and to any other request with a 404. haproxy only forwards
+// the challenges of the hosts of the routes requesting a certificate.
+func (s *ChallengeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, ChallengePath)
+	if r.Method != http.MethodGet || token == r.URL.Path || !validToken(token) {
+		log.V(4).Info("invalid challenge request", "host", r.Host, "method", r.Method, "path", r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	log.V(4).Info("serving challenge", "host", r.Host, "token", token)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write([]byte(token + "." + s.thumbprint))
+}
+
+// validToken returns true if token is a non-empty base64url string, as the
+// tokens of the challenges are.
+func validToken(token string) bool {
+	if len(token) == 0 {
+		return false
+	}
+	for _, c := range token {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Listen serves the challenges on addr. The router is shut down if serving
+// fails.
+func (s *ChallengeServer) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.V(0).Info("ACME challenge server listening", "address", addr)
+	go func() {
+		server := &http.Server{Handler: s}
+		if err := server.Serve(l); err != http.ErrServerClosed {
+			log.Error(err, "serving the ACME challenges failed")
+			shutdown.RequestShutdown()
+		}
+	}()
+	return nil
+}
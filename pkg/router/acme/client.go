@@ -0,0 +1,484 @@
+// Package acme requests certificates from an ACME server such as Let's
+// Encrypt (RFC 8555), proving the control of the route hosts with the HTTP-01
+// challenge served by the router.
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPollInterval is the interval between two polls of an
+	// authorization or an order, unless the server gives a Retry-After.
+	DefaultPollInterval = 2 * time.Second
+
+	// maxResponseSize is the maximum size in bytes of a response of the
+	// ACME server.
+	maxResponseSize = 1024 * 1024
+
+	// maxBadNonceRetries is how many times a request rejected for its
+	// nonce is sent again with a fresh nonce.
+	maxBadNonceRetries = 5
+
+	problemBadNonce = "urn:ietf:params:acme:error:badNonce"
+)
+
+// Problem is an error returned by the ACME server (RFC 7807).
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("acme: %d %s: %s", p.Status, p.Type, p.Detail)
+}
+
+// directory is the directory of the ACME server, listing the URLs of its
+// operations.
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+	Error          *Problem `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *Problem `json:"error"`
+}
+
+// jsonWebKey is the JWK of a P-256 public key. Its members are in
+// lexicographic order, as required to compute its thumbprint (RFC 7638).
+type jsonWebKey struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Client requests certificates from an ACME server, on behalf of an account
+// identified by its key. The account is registered on the first request.
+type Client struct {
+	// PollInterval is the interval between two polls of an authorization or
+	// an order, unless the server gives a Retry-After.
+	PollInterval time.Duration
+
+	directoryURL string
+	key          *ecdsa.PrivateKey
+	contact      []string
+	httpClient   *http.Client
+
+	// lock serializes the requests for certificates, which share the
+	// nonces and the account.
+	lock       sync.Mutex
+	directory  *directory
+	accountURL string
+	nonces     []string
+}
+
+// NewClient returns a Client of the ACME server whose directory is at
+// directoryURL, for the account of the P-256 key, contacted at email if it is
+// not empty. The HTTP-01 challenges are served by a ChallengeServer of the
+// same key.
+func NewClient(directoryURL string, key *ecdsa.PrivateKey, email string, httpClient *http.Client) (*Client, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("the ACME account key must be a P-256 key")
+	}
+	c := &Client{
+		PollInterval: DefaultPollInterval,
+		directoryURL: directoryURL,
+		key:          key,
+		httpClient:   httpClient,
+	}
+	if len(email) != 0 {
+		c.contact = []string{"mailto:" + email}
+	}
+	return c, nil
+}
+
+// GenerateAccountKey returns a new P-256 account key.
+func GenerateAccountKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// LoadAccountKey reads a P-256 account key from a PEM file, in the SEC 1 or
+// the PKCS #8 format.
+func LoadAccountKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid account key in %s: %w", path, err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the account key in %s is not an ECDSA key", path)
+	}
+	return ecKey, nil
+}
+
+// Obtain requests a certificate for host. Returns the PEM encoded certificate
+// chain and private key.
+func (c *Client) Obtain(ctx context.Context, host string) ([]byte, []byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.register(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to register the ACME account: %w", err)
+	}
+
+	var o order
+	resp, err := c.postJSON(ctx, c.directory.NewOrder, map[string]interface{}{
+		"identifiers": []identifier{{Type: "dns", Value: host}},
+	}, &o)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the order: %w", err)
+	}
+	orderURL := resp.Header.Get("Location")
+	for _, authorizationURL := range o.Authorizations {
+		if err := c.authorize(ctx, authorizationURL); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{host}}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp, err = c.postJSON(ctx, o.Finalize, map[string]string{"csr": encode(csr)}, &o); err != nil {
+		return nil, nil, fmt.Errorf("failed to finalize the order: %w", err)
+	}
+	for o.Status != "valid" {
+		if o.Status == "invalid" {
+			return nil, nil, fmt.Errorf("the order of %s is invalid: %v", host, o.Error)
+		}
+		if err := c.wait(ctx, resp); err != nil {
+			return nil, nil, err
+		}
+		if resp, err = c.postJSON(ctx, orderURL, nil, &o); err != nil {
+			return nil, nil, fmt.Errorf("failed to get the order: %w", err)
+		}
+	}
+
+	_, certPEM, err := c.post(ctx, o.Certificate, nil, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to download the certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// register gets the directory of the server and registers the account, once.
+func (c *Client) register(ctx context.Context) error {
+	if c.directory == nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.directoryURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, data, err := c.do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return problem(resp, data)
+		}
+		var d directory
+		if err := json.Unmarshal(data, &d); err != nil {
+			return fmt.Errorf("invalid directory: %w", err)
+		}
+		c.directory = &d
+	}
+	if len(c.accountURL) != 0 {
+		return nil
+	}
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if len(c.contact) != 0 {
+		account["contact"] = c.contact
+	}
+	payload, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	resp, _, err := c.post(ctx, c.directory.NewAccount, payload, true)
+	if err != nil {
+		return err
+	}
+	c.accountURL = resp.Header.Get("Location")
+	if len(c.accountURL) == 0 {
+		return errors.New("the server returned no account URL")
+	}
+	log.V(0).Info("registered ACME account", "directory", c.directoryURL, "account", c.accountURL)
+	return nil
+}
+
+// authorize proves the control of the identifier of an authorization with
+// its HTTP-01 challenge.
+func (c *Client) authorize(ctx context.Context, url string) error {
+	var authz authorization
+	resp, err := c.postJSON(ctx, url, nil, &authz)
+	if err != nil {
+		return fmt.Errorf("failed to get the authorization: %w", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	var chal *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "http-01" {
+			chal = &authz.Challenges[i]
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("the server offers no http-01 challenge for %s", authz.Identifier.Value)
+	}
+
+	if _, err := c.postJSON(ctx, chal.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("failed to respond to the challenge: %w", err)
+	}
+
+	for {
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "pending", "processing":
+		default:
+			for _, ch := range authz.Challenges {
+				if ch.Error != nil {
+					return fmt.Errorf("the authorization of %s is %s: %w", authz.Identifier.Value, authz.Status, ch.Error)
+				}
+			}
+			return fmt.Errorf("the authorization of %s is %s", authz.Identifier.Value, authz.Status)
+		}
+		if err := c.wait(ctx, resp); err != nil {
+			return err
+		}
+		if resp, err = c.postJSON(ctx, url, nil, &authz); err != nil {
+			return fmt.Errorf("failed to get the authorization: %w", err)
+		}
+	}
+}
+
+// wait waits for the Retry-After of resp, or for PollInterval.
+func (c *Client) wait(ctx context.Context, resp *http.Response) error {
+	d := c.PollInterval
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		d = time.Duration(seconds) * time.Second
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// postJSON sends payload, encoded in JSON, to url and decodes the response in
+// out if it is not nil. A nil payload is sent as a POST-as-GET request.
+func (c *Client) postJSON(ctx context.Context, url string, payload, out interface{}) (*http.Response, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	resp, data, err := c.post(ctx, url, body, false)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("invalid response of %s: %w", url, err)
+		}
+	}
+	return resp, nil
+}
+
+// post sends payload to url in a JWS signed by the account key, identified by
+// its JWK if jwk is set and by the account URL otherwise. The request is sent
+// again when the server rejects its nonce.
+func (c *Client) post(ctx context.Context, url string, payload []byte, jwk bool) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		nonce, err := c.nonce(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get a nonce: %w", err)
+		}
+		body, err := c.sign(url, nonce, payload, jwk)
+		if err != nil {
+			return nil, nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		resp, data, err := c.do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode < 400 {
+			return resp, data, nil
+		}
+		err = problem(resp, data)
+		if p, ok := err.(*Problem); !ok || p.Type != problemBadNonce || attempt >= maxBadNonceRetries {
+			return nil, nil, err
+		}
+	}
+}
+
+// do sends req and reads its response, keeping the nonce it returns.
+func (c *Client) do(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	if nonce := resp.Header.Get("Replay-Nonce"); len(nonce) != 0 {
+		c.nonces = append(c.nonces, nonce)
+	}
+	return resp, data, nil
+}
+
+// nonce returns an unused nonce, requesting a new one if there is none.
+func (c *Client) nonce(ctx context.Context) (string, error) {
+	if n := len(c.nonces); n != 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		return nonce, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.directory.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, data, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		return "", problem(resp, data)
+	}
+	return c.nonce(ctx)
+}
+
+// sign returns the JWS of payload for url, signed with the account key.
+func (c *Client) sign(url, nonce string, payload []byte, jwk bool) ([]byte, error) {
+	protected := map[string]interface{}{"alg": "ES256", "nonce": nonce, "url": url}
+	if jwk {
+		protected["jwk"] = accountJWK(c.key)
+	} else {
+		protected["kid"] = c.accountURL
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	signingInput := encode(header) + "." + encode(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return json.Marshal(map[string]string{
+		"protected": encode(header),
+		"payload":   encode(payload),
+		"signature": encode(signature),
+	})
+}
+
+// accountJWK returns the JWK of an account key.
+func accountJWK(key *ecdsa.PrivateKey) jsonWebKey {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return jsonWebKey{Crv: "P-256", Kty: "EC", X: encode(x), Y: encode(y)}
+}
+
+// thumbprint returns the JWK thumbprint of an account key (RFC 7638).
+func thumbprint(key *ecdsa.PrivateKey) (string, error) {
+	data, err := json.Marshal(accountJWK(key))
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(data)
+	return encode(digest[:]), nil
+}
+
+// problem returns the error of an unsuccessful response.
+func problem(resp *http.Response, data []byte) error {
+	p := &Problem{Status: resp.StatusCode}
+	if err := json.Unmarshal(data, p); err != nil || len(p.Type) == 0 {
+		return fmt.Errorf("acme: unexpected response %s from %s", resp.Status, resp.Request.URL)
+	}
+	p.Status = resp.StatusCode
+	return p
+}
+
+// encode encodes data in unpadded base64url.
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,415 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// acmeStub is an in-process ACME server, validating the HTTP-01 challenges
+// against a challenge server and issuing the certificates with a test CA.
+type acmeStub struct {
+	t      *testing.T
+	server *httptest.Server
+	// challengeURL is the URL of the challenge server the challenges are
+	// validated against.
+	challengeURL string
+
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+
+	lock   sync.Mutex
+	nonce  int
+	nonces map[string]bool
+	// badNonce is set for the server to reject the next request for its
+	// nonce.
+	badNonce bool
+	accounts map[string]*ecdsa.PublicKey
+	orders   map[string]*stubOrder
+}
+
+// stubOrder is an order of the stub, with a single authorization.
+type stubOrder struct {
+	host, token           string
+	authzStatus           string
+	authzError            string
+	status                string
+	certificate           []byte
+	pollsBeforeValidation int
+}
+
+func newACMEStub(t *testing.T, challengeURL string) *acmeStub {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ACME stub CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &acmeStub{
+		t:            t,
+		challengeURL: challengeURL,
+		ca:           ca,
+		caKey:        caKey,
+		nonces:       make(map[string]bool),
+		accounts:     make(map[string]*ecdsa.PublicKey),
+		orders:       make(map[string]*stubOrder),
+	}
+	s.server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *acmeStub) url(path string) string {
+	return s.server.URL + path
+}
+
+func (s *acmeStub) newNonce(w http.ResponseWriter) {
+	s.nonce++
+	nonce := fmt.Sprintf("nonce-%d", s.nonce)
+	s.nonces[nonce] = true
+	w.Header().Set("Replay-Nonce", nonce)
+}
+
+func (s *acmeStub) problem(w http.ResponseWriter, status int, problemType, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"type": problemType, "detail": detail})
+}
+
+func (s *acmeStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch {
+	case r.URL.Path == "/dir":
+		json.NewEncoder(w).Encode(map[string]string{"newNonce": s.url("/nonce"), "newAccount": s.url("/account"), "newOrder": s.url("/order")})
+		return
+	case r.URL.Path == "/nonce":
+		s.newNonce(w)
+		return
+	}
+
+	payload, key, ok := s.verify(w, r)
+	if !ok {
+		return
+	}
+	s.newNonce(w)
+	id := strings.TrimPrefix(r.URL.Path[1:], strings.SplitN(r.URL.Path[1:], "/", 2)[0]+"/")
+	o := s.orders[id]
+
+	switch {
+	case r.URL.Path == "/account":
+		accountURL := s.url(fmt.Sprintf("/accounts/%d", len(s.accounts)+1))
+		s.accounts[accountURL] = key
+		w.Header().Set("Location", accountURL)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"valid"}`))
+	case r.URL.Path == "/order":
+		var request struct {
+			Identifiers []identifier `json:"identifiers"`
+		}
+		if err := json.Unmarshal(payload, &request); err != nil || len(request.Identifiers) != 1 {
+			s.problem(w, http.StatusBadRequest, "urn:ietf:params:acme:error:malformed", "invalid order")
+			return
+		}
+		id = fmt.Sprintf("%d", len(s.orders)+1)
+		s.orders[id] = &stubOrder{host: request.Identifiers[0].Value, token: "token-" + id, authzStatus: "pending", status: "pending", pollsBeforeValidation: 1}
+		w.Header().Set("Location", s.url("/orders/"+id))
+		w.WriteHeader(http.StatusCreated)
+		s.writeOrder(w, id)
+	case strings.HasPrefix(r.URL.Path, "/orders/") && o != nil:
+		if o.status == "processing" {
+			o.status = "valid"
+		}
+		s.writeOrder(w, id)
+	case strings.HasPrefix(r.URL.Path, "/authz/") && o != nil:
+		if o.authzStatus == "processing" {
+			if o.pollsBeforeValidation--; o.pollsBeforeValidation < 0 {
+				s.validate(o, key)
+			}
+		}
+		challenge := map[string]interface{}{"type": "http-01", "url": s.url("/chall/" + id), "token": o.token, "status": o.authzStatus}
+		if len(o.authzError) != 0 {
+			challenge["error"] = map[string]string{"type": "urn:ietf:params:acme:error:unauthorized", "detail": o.authzError}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     o.authzStatus,
+			"identifier": identifier{Type: "dns", Value: o.host},
+			"challenges": []interface{}{map[string]interface{}{"type": "dns-01", "url": s.url("/chall/dns"), "token": "dns"}, challenge},
+		})
+	case strings.HasPrefix(r.URL.Path, "/chall/") && o != nil:
+		if string(payload) != "{}" {
+			s.problem(w, http.StatusBadRequest, "urn:ietf:params:acme:error:malformed", "expected an empty object")
+			return
+		}
+		o.authzStatus = "processing"
+		w.Write([]byte(`{"type":"http-01","status":"processing"}`))
+	case strings.HasPrefix(r.URL.Path, "/finalize/") && o != nil:
+		if o.authzStatus != "valid" {
+			s.problem(w, http.StatusForbidden, "urn:ietf:params:acme:error:orderNotReady", "the order is not ready")
+			return
+		}
+		s.finalize(w, o, payload)
+		if o.certificate != nil {
+			o.status = "processing"
+			s.writeOrder(w, id)
+		}
+	case strings.HasPrefix(r.URL.Path, "/cert/") && o != nil && o.certificate != nil:
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(o.certificate)
+	default:
+		s.problem(w, http.StatusNotFound, "urn:ietf:params:acme:error:malformed", "not found")
+	}
+}
+
+func (s *acmeStub) writeOrder(w http.ResponseWriter, id string) {
+	o := s.orders[id]
+	response := map[string]interface{}{
+		"status":         o.status,
+		"authorizations": []string{s.url("/authz/" + id)},
+		"finalize":       s.url("/finalize/" + id),
+	}
+	if o.status == "valid" {
+		response["certificate"] = s.url("/cert/" + id)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// verify checks the JWS of a request and returns its payload and the key of
+// its account.
+func (s *acmeStub) verify(w http.ResponseWriter, r *http.Request) ([]byte, *ecdsa.PublicKey, bool) {
+	var jws struct {
+		Protected, Payload, Signature string
+	}
+	body, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &jws); err != nil || r.Header.Get("Content-Type") != "application/jose+json" {
+		s.problem(w, http.StatusBadRequest, "urn:ietf:params:acme:error:malformed", "invalid JWS")
+		return nil, nil, false
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  *jsonWebKey
+	}
+	if err := json.Unmarshal(header, &protected); err != nil || protected.Alg != "ES256" || protected.URL != s.url(r.URL.Path) {
+		s.problem(w, http.StatusBadRequest, "urn:ietf:params:acme:error:malformed", "invalid protected header")
+		return nil, nil, false
+	}
+	if !s.nonces[protected.Nonce] || s.badNonce {
+		s.badNonce = false
+		s.newNonce(w)
+		s.problem(w, http.StatusBadRequest, problemBadNonce, "invalid nonce")
+		return nil, nil, false
+	}
+	delete(s.nonces, protected.Nonce)
+
+	var key *ecdsa.PublicKey
+	switch {
+	case protected.JWK != nil && r.URL.Path == "/account":
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK.Y)
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case protected.JWK == nil && s.accounts[protected.Kid] != nil:
+		key = s.accounts[protected.Kid]
+	default:
+		s.problem(w, http.StatusUnauthorized, "urn:ietf:params:acme:error:accountDoesNotExist", "unknown account")
+		return nil, nil, false
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(signature) != 64 || !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		s.problem(w, http.StatusUnauthorized, "urn:ietf:params:acme:error:malformed", "invalid signature")
+		return nil, nil, false
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload, key, true
+}
+
+// validate fetches the key authorization of the challenge of an order from the
+// challenge server.
+func (s *acmeStub) validate(o *stubOrder, key *ecdsa.PublicKey) {
+	o.authzStatus = "invalid"
+	resp, err := http.Get(s.challengeURL + ChallengePath + o.token)
+	if err != nil {
+		o.authzError = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	accountThumbprint, _ := thumbprint(&ecdsa.PrivateKey{PublicKey: *key})
+	if resp.StatusCode != http.StatusOK || string(body) != o.token+"."+accountThumbprint {
+		o.authzError = fmt.Sprintf("invalid response from %s: %d %q", o.host, resp.StatusCode, body)
+		return
+	}
+	o.authzStatus = "valid"
+}
+
+// finalize issues the certificate of the CSR of an order.
+func (s *acmeStub) finalize(w http.ResponseWriter, o *stubOrder, payload []byte) {
+	var request struct {
+		CSR string `json:"csr"`
+	}
+	json.Unmarshal(payload, &request)
+	der, _ := base64.RawURLEncoding.DecodeString(request.CSR)
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil || csr.CheckSignature() != nil || len(csr.DNSNames) != 1 || csr.DNSNames[0] != o.host {
+		s.problem(w, http.StatusBadRequest, "urn:ietf:params:acme:error:badCSR", "invalid CSR")
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, s.ca, csr.PublicKey, s.caKey)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	o.certificate = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.Raw})...)
+}
+
+func newTestClient(t *testing.T) (*Client, *acmeStub) {
+	key, err := GenerateAccountKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenges, err := NewChallengeServer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challengeServer := httptest.NewServer(challenges)
+	t.Cleanup(challengeServer.Close)
+	stub := newACMEStub(t, challengeServer.URL)
+
+	client, err := NewClient(stub.url("/dir"), key, "admin@example.com", stub.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.PollInterval = 10 * time.Millisecond
+	return client, stub
+}
+
+func TestObtain(t *testing.T) {
+	client, stub := newTestClient(t)
+	// The request rejected for its nonce is sent again.
+	stub.badNonce = true
+	ctx := context.Background()
+
+	for _, host := range []string{"www.example.com", "shop.example.com"} {
+		certPEM, keyPEM, err := client.Obtain(ctx, host)
+		if err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+		keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatalf("%s: invalid key pair: %v", host, err)
+		}
+		leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Errorf("%s: %v", host, err)
+		}
+		if len(keyPair.Certificate) != 2 {
+			t.Errorf("%s: expected the certificate and its CA, got %d certificates", host, len(keyPair.Certificate))
+		}
+	}
+	if len(stub.accounts) != 1 {
+		t.Errorf("expected a single account, got %d", len(stub.accounts))
+	}
+}
+
+func TestObtainInvalidChallenge(t *testing.T) {
+	client, stub := newTestClient(t)
+	// The challenges are validated against a server which doesn't serve
+	// them.
+	stub.challengeURL = stub.server.URL
+
+	_, _, err := client.Obtain(context.Background(), "www.example.com")
+	if err == nil || !strings.Contains(err.Error(), "the authorization of www.example.com is invalid") || !strings.Contains(err.Error(), "unauthorized") {
+		t.Fatalf("expected an invalid authorization error, got %v", err)
+	}
+}
+
+func TestChallengeServer(t *testing.T) {
+	key, err := GenerateAccountKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenges, err := NewChallengeServer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountThumbprint, err := thumbprint(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{method: http.MethodGet, path: ChallengePath + "token", status: http.StatusOK, body: "token." + accountThumbprint},
+		{method: http.MethodGet, path: ChallengePath + "other_Token-1", status: http.StatusOK, body: "other_Token-1." + accountThumbprint},
+		{method: http.MethodGet, path: ChallengePath, status: http.StatusNotFound},
+		{method: http.MethodGet, path: ChallengePath + "token/other", status: http.StatusNotFound},
+		{method: http.MethodGet, path: ChallengePath + "token.other", status: http.StatusNotFound},
+		{method: http.MethodPost, path: ChallengePath + "token", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/token", status: http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		challenges.ServeHTTP(w, httptest.NewRequest(tc.method, "http://www.example.com"+tc.path, nil))
+		if w.Code != tc.status || (tc.status == http.StatusOK && w.Body.String() != tc.body) {
+			t.Errorf("%s %s: unexpected response %d %q", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestChallengeServerKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewChallengeServer(key); err == nil {
+		t.Error("expected a P-384 key to be rejected")
+	}
+}
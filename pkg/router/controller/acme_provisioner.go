@@ -0,0 +1,393 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	kapi "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/workqueue"
+
+	routev1 "github.com/openshift/api/route/v1"
+	routelisters "github.com/openshift/client-go/route/listers/route/v1"
+
+	"github.com/openshift/router/pkg/router"
+	"github.com/openshift/router/pkg/router/writerlease"
+)
+
+const (
+	// ACMEIssuerAnnotation names the ACME issuer, among the issuers
+	// configured on the router, requesting the certificate of a route. The
+	// certificate is stored in the secret referenced by
+	// spec.tls.externalCertificate, which carries the same annotation.
+	ACMEIssuerAnnotation = "router.openshift.io/acme-issuer"
+
+	ACMEStatusReasonCertificateIssued = "ACMECertificateIssued"
+
+	// acmeObtainTimeout is the maximum duration of the request for a
+	// certificate.
+	acmeObtainTimeout = 5 * time.Minute
+)
+
+// ACMEIssuer requests certificates from an ACME server.
+type ACMEIssuer interface {
+	// Obtain requests a certificate for host. Returns the PEM encoded
+	// certificate chain and private key.
+	Obtain(ctx context.Context, host string) ([]byte, []byte, error)
+}
+
+// ACMEHostsRecorder is told the hosts of the routes requesting a certificate,
+// the only hosts whose HTTP-01 challenges are forwarded to the ACME challenge
+// server.
+type ACMEHostsRecorder interface {
+	// SetACMEHosts sets the hosts of the routes requesting a certificate,
+	// sorted.
+	SetACMEHosts(hosts []string)
+}
+
+// ACMEProvisioner implements the router.Plugin interface to request the
+// certificates of the routes annotated with an ACME issuer, and to renew them
+// before they expire. The certificates are stored in the secrets referenced by
+// spec.tls.externalCertificate, which the RouteSecretManager serves.
+//
+// The certificates are requested in the background: the routes are passed on
+// as is, and are evaluated again by the whole plugin chain once their secret
+// is created.
+//
+// The replicas of a router compete for the lease to request the certificates:
+// the followers only request them once the lease expires, and meanwhile pick
+// up the certificates the leader stored. Until a leader is elected, by storing
+// a certificate, the replicas may request the same certificate. Every replica
+// tracks the hosts of the routes requesting a certificate, to serve their
+// challenges whichever replica the ACME server reaches.
+type ACMEProvisioner struct {
+	// plugin is the next plugin in the chain.
+	plugin router.Plugin
+
+	// recorder is an interface for indicating route status.
+	recorder RouteStatusRecorder
+
+	// hostsRecorder is told the hosts of the routes requesting a
+	// certificate.
+	hostsRecorder ACMEHostsRecorder
+
+	// lease is the lease the replicas of the router compete for to request
+	// the certificates.
+	lease writerlease.Lease
+
+	issuers       map[string]ACMEIssuer
+	routerName    string
+	secretsGetter corev1client.SecretsGetter
+	routeLister   routelisters.RouteLister
+
+	// renewBefore is the time before the expiry of a certificate from
+	// which it is renewed.
+	renewBefore time.Duration
+
+	lock sync.Mutex
+	// routes holds the routes requesting a certificate, by namespace/name.
+	routes map[string]acmeRoute
+	queue  workqueue.TypedRateLimitingInterface[string]
+
+	// nowFn allows the plugin to be tested.
+	nowFn func() time.Time
+}
+
+// acmeRoute is a route requesting a certificate.
+type acmeRoute struct {
+	namespace, name, host, issuer, secret string
+	// renewAt is when the certificate of the route is to be renewed, zero
+	// until its secret is checked.
+	renewAt time.Time
+}
+
+// NewACMEProvisioner creates a plugin wrapper that requests the certificates
+// of the routes passed to the given plugin from the given issuers, by name.
+// Recorder is an interface for indicating route status updates, hostsRecorder
+// is told the hosts of the routes requesting a certificate. The certificates
+// are only requested while holding lease.
+func NewACMEProvisioner(plugin router.Plugin, recorder RouteStatusRecorder, hostsRecorder ACMEHostsRecorder, lease writerlease.Lease, issuers map[string]ACMEIssuer, renewBefore time.Duration, routerName string, secretsGetter corev1client.SecretsGetter, routeLister routelisters.RouteLister) *ACMEProvisioner {
+	return &ACMEProvisioner{
+		plugin:        plugin,
+		recorder:      recorder,
+		hostsRecorder: hostsRecorder,
+		lease:         lease,
+		issuers:       issuers,
+		routerName:    routerName,
+		secretsGetter: secretsGetter,
+		routeLister:   routeLister,
+		renewBefore:   renewBefore,
+		routes:        make(map[string]acmeRoute),
+		queue:         workqueue.NewTypedRateLimitingQueue(workqueue.NewTypedItemExponentialFailureRateLimiter[string](time.Minute, 6*time.Hour)),
+		nowFn:         time.Now,
+	}
+}
+
+// Run requests the certificates until stopCh is closed.
+func (p *ACMEProvisioner) Run(stopCh <-chan struct{}) {
+	go func() {
+		<-stopCh
+		p.queue.ShutDown()
+	}()
+	wait.Until(func() {
+		for p.processNextItem() {
+		}
+	}, time.Second, stopCh)
+}
+
+// HandleNode processes watch events on the node resource.
+func (p *ACMEProvisioner) HandleNode(eventType watch.EventType, node *kapi.Node) error {
+	return p.plugin.HandleNode(eventType, node)
+}
+
+// HandleEndpoints processes watch events on the Endpoints resource.
+func (p *ACMEProvisioner) HandleEndpoints(eventType watch.EventType, endpoints *kapi.Endpoints) error {
+	return p.plugin.HandleEndpoints(eventType, endpoints)
+}
+
+// HandleRoute processes watch events on the Route resource. It queues the
+// request for the certificate of the routes annotated with an ACME issuer,
+// when the route is new or changed, or when its certificate is to be renewed.
+func (p *ACMEProvisioner) HandleRoute(eventType watch.EventType, route *routev1.Route) error {
+	log.V(10).Info("HandleRoute: ACMEProvisioner")
+	key := generateKey(route.Namespace, route.Name)
+
+	p.lock.Lock()
+	previous, tracked := p.routes[key]
+	current, ok := p.acmeRoute(route)
+	switch {
+	case eventType == watch.Deleted || !ok:
+		if tracked {
+			p.untrack(key)
+			p.recordHosts()
+		}
+	case !tracked || previous.host != current.host || previous.issuer != current.issuer || previous.secret != current.secret:
+		p.routes[key] = current
+		p.queue.Forget(key)
+		p.queue.Add(key)
+		p.recordHosts()
+	case !previous.renewAt.IsZero() && !p.nowFn().Before(previous.renewAt):
+		p.queue.Add(key)
+	}
+	p.lock.Unlock()
+
+	return p.plugin.HandleRoute(eventType, route)
+}
+
+// HandleNamespaces limits the scope of valid routes to only those that match
+// the provided namespace list.
+func (p *ACMEProvisioner) HandleNamespaces(namespaces sets.String) error {
+	p.lock.Lock()
+	for key, route := range p.routes {
+		if !namespaces.Has(route.namespace) {
+			p.untrack(key)
+		}
+	}
+	p.recordHosts()
+	p.lock.Unlock()
+	return p.plugin.HandleNamespaces(namespaces)
+}
+
+// Commit commits the changes made to the wrapped plugin.
+func (p *ACMEProvisioner) Commit() error {
+	return p.plugin.Commit()
+}
+
+// untrack stops requesting the certificate of a route. Must be called while
+// holding the lock.
+func (p *ACMEProvisioner) untrack(key string) {
+	delete(p.routes, key)
+	p.queue.Forget(key)
+	p.lease.Remove(writerlease.WorkKey(key))
+}
+
+// recordHosts records the hosts of the routes requesting a certificate. Must
+// be called while holding the lock.
+func (p *ACMEProvisioner) recordHosts() {
+	hosts := make([]string, 0, len(p.routes))
+	for _, route := range p.routes {
+		hosts = append(hosts, route.host)
+	}
+	slices.Sort(hosts)
+	p.hostsRecorder.SetACMEHosts(slices.Compact(hosts))
+}
+
+// acmeRoute returns the certificate request of a route, false if the route is
+// not annotated with an ACME issuer or can't get a certificate.
+func (p *ACMEProvisioner) acmeRoute(route *routev1.Route) (acmeRoute, bool) {
+	issuer, ok := route.Annotations[ACMEIssuerAnnotation]
+	if !ok {
+		return acmeRoute{}, false
+	}
+	tls := route.Spec.TLS
+	switch {
+	case p.issuers[issuer] == nil:
+		log.V(0).Info("ignoring ACME issuer of route: unknown issuer", "namespace", route.Namespace, "route", route.Name, "issuer", issuer)
+	case len(route.Spec.Host) == 0 || route.Spec.WildcardPolicy == routev1.WildcardPolicySubdomain:
+		log.V(0).Info("ignoring ACME issuer of route: the HTTP-01 challenge requires a host and no wildcard", "namespace", route.Namespace, "route", route.Name, "issuer", issuer)
+	case !hasExternalCertificate(route) || (tls.Termination != routev1.TLSTerminationEdge && tls.Termination != routev1.TLSTerminationReencrypt):
+		log.V(0).Info("ignoring ACME issuer of route: an edge or reencrypt route with an external certificate is required", "namespace", route.Namespace, "route", route.Name, "issuer", issuer)
+	default:
+		return acmeRoute{
+			namespace: route.Namespace,
+			name:      route.Name,
+			host:      route.Spec.Host,
+			issuer:    issuer,
+			secret:    tls.ExternalCertificate.Name,
+		}, true
+	}
+	return acmeRoute{}, false
+}
+
+// processNextItem processes the next queued route under the lease, returns
+// false once the queue is shut down. Failed requests are retried with an
+// exponential backoff.
+func (p *ACMEProvisioner) processNextItem() bool {
+	key, quit := p.queue.Get()
+	if quit {
+		return false
+	}
+	defer p.queue.Done(key)
+
+	p.lease.Try(writerlease.WorkKey(key), func() (writerlease.WorkResult, bool) {
+		result, err := p.sync(key)
+		if err != nil {
+			log.Error(err, "failed to provision the ACME certificate of route", "route", key)
+			p.queue.AddRateLimited(key)
+			return result, false
+		}
+		p.queue.Forget(key)
+		return result, false
+	})
+	return true
+}
+
+// sync requests the certificate of a route if its secret does not exist or
+// holds a certificate which does not match the host of the route or is to be
+// renewed. Secrets which are not managed by the router are left untouched.
+// Returns the outcome for the lease: Extend once a certificate is stored,
+// Release if another replica stored the secret meanwhile.
+func (p *ACMEProvisioner) sync(key string) (writerlease.WorkResult, error) {
+	p.lock.Lock()
+	route, ok := p.routes[key]
+	p.lock.Unlock()
+	if !ok {
+		return writerlease.None, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), acmeObtainTimeout)
+	defer cancel()
+	secrets := p.secretsGetter.Secrets(route.namespace)
+	secret, err := secrets.Get(ctx, route.secret, metav1.GetOptions{})
+	switch {
+	case kerrors.IsNotFound(err):
+		secret = nil
+	case err != nil:
+		return writerlease.None, err
+	case len(secret.Annotations[ACMEIssuerAnnotation]) == 0:
+		log.V(0).Info("not provisioning the ACME certificate of route: the secret is not managed by the router", "route", key, "secret", route.secret)
+		return writerlease.None, nil
+	default:
+		if notAfter, ok := certificateNotAfter(secret.Data[kapi.TLSCertKey], route.host); ok && p.nowFn().Before(notAfter.Add(-p.renewBefore)) {
+			p.setRenewAt(key, route, notAfter.Add(-p.renewBefore))
+			// The certificate may have been stored by another
+			// replica: a follower keeps waiting for the lease to
+			// expire.
+			p.lease.Extend(writerlease.WorkKey(key))
+			return writerlease.None, nil
+		}
+	}
+
+	log.V(0).Info("requesting ACME certificate", "route", key, "host", route.host, "issuer", route.issuer)
+	certPEM, keyPEM, err := p.issuers[route.issuer].Obtain(ctx, route.host)
+	if err != nil {
+		return writerlease.None, err
+	}
+	notAfter, ok := certificateNotAfter(certPEM, route.host)
+	if !ok {
+		return writerlease.None, fmt.Errorf("the issued certificate is not valid for %s", route.host)
+	}
+
+	created := secret == nil
+	if created {
+		secret = &kapi.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: route.namespace, Name: route.secret},
+			Type:       kapi.SecretTypeTLS,
+		}
+	} else {
+		secret = secret.DeepCopy()
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[ACMEIssuerAnnotation] = route.issuer
+	secret.Data = map[string][]byte{kapi.TLSCertKey: certPEM, kapi.TLSPrivateKeyKey: keyPEM}
+	if created {
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	} else {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	switch {
+	case kerrors.IsAlreadyExists(err) || kerrors.IsConflict(err):
+		// Another replica stored the secret meanwhile.
+		return writerlease.Release, err
+	case err != nil:
+		return writerlease.None, err
+	}
+	log.V(0).Info("stored ACME certificate", "route", key, "secret", route.secret, "notAfter", notAfter)
+	p.setRenewAt(key, route, notAfter.Add(-p.renewBefore))
+
+	// The RouteSecretManager reacts to the updates of the secrets it
+	// watches, but a secret created for a route which was rejected for
+	// lacking it is not watched yet: update the route status for the whole
+	// plugin chain to evaluate the route again.
+	if created {
+		latest, err := p.routeLister.Routes(route.namespace).Get(route.name)
+		if err != nil {
+			log.Error(err, "failed to get route", "namespace", route.namespace, "route", route.name)
+			return writerlease.Extend, nil
+		}
+		msg := fmt.Sprintf("certificate issued by %q stored in secret %q", route.issuer, route.secret)
+		if isRouteAdmittedTrue(latest.DeepCopy(), p.routerName) {
+			p.recorder.RecordRouteUpdate(latest, ACMEStatusReasonCertificateIssued, msg)
+		} else {
+			p.recorder.RecordRouteRejection(latest, ACMEStatusReasonCertificateIssued, msg)
+		}
+	}
+	return writerlease.Extend, nil
+}
+
+// setRenewAt sets when the certificate of a route is to be renewed, unless the
+// route changed meanwhile.
+func (p *ACMEProvisioner) setRenewAt(key string, route acmeRoute, renewAt time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if current, ok := p.routes[key]; ok && current.host == route.host && current.issuer == route.issuer && current.secret == route.secret {
+		current.renewAt = renewAt
+		p.routes[key] = current
+	}
+}
+
+// certificateNotAfter returns the expiry of the leaf certificate of a PEM
+// chain, false if it can't be parsed or is not valid for host.
+func certificateNotAfter(certPEM []byte, host string) (time.Time, bool) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return time.Time{}, false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || cert.VerifyHostname(host) != nil {
+		return time.Time{}, false
+	}
+	return cert.NotAfter, true
+}
@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/workqueue"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	"github.com/openshift/router/pkg/router/writerlease"
+)
+
+// fakeACMEIssuer issues self-signed certificates expiring at notAfter.
+type fakeACMEIssuer struct {
+	t        *testing.T
+	notAfter time.Time
+	err      error
+	hosts    []string
+}
+
+func (i *fakeACMEIssuer) Obtain(ctx context.Context, host string) ([]byte, []byte, error) {
+	i.hosts = append(i.hosts, host)
+	if i.err != nil {
+		return nil, nil, i.err
+	}
+	c := newTestCertificate(i.t, nil, host, false, i.notAfter, host)
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(i.t, err)
+	return []byte(encodeTestCertificates(c)), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// acmeRecorder records the route status updates triggering the evaluation of
+// the routes whose secret was created.
+type acmeRecorder struct {
+	routeStatusRecorder
+	updates []string
+}
+
+func (r *acmeRecorder) RecordRouteRejection(route *routev1.Route, reason, message string) {
+	r.updates = append(r.updates, "rejection:"+reason)
+}
+
+func (r *acmeRecorder) RecordRouteUpdate(route *routev1.Route, reason, message string) {
+	r.updates = append(r.updates, "update:"+reason)
+}
+
+// acmeLease runs the work immediately, recording its results.
+type acmeLease struct {
+	noopLease
+	results  []writerlease.WorkResult
+	extended []writerlease.WorkKey
+}
+
+func (l *acmeLease) Try(key writerlease.WorkKey, fn writerlease.WorkFunc) {
+	result, _ := fn()
+	l.results = append(l.results, result)
+}
+
+func (l *acmeLease) Extend(key writerlease.WorkKey) {
+	l.extended = append(l.extended, key)
+}
+
+func (l *acmeLease) Remove(key writerlease.WorkKey) {
+}
+
+// acmeHostsRecorder records the hosts of the routes requesting a certificate.
+type acmeHostsRecorder struct {
+	hosts []string
+}
+
+func (r *acmeHostsRecorder) SetACMEHosts(hosts []string) {
+	r.hosts = hosts
+}
+
+func acmeTestRoute(issuer, host string, termination routev1.TLSTerminationType) *routev1.Route {
+	return &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "route", UID: "uid", Annotations: map[string]string{ACMEIssuerAnnotation: issuer}},
+		Spec: routev1.RouteSpec{
+			Host: host,
+			TLS: &routev1.TLSConfig{
+				Termination:         termination,
+				ExternalCertificate: &routev1.LocalObjectReference{Name: "tls"},
+			},
+		},
+	}
+}
+
+func TestACMEProvisioner(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+	issuer := &fakeACMEIssuer{t: t, notAfter: now.Add(90 * 24 * time.Hour)}
+	kubeClient := testclient.NewSimpleClientset()
+	route := acmeTestRoute("pebble", "www.example.com", routev1.TLSTerminationEdge)
+	recorder := &acmeRecorder{}
+	hostsRecorder := &acmeHostsRecorder{}
+	lease := &acmeLease{}
+	p := NewACMEProvisioner(&fakePlugin{}, recorder, hostsRecorder, lease, map[string]ACMEIssuer{"pebble": issuer}, 30*24*time.Hour, "test", kubeClient.CoreV1(), &routeLister{items: []*routev1.Route{route}})
+	p.nowFn = func() time.Time { return now }
+
+	// The certificate of a new route is requested and stored in its secret,
+	// which extends the lease.
+	require.NoError(t, p.HandleRoute(watch.Added, route))
+	assert.Equal(t, []string{"www.example.com"}, hostsRecorder.hosts)
+	require.Equal(t, 1, p.queue.Len())
+	require.True(t, p.processNextItem())
+	assert.Equal(t, []string{"www.example.com"}, issuer.hosts)
+	assert.Equal(t, []writerlease.WorkResult{writerlease.Extend}, lease.results)
+	secret, err := kubeClient.CoreV1().Secrets("ns").Get(context.Background(), "tls", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	assert.Equal(t, "pebble", secret.Annotations[ACMEIssuerAnnotation])
+	notAfter, ok := certificateNotAfter(secret.Data[corev1.TLSCertKey], "www.example.com")
+	require.True(t, ok)
+	assert.Equal(t, issuer.notAfter, notAfter)
+	// The route rejected for lacking its secret is evaluated again.
+	assert.Equal(t, []string{"rejection:" + ACMEStatusReasonCertificateIssued}, recorder.updates)
+
+	// The certificate is not requested again until it is to be renewed.
+	require.NoError(t, p.HandleRoute(watch.Modified, route))
+	assert.Equal(t, 0, p.queue.Len())
+	now = now.Add(61 * 24 * time.Hour)
+	require.NoError(t, p.HandleRoute(watch.Modified, route))
+	require.Equal(t, 1, p.queue.Len())
+	issuer.notAfter = now.Add(90 * 24 * time.Hour)
+	require.True(t, p.processNextItem())
+	assert.Len(t, issuer.hosts, 2)
+	secret, err = kubeClient.CoreV1().Secrets("ns").Get(context.Background(), "tls", metav1.GetOptions{})
+	require.NoError(t, err)
+	notAfter, _ = certificateNotAfter(secret.Data[corev1.TLSCertKey], "www.example.com")
+	assert.Equal(t, issuer.notAfter, notAfter)
+	// The RouteSecretManager reacts to the update of the secret.
+	assert.Len(t, recorder.updates, 1)
+
+	// A new host gets a new certificate, even if the current one is valid.
+	route = acmeTestRoute("pebble", "shop.example.com", routev1.TLSTerminationEdge)
+	require.NoError(t, p.HandleRoute(watch.Modified, route))
+	assert.Equal(t, []string{"shop.example.com"}, hostsRecorder.hosts)
+	require.True(t, p.processNextItem())
+	assert.Equal(t, "shop.example.com", issuer.hosts[2])
+
+	// Failed requests are retried.
+	issuer.err = errors.New("rate limited")
+	now = now.Add(61 * 24 * time.Hour)
+	require.NoError(t, p.HandleRoute(watch.Modified, route))
+	require.True(t, p.processNextItem())
+	assert.Equal(t, 1, p.queue.NumRequeues("ns/route"))
+
+	require.NoError(t, p.HandleRoute(watch.Deleted, route))
+	assert.Empty(t, p.routes)
+	assert.Empty(t, hostsRecorder.hosts)
+	assert.Equal(t, 0, p.queue.NumRequeues("ns/route"))
+}
+
+func TestACMEProvisionerReplicas(t *testing.T) {
+	now := time.Now()
+	issuer := &fakeACMEIssuer{t: t, notAfter: now.Add(90 * 24 * time.Hour)}
+	kubeClient := testclient.NewSimpleClientset()
+	route := acmeTestRoute("pebble", "www.example.com", routev1.TLSTerminationEdge)
+	lease := &acmeLease{}
+	p := NewACMEProvisioner(&fakePlugin{}, &acmeRecorder{}, &acmeHostsRecorder{}, lease, map[string]ACMEIssuer{"pebble": issuer}, 30*24*time.Hour, "test", kubeClient.CoreV1(), &routeLister{items: []*routev1.Route{route}})
+	p.queue = workqueue.NewTypedRateLimitingQueue(workqueue.NewTypedItemExponentialFailureRateLimiter[string](time.Millisecond, time.Second))
+
+	// Another replica stores the secret while the certificate is requested:
+	// the lease is released.
+	certPEM, keyPEM, err := (&fakeACMEIssuer{t: t, notAfter: now.Add(90 * 24 * time.Hour)}).Obtain(context.Background(), "www.example.com")
+	require.NoError(t, err)
+	kubeClient.PrependReactor("create", "secrets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "tls", Annotations: map[string]string{ACMEIssuerAnnotation: "pebble"}},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM},
+		}
+		require.NoError(t, kubeClient.Tracker().Add(secret))
+		return true, nil, kerrors.NewAlreadyExists(schema.GroupResource{Resource: "secrets"}, "tls")
+	})
+	require.NoError(t, p.HandleRoute(watch.Added, route))
+	require.True(t, p.processNextItem())
+	assert.Equal(t, []writerlease.WorkResult{writerlease.Release}, lease.results)
+	assert.Equal(t, 1, p.queue.NumRequeues("ns/route"))
+
+	// The certificate stored by the other replica is not requested again,
+	// and extends its lease.
+	require.True(t, p.processNextItem())
+	assert.Len(t, issuer.hosts, 1)
+	assert.Equal(t, []writerlease.WorkResult{writerlease.Release, writerlease.None}, lease.results)
+	assert.Equal(t, []writerlease.WorkKey{"ns/route"}, lease.extended)
+}
+
+func TestACMEProvisionerUnmanagedSecret(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "tls"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("key")},
+	}
+	issuer := &fakeACMEIssuer{t: t, notAfter: time.Now().Add(90 * 24 * time.Hour)}
+	kubeClient := testclient.NewSimpleClientset(secret)
+	route := acmeTestRoute("pebble", "www.example.com", routev1.TLSTerminationReencrypt)
+	p := NewACMEProvisioner(&fakePlugin{}, &acmeRecorder{}, &acmeHostsRecorder{}, &acmeLease{}, map[string]ACMEIssuer{"pebble": issuer}, 30*24*time.Hour, "test", kubeClient.CoreV1(), &routeLister{items: []*routev1.Route{route}})
+
+	require.NoError(t, p.HandleRoute(watch.Added, route))
+	require.True(t, p.processNextItem())
+	assert.Empty(t, issuer.hosts, "a secret not managed by the router must not be overwritten")
+	actual, err := kubeClient.CoreV1().Secrets("ns").Get(context.Background(), "tls", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, secret, actual)
+}
+
+func TestACMEProvisionerIgnoredRoutes(t *testing.T) {
+	wildcard := acmeTestRoute("pebble", "www.example.com", routev1.TLSTerminationEdge)
+	wildcard.Spec.WildcardPolicy = routev1.WildcardPolicySubdomain
+	noExternalCertificate := acmeTestRoute("pebble", "www.example.com", routev1.TLSTerminationEdge)
+	noExternalCertificate.Spec.TLS.ExternalCertificate = nil
+	noAnnotation := acmeTestRoute("pebble", "www.example.com", routev1.TLSTerminationEdge)
+	noAnnotation.Annotations = nil
+
+	testCases := map[string]*routev1.Route{
+		"no annotation":           noAnnotation,
+		"unknown issuer":          acmeTestRoute("letsencrypt", "www.example.com", routev1.TLSTerminationEdge),
+		"no host":                 acmeTestRoute("pebble", "", routev1.TLSTerminationEdge),
+		"wildcard route":          wildcard,
+		"passthrough route":       acmeTestRoute("pebble", "www.example.com", routev1.TLSTerminationPassthrough),
+		"no external certificate": noExternalCertificate,
+	}
+	for name, route := range testCases {
+		t.Run(name, func(t *testing.T) {
+			hostsRecorder := &acmeHostsRecorder{}
+			p := NewACMEProvisioner(&fakePlugin{}, &acmeRecorder{}, hostsRecorder, &acmeLease{}, map[string]ACMEIssuer{"pebble": &fakeACMEIssuer{t: t}}, 30*24*time.Hour, "test", testclient.NewSimpleClientset().CoreV1(), &routeLister{})
+			require.NoError(t, p.HandleRoute(watch.Added, route))
+			assert.Empty(t, p.routes)
+			assert.Empty(t, hostsRecorder.hosts)
+			assert.Equal(t, 0, p.queue.Len())
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	"github.com/openshift/router/pkg/router"
+	"github.com/openshift/router/pkg/router/routeapihelpers"
+)
+
+// RouteCertificateWarning is the route ingress condition set when the
+// certificate of a route is not valid for its host, or when its chain is
+// incomplete or was completed by the router. It is a warning: the route is
+// still served.
+const RouteCertificateWarning routev1.RouteIngressConditionType = "CertificateWarning"
+
+// The reasons of the CertificateWarning condition, from the most to the least
+// severe.
+const (
+	certificateWarningReasonHostMismatch    = "CertificateHostMismatch"
+	certificateWarningReasonIncompleteChain = "IncompleteCertificateChain"
+	certificateWarningReasonCompletedChain  = "CertificateChainCompleted"
+)
+
+// CertificateChainValidator implements the router.Plugin interface to build
+// the chain served for the certificate of the edge and reencrypt routes,
+// completing it from the intermediates of the chain builder, and optionally to
+// warn on the route status when the chain is incomplete, was completed, or
+// when the certificate is not valid for the host of the route. Routes are not
+// rejected.
+type CertificateChainValidator struct {
+	// plugin is the next plugin in the chain.
+	plugin router.Plugin
+
+	// recorder is an interface for indicating route status.
+	recorder RouteStatusRecorder
+
+	// builder builds the chains of the route certificates.
+	builder *routeapihelpers.ChainBuilder
+
+	// warnings is true if the CertificateWarning condition is set on the
+	// routes, rather than only cleared.
+	warnings bool
+}
+
+// NewCertificateChainValidator creates a plugin wrapper that completes the
+// certificate chains of the routes passed to the given plugin with the given
+// chain builder, warning on their status if warnings is true. Recorder is an
+// interface for indicating route status updates.
+func NewCertificateChainValidator(plugin router.Plugin, recorder RouteStatusRecorder, builder *routeapihelpers.ChainBuilder, warnings bool) *CertificateChainValidator {
+	return &CertificateChainValidator{
+		plugin:   plugin,
+		recorder: recorder,
+		builder:  builder,
+		warnings: warnings,
+	}
+}
+
+// HandleNode processes watch events on the node resource.
+func (p *CertificateChainValidator) HandleNode(eventType watch.EventType, node *kapi.Node) error {
+	return p.plugin.HandleNode(eventType, node)
+}
+
+// HandleEndpoints processes watch events on the Endpoints resource.
+func (p *CertificateChainValidator) HandleEndpoints(eventType watch.EventType, endpoints *kapi.Endpoints) error {
+	return p.plugin.HandleEndpoints(eventType, endpoints)
+}
+
+// HandleRoute processes watch events on the Route resource. It completes the
+// certificate chain of the route and sets or clears the CertificateWarning
+// condition.
+func (p *CertificateChainValidator) HandleRoute(eventType watch.EventType, route *routev1.Route) error {
+	log.V(10).Info("HandleRoute: CertificateChainValidator")
+	if eventType == watch.Deleted {
+		return p.plugin.HandleRoute(eventType, route)
+	}
+
+	tls := route.Spec.TLS
+	if tls == nil || len(tls.Certificate) == 0 || (tls.Termination != routev1.TLSTerminationEdge && tls.Termination != routev1.TLSTerminationReencrypt) {
+		p.recorder.RecordRouteConditionClear(route, RouteCertificateWarning)
+		return p.plugin.HandleRoute(eventType, route)
+	}
+
+	chain, err := p.builder.Build(tls.Certificate, tls.CACertificate)
+	if err != nil {
+		// The extended validation rejects the routes whose certificate
+		// can't be parsed.
+		log.V(4).Info("unable to build the certificate chain of the route", "namespace", route.Namespace, "name", route.Name, "error", err)
+		p.recorder.RecordRouteConditionClear(route, RouteCertificateWarning)
+		return p.plugin.HandleRoute(eventType, route)
+	}
+
+	var reasons, messages []string
+	if len(route.Spec.Host) > 0 {
+		if err := routeapihelpers.ValidateCertificateHost(tls.Certificate, route.Spec.Host, route.Spec.WildcardPolicy == routev1.WildcardPolicySubdomain); err != nil {
+			reasons = append(reasons, certificateWarningReasonHostMismatch)
+			messages = append(messages, err.Error())
+		}
+	}
+	if !chain.Complete() {
+		reasons = append(reasons, certificateWarningReasonIncompleteChain)
+		messages = append(messages, fmt.Sprintf("certificate chain is missing the issuer %s", quoteJoin(chain.Missing)))
+	}
+	if len(chain.Added) > 0 {
+		// The route of the informer cache is left as is, and completed
+		// again on its next event.
+		route = route.DeepCopy()
+		route.Spec.TLS.Certificate = chain.Certificate
+		reasons = append(reasons, certificateWarningReasonCompletedChain)
+		messages = append(messages, fmt.Sprintf("certificate chain was completed with %s", quoteJoin(chain.Added)))
+	}
+
+	if len(reasons) > 0 {
+		message := strings.Join(messages, "; ")
+		log.V(4).Info("route certificate warning", "namespace", route.Namespace, "name", route.Name, "reason", reasons[0], "message", message)
+		if p.warnings {
+			p.recorder.RecordRouteCondition(route, RouteCertificateWarning, reasons[0], message)
+			return p.plugin.HandleRoute(eventType, route)
+		}
+	}
+	p.recorder.RecordRouteConditionClear(route, RouteCertificateWarning)
+
+	return p.plugin.HandleRoute(eventType, route)
+}
+
+// HandleNamespaces limits the scope of valid routes to only those that match
+// the provided namespace list.
+func (p *CertificateChainValidator) HandleNamespaces(namespaces sets.String) error {
+	return p.plugin.HandleNamespaces(namespaces)
+}
+
+// Commit commits the changes made to the wrapped plugin.
+func (p *CertificateChainValidator) Commit() error {
+	return p.plugin.Commit()
+}
+
+// quoteJoin returns the quoted values, separated by commas.
+func quoteJoin(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, fmt.Sprintf("%q", value))
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	"github.com/openshift/router/pkg/router/routeapihelpers"
+)
+
+func TestCertificateChainValidator(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour)
+	root := newTestCertificate(t, nil, "Root CA", true, notAfter)
+	issuing := newTestCertificate(t, root, "Issuing CA", true, notAfter)
+	leaf := newTestCertificate(t, issuing, "www.example.com", false, notAfter, "www.example.com")
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	testCases := []struct {
+		name          string
+		host          string
+		tls           *routev1.TLSConfig
+		intermediates []*x509.Certificate
+		expected      string
+		certificate   string
+	}{
+		{
+			name: "insecure route",
+			host: "www.example.com",
+		},
+		{
+			name:        "complete chain",
+			host:        "www.example.com",
+			tls:         &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge, Certificate: encodeTestCertificates(leaf, issuing)},
+			certificate: encodeTestCertificates(leaf, issuing),
+		},
+		{
+			name:        "incomplete chain",
+			host:        "www.example.com",
+			tls:         &routev1.TLSConfig{Termination: routev1.TLSTerminationReencrypt, Certificate: encodeTestCertificates(leaf)},
+			expected:    `IncompleteCertificateChain: certificate chain is missing the issuer "CN=Issuing CA"`,
+			certificate: encodeTestCertificates(leaf),
+		},
+		{
+			name:          "completed chain",
+			host:          "www.example.com",
+			tls:           &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge, Certificate: encodeTestCertificates(leaf)},
+			intermediates: []*x509.Certificate{issuing.cert},
+			expected:      `CertificateChainCompleted: certificate chain was completed with "CN=Issuing CA"`,
+			certificate:   encodeTestCertificates(leaf, issuing),
+		},
+		{
+			name:          "host mismatch",
+			host:          "www.example.org",
+			tls:           &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge, Certificate: encodeTestCertificates(leaf)},
+			intermediates: []*x509.Certificate{issuing.cert},
+			expected:      `CertificateHostMismatch: certificate "CN=www.example.com" is not valid for "www.example.org"; certificate chain was completed with "CN=Issuing CA"`,
+			certificate:   encodeTestCertificates(leaf, issuing),
+		},
+		{
+			name: "passthrough route",
+			host: "www.example.org",
+			tls:  &routev1.TLSConfig{Termination: routev1.TLSTerminationPassthrough},
+		},
+		{
+			name: "default certificate",
+			host: "www.example.org",
+			tls:  &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := routeStatusRecorder{conditions: map[routev1.RouteIngressConditionType]map[string]string{RouteCertificateWarning: {"ns-route": "previous warning"}}}
+			next := &fakePlugin{}
+			validator := NewCertificateChainValidator(next, recorder, &routeapihelpers.ChainBuilder{Intermediates: tc.intermediates, Roots: roots}, true)
+			route := &routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "route", UID: "uid"},
+				Spec:       routev1.RouteSpec{Host: tc.host, TLS: tc.tls},
+			}
+			original := route.DeepCopy()
+
+			require.NoError(t, validator.HandleRoute(watch.Added, route))
+			assert.Equal(t, tc.expected, recorder.conditions[RouteCertificateWarning]["ns-route"])
+			if tc.tls != nil {
+				assert.Equal(t, tc.certificate, next.route.Spec.TLS.Certificate)
+			}
+			assert.Equal(t, original, route, "the route of the informer cache must not be modified")
+		})
+	}
+}
+
+// TestCertificateChainValidatorWithoutWarnings tests that the chains are
+// completed, and the previous warnings cleared, when the warnings are
+// disabled.
+func TestCertificateChainValidatorWithoutWarnings(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour)
+	root := newTestCertificate(t, nil, "Root CA", true, notAfter)
+	issuing := newTestCertificate(t, root, "Issuing CA", true, notAfter)
+	leaf := newTestCertificate(t, issuing, "www.example.com", false, notAfter, "www.example.com")
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	recorder := routeStatusRecorder{conditions: map[routev1.RouteIngressConditionType]map[string]string{RouteCertificateWarning: {"ns-route": "previous warning"}}}
+	next := &fakePlugin{}
+	validator := NewCertificateChainValidator(next, recorder, &routeapihelpers.ChainBuilder{Intermediates: []*x509.Certificate{issuing.cert}, Roots: roots}, false)
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "route", UID: "uid"},
+		Spec:       routev1.RouteSpec{Host: "www.example.org", TLS: &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge, Certificate: encodeTestCertificates(leaf)}},
+	}
+
+	require.NoError(t, validator.HandleRoute(watch.Added, route))
+	assert.Empty(t, recorder.conditions[RouteCertificateWarning])
+	assert.Equal(t, encodeTestCertificates(leaf, issuing), next.route.Spec.TLS.Certificate)
+}
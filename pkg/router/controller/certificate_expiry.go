@@ -0,0 +1,286 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	"github.com/openshift/router/pkg/router"
+	"github.com/openshift/router/pkg/router/defaultcert"
+	"github.com/openshift/router/pkg/router/routeapihelpers"
+)
+
+// RouteCertificateExpiring is the route ingress condition set when a
+// certificate served for the route expires soon or has expired. It is a
+// warning: the route is still served.
+const RouteCertificateExpiring routev1.RouteIngressConditionType = "CertificateExpiring"
+
+// The types of the certificates served for a route.
+const (
+	certificateTypeCertificate          = "certificate"
+	certificateTypeExternalCertificate  = "external_certificate"
+	certificateTypeCACertificate        = "ca_certificate"
+	certificateTypeDestinationCA        = "destination_ca"
+	certificateTypeDefaultCertificate   = "default_certificate"
+	certificateTypeDefaultDestinationCA = "default_destination_ca"
+)
+
+// certificateFile caches the expiry of a PEM file, read again when the file
+// is modified.
+type certificateFile struct {
+	lock    sync.Mutex
+	path    string
+	modTime time.Time
+	expiry  time.Time
+	ok      bool
+}
+
+// Expiry returns the expiry of the certificates of the file, false if the
+// file is not set or can't be read.
+func (f *certificateFile) Expiry() (time.Time, bool) {
+	if f == nil || len(f.path) == 0 {
+		return time.Time{}, false
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.V(4).Info("unable to read certificate file", "path", f.path, "error", err)
+		return time.Time{}, false
+	}
+	if !info.ModTime().Equal(f.modTime) {
+		f.modTime = info.ModTime()
+		f.ok = false
+		if data, err := os.ReadFile(f.path); err != nil {
+			log.V(4).Info("unable to read certificate file", "path", f.path, "error", err)
+		} else if expiry, err := routeapihelpers.CertificateExpiry(string(data)); err != nil {
+			log.V(4).Info("unable to parse certificate file", "path", f.path, "error", err)
+		} else {
+			f.expiry, f.ok = expiry, true
+		}
+	}
+	return f.expiry, f.ok
+}
+
+// CertificateExpiryMonitor implements the router.Plugin interface to track
+// the expiry of the certificates served for each route. It exports the
+// expiry times and warns on the route status when a certificate expires
+// within the warning window or has expired. Routes are not rejected.
+//
+// The routes are evaluated again on every event, including the periodic
+// resync of the route informer.
+type CertificateExpiryMonitor struct {
+	// plugin is the next plugin in the chain.
+	plugin router.Plugin
+
+	// recorder is an interface for indicating route status.
+	recorder RouteStatusRecorder
+
+	// warningWindow is the time before the expiry of a certificate from
+	// which the route status warns about it.
+	warningWindow time.Duration
+
+	// defaultCertificate and defaultDestinationCA are the files of the
+	// certificates used for the routes which don't specify their own.
+	defaultCertificate   *certificateFile
+	defaultDestinationCA *certificateFile
+
+	// defaultCertificates are the per-host default certificates, served in
+	// place of defaultCertificate for the routes whose host they match, and
+	// defaultCertificateFiles are their files, by path.
+	defaultCertificates     []defaultcert.Certificate
+	defaultCertificateFiles map[string]*certificateFile
+
+	// routes holds the exported certificate types of each route.
+	routes map[types.UID]monitoredRoute
+
+	// nowFn allows the plugin to be tested.
+	nowFn func() time.Time
+}
+
+// monitoredRoute is a route whose certificate expiry is exported.
+type monitoredRoute struct {
+	namespace, name string
+	types           []string
+}
+
+// NewCertificateExpiryMonitor creates a plugin wrapper that tracks the
+// expiry of the certificates served for the routes passed to the given
+// plugin, including the default certificate and the default destination CA
+// read from the given paths, and the per-host default certificates. Recorder
+// is an interface for indicating route status updates.
+func NewCertificateExpiryMonitor(plugin router.Plugin, recorder RouteStatusRecorder, warningWindow time.Duration, defaultCertificatePath, defaultDestinationCAPath string, defaultCertificates []defaultcert.Certificate) *CertificateExpiryMonitor {
+	registerMetrics()
+	files := make(map[string]*certificateFile, len(defaultCertificates))
+	for _, cert := range defaultCertificates {
+		files[cert.Path] = &certificateFile{path: cert.Path}
+	}
+	return &CertificateExpiryMonitor{
+		plugin:                  plugin,
+		recorder:                recorder,
+		warningWindow:           warningWindow,
+		defaultCertificate:      &certificateFile{path: defaultCertificatePath},
+		defaultDestinationCA:    &certificateFile{path: defaultDestinationCAPath},
+		defaultCertificates:     defaultCertificates,
+		defaultCertificateFiles: files,
+		routes:                  make(map[types.UID]monitoredRoute),
+		nowFn:                   time.Now,
+	}
+}
+
+// HandleNode processes watch events on the node resource.
+func (p *CertificateExpiryMonitor) HandleNode(eventType watch.EventType, node *kapi.Node) error {
+	return p.plugin.HandleNode(eventType, node)
+}
+
+// HandleEndpoints processes watch events on the Endpoints resource.
+func (p *CertificateExpiryMonitor) HandleEndpoints(eventType watch.EventType, endpoints *kapi.Endpoints) error {
+	return p.plugin.HandleEndpoints(eventType, endpoints)
+}
+
+// HandleRoute processes watch events on the Route resource. It exports the
+// expiry of the certificates of the route and sets or clears the
+// CertificateExpiring condition.
+func (p *CertificateExpiryMonitor) HandleRoute(eventType watch.EventType, route *routev1.Route) error {
+	log.V(10).Info("HandleRoute: CertificateExpiryMonitor")
+	p.forget(route.UID)
+
+	if eventType == watch.Deleted {
+		return p.plugin.HandleRoute(eventType, route)
+	}
+
+	expiries := p.certificateExpiries(route)
+	if len(expiries) > 0 {
+		monitored := monitoredRoute{namespace: route.Namespace, name: route.Name}
+		for certType, expiry := range expiries {
+			metricCertificateExpiry.WithLabelValues(route.Namespace, route.Name, certType).Set(float64(expiry.Unix()))
+			monitored.types = append(monitored.types, certType)
+		}
+		p.routes[route.UID] = monitored
+	}
+
+	if reason, message, expiring := p.expiryWarning(expiries); expiring {
+		log.V(4).Info("route certificate expiring", "namespace", route.Namespace, "name", route.Name, "reason", reason, "message", message)
+		p.recorder.RecordRouteCondition(route, RouteCertificateExpiring, reason, message)
+	} else {
+		p.recorder.RecordRouteConditionClear(route, RouteCertificateExpiring)
+	}
+
+	return p.plugin.HandleRoute(eventType, route)
+}
+
+// HandleNamespaces limits the scope of valid routes to only those that match
+// the provided namespace list, forgetting the routes of the other namespaces.
+func (p *CertificateExpiryMonitor) HandleNamespaces(namespaces sets.String) error {
+	for uid, route := range p.routes {
+		if !namespaces.Has(route.namespace) {
+			p.forget(uid)
+		}
+	}
+	return p.plugin.HandleNamespaces(namespaces)
+}
+
+// Commit commits the changes made to the wrapped plugin.
+func (p *CertificateExpiryMonitor) Commit() error {
+	return p.plugin.Commit()
+}
+
+// certificateExpiries returns the expiry of each certificate served for a
+// route, by certificate type.
+func (p *CertificateExpiryMonitor) certificateExpiries(route *routev1.Route) map[string]time.Time {
+	tls := route.Spec.TLS
+	if tls == nil || tls.Termination == routev1.TLSTerminationPassthrough {
+		return nil
+	}
+
+	expiries := make(map[string]time.Time)
+	add := func(certType, certPEM string) {
+		if len(certPEM) == 0 {
+			return
+		}
+		if expiry, err := routeapihelpers.CertificateExpiry(certPEM); err == nil {
+			expiries[certType] = expiry
+		}
+	}
+
+	switch {
+	case len(tls.Certificate) == 0:
+		if expiry, ok := p.defaultCertificateFor(route).Expiry(); ok {
+			expiries[certificateTypeDefaultCertificate] = expiry
+		}
+	case tls.ExternalCertificate != nil && len(tls.ExternalCertificate.Name) > 0:
+		// The route secret manager copied the certificate of the secret.
+		add(certificateTypeExternalCertificate, tls.Certificate)
+	default:
+		add(certificateTypeCertificate, tls.Certificate)
+	}
+	add(certificateTypeCACertificate, tls.CACertificate)
+
+	if tls.Termination == routev1.TLSTerminationReencrypt {
+		if len(tls.DestinationCACertificate) > 0 {
+			add(certificateTypeDestinationCA, tls.DestinationCACertificate)
+		} else if expiry, ok := p.defaultDestinationCA.Expiry(); ok {
+			expiries[certificateTypeDefaultDestinationCA] = expiry
+		}
+	}
+	return expiries
+}
+
+// defaultCertificateFor returns the file of the default certificate served for
+// a route without its own certificate: the per-host default certificate
+// matching its host, if any, or the default certificate.
+func (p *CertificateExpiryMonitor) defaultCertificateFor(route *routev1.Route) *certificateFile {
+	wildcard := route.Spec.WildcardPolicy == routev1.WildcardPolicySubdomain
+	if cert := defaultcert.Match(p.defaultCertificates, route.Spec.Host, wildcard); cert != nil {
+		return p.defaultCertificateFiles[cert.Path]
+	}
+	return p.defaultCertificate
+}
+
+// expiryWarning returns the reason and message of the CertificateExpiring
+// condition, or false if no certificate expires within the warning window.
+func (p *CertificateExpiryMonitor) expiryWarning(expiries map[string]time.Time) (string, string, bool) {
+	now := p.nowFn()
+	var expired, expiring []string
+	for certType, expiry := range expiries {
+		switch {
+		case !expiry.After(now):
+			expired = append(expired, fmt.Sprintf("%s expired at %s", certType, expiry.UTC().Format(time.RFC3339)))
+		case expiry.Sub(now) <= p.warningWindow:
+			expiring = append(expiring, fmt.Sprintf("%s expires at %s", certType, expiry.UTC().Format(time.RFC3339)))
+		}
+	}
+	sort.Strings(expired)
+	sort.Strings(expiring)
+
+	switch {
+	case len(expired) > 0:
+		return "CertificateExpired", strings.Join(append(expired, expiring...), ", "), true
+	case len(expiring) > 0:
+		return "CertificateExpiringSoon", strings.Join(expiring, ", "), true
+	}
+	return "", "", false
+}
+
+// forget removes the exported expiry of the certificates of a route.
+func (p *CertificateExpiryMonitor) forget(uid types.UID) {
+	route, ok := p.routes[uid]
+	if !ok {
+		return
+	}
+	for _, certType := range route.types {
+		metricCertificateExpiry.DeleteLabelValues(route.namespace, route.name, certType)
+	}
+	delete(p.routes, uid)
+}
@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	clientgotesting "k8s.io/client-go/testing"
+
+	routev1 "github.com/openshift/api/route/v1"
+	"github.com/openshift/client-go/route/clientset/versioned/fake"
+
+	"github.com/openshift/router/pkg/router/defaultcert"
+)
+
+// testCertificate is a test certificate and its key.
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCertificate returns an ECDSA certificate expiring at notAfter, issued
+// by issuer, self-signed if issuer is nil.
+func newTestCertificate(t *testing.T, issuer *testCertificate, commonName string, isCA bool, notAfter time.Time, dnsNames ...string) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              dnsNames,
+		NotBefore:             notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	parent, parentKey := template, key
+	if issuer != nil {
+		parent, parentKey = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCertificate{cert: cert, key: key}
+}
+
+// encodeTestCertificates returns the PEM encoding of the certificates.
+func encodeTestCertificates(certs ...*testCertificate) string {
+	var data []byte
+	for _, c := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})...)
+	}
+	return string(data)
+}
+
+func TestCertificateExpiryMonitor(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 10, 0, 0, 0, time.UTC)
+	valid := encodeTestCertificates(newTestCertificate(t, nil, "www.example.com", false, now.Add(90*24*time.Hour)))
+	expiring := encodeTestCertificates(newTestCertificate(t, nil, "www.example.com", false, now.Add(10*24*time.Hour)))
+	expired := encodeTestCertificates(newTestCertificate(t, nil, "www.example.com", false, now.Add(-time.Hour)))
+
+	dir := t.TempDir()
+	defaultCertificatePath := filepath.Join(dir, "default.pem")
+	require.NoError(t, os.WriteFile(defaultCertificatePath, []byte(expiring), 0600))
+	defaultDestinationCAPath := filepath.Join(dir, "service-ca.crt")
+	require.NoError(t, os.WriteFile(defaultDestinationCAPath, []byte(valid), 0600))
+	appsCertificatePath := filepath.Join(dir, "apps.pem")
+	require.NoError(t, os.WriteFile(appsCertificatePath, []byte(expired), 0600))
+	defaultCertificates := []defaultcert.Certificate{{Path: appsCertificatePath, DNSNames: []string{"*.apps.example.com"}}}
+
+	testCases := []struct {
+		name     string
+		host     string
+		tls      *routev1.TLSConfig
+		expiries map[string]time.Time
+		expected string
+	}{
+		{
+			name: "insecure route",
+		},
+		{
+			name:     "valid certificate",
+			tls:      &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge, Certificate: valid},
+			expiries: map[string]time.Time{"certificate": now.Add(90 * 24 * time.Hour)},
+		},
+		{
+			name:     "expiring certificate",
+			tls:      &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge, Certificate: expiring},
+			expiries: map[string]time.Time{"certificate": now.Add(10 * 24 * time.Hour)},
+			expected: "CertificateExpiringSoon: certificate expires at 2026-10-28T10:00:00Z",
+		},
+		{
+			name:     "expired destination CA",
+			tls:      &routev1.TLSConfig{Termination: routev1.TLSTerminationReencrypt, Certificate: valid, DestinationCACertificate: expired},
+			expiries: map[string]time.Time{"certificate": now.Add(90 * 24 * time.Hour), "destination_ca": now.Add(-time.Hour)},
+			expected: "CertificateExpired: destination_ca expired at 2026-10-18T09:00:00Z",
+		},
+		{
+			name:     "default certificate and destination CA",
+			tls:      &routev1.TLSConfig{Termination: routev1.TLSTerminationReencrypt},
+			expiries: map[string]time.Time{"default_certificate": now.Add(10 * 24 * time.Hour), "default_destination_ca": now.Add(90 * 24 * time.Hour)},
+			expected: "CertificateExpiringSoon: default_certificate expires at 2026-10-28T10:00:00Z",
+		},
+		{
+			name:     "per-host default certificate",
+			host:     "web.apps.example.com",
+			tls:      &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge},
+			expiries: map[string]time.Time{"default_certificate": now.Add(-time.Hour)},
+			expected: "CertificateExpired: default_certificate expired at 2026-10-18T09:00:00Z",
+		},
+		{
+			name:     "host matching no per-host default certificate",
+			host:     "www.example.com",
+			tls:      &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge},
+			expiries: map[string]time.Time{"default_certificate": now.Add(10 * 24 * time.Hour)},
+			expected: "CertificateExpiringSoon: default_certificate expires at 2026-10-28T10:00:00Z",
+		},
+		{
+			name: "external certificate",
+			tls: &routev1.TLSConfig{
+				Termination:         routev1.TLSTerminationEdge,
+				ExternalCertificate: &routev1.LocalObjectReference{Name: "tls"},
+				Certificate:         expired,
+				CACertificate:       valid,
+			},
+			expiries: map[string]time.Time{"external_certificate": now.Add(-time.Hour), "ca_certificate": now.Add(90 * 24 * time.Hour)},
+			expected: "CertificateExpired: external_certificate expired at 2026-10-18T09:00:00Z",
+		},
+		{
+			name: "passthrough route",
+			tls:  &routev1.TLSConfig{Termination: routev1.TLSTerminationPassthrough},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := routeStatusRecorder{conditions: map[routev1.RouteIngressConditionType]map[string]string{RouteCertificateExpiring: {"ns-route": "previous warning"}}}
+			monitor := NewCertificateExpiryMonitor(&fakePlugin{}, recorder, 30*24*time.Hour, defaultCertificatePath, defaultDestinationCAPath, defaultCertificates)
+			monitor.nowFn = func() time.Time { return now }
+			route := &routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "route", UID: "uid"},
+				Spec:       routev1.RouteSpec{Host: tc.host, TLS: tc.tls},
+			}
+
+			require.NoError(t, monitor.HandleRoute(watch.Added, route))
+			assert.Equal(t, tc.expected, recorder.conditions[RouteCertificateExpiring]["ns-route"])
+			assert.Equal(t, len(tc.expiries), testutil.CollectAndCount(metricCertificateExpiry, "router_certificate_expiry_timestamp_seconds"))
+			for certType, expiry := range tc.expiries {
+				assert.Equal(t, float64(expiry.Unix()), testutil.ToFloat64(metricCertificateExpiry.WithLabelValues("ns", "route", certType)), certType)
+			}
+
+			require.NoError(t, monitor.HandleRoute(watch.Deleted, route))
+			assert.Equal(t, 0, testutil.CollectAndCount(metricCertificateExpiry), "the expiry of a deleted route must be removed")
+		})
+	}
+}
+
+func TestStatusCertificateExpiring(t *testing.T) {
+	now := nowFn()
+	nowFn = func() metav1.Time { return now }
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{Name: "route1", Namespace: "default", UID: types.UID("uid1")},
+		Spec:       routev1.RouteSpec{Host: "route1.test.local"},
+		Status: routev1.RouteStatus{Ingress: []routev1.RouteIngress{{
+			Host:       "route1.test.local",
+			RouterName: "test",
+			Conditions: []routev1.RouteIngressCondition{{Type: routev1.RouteAdmitted, Status: corev1.ConditionTrue, LastTransitionTime: &now}},
+		}}},
+	}
+	c := fake.NewSimpleClientset(route)
+	lister := &routeLister{items: []*routev1.Route{route}}
+	admitter := NewStatusAdmitter(&fakePlugin{}, c.RouteV1(), lister, "test", "", noopLease{}, &fakeTracker{})
+
+	admitter.RecordRouteCondition(route, RouteCertificateExpiring, "CertificateExpired", "certificate expired at 2026-10-18T09:00:00Z")
+	require.Len(t, c.Actions(), 1)
+	obj := c.Actions()[0].(clientgotesting.UpdateAction).GetObject().(*routev1.Route)
+	require.Len(t, obj.Status.Ingress[0].Conditions, 2)
+	condition := obj.Status.Ingress[0].Conditions[1]
+	assert.Equal(t, RouteCertificateExpiring, condition.Type)
+	assert.Equal(t, corev1.ConditionTrue, condition.Status)
+	assert.Equal(t, "CertificateExpired", condition.Reason)
+
+	// The route is still admitted once the warning is cleared.
+	lister.items = []*routev1.Route{obj}
+	admitter.RecordRouteConditionClear(obj, RouteCertificateExpiring)
+	require.Len(t, c.Actions(), 2)
+	obj = c.Actions()[1].(clientgotesting.UpdateAction).GetObject().(*routev1.Route)
+	require.Len(t, obj.Status.Ingress[0].Conditions, 1)
+	assert.Equal(t, routev1.RouteAdmitted, obj.Status.Ingress[0].Conditions[0].Type)
+
+	// Nothing is updated when there is no warning to clear.
+	lister.items = []*routev1.Route{obj}
+	admitter.RecordRouteConditionClear(obj, RouteCertificateExpiring)
+	assert.Len(t, c.Actions(), 2)
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"fmt"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	"github.com/openshift/router/pkg/router"
+	"github.com/openshift/router/pkg/router/defaultcert"
+)
+
+// RouteDefaultCertificateMismatch is the route ingress condition set when a
+// route without its own certificate matches none of the default certificates.
+// It is a warning: the route is still served, with the certificate given by
+// --default-certificate which the router serves for the unmatched hosts.
+const RouteDefaultCertificateMismatch routev1.RouteIngressConditionType = "DefaultCertificateMismatch"
+
+// DefaultCertificateMatcher implements the router.Plugin interface to warn on
+// the status of the edge and reencrypt routes without their own certificate
+// whose host matches none of the default certificates. Routes are not
+// rejected.
+type DefaultCertificateMatcher struct {
+	// plugin is the next plugin in the chain.
+	plugin router.Plugin
+
+	// recorder is an interface for indicating route status.
+	recorder RouteStatusRecorder
+
+	// certificates are the default certificates the routes are matched
+	// against.
+	certificates []defaultcert.Certificate
+}
+
+// NewDefaultCertificateMatcher creates a plugin wrapper that warns on the
+// status of the routes passed to the given plugin which match none of the
+// given default certificates. Recorder is an interface for indicating route
+// status updates.
+func NewDefaultCertificateMatcher(plugin router.Plugin, recorder RouteStatusRecorder, certificates []defaultcert.Certificate) *DefaultCertificateMatcher {
+	return &DefaultCertificateMatcher{
+		plugin:       plugin,
+		recorder:     recorder,
+		certificates: certificates,
+	}
+}
+
+// HandleNode processes watch events on the node resource.
+func (p *DefaultCertificateMatcher) HandleNode(eventType watch.EventType, node *kapi.Node) error {
+	return p.plugin.HandleNode(eventType, node)
+}
+
+// HandleEndpoints processes watch events on the Endpoints resource.
+func (p *DefaultCertificateMatcher) HandleEndpoints(eventType watch.EventType, endpoints *kapi.Endpoints) error {
+	return p.plugin.HandleEndpoints(eventType, endpoints)
+}
+
+// HandleRoute processes watch events on the Route resource. It sets or clears
+// the DefaultCertificateMismatch condition.
+func (p *DefaultCertificateMatcher) HandleRoute(eventType watch.EventType, route *routev1.Route) error {
+	log.V(10).Info("HandleRoute: DefaultCertificateMatcher")
+	if eventType == watch.Deleted {
+		return p.plugin.HandleRoute(eventType, route)
+	}
+
+	if p.usesDefaultCertificate(route) {
+		wildcard := route.Spec.WildcardPolicy == routev1.WildcardPolicySubdomain
+		if defaultcert.Match(p.certificates, route.Spec.Host, wildcard) == nil {
+			message := fmt.Sprintf("no default certificate is valid for host %q", route.Spec.Host)
+			log.V(4).Info("route matches no default certificate", "namespace", route.Namespace, "name", route.Name, "host", route.Spec.Host)
+			p.recorder.RecordRouteCondition(route, RouteDefaultCertificateMismatch, "NoMatchingDefaultCertificate", message)
+			return p.plugin.HandleRoute(eventType, route)
+		}
+	}
+	p.recorder.RecordRouteConditionClear(route, RouteDefaultCertificateMismatch)
+
+	return p.plugin.HandleRoute(eventType, route)
+}
+
+// HandleNamespaces limits the scope of valid routes to only those that match
+// the provided namespace list.
+func (p *DefaultCertificateMatcher) HandleNamespaces(namespaces sets.String) error {
+	return p.plugin.HandleNamespaces(namespaces)
+}
+
+// Commit commits the changes made to the wrapped plugin.
+func (p *DefaultCertificateMatcher) Commit() error {
+	return p.plugin.Commit()
+}
+
+// usesDefaultCertificate returns true if the route is served with a default
+// certificate: an edge or reencrypt route with a host and without its own
+// certificate.
+func (p *DefaultCertificateMatcher) usesDefaultCertificate(route *routev1.Route) bool {
+	tls := route.Spec.TLS
+	if tls == nil || len(tls.Certificate) != 0 || len(route.Spec.Host) == 0 {
+		return false
+	}
+	return tls.Termination == routev1.TLSTerminationEdge || tls.Termination == routev1.TLSTerminationReencrypt
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	"github.com/openshift/router/pkg/router/defaultcert"
+)
+
+func TestDefaultCertificateMatcher(t *testing.T) {
+	certificates := []defaultcert.Certificate{
+		{Path: "/etc/pki/apps.pem", DNSNames: []string{"*.apps.example.com"}},
+		{Path: "/etc/pki/www.pem", DNSNames: []string{"www.example.com"}},
+	}
+
+	testCases := []struct {
+		name     string
+		host     string
+		wildcard routev1.WildcardPolicyType
+		tls      *routev1.TLSConfig
+		expected string
+	}{
+		{
+			name: "insecure route",
+			host: "www.example.org",
+		},
+		{
+			name: "exact match",
+			host: "www.example.com",
+			tls:  &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge},
+		},
+		{
+			name: "wildcard match",
+			host: "shop.apps.example.com",
+			tls:  &routev1.TLSConfig{Termination: routev1.TLSTerminationReencrypt},
+		},
+		{
+			name:     "wildcard route",
+			host:     "www.apps.example.com",
+			wildcard: routev1.WildcardPolicySubdomain,
+			tls:      &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge},
+		},
+		{
+			name:     "wildcard route without a wildcard certificate",
+			host:     "www.example.com",
+			wildcard: routev1.WildcardPolicySubdomain,
+			tls:      &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge},
+			expected: `NoMatchingDefaultCertificate: no default certificate is valid for host "www.example.com"`,
+		},
+		{
+			name:     "no match",
+			host:     "www.example.org",
+			tls:      &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge},
+			expected: `NoMatchingDefaultCertificate: no default certificate is valid for host "www.example.org"`,
+		},
+		{
+			name: "own certificate",
+			host: "www.example.org",
+			tls:  &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge, Certificate: "cert"},
+		},
+		{
+			name: "passthrough route",
+			host: "www.example.org",
+			tls:  &routev1.TLSConfig{Termination: routev1.TLSTerminationPassthrough},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := routeStatusRecorder{conditions: map[routev1.RouteIngressConditionType]map[string]string{RouteDefaultCertificateMismatch: {"ns-route": "previous warning"}}}
+			matcher := NewDefaultCertificateMatcher(&fakePlugin{}, recorder, certificates)
+			route := &routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "route", UID: "uid"},
+				Spec:       routev1.RouteSpec{Host: tc.host, WildcardPolicy: tc.wildcard, TLS: tc.tls},
+			}
+
+			require.NoError(t, matcher.HandleRoute(watch.Added, route))
+			assert.Equal(t, tc.expected, recorder.conditions[RouteDefaultCertificateMismatch]["ns-route"])
+		})
+	}
+}
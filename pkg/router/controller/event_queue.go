@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// EventQueue queues the events an informer delivers for a resource until the
+// router controller handles them, one at a time and in order. The informers
+// otherwise buffer the events the router controller is too slow to handle
+// where the time they wait cannot be observed.
+type EventQueue struct {
+	resource string
+	handle   func(watch.EventType, interface{})
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	events  []queuedEvent
+	stopped bool
+}
+
+// queuedEvent is an informer event and the time it was queued.
+type queuedEvent struct {
+	eventType watch.EventType
+	obj       interface{}
+	queued    time.Time
+}
+
+// NewEventQueue returns a queue handling the events of the given resource
+// with handle once Run is called.
+func NewEventQueue(resource string, handle func(watch.EventType, interface{})) *EventQueue {
+	q := &EventQueue{
+		resource: resource,
+		handle:   handle,
+	}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// Add queues an event delivered by the informer.
+func (q *EventQueue) Add(eventType watch.EventType, obj interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.events = append(q.events, queuedEvent{eventType: eventType, obj: obj, queued: time.Now()})
+	q.cond.Signal()
+}
+
+// Run handles the queued events until stopCh is closed, observing the time
+// each of them waited in the queue.
+func (q *EventQueue) Run(stopCh <-chan struct{}) {
+	go func() {
+		<-stopCh
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		q.stopped = true
+		q.cond.Broadcast()
+	}()
+
+	for {
+		event, ok := q.next()
+		if !ok {
+			return
+		}
+		metricInformerEventLag.WithLabelValues(q.resource).Observe(time.Since(event.queued).Seconds())
+		q.handle(event.eventType, event.obj)
+	}
+}
+
+// next waits for the next queued event, returning false once the queue is
+// stopped.
+func (q *EventQueue) next() (queuedEvent, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.events) == 0 && !q.stopped {
+		q.cond.Wait()
+	}
+	if q.stopped {
+		return queuedEvent{}, false
+	}
+	event := q.events[0]
+	q.events[0] = queuedEvent{}
+	q.events = q.events[1:]
+	return event, true
+}
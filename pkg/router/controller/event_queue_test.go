@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	client_model "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// TestEventQueueLag tests that the events are handled in order and that the
+// time an event waits behind a slow one is observed as its lag.
+func TestEventQueueLag(t *testing.T) {
+	handled := make(chan string, 2)
+	queue := NewEventQueue("testresource", func(eventType watch.EventType, obj interface{}) {
+		if obj.(string) == "slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		handled <- obj.(string)
+	})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go queue.Run(stopCh)
+
+	queue.Add(watch.Added, "slow")
+	queue.Add(watch.Modified, "next")
+
+	for _, expected := range []string{"slow", "next"} {
+		select {
+		case obj := <-handled:
+			assert.Equal(t, expected, obj)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q to be handled", expected)
+		}
+	}
+
+	var m client_model.Metric
+	require.NoError(t, metricInformerEventLag.WithLabelValues("testresource").(prometheus.Metric).Write(&m))
+	assert.Equal(t, uint64(2), m.GetHistogram().GetSampleCount())
+	assert.GreaterOrEqual(t, m.GetHistogram().GetSampleSum(), 0.05, "the time waited behind the slow event must be observed")
+}
+
+// TestEventQueueStop tests that a stopped queue no longer handles events.
+func TestEventQueueStop(t *testing.T) {
+	queue := NewEventQueue("testresource", func(watch.EventType, interface{}) {
+		t.Error("unexpected event handled by a stopped queue")
+	})
+	stopCh := make(chan struct{})
+	close(stopCh)
+
+	done := make(chan struct{})
+	go func() {
+		queue.Run(stopCh)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the queue to stop")
+	}
+	queue.Add(watch.Added, "ignored")
+}
@@ -41,6 +41,10 @@ func (r *fakeTestRecorder) RecordRouteUpdate(route *routev1.Route, reason, messa
 func (r *fakeTestRecorder) RecordRouteUnservableInFutureVersions(route *routev1.Route, reason, message string) {
 }
 func (r *fakeTestRecorder) RecordRouteUnservableInFutureVersionsClear(route *routev1.Route) {}
+func (r *fakeTestRecorder) RecordRouteCondition(route *routev1.Route, conditionType routev1.RouteIngressConditionType, reason, message string) {
+}
+func (r *fakeTestRecorder) RecordRouteConditionClear(route *routev1.Route, conditionType routev1.RouteIngressConditionType) {
+}
 
 func Test_checkRestrictedIP(t *testing.T) {
 	tests := []struct {
@@ -100,7 +100,7 @@ func (f *RouterControllerFactory) Create(plugin router.Plugin, watchNodes bool,
 
 	f.initInformers(rc, stopCh)
 	f.processExistingItems(rc)
-	f.registerInformerEventHandlers(rc)
+	f.registerInformerEventHandlers(rc, stopCh)
 	return rc
 }
 
@@ -132,14 +132,14 @@ func (f *RouterControllerFactory) initInformers(rc *routercontroller.RouterContr
 	}
 }
 
-func (f *RouterControllerFactory) registerInformerEventHandlers(rc *routercontroller.RouterController) {
+func (f *RouterControllerFactory) registerInformerEventHandlers(rc *routercontroller.RouterController, stopCh <-chan struct{}) {
 	if f.NamespaceLabels != nil {
-		f.registerSharedInformerEventHandlers(&kapi.Namespace{}, rc.HandleNamespace)
+		f.registerSharedInformerEventHandlers(&kapi.Namespace{}, "namespace", rc.HandleNamespace, stopCh)
 	}
 	if f.watchEndpoints {
-		f.registerSharedInformerEventHandlers(&kapi.Endpoints{}, rc.HandleEndpoints)
+		f.registerSharedInformerEventHandlers(&kapi.Endpoints{}, "endpoints", rc.HandleEndpoints, stopCh)
 	} else {
-		f.registerSharedInformerEventHandlers(&discoveryv1.EndpointSlice{}, func(eventType watch.EventType, obj interface{}) {
+		f.registerSharedInformerEventHandlers(&discoveryv1.EndpointSlice{}, "endpointslice", func(eventType watch.EventType, obj interface{}) {
 			eps := obj.(*discoveryv1.EndpointSlice)
 			if serviceName := endpointSliceServiceName(eps); len(serviceName) == 0 {
 				log.V(4).Info("EndpointSlice has no service name", "namespace", eps.Namespace, "name", eps.Name, "label", discoveryv1.LabelServiceName)
@@ -148,13 +148,13 @@ func (f *RouterControllerFactory) registerInformerEventHandlers(rc *routercontro
 				objMeta.Name = serviceName
 				rc.HandleEndpointSlice(eventType, *objMeta, f.aggregateEndpointSlice(eps.Namespace, serviceName))
 			}
-		})
+		}, stopCh)
 	}
 
-	f.registerSharedInformerEventHandlers(&routev1.Route{}, rc.HandleRoute)
+	f.registerSharedInformerEventHandlers(&routev1.Route{}, "route", rc.HandleRoute, stopCh)
 
 	if rc.WatchNodes {
-		f.registerSharedInformerEventHandlers(&kapi.Node{}, rc.HandleNode)
+		f.registerSharedInformerEventHandlers(&kapi.Node{}, "node", rc.HandleNode, stopCh)
 	}
 
 }
@@ -346,8 +346,10 @@ func (f *RouterControllerFactory) createNamespacesSharedInformer() {
 	f.informers[objType] = informer
 }
 
-func (f *RouterControllerFactory) registerSharedInformerEventHandlers(obj runtime.Object,
-	handleFunc func(watch.EventType, interface{})) {
+// registerSharedInformerEventHandlers queues the events of the shared informer
+// of obj for handleFunc, which handles them in order until stopCh is closed.
+func (f *RouterControllerFactory) registerSharedInformerEventHandlers(obj runtime.Object, resource string,
+	handleFunc func(watch.EventType, interface{}), stopCh <-chan struct{}) {
 	objType := reflect.TypeOf(obj)
 	informer, ok := f.informers[objType]
 	if !ok {
@@ -355,12 +357,15 @@ func (f *RouterControllerFactory) registerSharedInformerEventHandlers(obj runtim
 		return
 	}
 
+	queue := routercontroller.NewEventQueue(resource, handleFunc)
+	go queue.Run(stopCh)
+
 	informer.AddEventHandler(kcache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			handleFunc(watch.Added, obj)
+			queue.Add(watch.Added, obj)
 		},
 		UpdateFunc: func(_, obj interface{}) {
-			handleFunc(watch.Modified, obj)
+			queue.Add(watch.Modified, obj)
 		},
 		DeleteFunc: func(obj interface{}) {
 			if objType != reflect.TypeOf(obj) {
@@ -376,7 +381,7 @@ func (f *RouterControllerFactory) registerSharedInformerEventHandlers(obj runtim
 					return
 				}
 			}
-			handleFunc(watch.Deleted, obj)
+			queue.Add(watch.Deleted, obj)
 		},
 	})
 }
@@ -26,6 +26,9 @@ const (
 type routeStatusRecorder struct {
 	rejections                 map[string]string
 	unservableInFutureVersions map[string]string
+	// conditions holds the "reason: message" of the conditions recorded
+	// by route, by condition type. The conditions are not recorded if nil.
+	conditions map[routev1.RouteIngressConditionType]map[string]string
 }
 
 func (_ routeStatusRecorder) rejectionKey(route *routev1.Route) string {
@@ -48,6 +51,20 @@ func (r routeStatusRecorder) RecordRouteUnservableInFutureVersions(route *routev
 	r.unservableInFutureVersions[r.rejectionKey(route)] = reason
 }
 
+func (r routeStatusRecorder) RecordRouteCondition(route *routev1.Route, conditionType routev1.RouteIngressConditionType, reason, message string) {
+	if r.conditions == nil {
+		return
+	}
+	if r.conditions[conditionType] == nil {
+		r.conditions[conditionType] = make(map[string]string)
+	}
+	r.conditions[conditionType][r.rejectionKey(route)] = reason + ": " + message
+}
+
+func (r routeStatusRecorder) RecordRouteConditionClear(route *routev1.Route, conditionType routev1.RouteIngressConditionType) {
+	delete(r.conditions[conditionType], r.rejectionKey(route))
+}
+
 func (r routeStatusRecorder) Clear() {
 	r.rejections = make(map[string]string)
 }
@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"sync/atomic"
+	"time"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	"github.com/openshift/router/pkg/router"
+)
+
+const (
+	// hostAlreadyClaimedReason is the rejection reason of the routes whose
+	// host is claimed by another route.
+	hostAlreadyClaimedReason = "HostAlreadyClaimed"
+
+	// noTermination is the termination label of the insecure routes.
+	noTermination = "none"
+)
+
+// InstrumentedPlugin implements the router.Plugin interface to count and
+// time the events handled by the plugin it wraps.
+type InstrumentedPlugin struct {
+	name   string
+	plugin router.Plugin
+	// inner is the instrumented plugin the wrapped plugin delegates to, if
+	// any, whose time is excluded from the time of this plugin.
+	inner *InstrumentedPlugin
+	// elapsed is the total time spent handling events by this plugin and the
+	// plugins it delegates to, in nanoseconds.
+	elapsed atomic.Int64
+}
+
+// NewInstrumentedPlugin creates a plugin wrapper that records the events
+// handled by the given plugin, and the time spent handling them, under the
+// given plugin name. inner is the instrumented plugin the given plugin
+// delegates to, if any, so that only the time spent in the given plugin
+// itself is recorded.
+func NewInstrumentedPlugin(name string, plugin router.Plugin, inner *InstrumentedPlugin) *InstrumentedPlugin {
+	registerMetrics()
+	return &InstrumentedPlugin{name: name, plugin: plugin, inner: inner}
+}
+
+// handle calls the wrapped plugin and records the event.
+func (p *InstrumentedPlugin) handle(resource string, eventType watch.EventType, handle func() error) error {
+	var innerStart int64
+	if p.inner != nil {
+		innerStart = p.inner.elapsed.Load()
+	}
+	start := time.Now()
+	err := handle()
+	elapsed := time.Since(start)
+	p.elapsed.Add(int64(elapsed))
+
+	exclusive := elapsed
+	if p.inner != nil {
+		// The events handled concurrently by the inner plugin, e.g. the
+		// secret events of the route secret manager, may be counted here.
+		exclusive = max(0, elapsed-time.Duration(p.inner.elapsed.Load()-innerStart))
+	}
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	metricPluginEvents.WithLabelValues(p.name, resource, string(eventType), result).Inc()
+	metricPluginEventDuration.WithLabelValues(p.name, resource).Observe(exclusive.Seconds())
+	return err
+}
+
+// HandleNode processes watch events on the node resource.
+func (p *InstrumentedPlugin) HandleNode(eventType watch.EventType, node *kapi.Node) error {
+	return p.handle("node", eventType, func() error {
+		return p.plugin.HandleNode(eventType, node)
+	})
+}
+
+// HandleEndpoints processes watch events on the Endpoints resource.
+func (p *InstrumentedPlugin) HandleEndpoints(eventType watch.EventType, endpoints *kapi.Endpoints) error {
+	return p.handle("endpoints", eventType, func() error {
+		return p.plugin.HandleEndpoints(eventType, endpoints)
+	})
+}
+
+// HandleRoute processes watch events on the Route resource.
+func (p *InstrumentedPlugin) HandleRoute(eventType watch.EventType, route *routev1.Route) error {
+	return p.handle("route", eventType, func() error {
+		return p.plugin.HandleRoute(eventType, route)
+	})
+}
+
+// HandleNamespaces limits the scope of valid routes to only those that match
+// the provided namespace list.
+func (p *InstrumentedPlugin) HandleNamespaces(namespaces sets.String) error {
+	return p.handle("namespaces", watch.Modified, func() error {
+		return p.plugin.HandleNamespaces(namespaces)
+	})
+}
+
+// Commit records the commits of the plugin.
+func (p *InstrumentedPlugin) Commit() error {
+	return p.handle("commit", "", p.plugin.Commit)
+}
+
+// metricsRecorder counts the route rejections before delegating to another
+// recorder.
+type metricsRecorder struct {
+	RouteStatusRecorder
+}
+
+// NewMetricsRecorder returns a RouteStatusRecorder counting the rejected
+// routes by reason, and the host conflicts, before recording them with the
+// given recorder.
+func NewMetricsRecorder(recorder RouteStatusRecorder) RouteStatusRecorder {
+	registerMetrics()
+	return metricsRecorder{RouteStatusRecorder: recorder}
+}
+
+func (r metricsRecorder) RecordRouteRejection(route *routev1.Route, reason, message string) {
+	metricRouteRejections.WithLabelValues(reason).Inc()
+	if reason == hostAlreadyClaimedReason {
+		metricHostConflicts.Inc()
+	}
+	r.RouteStatusRecorder.RecordRouteRejection(route, reason, message)
+}
+
+// admittedRoute is the state of an admitted route exported by the
+// AdmittedRouteMetrics plugin.
+type admittedRoute struct {
+	namespace   string
+	termination string
+}
+
+// AdmittedRouteMetrics implements the router.Plugin interface to export the
+// admitted routes by termination type.
+// It is meant to wrap the plugin serving the routes, which only receives the
+// routes admitted by the rest of the chain.
+type AdmittedRouteMetrics struct {
+	plugin router.Plugin
+	routes map[types.UID]admittedRoute
+}
+
+// NewAdmittedRouteMetrics creates a plugin wrapper exporting the metrics of
+// the routes passed to the given plugin.
+func NewAdmittedRouteMetrics(plugin router.Plugin) *AdmittedRouteMetrics {
+	registerMetrics()
+	return &AdmittedRouteMetrics{
+		plugin: plugin,
+		routes: make(map[types.UID]admittedRoute),
+	}
+}
+
+// HandleNode processes watch events on the node resource.
+func (p *AdmittedRouteMetrics) HandleNode(eventType watch.EventType, node *kapi.Node) error {
+	return p.plugin.HandleNode(eventType, node)
+}
+
+// HandleEndpoints processes watch events on the Endpoints resource.
+func (p *AdmittedRouteMetrics) HandleEndpoints(eventType watch.EventType, endpoints *kapi.Endpoints) error {
+	return p.plugin.HandleEndpoints(eventType, endpoints)
+}
+
+// HandleRoute processes watch events on the Route resource and updates the
+// metrics of the route.
+func (p *AdmittedRouteMetrics) HandleRoute(eventType watch.EventType, route *routev1.Route) error {
+	p.forget(route.UID)
+
+	if eventType != watch.Deleted {
+		current := admittedRoute{namespace: route.Namespace, termination: noTermination}
+		if route.Spec.TLS != nil && len(route.Spec.TLS.Termination) > 0 {
+			current.termination = string(route.Spec.TLS.Termination)
+		}
+		metricAdmittedRoutes.WithLabelValues(current.termination).Inc()
+		p.routes[route.UID] = current
+	}
+
+	return p.plugin.HandleRoute(eventType, route)
+}
+
+// HandleNamespaces limits the scope of valid routes to only those that match
+// the provided namespace list, forgetting the routes of the other namespaces.
+func (p *AdmittedRouteMetrics) HandleNamespaces(namespaces sets.String) error {
+	for uid, route := range p.routes {
+		if !namespaces.Has(route.namespace) {
+			p.forget(uid)
+		}
+	}
+	return p.plugin.HandleNamespaces(namespaces)
+}
+
+// Commit commits the changes made to the wrapped plugin.
+func (p *AdmittedRouteMetrics) Commit() error {
+	return p.plugin.Commit()
+}
+
+// forget removes the metrics of a route.
+func (p *AdmittedRouteMetrics) forget(uid types.UID) {
+	route, ok := p.routes[uid]
+	if !ok {
+		return
+	}
+	metricAdmittedRoutes.WithLabelValues(route.termination).Dec()
+	delete(p.routes, uid)
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	client_model "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	"github.com/openshift/router/pkg/router"
+)
+
+func TestInstrumentedPlugin(t *testing.T) {
+	p := &fakePlugin{}
+	plugin := NewInstrumentedPlugin("TestPlugin", p, nil)
+	route := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "web"}}
+
+	require.NoError(t, plugin.HandleRoute(watch.Added, route))
+	p.err = errors.New("rejected")
+	require.Error(t, plugin.HandleRoute(watch.Modified, route))
+	require.Error(t, plugin.Commit())
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricPluginEvents.WithLabelValues("TestPlugin", "route", "ADDED", "success")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricPluginEvents.WithLabelValues("TestPlugin", "route", "MODIFIED", "error")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricPluginEvents.WithLabelValues("TestPlugin", "commit", "", "error")))
+	assert.Equal(t, 2, testutil.CollectAndCount(metricPluginEventDuration.MustCurryWith(map[string]string{"plugin": "TestPlugin"})))
+}
+
+// slowPlugin is a plugin taking some time to handle the routes before
+// delegating to another plugin.
+type slowPlugin struct {
+	fakePlugin
+	delay time.Duration
+	next  router.Plugin
+}
+
+func (p *slowPlugin) HandleRoute(eventType watch.EventType, route *routev1.Route) error {
+	time.Sleep(p.delay)
+	if p.next != nil {
+		return p.next.HandleRoute(eventType, route)
+	}
+	return nil
+}
+
+// TestInstrumentedPluginExclusiveTime tests that the time of the plugins an
+// instrumented plugin delegates to is excluded from its own.
+func TestInstrumentedPluginExclusiveTime(t *testing.T) {
+	inner := NewInstrumentedPlugin("TestInner", &slowPlugin{delay: 50 * time.Millisecond}, nil)
+	outer := NewInstrumentedPlugin("TestOuter", &slowPlugin{next: inner}, inner)
+
+	require.NoError(t, outer.HandleRoute(watch.Added, &routev1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "web"}}))
+
+	assert.GreaterOrEqual(t, histogramSum(t, "TestInner"), 0.05)
+	assert.Less(t, histogramSum(t, "TestOuter"), 0.04, "the time of the inner plugin must be excluded")
+}
+
+// histogramSum returns the sum of the route event durations of a plugin.
+func histogramSum(t *testing.T, plugin string) float64 {
+	var m client_model.Metric
+	require.NoError(t, metricPluginEventDuration.WithLabelValues(plugin, "route").(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleSum()
+}
+
+func TestMetricsRecorder(t *testing.T) {
+	recorder := routeStatusRecorder{rejections: make(map[string]string)}
+	metrics := NewMetricsRecorder(recorder)
+	route := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "web"}}
+
+	conflicts := testutil.ToFloat64(metricHostConflicts)
+	invalid := testutil.ToFloat64(metricRouteRejections.WithLabelValues("InvalidHost"))
+
+	metrics.RecordRouteRejection(route, "InvalidHost", "invalid host")
+	metrics.RecordRouteRejection(route, "HostAlreadyClaimed", "route other already exposes www.example.com and is older")
+
+	assert.Equal(t, invalid+1, testutil.ToFloat64(metricRouteRejections.WithLabelValues("InvalidHost")))
+	assert.Equal(t, conflicts+1, testutil.ToFloat64(metricHostConflicts))
+	assert.Equal(t, "HostAlreadyClaimed", recorder.rejections["ns-web"], "the rejection must be recorded")
+}
+
+func TestAdmittedRouteMetrics(t *testing.T) {
+	plugin := NewAdmittedRouteMetrics(&fakePlugin{})
+
+	edge := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "edge", UID: "edge-uid"},
+		Spec:       routev1.RouteSpec{TLS: &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge}},
+	}
+	insecure := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "insecure", UID: "insecure-uid"}}
+
+	edges := testutil.ToFloat64(metricAdmittedRoutes.WithLabelValues("edge"))
+	insecures := testutil.ToFloat64(metricAdmittedRoutes.WithLabelValues("none"))
+
+	require.NoError(t, plugin.HandleRoute(watch.Added, edge))
+	require.NoError(t, plugin.HandleRoute(watch.Added, insecure))
+	// Modifications don't count the route twice.
+	require.NoError(t, plugin.HandleRoute(watch.Modified, edge))
+
+	assert.Equal(t, edges+1, testutil.ToFloat64(metricAdmittedRoutes.WithLabelValues("edge")))
+	assert.Equal(t, insecures+1, testutil.ToFloat64(metricAdmittedRoutes.WithLabelValues("none")))
+
+	require.NoError(t, plugin.HandleRoute(watch.Deleted, edge))
+	assert.Equal(t, edges, testutil.ToFloat64(metricAdmittedRoutes.WithLabelValues("edge")))
+
+	// The routes of the namespaces no longer served are forgotten.
+	plugin.HandleNamespaces(sets.NewString("ns"))
+	assert.Equal(t, insecures, testutil.ToFloat64(metricAdmittedRoutes.WithLabelValues("none")))
+}
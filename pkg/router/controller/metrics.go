@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// metricPluginEvents is the number of events handled by each plugin of
+	// the chain, by resource, event type and result.
+	metricPluginEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "template_router",
+		Subsystem: "plugin",
+		Name:      "events_total",
+		Help:      "Number of events handled by each plugin of the router, by resource, event type and result.",
+	}, []string{"plugin", "resource", "event", "result"})
+
+	// metricPluginEventDuration measures the time spent handling events by
+	// each plugin, excluding the plugins it delegates to.
+	metricPluginEventDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "template_router",
+		Subsystem: "plugin",
+		Name:      "event_duration_seconds",
+		Help:      "Measures the time spent handling an event by each plugin of the router, excluding the plugins it delegates to, in seconds.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"plugin", "resource"})
+
+	// metricInformerEventLag measures the time informer events wait, from
+	// their delivery by the informer, before the router controller starts
+	// handling them.
+	metricInformerEventLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "template_router",
+		Subsystem: "informer",
+		Name:      "event_lag_seconds",
+		Help:      "Measures the time informer events wait from their delivery by the informer until the router controller starts handling them, in seconds.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"resource"})
+
+	// metricRouteRejections is the number of route rejections, by reason.
+	metricRouteRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "template_router",
+		Name:      "route_rejections_total",
+		Help:      "Number of routes rejected by the router, by reason.",
+	}, []string{"reason"})
+
+	// metricHostConflicts is the number of routes rejected because another
+	// route claimed their host.
+	metricHostConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "template_router",
+		Name:      "host_conflicts_total",
+		Help:      "Number of routes rejected because their host was already claimed by another route.",
+	})
+
+	// metricAdmittedRoutes is the number of routes admitted by the router,
+	// by termination type.
+	metricAdmittedRoutes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "template_router",
+		Name:      "admitted_routes",
+		Help:      "Number of routes admitted by the router, by termination type.",
+	}, []string{"termination"})
+
+	// metricCertificateExpiry is the expiry time of the certificates served
+	// for each route, by certificate type. Exported as
+	// router_certificate_expiry_timestamp_seconds, unlike the other router
+	// controller metrics.
+	metricCertificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "router",
+		Subsystem: "certificate",
+		Name:      "expiry_timestamp_seconds",
+		Help:      "Expiry time of the certificates served for each route, by certificate type, in seconds since the epoch.",
+	}, []string{"namespace", "route", "type"})
+
+	registerMetricsOnce sync.Once
+)
+
+// registerMetrics registers the router controller metrics.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(
+			metricPluginEvents,
+			metricPluginEventDuration,
+			metricInformerEventLag,
+			metricRouteRejections,
+			metricHostConflicts,
+			metricAdmittedRoutes,
+			metricCertificateExpiry,
+		)
+	})
+}
@@ -138,6 +138,10 @@ func (r *statusRecorder) RecordRouteUnservableInFutureVersionsClear(route *route
 func (r *statusRecorder) RecordRouteUnservableInFutureVersions(route *routev1.Route, reason, message string) {
 	r.unservableInFutureVersions[r.routeKey(route)] = reason
 }
+func (r *statusRecorder) RecordRouteCondition(route *routev1.Route, conditionType routev1.RouteIngressConditionType, reason, message string) {
+}
+func (r *statusRecorder) RecordRouteConditionClear(route *routev1.Route, conditionType routev1.RouteIngressConditionType) {
+}
 
 var _ RouteStatusRecorder = &statusRecorder{}
 
@@ -52,6 +52,7 @@ type RouterController struct {
 // Run begins watching and syncing.
 func (c *RouterController) Run() {
 	log.V(4).Info("running router controller")
+	registerMetrics()
 	if c.ProjectLabels != nil {
 		c.HandleProjects()
 		go utilwait.Forever(c.HandleProjects, c.ProjectSyncInterval)
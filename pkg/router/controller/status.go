@@ -39,6 +39,12 @@ type RouteStatusRecorder interface {
 	RecordRouteUpdate(route *routev1.Route, reason, message string)
 	RecordRouteUnservableInFutureVersions(route *routev1.Route, reason, message string)
 	RecordRouteUnservableInFutureVersionsClear(route *routev1.Route)
+	// RecordRouteCondition sets a warning condition of the given type on the
+	// route, which is still served.
+	RecordRouteCondition(route *routev1.Route, conditionType routev1.RouteIngressConditionType, reason, message string)
+	// RecordRouteConditionClear removes the condition of the given type from
+	// the route.
+	RecordRouteConditionClear(route *routev1.Route, conditionType routev1.RouteIngressConditionType)
 }
 
 // LogRejections writes route status change messages to the log.
@@ -62,6 +68,14 @@ func (logRecorder) RecordRouteUnservableInFutureVersionsClear(route *routev1.Rou
 	log.V(3).Info("route clear unservable in future versions", "name", route.Name, "namespace", route.Namespace)
 }
 
+func (logRecorder) RecordRouteCondition(route *routev1.Route, conditionType routev1.RouteIngressConditionType, reason, message string) {
+	log.V(3).Info("route condition", "name", route.Name, "namespace", route.Namespace, "condition", conditionType, "reason", reason, "message", message)
+}
+
+func (logRecorder) RecordRouteConditionClear(route *routev1.Route, conditionType routev1.RouteIngressConditionType) {
+	log.V(5).Info("route clear condition", "name", route.Name, "namespace", route.Namespace, "condition", conditionType)
+}
+
 // StatusAdmitter ensures routes added to the plugin have status set.
 type StatusAdmitter struct {
 	lock   sync.Mutex
@@ -205,6 +219,41 @@ func (a *StatusAdmitter) RecordRouteUnservableInFutureVersionsClear(route *route
 	performIngressConditionRemoval(unservableInFutureVersionsClearAction, a.lease, a.tracker, a.client, a.lister, route, a.routerName, routev1.RouteUnservableInFutureVersions)
 }
 
+// RecordRouteCondition attempts to update the route status with a warning
+// condition of the given type.
+func (a *StatusAdmitter) RecordRouteCondition(route *routev1.Route, conditionType routev1.RouteIngressConditionType, reason, message string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	action := string(conditionType)
+	expectedCondition := routev1.RouteIngressCondition{
+		Type:    conditionType,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	}
+	// As for UnservableInFutureVersions, skip the writerlease queue if the
+	// route status is already up to date.
+	if !isIngressConditionUpdateRequired(route, a.routerName, expectedCondition) {
+		log.V(4).Info("route status matches expected values, update not required", "action", action, "namespace", route.Namespace, "name", route.Name)
+		return
+	}
+
+	performIngressConditionUpdate(action, a.lease, a.tracker, a.client, a.lister, route, a.routerName, a.routerCanonicalHostname, expectedCondition)
+}
+
+// RecordRouteConditionClear clears the condition of the given type back to an unset state.
+func (a *StatusAdmitter) RecordRouteConditionClear(route *routev1.Route, conditionType routev1.RouteIngressConditionType) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	action := string(conditionType) + "-Clear"
+	if !isIngressConditionRemoveRequired(route, a.routerName, conditionType) {
+		log.V(4).Info("route status matches expected values, update not required", "action", action, "namespace", route.Namespace, "name", route.Name)
+		return
+	}
+
+	performIngressConditionRemoval(action, a.lease, a.tracker, a.client, a.lister, route, a.routerName, conditionType)
+}
+
 // performIngressConditionUpdate updates the route to the appropriate status for the provided condition.
 func performIngressConditionUpdate(action string, lease writerlease.Lease, tracker ContentionTracker, oc client.RoutesGetter, lister routelisters.RouteLister, route *routev1.Route, routerName, hostName string, condition routev1.RouteIngressCondition) {
 	// Key the lease's work off of the route UID and the condition type, as different conditions will require separate updates.
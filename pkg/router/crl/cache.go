@@ -0,0 +1,88 @@
+package crl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// cacheDirPermissions is the permission bits used for the CRL cache directory.
+	cacheDirPermissions = 0755
+	// cacheFilePermissions is the permission bits used for the CRL cache files.
+	cacheFilePermissions = 0644
+)
+
+// diskCache persists the retrieved CRLs, keyed by distribution point, so that a restarted router can use the CRLs
+// which are still valid instead of downloading them again before it can serve mTLS traffic, and can retrieve the
+// expired ones with conditional requests. A nil diskCache caches nothing.
+type diskCache struct {
+	dir string
+}
+
+// newDiskCache returns a cache storing its files in dir, or nil if dir is empty.
+func newDiskCache(dir string) *diskCache {
+	if len(dir) == 0 {
+		return nil
+	}
+	return &diskCache{dir: dir}
+}
+
+// filename returns the name of the cache file of the distribution point url.
+func (c *diskCache) filename(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the cached CRL of the distribution point url, or nil if there is none.
+func (c *diskCache) get(url string) *FetchedCRL {
+	if c == nil {
+		return nil
+	}
+	data, err := os.ReadFile(c.filename(url))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error(err, "failed to read cached CRL", "distribution point", url)
+		}
+		return nil
+	}
+	crl := &FetchedCRL{}
+	if err := json.Unmarshal(data, crl); err != nil || crl.URL != url {
+		log.Info("ignoring invalid cached CRL", "distribution point", url, "error", err)
+		return nil
+	}
+	return crl
+}
+
+// put stores crl in the cache, replacing the previously cached CRL of its distribution point.
+func (c *diskCache) put(crl *FetchedCRL) error {
+	if c == nil {
+		return nil
+	}
+	data, err := json.Marshal(crl)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, cacheDirPermissions); err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a router interrupted while writing never reads a truncated file.
+	f, err := os.CreateTemp(c.dir, ".crl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), cacheFilePermissions); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.filename(crl.URL))
+}
@@ -2,14 +2,14 @@ package crl
 
 import (
 	"bytes"
+	"context"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
-	"io"
-	"net/http"
+	"math/big"
 	"os"
 	"path/filepath"
 	"strings"
@@ -37,6 +37,9 @@ const (
 	crlBasename = "crls.pem"
 	// caBundleBasename is the name of the CA bundle file copied from the CA bundle configmap.
 	caBundleBasename = "ca-bundle.pem"
+	// DefaultCacheDirectory is the default directory where the retrieved CRLs are persisted. It is outside of
+	// mtlsBaseDirectory, whose stale entries are removed on every update.
+	DefaultCacheDirectory = "/var/lib/haproxy/mtls-crl-cache"
 	// dummyCRL is a placeholder CRL so that HAProxy can start serving non-mTLS traffic while CRLs are downloaded. This
 	// CRL is for a CA cert/key that are intentionally not included, and was generated with an expiration (nextUpdate)
 	// at 1:00AM GMT on Jan 1, 2000 so that in the extremely unlikely case that someone is able to generate a matching
@@ -129,12 +132,91 @@ func CABundleHasCRLs(caBundleFilename string) (bool, error) {
 	return false, nil
 }
 
+// Options configures how ManageCRLs retrieves the CRLs.
+type Options struct {
+	// Fetchers are the fetchers of the CRL distribution points, by URL scheme. If nil, "http" and "ldap" distribution
+	// points are retrieved by an HTTPFetcher and an LDAPFetcher with the default timeout and maximum size.
+	Fetchers map[string]Fetcher
+	// CacheDirectory is the directory where retrieved CRLs are persisted so that they survive a restart of the router.
+	// If empty, the CRLs are only kept in memory.
+	CacheDirectory string
+	// Policy is the policy of the CAs when their CRLs can't be retrieved. Defaults to PolicyHardFail.
+	Policy Policy
+	// GracePeriod is how long the CRLs of the CAs with PolicySoftFail are served past their next update.
+	GracePeriod time.Duration
+	// FailOpenCAs are the subject key IDs, in hex, of the CAs with PolicyFailOpen, whatever Policy is.
+	FailOpenCAs []string
+}
+
+// crlManager keeps the CRLs of a CA bundle up-to-date.
+type crlManager struct {
+	// fetchers are the fetchers of the distribution points, by URL scheme.
+	fetchers map[string]Fetcher
+	// cache persists the retrieved CRLs, by distribution point.
+	cache *diskCache
+	// existingCRLs are the CRLs currently in use, keyed by the subject key ID of their CA.
+	existingCRLs map[string]*caCRLs
+	// defaultPolicy is the policy of the CAs which are not in failOpenCAs.
+	defaultPolicy Policy
+	// gracePeriod is how long the CRLs of the CAs with PolicySoftFail are served past their next update.
+	gracePeriod time.Duration
+	// failOpenCAs are the subject key IDs of the CAs with PolicyFailOpen.
+	failOpenCAs map[string]struct{}
+}
+
+func newCRLManager(opts Options) *crlManager {
+	fetchers := opts.Fetchers
+	if fetchers == nil {
+		fetchers = map[string]Fetcher{
+			"http": NewHTTPFetcher(DefaultFetchTimeout, DefaultMaxCRLSize, nil),
+			"ldap": NewLDAPFetcher(DefaultFetchTimeout, DefaultMaxCRLSize),
+		}
+	}
+	defaultPolicy := opts.Policy
+	if len(defaultPolicy) == 0 {
+		defaultPolicy = PolicyHardFail
+	}
+	failOpenCAs := make(map[string]struct{}, len(opts.FailOpenCAs))
+	for _, keyID := range opts.FailOpenCAs {
+		failOpenCAs[normalizeKeyID(keyID)] = struct{}{}
+	}
+	return &crlManager{
+		fetchers:      fetchers,
+		cache:         newDiskCache(opts.CacheDirectory),
+		defaultPolicy: defaultPolicy,
+		gracePeriod:   opts.GracePeriod,
+		failOpenCAs:   failOpenCAs,
+	}
+}
+
+// caCRLs are the CRLs of a CA: its base CRL and, if the CA publishes one, the delta CRL listing the revocations since
+// the base CRL was issued.
+type caCRLs struct {
+	base  *x509.RevocationList
+	delta *x509.RevocationList
+	// degraded is set when the CRLs expired, and new ones can't be retrieved, but the policy of the CA allows serving
+	// them.
+	degraded bool
+}
+
+// nextUpdate returns the time at which the first of the CRLs expires.
+func (c *caCRLs) nextUpdate() time.Time {
+	if c.delta != nil && c.delta.NextUpdate.Before(c.base.NextUpdate) {
+		return c.delta.NextUpdate
+	}
+	return c.base.NextUpdate
+}
+
 // ManageCRLs spins off a goroutine that ensures that any CRLs specified in caBundleFilename are downloaded and kept
 // up-to-date. It will automatically refresh expired CRLs and download missing CRLs when it receives a message on
 // caUpdateChannel (indicating the CA bundle has been updated), or when any existing CRL expires. Whenever either the CA
 // bundle or the CRL file has changed, updateCallback is called, with a boolean indicating whether crl-file needs to be
-// specified in the HAProxy config.
-func ManageCRLs(caBundleFilename string, caUpdateChannel <-chan struct{}, updateCallback func(bool)) {
+// specified in the HAProxy config, and a boolean indicating whether HAProxy must accept expired CRLs, which is the case
+// while the CRL file has expired CRLs served as allowed by the policy of their CA.
+func ManageCRLs(caBundleFilename string, caUpdateChannel <-chan struct{}, opts Options, updateCallback func(bool, bool)) {
+	registerMetrics()
+	status.manage()
+	m := newCRLManager(opts)
 	go func() {
 		caUpdated := false
 		nextUpdate := time.Now()
@@ -167,7 +249,14 @@ func ManageCRLs(caBundleFilename string, caUpdateChannel <-chan struct{}, update
 				}
 			}
 
-			nextUpdate, updated, err = updateCRLFile(caBundleFilename, caUpdated)
+			nextUpdate, updated, err = m.updateCRLFile(caBundleFilename, caUpdated)
+			if missing, _ := status.missing(time.Now()); len(missing) != 0 {
+				log.Info("HAProxy has no valid CRLs for some client CAs, rejecting their clients", "CAs", missing)
+			}
+			// The CRL file may have been updated with the CRLs of some of the CAs despite an error.
+			if updated {
+				updateCallback(shouldHaveCRLs, m.degraded())
+			}
 			if err != nil {
 				log.Error(err, "failed to update CRLs")
 				nextUpdate = time.Now().Add(errorBackoffTime)
@@ -175,17 +264,25 @@ func ManageCRLs(caBundleFilename string, caUpdateChannel <-chan struct{}, update
 			}
 			// After successfully updating the CRL file, reset caUpdated
 			caUpdated = false
-			if updated {
-				updateCallback(shouldHaveCRLs)
-			}
 		}
 	}()
 }
 
+// degraded returns whether any of the CRLs in use expired but are served as allowed by the policy of their CA.
+func (m *crlManager) degraded() bool {
+	for _, crl := range m.existingCRLs {
+		if crl.degraded {
+			return true
+		}
+	}
+	return false
+}
+
 // updateCRLFile creates a new staging directory, updates CRLs, and updates mtlsLatestSymlink to point to the new
 // staging directory. Returns the next update time and a boolean for if anything changed. Returns an error if there was
-// an issue during the update.
-func updateCRLFile(caBundleFilename string, caUpdated bool) (time.Time, bool, error) {
+// an issue during the update, along with whether the CRLs of the other CAs were updated if only some of the CRLs could
+// not be retrieved.
+func (m *crlManager) updateCRLFile(caBundleFilename string, caUpdated bool) (time.Time, bool, error) {
 	stagingDirectory, err := makeStagingDirectory()
 	if err != nil {
 		log.Error(err, "failed to create staging directory")
@@ -196,10 +293,10 @@ func updateCRLFile(caBundleFilename string, caUpdated bool) (time.Time, bool, er
 
 	stagingCRLFilename := filepath.Join(stagingDirectory, crlBasename)
 
-	nextUpdate, crlsUpdated, err := writeCRLFile(caBundleFilename, CRLFilename, stagingCRLFilename)
-	if err != nil {
-		log.Error(err, "failed to update CRLs")
-		return time.Time{}, false, err
+	nextUpdate, crlsUpdated, fetchErr := m.writeCRLFile(caBundleFilename, CRLFilename, stagingCRLFilename)
+	if fetchErr != nil && !crlsUpdated {
+		log.Error(fetchErr, "failed to update CRLs")
+		return time.Time{}, false, fetchErr
 	}
 
 	if caUpdated || crlsUpdated {
@@ -207,8 +304,10 @@ func updateCRLFile(caBundleFilename string, caUpdated bool) (time.Time, bool, er
 			log.Error(err, "failed to commit CRL update")
 			return time.Time{}, false, err
 		}
-		return nextUpdate, true, nil
+		status.setServed(m.existingCRLs)
+		return nextUpdate, true, fetchErr
 	}
+	status.setServed(m.existingCRLs)
 	return nextUpdate, false, nil
 }
 
@@ -257,49 +356,54 @@ func commitCACRLUpdate(stagingDirectory, caBundleFilename string, stagingCRLUpda
 	return nil
 }
 
-var existingCRLs map[string]*pkix.CertificateList
-
 // writeCRLFile reads the CA bundle at caBundleFilename, and makes sure all CRLs specified in the CA bundle are written
 // into the crl file at newCRLFilename. If any of the specified CRLs are in existingCRLFilename and have not expired,
 // writeCRLFile will prefer to use those over downloading them again from their distribution points.
 //
 // Returns the time of the next CRL expiration (zero if no CRLs are in use), and whether or not the CRL file was
-// updated. Returns an error if parsing data, encoding data, or a file operation fails.
-func writeCRLFile(caBundleFilename, existingCRLFilename, newCRLFilename string) (time.Time, bool, error) {
+// updated. Returns an error if parsing data, encoding data, or a file operation fails, or if the CRLs of some of the CAs
+// could not be retrieved, in which case the CRL file is still updated with the CRLs of the other CAs.
+func (m *crlManager) writeCRLFile(caBundleFilename, existingCRLFilename, newCRLFilename string) (time.Time, bool, error) {
 	clientCAData, err := os.ReadFile(caBundleFilename)
 	if err != nil {
 		return time.Time{}, false, err
 	}
 
-	crls, nextCRLUpdate, updated, err := downloadMissingCRLs(existingCRLs, clientCAData)
-	if err != nil {
-		return time.Time{}, false, err
+	crls, nextCRLUpdate, updated, fetchErr := m.downloadMissingCRLs(m.existingCRLs, clientCAData)
+	if crls == nil {
+		return time.Time{}, false, fetchErr
 	}
 
-	existingCRLs = crls
+	m.existingCRLs = crls
 
 	if len(crls) == 0 {
 		// If there are no CRLs, still write out dummyCRL as a placeholder.
-		return time.Time{}, updated, os.WriteFile(newCRLFilename, []byte(dummyCRL), crlFilePermissions)
+		if err := os.WriteFile(newCRLFilename, []byte(dummyCRL), crlFilePermissions); err != nil {
+			return time.Time{}, false, err
+		}
+		return nextCRLUpdate, updated, fetchErr
 	}
 
 	// If any CRLs changed, encode the CRLs and write to newCRLFilename.
 	if !updated {
-		return nextCRLUpdate, updated, nil
+		return nextCRLUpdate, updated, fetchErr
 	}
 
+	// The delta CRL of a CA, if any, is written right after its base CRL.
 	buf := &bytes.Buffer{}
 	for subjectKeyId, crl := range crls {
-		asn1Data, err := asn1.Marshal(*crl)
-		if err != nil {
-			return time.Time{}, false, fmt.Errorf("failed to encode ASN.1 for CRL for certificate key %s: %w", subjectKeyId, err)
-		}
-		block := &pem.Block{
-			Type:  "X509 CRL",
-			Bytes: asn1Data,
+		lists := []*x509.RevocationList{crl.base}
+		if crl.delta != nil {
+			lists = append(lists, crl.delta)
 		}
-		if err := pem.Encode(buf, block); err != nil {
-			return time.Time{}, false, fmt.Errorf("failed to encode PEM for CRL for certificate key %s: %w", subjectKeyId, err)
+		for _, list := range lists {
+			block := &pem.Block{
+				Type:  "X509 CRL",
+				Bytes: list.Raw,
+			}
+			if err := pem.Encode(buf, block); err != nil {
+				return time.Time{}, false, fmt.Errorf("failed to encode PEM for CRL for certificate key %s: %w", subjectKeyId, err)
+			}
 		}
 	}
 
@@ -307,12 +411,12 @@ func writeCRLFile(caBundleFilename, existingCRLFilename, newCRLFilename string)
 		return time.Time{}, false, err
 	}
 
-	return nextCRLUpdate, updated, nil
+	return nextCRLUpdate, updated, fetchErr
 }
 
 // downloadMissingCRLs parses the certificates in the CA bundle, clientCAData, and returns a map of all CRLs that were
 // specified. downloadMissingCRLs will prefer to use CRLs from existingCRLs if they are still valid, but otherwise, CRLs
-// are downloaded from the distribution points from the CA bundle.
+// are retrieved from the cache or downloaded from the distribution points from the CA bundle.
 //
 // Returns:
 //   - a map of all CRLs keyed by their subject key ID
@@ -321,10 +425,16 @@ func writeCRLFile(caBundleFilename, existingCRLFilename, newCRLFilename string)
 //   - whether the crl map has been updated, either because new CRLs were downloaded, or because some CRLs in
 //     existingCRLs are no longer required
 //
-// Returns an error if CRL downloading or parsing fails.
-func downloadMissingCRLs(existingCRLs map[string]*pkix.CertificateList, clientCAData []byte) (map[string]*pkix.CertificateList, time.Time, bool, error) {
+// If the CRLs of a CA can't be downloaded, its last CRLs are kept past their next update if its policy allows it.
+// Otherwise, the CA is left out of the map, so that HAProxy rejects its clients for lack of CRLs, and an error is
+// returned along with the CRLs of the other CAs, after trying all of them. Returns a nil map and an error if parsing
+// fails.
+func (m *crlManager) downloadMissingCRLs(existingCRLs map[string]*caCRLs, clientCAData []byte) (map[string]*caCRLs, time.Time, bool, error) {
 	var nextCRLUpdate time.Time
-	crls := make(map[string]*pkix.CertificateList)
+	var errs []error
+	crls := make(map[string]*caCRLs)
+	// required are the subjects of the CAs requiring CRLs, by subject key ID.
+	required := make(map[string]string)
 	updated := false
 	now := time.Now()
 	for len(clientCAData) > 0 {
@@ -345,31 +455,51 @@ func downloadMissingCRLs(existingCRLs map[string]*pkix.CertificateList, clientCA
 		if len(cert.CRLDistributionPoints) == 0 {
 			continue
 		}
+		subject := cert.Subject.String()
+		required[subjectKeyId] = subject
+		policy := m.policy(subjectKeyId)
+		status.setPolicy(subjectKeyId, subject, policy)
 		if crl, ok := existingCRLs[subjectKeyId]; ok {
-			if crl.TBSCertList.NextUpdate.Before(now) {
-				log.Info("certificate revocation list has expired", "subject key identifier", subjectKeyId, "next update", crl.TBSCertList.NextUpdate.Format(time.RFC3339))
+			if crl.nextUpdate().Before(now) {
+				log.Info("certificate revocation list has expired", "subject key identifier", subjectKeyId, "next update", crl.nextUpdate().Format(time.RFC3339))
 			} else {
 				crls[subjectKeyId] = existingCRLs[subjectKeyId]
-				if nextCRLUpdate.IsZero() || crl.TBSCertList.NextUpdate.Before(nextCRLUpdate) {
-					nextCRLUpdate = crl.TBSCertList.NextUpdate
+				if nextCRLUpdate.IsZero() || crl.nextUpdate().Before(nextCRLUpdate) {
+					nextCRLUpdate = crl.nextUpdate()
 				}
 				continue
 			}
 		}
 		log.Info("retrieving certificate revocation list", "subject key identifier", subjectKeyId)
-		if crl, err := getCRL(cert.CRLDistributionPoints, now); err != nil {
-			// Creating or updating the crl file with incomplete data would compromise security by potentially
-			// permitting revoked certificates.
-			return nil, time.Time{}, false, fmt.Errorf("failed to get certificate revocation list for certificate key %s: %w", subjectKeyId, err)
+		if crl, err := m.getCACRLs(cert, now); err != nil {
+			status.recordFailure(subjectKeyId, subject)
+			existing := existingCRLs[subjectKeyId]
+			if last := m.lastCRLs(cert, existing); last != nil && m.allows(policy, last, now) {
+				log.Info("failed to get certificate revocation list. serving the expired one as allowed by the CA policy", "subject key identifier", subjectKeyId, "policy", policy, "next update", last.nextUpdate().Format(time.RFC3339), "error", err)
+				crls[subjectKeyId] = &caCRLs{base: last.base, delta: last.delta, degraded: true}
+				if nextCRLUpdate.IsZero() || last.nextUpdate().Before(nextCRLUpdate) {
+					nextCRLUpdate = last.nextUpdate()
+				}
+				// HAProxy needs to be told to accept the expired CRLs.
+				updated = updated || existing == nil || !existing.degraded
+				continue
+			}
+			// Keep trying the other CAs, so that the state of all of their CRLs is known. Leaving out the CRLs of
+			// the CA rejects its clients: creating or updating the crl file with an expired CRL of a hard-fail CA
+			// while HAProxy accepts expired CRLs would potentially permit revoked certificates.
+			log.Info("failed to get certificate revocation list. rejecting the clients of the CA as required by the CA policy", "subject key identifier", subjectKeyId, "policy", policy)
+			errs = append(errs, fmt.Errorf("failed to get certificate revocation list for certificate key %s: %w", subjectKeyId, err))
 		} else {
+			status.recordSuccess(subjectKeyId, subject, crl, now)
 			crls[subjectKeyId] = crl
-			log.Info("new certificate revocation list", "subject key identifier", subjectKeyId, "next update", crl.TBSCertList.NextUpdate.Format(time.RFC3339))
-			if nextCRLUpdate.IsZero() || crl.TBSCertList.NextUpdate.Before(nextCRLUpdate) {
-				nextCRLUpdate = crl.TBSCertList.NextUpdate
+			log.Info("new certificate revocation list", "subject key identifier", subjectKeyId, "next update", crl.nextUpdate().Format(time.RFC3339), "delta", crl.delta != nil)
+			if nextCRLUpdate.IsZero() || crl.nextUpdate().Before(nextCRLUpdate) {
+				nextCRLUpdate = crl.nextUpdate()
 			}
 			updated = true
 		}
 	}
+	status.setRequired(required)
 	// If updated is still false, no new CRLs have been downloaded, but it's possible that some existing CRLs are no
 	// longer necessary. If that's the case, then existingCRLs will contain more items than crls, so we can compare
 	// their lengths to determine if an update is necessary.
@@ -380,76 +510,181 @@ func downloadMissingCRLs(existingCRLs map[string]*pkix.CertificateList, clientCA
 	if !nextCRLUpdate.IsZero() && nextCRLUpdate.Before(now) {
 		nextCRLUpdate = now.Add(crlFallbackTime)
 	}
-	return crls, nextCRLUpdate, updated, nil
+	return crls, nextCRLUpdate, updated, kerrors.NewAggregate(errs)
+}
+
+// getCACRLs gets the base CRL of the CA certificate cert and, if either the base CRL or the certificate specify
+// freshest CRL distribution points, the delta CRL. Returns an error if any of the CRLs could not be retrieved, or if
+// the delta CRL does not apply to the base CRL.
+func (m *crlManager) getCACRLs(cert *x509.Certificate, now time.Time) (*caCRLs, error) {
+	base, err := m.getCRL(cert.CRLDistributionPoints, now)
+	if err != nil {
+		return nil, err
+	}
+	crls := &caCRLs{base: base}
+	// The freshest CRL extension of the base CRL takes precedence over the one of the certificate, as it is more
+	// likely to be up-to-date.
+	deltaDistributionPoints, err := freshestCRLDistributionPoints(base.Extensions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid freshest CRL extension in CRL: %w", err)
+	}
+	if len(deltaDistributionPoints) == 0 {
+		deltaDistributionPoints, err = freshestCRLDistributionPoints(cert.Extensions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid freshest CRL extension in certificate: %w", err)
+		}
+	}
+	if len(deltaDistributionPoints) == 0 {
+		return crls, nil
+	}
+	log.Info("retrieving delta certificate revocation list", "distribution points", deltaDistributionPoints)
+	delta, err := m.getCRL(deltaDistributionPoints, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delta CRL: %w", err)
+	}
+	if err := checkDeltaCRL(base, delta); err != nil {
+		return nil, err
+	}
+	crls.delta = delta
+	return crls, nil
 }
 
 // getCRL gets a certificate revocation list using the provided distribution points and returns the certificate list.
-// Returns an error if the CRL could not be downloaded.
-func getCRL(distributionPoints []string, now time.Time) (*pkix.CertificateList, error) {
+// Returns an error if the CRL could not be retrieved.
+func (m *crlManager) getCRL(distributionPoints []string, now time.Time) (*x509.RevocationList, error) {
 	var errs []error
 	for _, distributionPoint := range distributionPoints {
-		// The distribution point is typically a URL with the "http" scheme.  "https" is generally not used because the
-		// certificate list is signed, and because using TLS to get the certificate list could introduce a circular
-		// dependency (cannot use TLS without the revocation list, and cannot get the revocation list without using
-		// TLS).
-		//
-		// TODO Support ldap.
-		switch {
-		case strings.HasPrefix(distributionPoint, "http:"):
-			log.Info("retrieving CRL distribution point", "distribution point", distributionPoint)
-			crl, err := getHTTPCRL(distributionPoint)
-			if err != nil {
-				errs = append(errs, fmt.Errorf("error getting %q: %w", distributionPoint, err))
-				continue
-			}
-			if crl.TBSCertList.NextUpdate.Before(now) {
-				log.Info("CRL expired. trying next distribution point", "nextUpdate", crl.TBSCertList.NextUpdate.Format(time.RFC3339))
-				errs = append(errs, fmt.Errorf("retrieved expired CRL from %s", distributionPoint))
-				continue
-			}
-			return crl, nil
-		default:
+		// The distribution point is typically a URL with the "http" or "ldap" scheme.  "https" and "ldaps" are
+		// generally not used because the certificate list is signed, and because using TLS to get the certificate
+		// list could introduce a circular dependency (cannot use TLS without the revocation list, and cannot get the
+		// revocation list without using TLS).
+		scheme, _, _ := strings.Cut(distributionPoint, ":")
+		fetcher, ok := m.fetchers[strings.ToLower(scheme)]
+		if !ok {
 			errs = append(errs, fmt.Errorf("unsupported distribution point type: %s", distributionPoint))
+			continue
+		}
+		crl, err := m.fetchCRL(fetcher, distributionPoint, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error getting %q: %w", distributionPoint, err))
+			continue
+		}
+		if crl.NextUpdate.Before(now) {
+			log.Info("CRL expired. trying next distribution point", "nextUpdate", crl.NextUpdate.Format(time.RFC3339))
+			errs = append(errs, fmt.Errorf("retrieved expired CRL from %s", distributionPoint))
+			continue
 		}
+		return crl, nil
 	}
 	log.Info("failed to get valid CRL after trying all distribution points")
 	return nil, kerrors.NewAggregate(errs)
 }
 
-// getHTTPCRL gets a certificate revocation list using the provided HTTP URL. Returns an error if the CRL could not be
-// downloaded, or if parsing the CRL fails.
-func getHTTPCRL(url string) (*pkix.CertificateList, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("http.Get failed: %w", err)
-	}
-	defer resp.Body.Close()
-	// If http.Get returned anything other than 200 OK, we can't rely on the response body to actually be a CRL. Return
-	// an error with the status code rather than failing at the parsing stage.
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("got unexpected status %s", resp.Status)
+// fetchCRL gets the CRL at distributionPoint. A cached CRL which has not expired is used as is, otherwise the CRL is
+// retrieved with fetcher, conditionally on the cached CRL having changed, and cached. Returns an error if the CRL could
+// not be retrieved, or if parsing the CRL fails.
+func (m *crlManager) fetchCRL(fetcher Fetcher, distributionPoint string, now time.Time) (*x509.RevocationList, error) {
+	cached := m.cache.get(distributionPoint)
+	if cached != nil {
+		if crl, err := x509.ParseRevocationList(cached.Data); err != nil {
+			log.Info("ignoring invalid cached CRL", "distribution point", distributionPoint, "error", err)
+			cached = nil
+		} else if !crl.NextUpdate.Before(now) {
+			log.Info("using cached CRL", "distribution point", distributionPoint, "nextUpdate", crl.NextUpdate.Format(time.RFC3339))
+			return crl, nil
+		}
 	}
-	crlBytes, err := io.ReadAll(resp.Body)
+
+	log.Info("retrieving CRL distribution point", "distribution point", distributionPoint)
+	fetched, err := fetcher.Fetch(context.Background(), distributionPoint, cached)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response: %w", err)
-	}
-	// Try to decode the CRL from PEM to DER. If pemBlock comes back nil, assume the CRL was already in DER format, and
-	// try to parse anyway.
-	pemBlock, _ := pem.Decode(crlBytes)
-	if pemBlock != nil {
-		if pemBlock.Type == "X509 CRL" {
-			crlBytes = pemBlock.Bytes
-		} else {
-			return nil, fmt.Errorf("error parsing response: file is not CRL type")
-		}
+		return nil, err
 	}
-	crl, err := x509.ParseCRL(crlBytes)
+	crl, err := x509.ParseRevocationList(fetched.Data)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing response: %w", err)
 	}
+	if fetched != cached {
+		if err := m.cache.put(fetched); err != nil {
+			log.Error(err, "failed to cache CRL", "distribution point", distributionPoint)
+		}
+	}
 	return crl, nil
 }
 
+// distributionPoint is a distribution point of the CRL distribution points and freshest CRL extensions, as defined
+// in RFC 5280 section 4.2.1.13.
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	Reason            asn1.BitString        `asn1:"optional,tag:1"`
+	CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+}
+
+type distributionPointName struct {
+	FullName     []asn1.RawValue  `asn1:"optional,tag:0"`
+	RelativeName pkix.RDNSequence `asn1:"optional,tag:1"`
+}
+
+var (
+	// freshestCRLOID is the ASN.1 object identifier for the freshest CRL extension, which lists the distribution
+	// points of the delta CRLs.
+	freshestCRLOID = asn1.ObjectIdentifier{2, 5, 29, 46}
+	// deltaCRLIndicatorOID is the ASN.1 object identifier for the delta CRL indicator extension, which identifies a
+	// delta CRL and the base CRL it applies to.
+	deltaCRLIndicatorOID = asn1.ObjectIdentifier{2, 5, 29, 27}
+)
+
+// freshestCRLDistributionPoints returns the URIs of the freshest CRL extension in extensions, if any.
+func freshestCRLDistributionPoints(extensions []pkix.Extension) ([]string, error) {
+	var uris []string
+	for _, ext := range extensions {
+		if !ext.Id.Equal(freshestCRLOID) {
+			continue
+		}
+		var points []distributionPoint
+		if rest, err := asn1.Unmarshal(ext.Value, &points); err != nil {
+			return nil, err
+		} else if len(rest) != 0 {
+			return nil, fmt.Errorf("trailing data after freshest CRL extension")
+		}
+		for _, point := range points {
+			for _, name := range point.DistributionPoint.FullName {
+				// uniformResourceIdentifier [6] IA5String
+				if name.Class == asn1.ClassContextSpecific && name.Tag == 6 {
+					uris = append(uris, string(name.Bytes))
+				}
+			}
+		}
+	}
+	return uris, nil
+}
+
+// checkDeltaCRL returns an error if delta is not a delta CRL which applies to the base CRL base.
+func checkDeltaCRL(base, delta *x509.RevocationList) error {
+	var baseCRLNumber *big.Int
+	for _, ext := range delta.Extensions {
+		if ext.Id.Equal(deltaCRLIndicatorOID) {
+			baseCRLNumber = new(big.Int)
+			if _, err := asn1.Unmarshal(ext.Value, &baseCRLNumber); err != nil {
+				return fmt.Errorf("invalid delta CRL indicator: %w", err)
+			}
+		}
+	}
+	switch {
+	case baseCRLNumber == nil:
+		return fmt.Errorf("delta CRL has no delta CRL indicator")
+	case !bytes.Equal(base.RawIssuer, delta.RawIssuer):
+		return fmt.Errorf("delta CRL and base CRL have different issuers")
+	case base.Number == nil:
+		return fmt.Errorf("base CRL has no CRL number")
+	case base.Number.Cmp(baseCRLNumber) < 0:
+		// The delta CRL only lists the revocations since the CRL numbered baseCRLNumber, so it can't complete an
+		// older base CRL.
+		return fmt.Errorf("delta CRL applies to base CRL %s, newer than the base CRL %s", baseCRLNumber, base.Number)
+	}
+	return nil
+}
+
 // reapStaleDirectories deletes any subdirectories of mtlsBaseDirectory that are no longer necessary
 func reapStaleDirectories() {
 	log.V(4).Info("cleaning up stale mtls files...")
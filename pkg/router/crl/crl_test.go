@@ -0,0 +1,465 @@
+package crl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testCA is a CA issuing CRLs.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA returns a CA whose certificate has the given CRL distribution points and extensions.
+func newTestCA(t *testing.T, distributionPoints []string, extensions ...pkix.Extension) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		CRLDistributionPoints: distributionPoints,
+		ExtraExtensions:       extensions,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// crl returns a DER encoded CRL numbered number, expiring at nextUpdate, with the given extensions.
+func (ca *testCA) crl(t *testing.T, number int64, nextUpdate time.Time, extensions ...pkix.Extension) []byte {
+	t.Helper()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:          big.NewInt(number),
+		ThisUpdate:      nextUpdate.Add(-time.Hour),
+		NextUpdate:      nextUpdate,
+		ExtraExtensions: extensions,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// freshestCRLExtension returns a freshest CRL extension with the given distribution point.
+func freshestCRLExtension(t *testing.T, uri string) pkix.Extension {
+	t.Helper()
+	value, err := asn1.Marshal([]distributionPoint{{
+		DistributionPoint: distributionPointName{
+			FullName: []asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(uri)}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: freshestCRLOID, Value: value}
+}
+
+// deltaCRLIndicatorExtension returns a delta CRL indicator extension referencing the base CRL baseCRLNumber.
+func deltaCRLIndicatorExtension(t *testing.T, baseCRLNumber int64) pkix.Extension {
+	t.Helper()
+	value, err := asn1.Marshal(big.NewInt(baseCRLNumber))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: deltaCRLIndicatorOID, Critical: true, Value: value}
+}
+
+// crlServer serves CRLs by path, with an ETag, and counts the requests and the full responses.
+type crlServer struct {
+	*httptest.Server
+
+	lock      sync.Mutex
+	crls      map[string][]byte
+	requests  int
+	responses int
+}
+
+func newCRLServer(t *testing.T) *crlServer {
+	s := &crlServer{crls: make(map[string][]byte)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.requests++
+		crl, ok := s.crls[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		etag := fmt.Sprintf(`"%d-%s"`, len(crl), r.URL.Path)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		s.responses++
+		w.Header().Set("ETag", etag)
+		w.Write(crl)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *crlServer) set(path string, crl []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.crls[path] = crl
+}
+
+func (s *crlServer) counts() (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests, s.responses
+}
+
+func TestHTTPFetcher(t *testing.T) {
+	ca := newTestCA(t, nil)
+	der := ca.crl(t, 1, time.Now().Add(time.Hour))
+	server := newCRLServer(t)
+	server.set("/der.crl", der)
+	server.set("/pem.crl", pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}))
+	server.set("/cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+
+	fetcher := NewHTTPFetcher(time.Second, 1024, nil)
+	ctx := context.Background()
+
+	fetched, err := fetcher.Fetch(ctx, server.URL+"/der.crl", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fetched.Data) != string(der) || len(fetched.ETag) == 0 || fetched.URL != server.URL+"/der.crl" {
+		t.Fatalf("unexpected fetched CRL: %+v", fetched)
+	}
+
+	// An unchanged CRL is not downloaded again.
+	if again, err := fetcher.Fetch(ctx, server.URL+"/der.crl", fetched); err != nil {
+		t.Fatal(err)
+	} else if again != fetched {
+		t.Fatalf("expected the cached CRL, got %+v", again)
+	}
+	if requests, responses := server.counts(); requests != 2 || responses != 1 {
+		t.Fatalf("expected 2 requests and 1 full response, got %d and %d", requests, responses)
+	}
+
+	// A PEM encoded CRL is converted to DER.
+	if fetched, err := fetcher.Fetch(ctx, server.URL+"/pem.crl", nil); err != nil {
+		t.Fatal(err)
+	} else if string(fetched.Data) != string(der) {
+		t.Fatal("expected the DER encoding of the PEM encoded CRL")
+	}
+
+	for _, path := range []string{"/cert.pem", "/missing.crl"} {
+		if _, err := fetcher.Fetch(ctx, server.URL+path, nil); err == nil {
+			t.Fatalf("expected an error for %s", path)
+		}
+	}
+
+	small := NewHTTPFetcher(time.Second, int64(len(der)-1), nil)
+	if _, err := small.Fetch(ctx, server.URL+"/der.crl", nil); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("expected a size error, got %v", err)
+	}
+}
+
+func TestHTTPFetcher_timeout(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	fetcher := NewHTTPFetcher(50*time.Millisecond, DefaultMaxCRLSize, nil)
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/slow.crl", nil); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	var noCache *diskCache
+	if err := noCache.put(&FetchedCRL{URL: "http://example.com/ca.crl"}); err != nil {
+		t.Fatal(err)
+	}
+	if noCache.get("http://example.com/ca.crl") != nil {
+		t.Fatal("expected nothing from a nil cache")
+	}
+
+	cache := newDiskCache(t.TempDir() + "/cache")
+	if cache.get("http://example.com/ca.crl") != nil {
+		t.Fatal("expected nothing from an empty cache")
+	}
+	crl := &FetchedCRL{URL: "http://example.com/ca.crl", Data: []byte{1, 2, 3}, ETag: `"1"`, LastModified: "Mon, 19 Oct 2026 10:00:00 GMT"}
+	if err := cache.put(crl); err != nil {
+		t.Fatal(err)
+	}
+	cached := cache.get("http://example.com/ca.crl")
+	if cached == nil || string(cached.Data) != string(crl.Data) || cached.ETag != crl.ETag || cached.LastModified != crl.LastModified {
+		t.Fatalf("unexpected cached CRL: %+v", cached)
+	}
+	if cache.get("http://example.com/other.crl") != nil {
+		t.Fatal("unexpected cached CRL for another distribution point")
+	}
+}
+
+// failingFetcher fails every fetch.
+type failingFetcher struct{}
+
+func (failingFetcher) Fetch(ctx context.Context, url string, cached *FetchedCRL) (*FetchedCRL, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestGetCACRLs(t *testing.T) {
+	server := newCRLServer(t)
+	ca := newTestCA(t, []string{server.URL + "/base.crl"})
+	base := ca.crl(t, 5, time.Now().Add(time.Hour), freshestCRLExtension(t, server.URL+"/delta.crl"))
+	server.set("/base.crl", base)
+	server.set("/delta.crl", ca.crl(t, 6, time.Now().Add(10*time.Minute), deltaCRLIndicatorExtension(t, 5)))
+
+	cacheDir := t.TempDir()
+	m := newCRLManager(Options{CacheDirectory: cacheDir})
+	crls, err := m.getCACRLs(ca.cert, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crls.base.Number.Int64() != 5 || crls.delta == nil || crls.delta.Number.Int64() != 6 {
+		t.Fatalf("unexpected CRLs: %+v", crls)
+	}
+	if !crls.nextUpdate().Equal(crls.delta.NextUpdate) {
+		t.Fatalf("expected the next update of the delta CRL, got %s", crls.nextUpdate())
+	}
+
+	// A restarted router uses the cached CRLs without downloading them.
+	restarted := newCRLManager(Options{CacheDirectory: cacheDir, Fetchers: map[string]Fetcher{"http": failingFetcher{}}})
+	if crls, err := restarted.getCACRLs(ca.cert, time.Now()); err != nil {
+		t.Fatal(err)
+	} else if crls.base.Number.Int64() != 5 || crls.delta == nil {
+		t.Fatalf("unexpected cached CRLs: %+v", crls)
+	}
+
+	// Expired cached CRLs are retrieved again, conditionally.
+	_, responses := server.counts()
+	if _, err := m.getCACRLs(ca.cert, time.Now().Add(30*time.Minute)); err == nil {
+		t.Fatal("expected an error for the expired delta CRL")
+	}
+	if requests, newResponses := server.counts(); requests != 3 || newResponses != responses {
+		t.Fatalf("expected a conditional request for the delta CRL, got %d requests and %d responses", requests, newResponses)
+	}
+
+	// A delta CRL of a newer base CRL is rejected.
+	server.set("/delta.crl", ca.crl(t, 7, time.Now().Add(time.Hour), deltaCRLIndicatorExtension(t, 6)))
+	noCache := newCRLManager(Options{})
+	if _, err := noCache.getCACRLs(ca.cert, time.Now()); err == nil || !strings.Contains(err.Error(), "newer than the base CRL") {
+		t.Fatalf("expected an error for the delta CRL of a newer base CRL, got %v", err)
+	}
+
+	// The freshest CRL extension of the CA certificate is used when the base CRL has none.
+	server.set("/nodelta.crl", ca.crl(t, 6, time.Now().Add(time.Hour)))
+	server.set("/delta.crl", ca.crl(t, 7, time.Now().Add(time.Hour), deltaCRLIndicatorExtension(t, 6)))
+	other := newTestCA(t, []string{server.URL + "/nodelta.crl"}, freshestCRLExtension(t, server.URL+"/delta.crl"))
+	// The CRLs are issued by the first CA, which doesn't matter as their signatures are not checked.
+	if crls, err := noCache.getCACRLs(other.cert, time.Now()); err != nil {
+		t.Fatal(err)
+	} else if crls.delta == nil || crls.delta.Number.Int64() != 7 {
+		t.Fatalf("unexpected CRLs: %+v", crls)
+	}
+}
+
+func TestGetCRL_unsupported(t *testing.T) {
+	m := newCRLManager(Options{})
+	if _, err := m.getCRL([]string{"ldaps://example.com/cn=CA", "ftp://example.com/ca.crl"}, time.Now()); err == nil || !strings.Contains(err.Error(), "unsupported distribution point type") {
+		t.Fatalf("expected an unsupported distribution point error, got %v", err)
+	}
+}
+
+func TestDownloadMissingCRLs_status(t *testing.T) {
+	defer func(s *crlStatus) { status = s }(status)
+	status = &crlStatus{cas: make(map[string]*caStatus)}
+	status.manage()
+	if _, loaded := MissingCRLs(); loaded {
+		t.Fatal("expected the CA bundle not to be loaded")
+	}
+
+	server := newCRLServer(t)
+	available := newTestCA(t, []string{server.URL + "/available.crl"})
+	unavailable := newTestCA(t, []string{server.URL + "/unavailable.crl"})
+	crl := available.crl(t, 1, time.Now().Add(time.Hour))
+	server.set("/available.crl", crl)
+	var bundle []byte
+	for _, ca := range []*testCA{unavailable, available} {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	}
+	availableLabels := []string{available.cert.Subject.String(), hex.EncodeToString(available.cert.SubjectKeyId)}
+	unavailableLabels := []string{unavailable.cert.Subject.String(), hex.EncodeToString(unavailable.cert.SubjectKeyId)}
+
+	m := newCRLManager(Options{})
+	for i := 1; i <= 2; i++ {
+		// The CRLs of all the CAs are tried despite the failure of the first one.
+		if _, _, _, err := m.downloadMissingCRLs(nil, bundle); err == nil {
+			t.Fatal("expected an error for the unavailable CRL")
+		}
+		if failures := testutil.ToFloat64(metricConsecutiveFailures.WithLabelValues(unavailableLabels...)); failures != float64(i) {
+			t.Fatalf("expected %d consecutive failures, got %f", i, failures)
+		}
+	}
+	if size := testutil.ToFloat64(metricSize.WithLabelValues(availableLabels...)); size != float64(len(crl)) {
+		t.Fatalf("expected a size of %d bytes, got %f", len(crl), size)
+	}
+	if failures := testutil.ToFloat64(metricConsecutiveFailures.WithLabelValues(availableLabels...)); failures != 0 {
+		t.Fatalf("expected no failures, got %f", failures)
+	}
+	if missing, loaded := MissingCRLs(); !loaded || len(missing) != 2 {
+		t.Fatalf("expected the CRLs of both CAs to be missing, got %v", missing)
+	}
+
+	server.set("/unavailable.crl", unavailable.crl(t, 1, time.Now().Add(10*time.Minute)))
+	crls, nextUpdate, updated, err := m.downloadMissingCRLs(nil, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated || len(crls) != 2 || !nextUpdate.Equal(crls[unavailableLabels[1]].nextUpdate()) {
+		t.Fatalf("unexpected CRLs %v expiring at %s", crls, nextUpdate)
+	}
+	if failures := testutil.ToFloat64(metricConsecutiveFailures.WithLabelValues(unavailableLabels...)); failures != 0 {
+		t.Fatalf("expected the failures to be reset, got %f", failures)
+	}
+	status.setServed(crls)
+	if missing, loaded := MissingCRLs(); !loaded || len(missing) != 0 {
+		t.Fatalf("expected no missing CRLs, got %v", missing)
+	}
+	if missing, _ := status.missing(time.Now().Add(30 * time.Minute)); len(missing) != 1 || missing[0] != unavailableLabels[0] {
+		t.Fatalf("expected the expired CRL to be missing, got %v", missing)
+	}
+	if value := testutil.ToFloat64(metricMissing.WithLabelValues(unavailableLabels...)); value != 1 {
+		t.Fatalf("expected the expired CRL to be reported missing, got %f", value)
+	}
+
+	// The CAs removed from the bundle are forgotten.
+	status.setRequired(map[string]string{availableLabels[1]: availableLabels[0]})
+	if missing, _ := status.missing(time.Now().Add(30 * time.Minute)); len(missing) != 0 {
+		t.Fatalf("expected no missing CRLs, got %v", missing)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for s, expected := range map[string]Policy{"": PolicyHardFail, "hard-fail": PolicyHardFail, "soft-fail": PolicySoftFail, "fail-open": PolicyFailOpen} {
+		if policy, err := ParsePolicy(s); err != nil || policy != expected {
+			t.Fatalf("expected %q for %q, got %q and %v", expected, s, policy, err)
+		}
+	}
+	if _, err := ParsePolicy("fail-closed"); err == nil {
+		t.Fatal("expected an error for an unknown policy")
+	}
+}
+
+func TestDownloadMissingCRLs_policy(t *testing.T) {
+	defer func(s *crlStatus) { status = s }(status)
+	status = &crlStatus{cas: make(map[string]*caStatus)}
+
+	// All the distribution points are unavailable.
+	server := newCRLServer(t)
+	newCA := func(name string) *testCA {
+		return newTestCA(t, []string{server.URL + "/" + name + ".crl"})
+	}
+	hardFail, softFail, expiredSoftFail, failOpen, cachedFailOpen := newCA("hard-fail"), newCA("soft-fail"), newCA("expired-soft-fail"), newCA("fail-open"), newCA("cached-fail-open")
+	keyID := func(ca *testCA) string {
+		return hex.EncodeToString(ca.cert.SubjectKeyId)
+	}
+	existing := func(ca *testCA, nextUpdate time.Time) *caCRLs {
+		crl, err := x509.ParseRevocationList(ca.crl(t, 1, nextUpdate))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &caCRLs{base: crl}
+	}
+	bundle := func(cas ...*testCA) []byte {
+		var bundle []byte
+		for _, ca := range cas {
+			bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+		}
+		return bundle
+	}
+
+	m := newCRLManager(Options{
+		CacheDirectory: t.TempDir(),
+		Policy:         PolicySoftFail,
+		GracePeriod:    2 * time.Hour,
+		FailOpenCAs:    []string{strings.ToUpper(keyID(failOpen)), keyID(cachedFailOpen)},
+	})
+	// The CRL of a CA which is not in use yet is taken from the cache.
+	if err := m.cache.put(&FetchedCRL{URL: server.URL + "/cached-fail-open.crl", Data: cachedFailOpen.crl(t, 1, time.Now().Add(-time.Hour))}); err != nil {
+		t.Fatal(err)
+	}
+	existingCRLs := map[string]*caCRLs{
+		keyID(softFail):        existing(softFail, time.Now().Add(-time.Hour)),
+		keyID(expiredSoftFail): existing(expiredSoftFail, time.Now().Add(-3*time.Hour)),
+		keyID(failOpen):        existing(failOpen, time.Now().Add(-72*time.Hour)),
+	}
+	crls, nextUpdate, updated, err := m.downloadMissingCRLs(existingCRLs, bundle(softFail, expiredSoftFail, failOpen, cachedFailOpen))
+	if err == nil || !strings.Contains(err.Error(), keyID(expiredSoftFail)) {
+		t.Fatalf("expected an error for the CRL past its grace period, got %v", err)
+	}
+	if !updated || !nextUpdate.After(time.Now()) {
+		t.Fatalf("expected the CRLs to be updated and retried, got %t and %s", updated, nextUpdate)
+	}
+	for _, ca := range []*testCA{softFail, failOpen, cachedFailOpen} {
+		if crl, ok := crls[keyID(ca)]; !ok || !crl.degraded {
+			t.Fatalf("expected the expired CRLs of %s to be served, got %v", keyID(ca), crl)
+		}
+	}
+	if _, ok := crls[keyID(expiredSoftFail)]; ok {
+		t.Fatal("unexpected CRLs served past the grace period")
+	}
+	m.existingCRLs = crls
+	if !m.degraded() {
+		t.Fatal("expected HAProxy to be told to accept expired CRLs")
+	}
+	status.setServed(crls)
+	if missing, _ := status.missing(time.Now()); len(missing) != 1 {
+		t.Fatalf("expected the CRLs of a single CA to be missing, got %v", missing)
+	}
+	if policy := status.cas[keyID(failOpen)].policy; policy != PolicyFailOpen {
+		t.Fatalf("expected the fail-open policy, got %q", policy)
+	}
+
+	// The degraded CRLs are not updated again.
+	if _, _, updated, _ := m.downloadMissingCRLs(crls, bundle(softFail, failOpen, cachedFailOpen)); updated {
+		t.Fatal("unexpected update of the degraded CRLs")
+	}
+
+	// The expired CRLs of a hard-fail CA are left out.
+	hard := newCRLManager(Options{})
+	crls, _, updated, err = hard.downloadMissingCRLs(map[string]*caCRLs{keyID(hardFail): existing(hardFail, time.Now().Add(-time.Minute))}, bundle(hardFail))
+	if err == nil || !updated || len(crls) != 0 {
+		t.Fatalf("expected the expired CRL of the hard-fail CA to be left out, got %v, %t and %v", crls, updated, err)
+	}
+}
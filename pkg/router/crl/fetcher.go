@@ -0,0 +1,132 @@
+package crl
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DefaultFetchTimeout is the default timeout for retrieving a CRL from a distribution point.
+	DefaultFetchTimeout = 30 * time.Second
+	// DefaultMaxCRLSize is the default maximum size in bytes of a retrieved CRL.
+	DefaultMaxCRLSize = 64 * 1024 * 1024
+)
+
+// FetchedCRL is a CRL retrieved from a distribution point, along with the validators which allow retrieving it again
+// only if it has changed.
+type FetchedCRL struct {
+	// URL is the distribution point the CRL was retrieved from.
+	URL string `json:"url"`
+	// Data is the DER encoded CRL.
+	Data []byte `json:"data"`
+	// ETag is the entity tag of the CRL, if the distribution point provided one.
+	ETag string `json:"etag,omitempty"`
+	// LastModified is the last modification time of the CRL, if the distribution point provided one.
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// Fetcher retrieves CRLs from distribution points.
+type Fetcher interface {
+	// Fetch retrieves the CRL at the distribution point url. If cached is not nil, it is a previously retrieved
+	// version of the CRL, which Fetch returns as is if the CRL has not changed since.
+	Fetch(ctx context.Context, url string, cached *FetchedCRL) (*FetchedCRL, error)
+}
+
+// HTTPFetcher retrieves CRLs from "http" distribution points. The CRLs are retrieved with conditional requests when a
+// previous version is known, so that unchanged CRLs are not downloaded again.
+type HTTPFetcher struct {
+	// MaxSize is the maximum size in bytes of a CRL. Larger responses are rejected.
+	MaxSize int64
+
+	client *http.Client
+}
+
+// NewHTTPFetcher returns an HTTPFetcher whose requests time out after timeout and whose responses are limited to
+// maxSize bytes. The requests go through proxyURL if it is not nil, otherwise through the proxy given by the
+// environment, if any.
+func NewHTTPFetcher(timeout time.Duration, maxSize int64, proxyURL *url.URL) *HTTPFetcher {
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != nil {
+		proxy = http.ProxyURL(proxyURL)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return &HTTPFetcher{
+		MaxSize: maxSize,
+		client:  &http.Client{Timeout: timeout, Transport: transport},
+	}
+}
+
+// Fetch retrieves the CRL at url, sending the validators of cached, if any, so that the server responds with "304 Not
+// Modified" if the CRL has not changed. Returns an error if the CRL could not be downloaded, or if it is larger than
+// MaxSize.
+func (f *HTTPFetcher) Fetch(ctx context.Context, url string, cached *FetchedCRL) (*FetchedCRL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if len(cached.ETag) != 0 {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if len(cached.LastModified) != 0 {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		log.V(4).Info("CRL not modified", "distribution point", url)
+		return cached, nil
+	}
+	// If the server returned anything other than 200 OK, we can't rely on the response body to actually be a CRL.
+	// Return an error with the status code rather than failing at the parsing stage.
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got unexpected status %s", resp.Status)
+	}
+	if f.MaxSize > 0 && resp.ContentLength > f.MaxSize {
+		return nil, fmt.Errorf("CRL size %d exceeds the maximum of %d bytes", resp.ContentLength, f.MaxSize)
+	}
+	body := io.Reader(resp.Body)
+	if f.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, f.MaxSize+1)
+	}
+	crlBytes, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	if f.MaxSize > 0 && int64(len(crlBytes)) > f.MaxSize {
+		return nil, fmt.Errorf("CRL exceeds the maximum of %d bytes", f.MaxSize)
+	}
+	der, err := decodeCRL(crlBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing response: %w", err)
+	}
+	return &FetchedCRL{
+		URL:          url,
+		Data:         der,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// decodeCRL returns the DER encoding of a CRL given either in PEM or in DER format.
+func decodeCRL(crlBytes []byte) ([]byte, error) {
+	// Try to decode the CRL from PEM to DER. If pemBlock comes back nil, assume the CRL was already in DER format.
+	pemBlock, _ := pem.Decode(crlBytes)
+	if pemBlock == nil {
+		return crlBytes, nil
+	}
+	if pemBlock.Type != "X509 CRL" {
+		return nil, fmt.Errorf("file is not CRL type")
+	}
+	return pemBlock.Bytes, nil
+}
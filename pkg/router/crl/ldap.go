@@ -0,0 +1,332 @@
+package crl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+const (
+	// defaultLDAPPort is the port of the "ldap" distribution points which specify none.
+	defaultLDAPPort = "389"
+	// defaultLDAPAttribute is the attribute of the CRL in the entry of an "ldap" distribution point which specifies no
+	// attribute.
+	defaultLDAPAttribute = "certificateRevocationList;binary"
+)
+
+// classApplication is the class of the tags of the LDAP protocol operations.
+const classApplication = 0x40
+
+// Tags of the LDAP messages, from RFC 4511.
+var (
+	ldapUnbindRequest     = asn1.Tag(2 | classApplication)
+	ldapSearchRequest     = asn1.Tag(3 | classApplication).Constructed()
+	ldapSearchResultEntry = asn1.Tag(4 | classApplication).Constructed()
+	ldapSearchResultDone  = asn1.Tag(5 | classApplication).Constructed()
+	ldapFilterPresent     = asn1.Tag(7).ContextSpecific()
+)
+
+// maxLDAPMessages is the maximum number of messages read in response to a search. A search of a single entry returns
+// an entry and the result, possibly with a few references, which are ignored.
+const maxLDAPMessages = 16
+
+// LDAPFetcher retrieves CRLs from "ldap" distribution points, with an anonymous LDAPv3 search of the attribute of the
+// entry given by the distribution point URL, as defined in RFC 4516:
+//
+//	ldap://ldap.example.com/cn=Example%20CA,o=Example?certificateRevocationList;binary
+//
+// LDAP has no conditional retrieval, so an unchanged CRL is downloaded again, but the cached CRL is returned as is.
+type LDAPFetcher struct {
+	// Timeout is the timeout of the connection to the LDAP server and of the search.
+	Timeout time.Duration
+	// MaxSize is the maximum size in bytes of the LDAP messages, hence of a CRL. Larger messages are rejected.
+	MaxSize int64
+}
+
+// NewLDAPFetcher returns an LDAPFetcher whose connections and searches time out after timeout and whose responses are
+// limited to maxSize bytes.
+func NewLDAPFetcher(timeout time.Duration, maxSize int64) *LDAPFetcher {
+	return &LDAPFetcher{Timeout: timeout, MaxSize: maxSize}
+}
+
+// Fetch retrieves the CRL at the LDAP URL url. Returns cached if the CRL has not changed, and an error if the CRL could
+// not be retrieved, or if the LDAP messages are larger than MaxSize.
+func (f *LDAPFetcher) Fetch(ctx context.Context, url string, cached *FetchedCRL) (*FetchedCRL, error) {
+	address, dn, attributes, err := parseLDAPURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("ldap connection failed: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	crlBytes, err := f.search(conn, dn, attributes)
+	if err != nil {
+		return nil, err
+	}
+	// Unbind politely, ignoring failures as the CRL has been retrieved.
+	var unbind cryptobyte.Builder
+	unbind.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1Int64(2)
+		b.AddASN1(ldapUnbindRequest, func(b *cryptobyte.Builder) {})
+	})
+	conn.Write(unbind.BytesOrPanic())
+
+	der, err := decodeCRL(crlBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing response: %w", err)
+	}
+	if cached != nil && bytes.Equal(cached.Data, der) {
+		log.V(4).Info("CRL not modified", "distribution point", url)
+		return cached, nil
+	}
+	return &FetchedCRL{URL: url, Data: der}, nil
+}
+
+// search sends the search request of the attributes of the entry dn on conn, and returns the first value of the
+// attributes found.
+func (f *LDAPFetcher) search(conn net.Conn, dn string, attributes []string) ([]byte, error) {
+	const messageID = 1
+	var timeLimit int64
+	if f.Timeout > 0 {
+		timeLimit = int64((f.Timeout + time.Second - 1) / time.Second)
+	}
+	var b cryptobyte.Builder
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1Int64(messageID)
+		b.AddASN1(ldapSearchRequest, func(b *cryptobyte.Builder) {
+			b.AddASN1OctetString([]byte(dn))
+			// the baseObject scope, neverDerefAliases, no size limit and the values of the attributes
+			b.AddASN1Enum(0)
+			b.AddASN1Enum(0)
+			b.AddASN1Int64(0)
+			b.AddASN1Int64(timeLimit)
+			b.AddASN1Boolean(false)
+			b.AddASN1(ldapFilterPresent, func(b *cryptobyte.Builder) {
+				b.AddBytes([]byte("objectClass"))
+			})
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				for _, attribute := range attributes {
+					b.AddASN1OctetString([]byte(attribute))
+				}
+			})
+		})
+	})
+	request, err := b.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("ldap search failed: %w", err)
+	}
+	if _, err := conn.Write(request); err != nil {
+		return nil, fmt.Errorf("ldap search failed: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	var value []byte
+	for i := 0; i < maxLDAPMessages; i++ {
+		message, err := readLDAPMessage(r, f.MaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("ldap search failed: %w", err)
+		}
+		var id int
+		var op cryptobyte.String
+		var opTag asn1.Tag
+		if !readBERInt(&message, &id, asn1.INTEGER) || !readAnyBER(&message, &op, &opTag) {
+			return nil, fmt.Errorf("ldap search failed: invalid message")
+		}
+		if id != messageID {
+			// Unsolicited notifications, such as a notice of disconnection, have the message ID 0.
+			return nil, fmt.Errorf("ldap search failed: unexpected message with ID %d", id)
+		}
+		switch opTag {
+		case ldapSearchResultEntry:
+			if value == nil {
+				value = findLDAPAttribute(op, attributes)
+			}
+		case ldapSearchResultDone:
+			var code int
+			var matchedDN, diagnosticMessage cryptobyte.String
+			if !readBERInt(&op, &code, asn1.ENUM) || !readBER(&op, &matchedDN, asn1.OCTET_STRING) || !readBER(&op, &diagnosticMessage, asn1.OCTET_STRING) {
+				return nil, fmt.Errorf("ldap search failed: invalid result")
+			}
+			if code != 0 {
+				return nil, fmt.Errorf("ldap search failed with result code %d: %s", code, diagnosticMessage)
+			}
+			if value == nil {
+				return nil, fmt.Errorf("no %s attribute in entry %q", strings.Join(attributes, " or "), dn)
+			}
+			return value, nil
+		}
+		// Search result references are ignored.
+	}
+	return nil, fmt.Errorf("ldap search failed: no result after %d messages", maxLDAPMessages)
+}
+
+// findLDAPAttribute returns the first value of the attributes of the search result entry, or nil if it has none.
+func findLDAPAttribute(entry cryptobyte.String, attributes []string) []byte {
+	var objectName, partialAttributes cryptobyte.String
+	if !readBER(&entry, &objectName, asn1.OCTET_STRING) || !readBER(&entry, &partialAttributes, asn1.SEQUENCE) {
+		return nil
+	}
+	for !partialAttributes.Empty() {
+		var attribute, attributeType, values cryptobyte.String
+		if !readBER(&partialAttributes, &attribute, asn1.SEQUENCE) || !readBER(&attribute, &attributeType, asn1.OCTET_STRING) || !readBER(&attribute, &values, asn1.SET) {
+			return nil
+		}
+		// The server may return the attribute with or without the ";binary" transfer option.
+		name := strings.TrimSuffix(strings.ToLower(string(attributeType)), ";binary")
+		for _, requested := range attributes {
+			if name != strings.TrimSuffix(strings.ToLower(requested), ";binary") {
+				continue
+			}
+			var value cryptobyte.String
+			if readBER(&values, &value, asn1.OCTET_STRING) {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+// parseLDAPURL returns the address of the server, the distinguished name of the entry and the attributes of an LDAP
+// URL.
+func parseLDAPURL(ldapURL string) (string, string, []string, error) {
+	u, err := url.Parse(ldapURL)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if u.Scheme != "ldap" {
+		return "", "", nil, fmt.Errorf("unsupported LDAP URL scheme %q", u.Scheme)
+	}
+	if len(u.Host) == 0 {
+		// The client default LDAP server is unknown.
+		return "", "", nil, fmt.Errorf("no LDAP server in %q", ldapURL)
+	}
+	address := u.Host
+	if len(u.Port()) == 0 {
+		address = net.JoinHostPort(u.Hostname(), defaultLDAPPort)
+	}
+	dn := strings.TrimPrefix(u.Path, "/")
+	var attributes []string
+	attrs, _, _ := strings.Cut(u.RawQuery, "?")
+	for _, attribute := range strings.Split(attrs, ",") {
+		if attribute, err = url.PathUnescape(attribute); err != nil {
+			return "", "", nil, fmt.Errorf("invalid LDAP attribute in %q: %w", ldapURL, err)
+		}
+		if len(attribute) != 0 {
+			attributes = append(attributes, attribute)
+		}
+	}
+	if len(attributes) == 0 {
+		attributes = []string{defaultLDAPAttribute}
+	}
+	return address, dn, attributes, nil
+}
+
+// readLDAPMessage reads an LDAP message of at most maxSize bytes, if maxSize is positive, and returns its content.
+func readLDAPMessage(r *bufio.Reader, maxSize int64) (cryptobyte.String, error) {
+	// The header is the identifier octet and the length, whose first octet gives the number of the following ones.
+	peeked, err := r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if peeked[1]&0x80 != 0 {
+		if peeked, err = r.Peek(2 + int(peeked[1]&0x7f)); err != nil {
+			return nil, err
+		}
+	}
+	header := cryptobyte.String(peeked)
+	tag, length, ok := readBERHeader(&header)
+	if !ok || tag != asn1.SEQUENCE {
+		return nil, errors.New("invalid message")
+	}
+	if maxSize > 0 && length > uint64(maxSize) {
+		return nil, fmt.Errorf("message size %d exceeds the maximum of %d bytes", length, maxSize)
+	}
+	if _, err := r.Discard(len(peeked) - len(header)); err != nil {
+		return nil, err
+	}
+	// The content buffer grows as the content is read, rather than trusting the length.
+	buf := &bytes.Buffer{}
+	if _, err := io.CopyN(buf, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return cryptobyte.String(buf.Bytes()), nil
+}
+
+// readBERHeader reads the identifier and length octets of a BER element. Unlike cryptobyte, which only reads DER, it
+// accepts the non-minimal lengths of some LDAP servers, e.g. Active Directory, which encodes all lengths on 4 octets.
+// Only the definite lengths and the low tag numbers, used by LDAP, are supported.
+func readBERHeader(s *cryptobyte.String) (asn1.Tag, uint64, bool) {
+	var identifier, first uint8
+	if !s.ReadUint8(&identifier) || !s.ReadUint8(&first) || identifier&0x1f == 0x1f {
+		return 0, 0, false
+	}
+	if first&0x80 == 0 {
+		return asn1.Tag(identifier), uint64(first), true
+	}
+	var lengthBytes []byte
+	if n := int(first & 0x7f); n == 0 || n > 4 || !s.ReadBytes(&lengthBytes, n) {
+		return 0, 0, false
+	}
+	var length uint64
+	for _, b := range lengthBytes {
+		length = length<<8 | uint64(b)
+	}
+	return asn1.Tag(identifier), length, true
+}
+
+// readAnyBER reads a BER element into out, and its tag into outTag.
+func readAnyBER(s *cryptobyte.String, out *cryptobyte.String, outTag *asn1.Tag) bool {
+	tag, length, ok := readBERHeader(s)
+	var content []byte
+	if !ok || length > uint64(len(*s)) || !s.ReadBytes(&content, int(length)) {
+		return false
+	}
+	*out, *outTag = cryptobyte.String(content), tag
+	return true
+}
+
+// readBER reads a BER element with the given tag into out.
+func readBER(s *cryptobyte.String, out *cryptobyte.String, tag asn1.Tag) bool {
+	var actual asn1.Tag
+	return readAnyBER(s, out, &actual) && actual == tag
+}
+
+// readBERInt reads a small INTEGER or ENUMERATED BER element with the given tag into out.
+func readBERInt(s *cryptobyte.String, out *int, tag asn1.Tag) bool {
+	var content cryptobyte.String
+	if !readBER(s, &content, tag) || len(content) == 0 || len(content) > 4 {
+		return false
+	}
+	n := int(int8(content[0]))
+	for _, b := range content[1:] {
+		n = n<<8 | int(b)
+	}
+	*out = n
+	return true
+}
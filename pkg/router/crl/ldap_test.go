@@ -0,0 +1,250 @@
+package crl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// ldapStub is an in-process LDAP server answering the searches of the attributes of its entries.
+type ldapStub struct {
+	listener net.Listener
+	// entries are the attributes of the entries, by distinguished name.
+	entries map[string]map[string][]byte
+	// hang is set for the server not to answer.
+	hang bool
+	// longLengths is set for the server to encode its messages with non-minimal lengths, like Active Directory.
+	longLengths bool
+	// flood is set for the server to answer with search result entries and never with the result.
+	flood bool
+}
+
+func newLDAPStub(t *testing.T) *ldapStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapStub{listener: listener, entries: make(map[string]map[string][]byte)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(t, conn)
+		}
+	}()
+	return s
+}
+
+// url returns the LDAP URL of the attribute of the entry dn.
+func (s *ldapStub) url(dn, attribute string) string {
+	return "ldap://" + s.listener.Addr().String() + "/" + strings.ReplaceAll(dn, " ", "%20") + "?" + attribute
+}
+
+// element encodes an element, with a 4 bytes length if longLengths is set.
+func (s *ldapStub) element(tag asn1.Tag, content ...[]byte) []byte {
+	body := bytes.Join(content, nil)
+	if s.longLengths {
+		length := len(body)
+		return append([]byte{byte(tag), 0x84, byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)}, body...)
+	}
+	var b cryptobyte.Builder
+	b.AddASN1(tag, func(b *cryptobyte.Builder) { b.AddBytes(body) })
+	return b.BytesOrPanic()
+}
+
+// message encodes an LDAP message.
+func (s *ldapStub) message(id byte, op asn1.Tag, content ...[]byte) []byte {
+	return s.element(asn1.SEQUENCE, s.element(asn1.INTEGER, []byte{id}), s.element(op, content...))
+}
+
+// result encodes a search result with the given result code.
+func (s *ldapStub) result(id, code byte, diagnosticMessage string) []byte {
+	return s.message(id, ldapSearchResultDone, s.element(asn1.ENUM, []byte{code}), s.element(asn1.OCTET_STRING), s.element(asn1.OCTET_STRING, []byte(diagnosticMessage)))
+}
+
+func (s *ldapStub) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	message, err := readLDAPMessage(r, 0)
+	if err != nil {
+		t.Errorf("failed to read the LDAP request: %v", err)
+		return
+	}
+	if s.hang {
+		r.ReadByte()
+		return
+	}
+	var id int64
+	var request, dn, attributes cryptobyte.String
+	if !message.ReadASN1Integer(&id) || !message.ReadASN1(&request, ldapSearchRequest) ||
+		!request.ReadASN1(&dn, asn1.OCTET_STRING) ||
+		!request.SkipASN1(asn1.ENUM) || !request.SkipASN1(asn1.ENUM) ||
+		!request.SkipASN1(asn1.INTEGER) || !request.SkipASN1(asn1.INTEGER) ||
+		!request.SkipASN1(asn1.BOOLEAN) || !request.SkipASN1(ldapFilterPresent) ||
+		!request.ReadASN1(&attributes, asn1.SEQUENCE) || !request.Empty() {
+		t.Errorf("unexpected LDAP request: %x", message)
+		return
+	}
+
+	entry, ok := s.entries[string(dn)]
+	if !ok {
+		conn.Write(s.result(byte(id), 32, "no such object"))
+		return
+	}
+	var partialAttributes [][]byte
+	for !attributes.Empty() {
+		var attribute cryptobyte.String
+		if !attributes.ReadASN1(&attribute, asn1.OCTET_STRING) {
+			t.Errorf("unexpected LDAP attributes: %x", attributes)
+			return
+		}
+		// the attribute is returned without the ";binary" option, like OpenLDAP does.
+		name := strings.TrimSuffix(string(attribute), ";binary")
+		if value, ok := entry[name]; ok {
+			partialAttributes = append(partialAttributes, s.element(asn1.SEQUENCE, s.element(asn1.OCTET_STRING, []byte(name)), s.element(asn1.SET, s.element(asn1.OCTET_STRING, value))))
+		}
+	}
+	searchResultEntry := s.message(byte(id), ldapSearchResultEntry, s.element(asn1.OCTET_STRING, dn), s.element(asn1.SEQUENCE, partialAttributes...))
+	if s.flood {
+		for i := 0; i <= maxLDAPMessages; i++ {
+			if _, err := conn.Write(searchResultEntry); err != nil {
+				return
+			}
+		}
+		return
+	}
+	conn.Write(searchResultEntry)
+	conn.Write(s.result(byte(id), 0, ""))
+}
+
+func TestParseLDAPURL(t *testing.T) {
+	testCases := []struct {
+		url        string
+		address    string
+		dn         string
+		attributes []string
+		expectErr  bool
+	}{
+		{
+			url:        "ldap://ldap.example.com/cn=Example%20CA,o=Example?certificateRevocationList;binary",
+			address:    "ldap.example.com:389",
+			dn:         "cn=Example CA,o=Example",
+			attributes: []string{"certificateRevocationList;binary"},
+		},
+		{
+			url:        "ldap://ldap.example.com:1389/cn=CA?authorityRevocationList,certificateRevocationList?base?objectClass=cRLDistributionPoint",
+			address:    "ldap.example.com:1389",
+			dn:         "cn=CA",
+			attributes: []string{"authorityRevocationList", "certificateRevocationList"},
+		},
+		{
+			url:        "ldap://ldap.example.com/cn=CA",
+			address:    "ldap.example.com:389",
+			dn:         "cn=CA",
+			attributes: []string{defaultLDAPAttribute},
+		},
+		{
+			url:       "ldap:///CN=CA,CN=CDP,CN=Public%20Key%20Services?certificateRevocationList?base",
+			expectErr: true,
+		},
+		{
+			url:       "ldaps://ldap.example.com/cn=CA",
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		address, dn, attributes, err := parseLDAPURL(tc.url)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.url, err)
+			continue
+		}
+		if address != tc.address || dn != tc.dn || !reflect.DeepEqual(attributes, tc.attributes) {
+			t.Errorf("%s: unexpected address %q, dn %q and attributes %q", tc.url, address, dn, attributes)
+		}
+	}
+}
+
+func TestLDAPFetcher(t *testing.T) {
+	ca := newTestCA(t, nil)
+	der := ca.crl(t, 1, time.Now().Add(time.Hour))
+	stub := newLDAPStub(t)
+	stub.entries["cn=Example CA,o=Example"] = map[string][]byte{"certificateRevocationList": der}
+	ctx := context.Background()
+
+	for _, longLengths := range []bool{false, true} {
+		stub.longLengths = longLengths
+		fetcher := NewLDAPFetcher(time.Second, DefaultMaxCRLSize)
+		url := stub.url("cn=Example CA,o=Example", "certificateRevocationList;binary")
+		fetched, err := fetcher.Fetch(ctx, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(fetched.Data) != string(der) || fetched.URL != url {
+			t.Fatalf("unexpected fetched CRL: %+v", fetched)
+		}
+		// An unchanged CRL is returned as cached.
+		if again, err := fetcher.Fetch(ctx, url, fetched); err != nil {
+			t.Fatal(err)
+		} else if again != fetched {
+			t.Fatalf("expected the cached CRL, got %+v", again)
+		}
+	}
+
+	fetcher := NewLDAPFetcher(time.Second, DefaultMaxCRLSize)
+	for _, url := range []string{stub.url("cn=Other CA,o=Example", "certificateRevocationList"), stub.url("cn=Example CA,o=Example", "deltaRevocationList")} {
+		if _, err := fetcher.Fetch(ctx, url, nil); err == nil {
+			t.Fatalf("expected an error for %s", url)
+		}
+	}
+
+	small := NewLDAPFetcher(time.Second, int64(len(der)))
+	if _, err := small.Fetch(ctx, stub.url("cn=Example CA,o=Example", "certificateRevocationList"), nil); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("expected a size error, got %v", err)
+	}
+
+	// The search fails rather than reading messages until the timeout.
+	stub.flood = true
+	if _, err := fetcher.Fetch(ctx, stub.url("cn=Example CA,o=Example", "certificateRevocationList"), nil); err == nil || !strings.Contains(err.Error(), "no result") {
+		t.Fatalf("expected an error for too many messages, got %v", err)
+	}
+	stub.flood = false
+
+	stub.hang = true
+	slow := NewLDAPFetcher(50*time.Millisecond, DefaultMaxCRLSize)
+	if _, err := slow.Fetch(ctx, stub.url("cn=Example CA,o=Example", "certificateRevocationList"), nil); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+}
+
+func TestGetCRL_ldap(t *testing.T) {
+	ca := newTestCA(t, nil)
+	stub := newLDAPStub(t)
+	stub.entries["cn=Example CA"] = map[string][]byte{"certificateRevocationList": ca.crl(t, 3, time.Now().Add(time.Hour))}
+
+	// The unsupported distribution points are skipped.
+	m := newCRLManager(Options{})
+	crl, err := m.getCRL([]string{"ftp://example.com/ca.crl", stub.url("cn=Example CA", "certificateRevocationList;binary")}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crl.Number.Int64() != 3 {
+		t.Fatalf("unexpected CRL number %s", crl.Number)
+	}
+}
@@ -0,0 +1,89 @@
+package crl
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// caLabels identify the CA of the CRLs: its subject, and its subject key
+	// identifier, which is the authority key identifier of its CRLs.
+	caLabels = []string{"ca", "key_id"}
+
+	// metricLastSuccess is the time the CRLs of each CA were last retrieved.
+	metricLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "router",
+		Subsystem: "crl",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Time the CRLs of each client CA were last retrieved, in seconds since the epoch.",
+	}, caLabels)
+
+	// metricNextUpdate is the time the CRLs of each CA expire.
+	metricNextUpdate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "router",
+		Subsystem: "crl",
+		Name:      "next_update_timestamp_seconds",
+		Help:      "Time the last retrieved CRLs of each client CA expire, in seconds since the epoch.",
+	}, caLabels)
+
+	// metricConsecutiveFailures is the number of failures to retrieve the
+	// CRLs of each CA since they were last retrieved.
+	metricConsecutiveFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "router",
+		Subsystem: "crl",
+		Name:      "consecutive_failures",
+		Help:      "Number of consecutive failures to retrieve the CRLs of each client CA.",
+	}, caLabels)
+
+	// metricSize is the size of the CRLs of each CA.
+	metricSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "router",
+		Subsystem: "crl",
+		Name:      "size_bytes",
+		Help:      "Size of the last retrieved CRLs of each client CA in bytes.",
+	}, caLabels)
+
+	// metricMissing is 1 for each CA whose CRLs HAProxy doesn't have, or
+	// which expired, so that HAProxy rejects the clients of the CA.
+	metricMissing = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "router",
+		Subsystem: "crl",
+		Name:      "missing",
+		Help:      "Whether HAProxy lacks valid CRLs for each client CA, rejecting its clients.",
+	}, caLabels)
+
+	// metricPolicy is 1 for the policy of each CA.
+	metricPolicy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "router",
+		Subsystem: "crl",
+		Name:      "policy",
+		Help:      "Policy of each client CA when its CRLs can't be retrieved.",
+	}, append(caLabels, "policy"))
+
+	// metricDegraded is 1 for each CA whose expired CRLs are served as
+	// allowed by its policy.
+	metricDegraded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "router",
+		Subsystem: "crl",
+		Name:      "degraded",
+		Help:      "Whether the expired CRLs of each client CA are served as allowed by its policy.",
+	}, caLabels)
+
+	registerMetricsOnce sync.Once
+)
+
+// registerMetrics registers the CRL metrics.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(
+			metricLastSuccess,
+			metricNextUpdate,
+			metricConsecutiveFailures,
+			metricSize,
+			metricMissing,
+			metricPolicy,
+			metricDegraded,
+		)
+	})
+}
@@ -0,0 +1,105 @@
+package crl
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Policy decides whether the clients of a CA are accepted once its CRLs expired and new ones can't be retrieved.
+type Policy string
+
+const (
+	// PolicyHardFail rejects the clients of the CA as soon as its CRLs expire.
+	PolicyHardFail Policy = "hard-fail"
+	// PolicySoftFail keeps serving the last retrieved CRLs of the CA past their next update, for a grace period.
+	PolicySoftFail Policy = "soft-fail"
+	// PolicyFailOpen keeps serving the last retrieved CRLs of the CA past their next update, for as long as new ones
+	// can't be retrieved. The clients of a CA whose CRLs were never retrieved are still rejected.
+	PolicyFailOpen Policy = "fail-open"
+)
+
+// DefaultGracePeriod is the default period the CRLs of the CAs with PolicySoftFail are served past their next update.
+const DefaultGracePeriod = 24 * time.Hour
+
+// ParsePolicy returns the policy named s, which may be "hard-fail", "soft-fail" or "fail-open". An empty name is the
+// "hard-fail" policy.
+func ParsePolicy(s string) (Policy, error) {
+	switch Policy(s) {
+	case "", PolicyHardFail:
+		return PolicyHardFail, nil
+	case PolicySoftFail, PolicyFailOpen:
+		return Policy(s), nil
+	}
+	return "", fmt.Errorf("unknown CRL policy %q: must be one of %q, %q or %q", s, PolicyHardFail, PolicySoftFail, PolicyFailOpen)
+}
+
+// normalizeKeyID returns a subject key ID in the hex format of the CRL metrics, accepting upper case and colon
+// separated bytes as printed by openssl.
+func normalizeKeyID(keyID string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(keyID), ":", ""))
+}
+
+// policy returns the policy of the CA with the given subject key ID.
+func (m *crlManager) policy(keyID string) Policy {
+	if _, ok := m.failOpenCAs[keyID]; ok {
+		return PolicyFailOpen
+	}
+	return m.defaultPolicy
+}
+
+// allows returns whether policy allows serving the expired CRLs crls at now.
+func (m *crlManager) allows(policy Policy, crls *caCRLs, now time.Time) bool {
+	switch policy {
+	case PolicySoftFail:
+		return now.Before(crls.nextUpdate().Add(m.gracePeriod))
+	case PolicyFailOpen:
+		return true
+	}
+	return false
+}
+
+// lastCRLs returns the last retrieved CRLs of the CA certificate cert, expired or not: existing if it is not nil,
+// otherwise the cached CRLs, so that they survive a restart of the router. Returns nil if there are none.
+func (m *crlManager) lastCRLs(cert *x509.Certificate, existing *caCRLs) *caCRLs {
+	if existing != nil {
+		return existing
+	}
+	for _, distributionPoint := range cert.CRLDistributionPoints {
+		base := m.cachedCRL(distributionPoint)
+		if base == nil {
+			continue
+		}
+		crls := &caCRLs{base: base}
+		deltaDistributionPoints, _ := freshestCRLDistributionPoints(base.Extensions)
+		if len(deltaDistributionPoints) == 0 {
+			deltaDistributionPoints, _ = freshestCRLDistributionPoints(cert.Extensions)
+		}
+		for _, deltaDistributionPoint := range deltaDistributionPoints {
+			if delta := m.cachedCRL(deltaDistributionPoint); delta != nil && checkDeltaCRL(base, delta) == nil {
+				crls.delta = delta
+				break
+			}
+		}
+		if len(deltaDistributionPoints) != 0 && crls.delta == nil {
+			// The base CRL alone would miss the revocations of the delta CRL.
+			continue
+		}
+		return crls
+	}
+	return nil
+}
+
+// cachedCRL returns the cached CRL of distributionPoint, expired or not, or nil if there is none.
+func (m *crlManager) cachedCRL(distributionPoint string) *x509.RevocationList {
+	cached := m.cache.get(distributionPoint)
+	if cached == nil {
+		return nil
+	}
+	crl, err := x509.ParseRevocationList(cached.Data)
+	if err != nil {
+		return nil
+	}
+	return crl
+}
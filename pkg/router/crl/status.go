@@ -0,0 +1,169 @@
+package crl
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// caStatus is the state of the CRLs of a client CA.
+type caStatus struct {
+	// subject is the subject of the CA.
+	subject string
+	// failures is the number of consecutive failures to retrieve the CRLs.
+	failures int
+	// policy is the policy of the CA.
+	policy Policy
+	// served is the time the CRLs in the CRL file in use expire, zero if the
+	// file has no CRLs of the CA.
+	served time.Time
+	// degraded is set when the served CRLs expired but are served as allowed
+	// by the policy of the CA.
+	degraded bool
+}
+
+// crlStatus tracks the state of the CRLs of the client CAs which specify CRL
+// distribution points, for the metrics and the readiness check.
+type crlStatus struct {
+	lock sync.Mutex
+	// managed is set when the CRLs are managed by ManageCRLs.
+	managed bool
+	// loaded is set once the CAs requiring CRLs are known.
+	loaded bool
+	// cas are the CAs requiring CRLs, by subject key ID.
+	cas map[string]*caStatus
+}
+
+// status is the state of the CRLs managed by ManageCRLs.
+var status = &crlStatus{cas: make(map[string]*caStatus)}
+
+// MissingCRLs returns the subjects of the client CAs whose CRLs HAProxy
+// doesn't have, or which expired, so that HAProxy rejects their clients. The
+// returned boolean is false while the client CA bundle has not been loaded
+// yet, in which case the CRLs are not known.
+func MissingCRLs() ([]string, bool) {
+	return status.missing(time.Now())
+}
+
+// manage marks the CRLs as managed, until the CAs requiring CRLs are known.
+func (s *crlStatus) manage() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.managed = true
+}
+
+// setRequired sets the CAs requiring CRLs, subjects by subject key ID, and
+// forgets the other CAs.
+func (s *crlStatus) setRequired(subjects map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.loaded = true
+	for keyID, ca := range s.cas {
+		if _, ok := subjects[keyID]; !ok {
+			labels := []string{ca.subject, keyID}
+			metricLastSuccess.DeleteLabelValues(labels...)
+			metricNextUpdate.DeleteLabelValues(labels...)
+			metricConsecutiveFailures.DeleteLabelValues(labels...)
+			metricSize.DeleteLabelValues(labels...)
+			metricMissing.DeleteLabelValues(labels...)
+			metricPolicy.DeleteLabelValues(append(labels, string(ca.policy))...)
+			metricDegraded.DeleteLabelValues(labels...)
+			delete(s.cas, keyID)
+		}
+	}
+	for keyID, subject := range subjects {
+		s.get(keyID, subject)
+	}
+}
+
+// get returns the status of a CA, adding it if it is not known yet. The
+// caller must hold the lock.
+func (s *crlStatus) get(keyID, subject string) *caStatus {
+	ca, ok := s.cas[keyID]
+	if !ok {
+		ca = &caStatus{subject: subject}
+		s.cas[keyID] = ca
+		metricConsecutiveFailures.WithLabelValues(subject, keyID).Set(0)
+	}
+	return ca
+}
+
+// setPolicy records the policy of a CA.
+func (s *crlStatus) setPolicy(keyID, subject string, policy Policy) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ca := s.get(keyID, subject)
+	if ca.policy == policy {
+		return
+	}
+	if len(ca.policy) != 0 {
+		metricPolicy.DeleteLabelValues(subject, keyID, string(ca.policy))
+	}
+	ca.policy = policy
+	metricPolicy.WithLabelValues(subject, keyID, string(policy)).Set(1)
+}
+
+// recordSuccess records the retrieval of the CRLs of a CA.
+func (s *crlStatus) recordSuccess(keyID, subject string, crls *caCRLs, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.get(keyID, subject).failures = 0
+	size := len(crls.base.Raw)
+	if crls.delta != nil {
+		size += len(crls.delta.Raw)
+	}
+	metricLastSuccess.WithLabelValues(subject, keyID).Set(float64(now.Unix()))
+	metricNextUpdate.WithLabelValues(subject, keyID).Set(float64(crls.nextUpdate().Unix()))
+	metricConsecutiveFailures.WithLabelValues(subject, keyID).Set(0)
+	metricSize.WithLabelValues(subject, keyID).Set(float64(size))
+}
+
+// recordFailure records a failure to retrieve the CRLs of a CA.
+func (s *crlStatus) recordFailure(keyID, subject string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ca := s.get(keyID, subject)
+	ca.failures++
+	metricConsecutiveFailures.WithLabelValues(subject, keyID).Set(float64(ca.failures))
+}
+
+// setServed records the CRLs of the CRL file in use, by subject key ID.
+func (s *crlStatus) setServed(crls map[string]*caCRLs) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for keyID, ca := range s.cas {
+		if crl, ok := crls[keyID]; ok {
+			ca.served, ca.degraded = crl.nextUpdate(), crl.degraded
+		} else {
+			ca.served, ca.degraded = time.Time{}, false
+		}
+		degraded := 0.0
+		if ca.degraded {
+			degraded = 1
+		}
+		metricDegraded.WithLabelValues(ca.subject, keyID).Set(degraded)
+	}
+}
+
+// missing returns the subjects of the CAs without valid CRLs at now, and
+// updates the metrics accordingly. The expired CRLs served as allowed by the
+// policy of their CA are valid. The returned boolean is false while the
+// CAs requiring CRLs are not known.
+func (s *crlStatus) missing(now time.Time) ([]string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.managed && !s.loaded {
+		return nil, false
+	}
+	var missing []string
+	for keyID, ca := range s.cas {
+		value := 0.0
+		if ca.served.IsZero() || (ca.served.Before(now) && !ca.degraded) {
+			missing = append(missing, ca.subject)
+			value = 1
+		}
+		metricMissing.WithLabelValues(ca.subject, keyID).Set(value)
+	}
+	sort.Strings(missing)
+	return missing, true
+}
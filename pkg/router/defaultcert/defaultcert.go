@@ -0,0 +1,107 @@
+// Package defaultcert selects, among several default certificates, the one
+// served for the routes which don't provide their own certificate.
+package defaultcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Certificate is a default certificate, served for the routes without their
+// own certificate whose host matches one of its DNS names.
+type Certificate struct {
+	// Path is the PEM file of the certificate, its key and its chain.
+	Path string
+	// DNSNames are the DNS subject alternative names of the certificate,
+	// possibly wildcards such as *.apps.example.com.
+	DNSNames []string
+}
+
+// Load reads the default certificates of paths. A path is either a PEM file
+// holding a certificate, its key and its chain, or a directory whose *.pem
+// files are default certificates. Returns an error if a file is not a valid
+// certificate and key, or if a certificate has no DNS name.
+func Load(paths []string) ([]Certificate, error) {
+	var certs []Certificate
+	for _, path := range paths {
+		// The paths are written as is in the haproxy crt-list.
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{path}
+		if info.IsDir() {
+			if files, err = filepath.Glob(filepath.Join(path, "*.pem")); err != nil {
+				return nil, err
+			}
+			sort.Strings(files)
+		}
+		for _, file := range files {
+			cert, err := load(file)
+			if err != nil {
+				return nil, fmt.Errorf("invalid default certificate %s: %w", file, err)
+			}
+			certs = append(certs, *cert)
+		}
+	}
+	return certs, nil
+}
+
+// load reads the default certificate of a PEM file.
+func load(file string) (*Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keyPair, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(leaf.DNSNames) == 0 {
+		return nil, fmt.Errorf("the certificate has no DNS subject alternative name")
+	}
+	return &Certificate{Path: file, DNSNames: leaf.DNSNames}, nil
+}
+
+// Match returns the first default certificate valid for the host of a route,
+// or nil if there is none. A certificate with the exact host among its DNS
+// names is preferred over a certificate matching the host with a wildcard.
+// The host of a wildcard route, which accepts any subdomain of the domain of
+// its host, only matches a certificate with that wildcard domain.
+func Match(certs []Certificate, host string, wildcard bool) *Certificate {
+	host = strings.ToLower(host)
+	wildcardHost := ""
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		wildcardHost = "*" + host[i:]
+	}
+	names := []string{host, wildcardHost}
+	if wildcard {
+		names = []string{wildcardHost}
+	}
+	for _, name := range names {
+		if len(name) == 0 {
+			continue
+		}
+		for i := range certs {
+			for _, dnsName := range certs[i].DNSNames {
+				if strings.EqualFold(dnsName, name) {
+					return &certs[i]
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package defaultcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for dnsNames and its key
+// to a PEM file.
+func writeCertificate(t *testing.T, file string, dnsNames ...string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "default"},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...)
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	certsDir := filepath.Join(dir, "certs")
+	if err := os.Mkdir(certsDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeCertificate(t, filepath.Join(certsDir, "b.pem"), "www.example.com")
+	writeCertificate(t, filepath.Join(certsDir, "a.pem"), "*.apps.example.com", "apps.example.com")
+	if err := os.WriteFile(filepath.Join(certsDir, "README"), []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	single := filepath.Join(dir, "single.pem")
+	writeCertificate(t, single, "www.example.org")
+
+	certs, err := Load([]string{single, certsDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Certificate{
+		{Path: single, DNSNames: []string{"www.example.org"}},
+		{Path: filepath.Join(certsDir, "a.pem"), DNSNames: []string{"*.apps.example.com", "apps.example.com"}},
+		{Path: filepath.Join(certsDir, "b.pem"), DNSNames: []string{"www.example.com"}},
+	}
+	if !reflect.DeepEqual(certs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, certs)
+	}
+
+	noNames := filepath.Join(dir, "nonames.pem")
+	writeCertificate(t, noNames)
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalid, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{noNames, invalid, filepath.Join(dir, "missing.pem")} {
+		if _, err := Load([]string{path}); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	certs := []Certificate{
+		{Path: "apps.pem", DNSNames: []string{"*.apps.example.com"}},
+		{Path: "www.pem", DNSNames: []string{"www.example.com", "WWW.apps.example.com"}},
+	}
+	testCases := []struct {
+		host     string
+		wildcard bool
+		expected string
+	}{
+		{host: "www.example.com", expected: "www.pem"},
+		{host: "shop.apps.example.com", expected: "apps.pem"},
+		// An exact name is preferred over a wildcard.
+		{host: "www.apps.example.com", expected: "www.pem"},
+		{host: "www.apps.example.com", wildcard: true, expected: "apps.pem"},
+		{host: "www.example.com", wildcard: true},
+		// A wildcard only matches a single label.
+		{host: "a.shop.apps.example.com"},
+		{host: "www.example.org"},
+		{host: "localhost"},
+	}
+	for _, tc := range testCases {
+		var path string
+		if cert := Match(certs, tc.host, tc.wildcard); cert != nil {
+			path = cert.Path
+		}
+		if path != tc.expected {
+			t.Errorf("%s (wildcard %t): expected %q, got %q", tc.host, tc.wildcard, tc.expected, path)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package haproxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/openshift/router/pkg/router/accesslog"
+)
+
+// accessLogOtherRoute is the route label of the requests to routes beyond
+// the maximum number of routes.
+const accessLogOtherRoute = "_other"
+
+var (
+	// accessLogLabelNames are the labels of the access log histograms.
+	accessLogLabelNames = []string{"namespace", "route"}
+
+	// accessLogBuckets are the buckets, in seconds, of the access log
+	// histograms.
+	accessLogBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+)
+
+// AccessLogOptions are the options of the access log collector.
+type AccessLogOptions struct {
+	// Address is the address haproxy sends the access logs to, either
+	// a host:port UDP address or the path of a unix datagram socket.
+	Address string
+	// MaxRoutes is the maximum number of routes labelled in the
+	// histograms. The requests of any further route are observed under
+	// the "_other" route.
+	MaxRoutes int
+	// RouteIdleTimeout is how long a route is labelled in the histograms
+	// after its last request. The series of the routes idle for longer,
+	// e.g. deleted, are removed, making room for other routes.
+	RouteIdleTimeout time.Duration
+}
+
+// routeID identifies the route of an haproxy backend.
+type routeID struct {
+	namespace, name string
+}
+
+// accessLogEntry holds the timers of an access log line, in milliseconds.
+// A negative timer is unknown, e.g. no response was received.
+type accessLogEntry struct {
+	backend                string
+	queue, response, total int64
+}
+
+// AccessLogCollector receives the haproxy access logs and observes the
+// request timers into per route histograms. It implements
+// prometheus.Collector.
+type AccessLogCollector struct {
+	opts AccessLogOptions
+	conn net.PacketConn
+
+	mutex sync.Mutex
+	// routes are the routes labelled in the histograms, with the time of
+	// their last request.
+	routes map[routeID]time.Time
+	nowFn  func() time.Time
+
+	queueTime, responseTime, totalTime *prometheus.HistogramVec
+	lines, parseFailures, overflows    prometheus.Counter
+}
+
+func newAccessLogHistogram(metricName, docString string) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "route_" + metricName,
+			Help:      docString,
+			Buckets:   accessLogBuckets,
+		},
+		accessLogLabelNames,
+	)
+}
+
+// NewAccessLogCollector returns an initialized AccessLogCollector.
+func NewAccessLogCollector(opts AccessLogOptions) *AccessLogCollector {
+	if opts.MaxRoutes == 0 {
+		opts.MaxRoutes = 1000
+	}
+	if opts.RouteIdleTimeout == 0 {
+		opts.RouteIdleTimeout = time.Hour
+	}
+	return &AccessLogCollector{
+		opts:         opts,
+		routes:       make(map[routeID]time.Time),
+		nowFn:        time.Now,
+		queueTime:    newAccessLogHistogram("queue_time_seconds", "Time spent by the requests in the queues (Tw)."),
+		responseTime: newAccessLogHistogram("response_time_seconds", "Time waiting for the server to send the response headers (Tr)."),
+		totalTime:    newAccessLogHistogram("total_time_seconds", "Total time of the requests, from accept to the end of the response (Tt)."),
+		lines: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_access_log_lines_total",
+			Help:      "Number of access log lines received.",
+		}),
+		parseFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_access_log_parse_failures_total",
+			Help:      "Number of access log lines that could not be parsed.",
+		}),
+		overflows: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_access_log_route_overflows_total",
+			Help:      "Number of requests observed under the \"_other\" route as the maximum number of routes was reached.",
+		}),
+	}
+}
+
+// Describe describes all the metrics exported by the access log collector.
+// It implements prometheus.Collector.
+func (c *AccessLogCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.queueTime.Describe(ch)
+	c.responseTime.Describe(ch)
+	c.totalTime.Describe(ch)
+	ch <- c.lines.Desc()
+	ch <- c.parseFailures.Desc()
+	ch <- c.overflows.Desc()
+}
+
+// Collect delivers the access log metrics. It implements
+// prometheus.Collector.
+func (c *AccessLogCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	c.evictIdleRoutes()
+	c.mutex.Unlock()
+
+	c.queueTime.Collect(ch)
+	c.responseTime.Collect(ch)
+	c.totalTime.Collect(ch)
+	ch <- c.lines
+	ch <- c.parseFailures
+	ch <- c.overflows
+}
+
+// Listen starts receiving the access logs on the configured address. The
+// datagrams are processed in a goroutine until Close is called.
+func (c *AccessLogCollector) Listen() error {
+	conn, err := accesslog.Listen(c.opts.Address, c.Observe)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+// Close stops receiving the access logs.
+func (c *AccessLogCollector) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Observe observes the timers of an access log line.
+func (c *AccessLogCollector) Observe(line string) {
+	c.lines.Inc()
+
+	entry, ok := parseAccessLogLine(line)
+	if !ok {
+		c.parseFailures.Inc()
+		return
+	}
+
+	// Only the routes backends are observed.
+	_, value, ok := knownBackendSegment(entry.backend)
+	if !ok {
+		return
+	}
+	namespace, name, ok := parseNameSegment(value)
+	if !ok {
+		return
+	}
+
+	// The series are observed while holding the lock so that they are not
+	// evicted meanwhile.
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	labels := c.routeLabels(routeID{namespace: namespace, name: name})
+
+	observe := func(h *prometheus.HistogramVec, ms int64) {
+		if ms >= 0 {
+			h.WithLabelValues(labels...).Observe(float64(ms) / float64(time.Second/time.Millisecond))
+		}
+	}
+	observe(c.queueTime, entry.queue)
+	observe(c.responseTime, entry.response)
+	observe(c.totalTime, entry.total)
+}
+
+// routeLabels returns the histogram labels of a route, bounding the
+// number of routes labelled. Must be called while holding c.mutex.
+func (c *AccessLogCollector) routeLabels(id routeID) []string {
+	if _, ok := c.routes[id]; !ok && len(c.routes) >= c.opts.MaxRoutes {
+		c.evictIdleRoutes()
+		if len(c.routes) >= c.opts.MaxRoutes {
+			c.overflows.Inc()
+			return []string{"", accessLogOtherRoute}
+		}
+	}
+	c.routes[id] = c.nowFn()
+	return []string{id.namespace, id.name}
+}
+
+// evictIdleRoutes removes the routes without requests for longer than the
+// idle timeout, along with their series. Must be called while holding
+// c.mutex.
+func (c *AccessLogCollector) evictIdleRoutes() {
+	now := c.nowFn()
+	for id, lastSeen := range c.routes {
+		if now.Sub(lastSeen) < c.opts.RouteIdleTimeout {
+			continue
+		}
+		delete(c.routes, id)
+		for _, h := range []*prometheus.HistogramVec{c.queueTime, c.responseTime, c.totalTime} {
+			h.DeleteLabelValues(id.namespace, id.name)
+		}
+	}
+}
+
+// parseAccessLogLine extracts the backend and timers of an access log line
+// in the JSON format or in the haproxy httplog or tcplog format:
+//
+//	... client:port [date] frontend backend/server TR/Tw/Tc/Tr/Tt status ...
+//	... client:port [date] frontend backend/server Tw/Tc/Tt bytes ...
+//
+// The timers field is the first one made of 5 (http) or 3 (tcp) integers
+// separated by slashes following a backend/server field.
+func parseAccessLogLine(line string) (accessLogEntry, bool) {
+	if entry, ok := accesslog.ParseJSON(line, "metrics"); ok {
+		total := entry.ActiveMS
+		if total < 0 {
+			total = entry.TotalMS
+		}
+		return accessLogEntry{backend: entry.Backend, queue: entry.QueueMS, response: entry.ResponseMS, total: total}, len(entry.Backend) > 0
+	}
+
+	fields := strings.Fields(line)
+	for i := 1; i < len(fields); i++ {
+		timers, ok := parseTimers(fields[i])
+		if !ok {
+			continue
+		}
+		backend, _, ok := strings.Cut(fields[i-1], "/")
+		if !ok || len(backend) == 0 {
+			continue
+		}
+		entry := accessLogEntry{backend: backend, response: -1}
+		switch len(timers) {
+		case 5:
+			entry.queue, entry.response, entry.total = timers[1], timers[3], timers[4]
+		case 3:
+			entry.queue, entry.total = timers[0], timers[2]
+		default:
+			continue
+		}
+		return entry, true
+	}
+	return accessLogEntry{}, false
+}
+
+// parseTimers parses a slash separated list of haproxy timers. The total
+// time may be prefixed with "+" when logging as soon as possible.
+func parseTimers(field string) ([]int64, bool) {
+	parts := strings.Split(field, "/")
+	if len(parts) != 3 && len(parts) != 5 {
+		return nil, false
+	}
+	timers := make([]int64, len(parts))
+	for i, part := range parts {
+		v, err := strconv.ParseInt(strings.TrimPrefix(part, "+"), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		timers[i] = v
+	}
+	return timers, true
+}
+
+// NewPrometheusAccessLogCollector starts receiving the haproxy access logs
+// on the configured address. Use the default prometheus handler to access
+// these metrics.
+func NewPrometheusAccessLogCollector(opts AccessLogOptions) (*AccessLogCollector, error) {
+	collector := NewAccessLogCollector(opts)
+	if err := collector.Listen(); err != nil {
+		return nil, err
+	}
+	if err := prometheus.Register(collector); err != nil {
+		collector.Close()
+		return nil, err
+	}
+	return collector, nil
+}
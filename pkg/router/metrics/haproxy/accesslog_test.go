@@ -0,0 +1,169 @@
+package haproxy
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseAccessLogLine(t *testing.T) {
+	testCases := []struct {
+		name     string
+		line     string
+		expected accessLogEntry
+		ok       bool
+	}{
+		{
+			name:     "http",
+			line:     `<142>Oct 18 10:01:02 router haproxy[42]: 10.0.0.9:51234 [18/Oct/2026:10:01:02.123] public be_http:ns:web/pod:web-1:web:http:10.0.0.1:8080 1/2/3/40/120 200 512 - - ---- 1/1/0/0/0 0/0 "GET / HTTP/1.1"`,
+			expected: accessLogEntry{backend: "be_http:ns:web", queue: 2, response: 40, total: 120},
+			ok:       true,
+		},
+		{
+			name:     "http without response",
+			line:     `10.0.0.9:51234 [18/Oct/2026:10:01:02.123] fe_sni~ be_edge_http:ns:web/pod:web-1:web:http:10.0.0.1:8080 0/0/-1/-1/3001 503 217 - - SC-- 1/1/0/0/3 0/0 "GET / HTTP/1.1"`,
+			expected: accessLogEntry{backend: "be_edge_http:ns:web", queue: 0, response: -1, total: 3001},
+			ok:       true,
+		},
+		{
+			name:     "http logged as soon as possible",
+			line:     `10.0.0.9:51234 [18/Oct/2026:10:01:02.123] public be_http:ns:web/pod:web-1:web:http:10.0.0.1:8080 0/0/1/5/+6 200 512 - - ---- 1/1/0/0/0 0/0 "GET / HTTP/1.1"`,
+			expected: accessLogEntry{backend: "be_http:ns:web", queue: 0, response: 5, total: 6},
+			ok:       true,
+		},
+		{
+			name:     "tcp",
+			line:     `10.0.0.9:51234 [18/Oct/2026:10:01:02.123] public_ssl be_tcp:ns:db/pod:db-1:db:tls:10.0.0.2:8443 5/1/2500 4096 -- 1/1/0/0/0 0/0`,
+			expected: accessLogEntry{backend: "be_tcp:ns:db", queue: 5, response: -1, total: 2500},
+			ok:       true,
+		},
+		{
+			name:     "http json",
+			line:     `<142>Oct 18 10:01:02 router haproxy[42]: {"backend":"be_http:ns:web","server":"pod:web-1","request_ms":1,"queue_ms":2,"connect_ms":3,"response_ms":40,"active_ms":120,"total_ms":121}`,
+			expected: accessLogEntry{backend: "be_http:ns:web", queue: 2, response: 40, total: 120},
+			ok:       true,
+		},
+		{
+			name:     "tcp json",
+			line:     `{"backend":"be_tcp:ns:db","server":"pod:db-1","queue_ms":5,"connect_ms":1,"total_ms":2500}`,
+			expected: accessLogEntry{backend: "be_tcp:ns:db", queue: 5, response: -1, total: 2500},
+			ok:       true,
+		},
+		{
+			name: "custom format",
+			line: `10.0.0.9 GET / 200`,
+		},
+		{
+			name: "timers without backend",
+			line: `10.0.0.9:51234 1/2/3/4/5 200`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, ok := parseAccessLogLine(tc.line)
+			if ok != tc.ok {
+				t.Fatalf("expected ok %t, got %t", tc.ok, ok)
+			}
+			if entry != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, entry)
+			}
+		})
+	}
+}
+
+func TestAccessLogCollector(t *testing.T) {
+	address := filepath.Join(t.TempDir(), "access.sock")
+	c := NewAccessLogCollector(AccessLogOptions{Address: address, MaxRoutes: 2})
+	if err := c.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	conn, err := net.Dial("unixgram", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, line := range []string{
+		`10.0.0.9:51234 [18/Oct/2026:10:01:02.123] public be_http:ns:web/pod:web-1:web:http:10.0.0.1:8080 1/2/3/40/120 200 512 - - ---- 1/1/0/0/0 0/0 "GET / HTTP/1.1"`,
+		`10.0.0.9:51234 [18/Oct/2026:10:01:02.123] public be_http:ns:web/pod:web-1:web:http:10.0.0.1:8080 0/0/-1/-1/3001 503 217 - - SC-- 1/1/0/0/3 0/0 "GET / HTTP/1.1"`,
+		`10.0.0.9:51234 [18/Oct/2026:10:01:02.123] public be_http:ns:api/pod:api-1:api:http:10.0.0.3:8080 0/0/1/5/6 200 512 - - ---- 1/1/0/0/0 0/0 "GET / HTTP/1.1"`,
+		`10.0.0.9:51234 [18/Oct/2026:10:01:02.123] public be_http:ns:other/pod:other-1:other:http:10.0.0.4:8080 0/0/1/5/6 200 512 - - ---- 1/1/0/0/0 0/0 "GET / HTTP/1.1"`,
+		`10.0.0.9:51234 [18/Oct/2026:10:01:02.123] public openshift_default/<NOSRV> 0/-1/-1/-1/0 503 3278 - - SC-- 1/1/0/0/0 0/0 "GET / HTTP/1.1"`,
+		`not an access log`,
+	} {
+		if _, err := conn.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !hasMetric(gatherMetrics(t, r), "haproxy_exporter_access_log_lines_total", 6) {
+		if time.Now().After(deadline) {
+			t.Fatalf("access logs not received:\n\n%s", mustMetricsToString(gatherMetrics(t, r)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	f := gatherMetrics(t, r)
+	web := map[string]string{"namespace": "ns", "route": "web"}
+	mustHaveMetric(t, f, "haproxy_route_total_time_seconds", 2, web)
+	mustHaveMetric(t, f, "haproxy_route_queue_time_seconds", 2, web)
+	mustHaveMetric(t, f, "haproxy_route_response_time_seconds", 1, web)
+	mustHaveMetric(t, f, "haproxy_route_total_time_seconds", 1, map[string]string{"namespace": "ns", "route": "api"})
+	mustHaveMetric(t, f, "haproxy_route_total_time_seconds", 1, map[string]string{"namespace": "", "route": accessLogOtherRoute})
+	mustHaveMetric(t, f, "haproxy_exporter_access_log_route_overflows_total", 1)
+	mustHaveMetric(t, f, "haproxy_exporter_access_log_parse_failures_total", 1)
+}
+
+func TestAccessLogCollectorEvictsIdleRoutes(t *testing.T) {
+	c := NewAccessLogCollector(AccessLogOptions{MaxRoutes: 2, RouteIdleTimeout: time.Hour})
+	now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+	c.nowFn = func() time.Time { return now }
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+
+	observe := func(route string) {
+		c.Observe(`10.0.0.9:51234 [19/Oct/2026:10:00:00.000] public be_http:ns:` + route + `/pod:` + route + `-1:` + route + `:http:10.0.0.1:8080 0/0/1/5/6 200 512 - - ---- 1/1/0/0/0 0/0 "GET / HTTP/1.1"`)
+	}
+	web := map[string]string{"namespace": "ns", "route": "web"}
+	api := map[string]string{"namespace": "ns", "route": "api"}
+	other := map[string]string{"namespace": "ns", "route": "other"}
+
+	observe("web")
+	observe("api")
+	now = now.Add(30 * time.Minute)
+	observe("api")
+	observe("other")
+	f := gatherMetrics(t, r)
+	mustHaveMetric(t, f, "haproxy_route_total_time_seconds", 1, map[string]string{"namespace": "", "route": accessLogOtherRoute})
+
+	// The route idle for longer than the timeout makes room for another.
+	now = now.Add(31 * time.Minute)
+	observe("other")
+	f = gatherMetrics(t, r)
+	if hasMetric(f, "haproxy_route_total_time_seconds", 1, web) {
+		t.Errorf("expected the series of the idle route to be removed:\n\n%s", mustMetricsToString(f, "haproxy_route_total_time_seconds"))
+	}
+	mustHaveMetric(t, f, "haproxy_route_total_time_seconds", 2, api)
+	mustHaveMetric(t, f, "haproxy_route_total_time_seconds", 1, other)
+
+	// The routes without requests, e.g. deleted, are removed when
+	// collected.
+	now = now.Add(2 * time.Hour)
+	f = gatherMetrics(t, r)
+	for _, labels := range []map[string]string{api, other} {
+		if hasMetric(f, "haproxy_route_total_time_seconds", 1, labels) || hasMetric(f, "haproxy_route_total_time_seconds", 2, labels) {
+			t.Errorf("expected the series of the idle route %v to be removed", labels)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -45,10 +46,47 @@ const (
 
 var (
 	frontendLabelNames = []string{"frontend"}
-	backendLabelNames  = []string{"backend", "namespace", "route"}
-	serverLabelNames   = []string{"server", "namespace", "route", "pod", "service"}
+	backendLabelNames  = []string{"backend", "namespace", "route", "service", "termination"}
+	serverLabelNames   = []string{"server", "namespace", "route", "pod", "service", "termination"}
+
+	// backendTerminations are the route terminations of the backend
+	// labels returned by knownBackendSegment.
+	backendTerminations = map[string]string{
+		"http":       "",
+		"https-edge": "edge",
+		"https":      "reencrypt",
+		"tcp":        "passthrough",
+	}
+
+	// invalidLabelNameChars matches the characters not allowed in a
+	// Prometheus label name.
+	invalidLabelNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
 )
 
+// RouteInfo is the route served by an haproxy backend.
+type RouteInfo struct {
+	Namespace string
+	Name      string
+	// Service is the name of the primary service of the route.
+	Service string
+	// Termination is the route TLS termination, empty for insecure routes.
+	Termination string
+	// Labels are the route labels.
+	Labels map[string]string
+}
+
+// routeLookup is the result of a route lookup.
+type routeLookup struct {
+	route RouteInfo
+	found bool
+}
+
+// routeLabelName returns the metric label name of a route label, as
+// kube-state-metrics does.
+func routeLabelName(name string) string {
+	return "label_" + invalidLabelNameChars.ReplaceAllString(name, "_")
+}
+
 func newFrontendMetric(metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
 	return prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -61,7 +99,7 @@ func newFrontendMetric(metricName string, docString string, constLabels promethe
 	)
 }
 
-func newBackendMetric(metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+func newBackendMetric(metricName string, docString string, constLabels prometheus.Labels, labelNames []string) *prometheus.GaugeVec {
 	return prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace:   namespace,
@@ -69,11 +107,11 @@ func newBackendMetric(metricName string, docString string, constLabels prometheu
 			Help:        docString,
 			ConstLabels: constLabels,
 		},
-		backendLabelNames,
+		labelNames,
 	)
 }
 
-func newServerMetric(metricName string, docString string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+func newServerMetric(metricName string, docString string, constLabels prometheus.Labels, labelNames []string) *prometheus.GaugeVec {
 	return prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace:   namespace,
@@ -81,7 +119,7 @@ func newServerMetric(metricName string, docString string, constLabels prometheus
 			Help:        docString,
 			ConstLabels: constLabels,
 		},
-		serverLabelNames,
+		labelNames,
 	)
 }
 
@@ -151,6 +189,20 @@ type Exporter struct {
 	counterIndices []byte
 	// counterIndexSize the number of counters for each remembered counterValues
 	counterIndexSize int
+
+	// processes lists the HAProxy processes to scrape. It is nil when the
+	// processes can't be told apart, such as when scraping over HTTP, in
+	// which case the counters are only preserved by CollectNow.
+	processes func() ([]haproxyProcess, error)
+	// counters tracks the counters of the processes in place of
+	// counterValues when processes is set.
+	counters *processCounters
+	// currentPid is the pid of the current process of the last scrape.
+	currentPid int
+
+	// routes caches the route lookups of the backends for the duration of
+	// a scrape.
+	routes map[string]routeLookup
 }
 
 // NewExporter returns an initialized Exporter. baseScrapeInterval is how often to scrape per 1000 entries
@@ -166,10 +218,19 @@ func NewExporter(opts PrometheusOptions) (*Exporter, error) {
 	case "http", "https", "file":
 		fetch = fetchHTTP(opts.ScrapeURI, opts.Timeout)
 	case "unix":
-		fetch = fetchUnix(u, opts.Timeout)
+		fetch = fetchUnix(u, opts.Timeout, opts.ScrapeMode)
 	default:
 		return nil, fmt.Errorf("unsupported scheme: %q", u.Scheme)
 	}
+	switch opts.ScrapeMode {
+	case "", ScrapeModeCSV:
+	case ScrapeModeTyped:
+		if u.Scheme != "unix" {
+			return nil, fmt.Errorf("the %s scrape mode requires a unix scrape URI", ScrapeModeTyped)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported scrape mode: %q", opts.ScrapeMode)
+	}
 
 	// use a compact table representation of previous counter values
 	if len(defaultCounterMetrics) >= math.MaxUint8 {
@@ -183,7 +244,16 @@ func NewExporter(opts PrometheusOptions) (*Exporter, error) {
 		counterIndices[m] = byte(counterIndexSize)
 	}
 
-	return &Exporter{
+	// the configured route labels are added to the backend and server labels
+	var backendLabels, serverLabels []string
+	backendLabels = append(backendLabels, backendLabelNames...)
+	serverLabels = append(serverLabels, serverLabelNames...)
+	for _, name := range opts.RouteLabels {
+		backendLabels = append(backendLabels, routeLabelName(name))
+		serverLabels = append(serverLabels, routeLabelName(name))
+	}
+
+	e := &Exporter{
 		opts:  opts,
 		fetch: fetch,
 		up: prometheus.NewGauge(prometheus.GaugeOpts{
@@ -245,67 +315,75 @@ func NewExporter(opts PrometheusOptions) (*Exporter, error) {
 		}),
 		reducedBackendExports: map[int]struct{}{2: {}, 3: {}, 7: {}, 17: {}},
 		backendMetrics: filterMetrics(opts.ExportedMetrics, metrics{
-			2:  newBackendMetric("current_queue", "Current number of queued requests not assigned to any server.", nil),
-			3:  newBackendMetric("max_queue", "Maximum observed number of queued requests not assigned to any server.", nil),
-			4:  newBackendMetric("current_sessions", "Current number of active sessions.", nil),
-			5:  newBackendMetric("max_sessions", "Maximum observed number of active sessions.", nil),
-			6:  newBackendMetric("limit_sessions", "Configured session limit.", nil),
-			7:  newBackendMetric("connections_total", "Total number of connections.", nil),
-			8:  newBackendMetric("bytes_in_total", "Current total of incoming bytes.", nil),
-			9:  newBackendMetric("bytes_out_total", "Current total of outgoing bytes.", nil),
-			13: newBackendMetric("connection_errors_total", "Total of connection errors.", nil),
-			14: newBackendMetric("response_errors_total", "Total of response errors.", nil),
-			15: newBackendMetric("retry_warnings_total", "Total of retry warnings.", nil),
-			16: newBackendMetric("redispatch_warnings_total", "Total of redispatch warnings.", nil),
-			17: newBackendMetric("up", "Current health status of the backend (1 = UP, 0 = DOWN).", nil),
-			18: newBackendMetric("weight", "Total weight of the servers in the backend.", nil),
-			33: newBackendMetric("current_session_rate", "Current number of sessions per second over last elapsed second.", nil),
-			35: newBackendMetric("max_session_rate", "Maximum number of sessions per second.", nil),
-			39: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "1xx"}),
-			40: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "2xx"}),
-			41: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "3xx"}),
-			42: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "4xx"}),
-			43: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "5xx"}),
-			44: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "other"}),
-			58: newBackendMetric("http_average_queue_latency_milliseconds", "Average latency to be dequeued of the last 1024 requests in milliseconds.", nil),
-			59: newBackendMetric("http_average_connect_latency_milliseconds", "Average connect latency of the last 1024 requests in milliseconds.", nil),
-			60: newBackendMetric("http_average_response_latency_milliseconds", "Average response latency of the last 1024 requests in milliseconds.", nil),
-			85: newBackendMetric("connections_reused_total", "Total number of connections reused.", nil),
+			2:  newBackendMetric("current_queue", "Current number of queued requests not assigned to any server.", nil, backendLabels),
+			3:  newBackendMetric("max_queue", "Maximum observed number of queued requests not assigned to any server.", nil, backendLabels),
+			4:  newBackendMetric("current_sessions", "Current number of active sessions.", nil, backendLabels),
+			5:  newBackendMetric("max_sessions", "Maximum observed number of active sessions.", nil, backendLabels),
+			6:  newBackendMetric("limit_sessions", "Configured session limit.", nil, backendLabels),
+			7:  newBackendMetric("connections_total", "Total number of connections.", nil, backendLabels),
+			8:  newBackendMetric("bytes_in_total", "Current total of incoming bytes.", nil, backendLabels),
+			9:  newBackendMetric("bytes_out_total", "Current total of outgoing bytes.", nil, backendLabels),
+			13: newBackendMetric("connection_errors_total", "Total of connection errors.", nil, backendLabels),
+			14: newBackendMetric("response_errors_total", "Total of response errors.", nil, backendLabels),
+			15: newBackendMetric("retry_warnings_total", "Total of retry warnings.", nil, backendLabels),
+			16: newBackendMetric("redispatch_warnings_total", "Total of redispatch warnings.", nil, backendLabels),
+			17: newBackendMetric("up", "Current health status of the backend (1 = UP, 0 = DOWN).", nil, backendLabels),
+			18: newBackendMetric("weight", "Total weight of the servers in the backend.", nil, backendLabels),
+			33: newBackendMetric("current_session_rate", "Current number of sessions per second over last elapsed second.", nil, backendLabels),
+			35: newBackendMetric("max_session_rate", "Maximum number of sessions per second.", nil, backendLabels),
+			39: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "1xx"}, backendLabels),
+			40: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "2xx"}, backendLabels),
+			41: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "3xx"}, backendLabels),
+			42: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "4xx"}, backendLabels),
+			43: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "5xx"}, backendLabels),
+			44: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "other"}, backendLabels),
+			58: newBackendMetric("http_average_queue_latency_milliseconds", "Average latency to be dequeued of the last 1024 requests in milliseconds.", nil, backendLabels),
+			59: newBackendMetric("http_average_connect_latency_milliseconds", "Average connect latency of the last 1024 requests in milliseconds.", nil, backendLabels),
+			60: newBackendMetric("http_average_response_latency_milliseconds", "Average response latency of the last 1024 requests in milliseconds.", nil, backendLabels),
+			85: newBackendMetric("connections_reused_total", "Total number of connections reused.", nil, backendLabels),
 		}),
 		serverMetrics: filterMetrics(opts.ExportedMetrics, metrics{
-			2:  newServerMetric("current_queue", "Current number of queued requests assigned to this server.", nil),
-			3:  newServerMetric("max_queue", "Maximum observed number of queued requests assigned to this server.", nil),
-			4:  newServerMetric("current_sessions", "Current number of active sessions.", nil),
-			5:  newServerMetric("max_sessions", "Maximum observed number of active sessions.", nil),
-			6:  newServerMetric("limit_sessions", "Configured session limit.", nil),
-			7:  newServerMetric("connections_total", "Total number of connections.", nil),
-			8:  newServerMetric("bytes_in_total", "Current total of incoming bytes.", nil),
-			9:  newServerMetric("bytes_out_total", "Current total of outgoing bytes.", nil),
-			13: newServerMetric("connection_errors_total", "Total of connection errors.", nil),
-			14: newServerMetric("response_errors_total", "Total of response errors.", nil),
-			15: newServerMetric("retry_warnings_total", "Total of retry warnings.", nil),
-			16: newServerMetric("redispatch_warnings_total", "Total of redispatch warnings.", nil),
-			17: newServerMetric("up", "Current health status of the server (1 = UP, 0 = DOWN).", nil),
-			18: newServerMetric("weight", "Current weight of the server.", nil),
-			21: newServerMetric("check_failures_total", "Total number of failed health checks.", nil),
-			24: newServerMetric("downtime_seconds_total", "Total downtime in seconds.", nil),
-			33: newServerMetric("current_session_rate", "Current number of sessions per second over last elapsed second.", nil),
-			35: newServerMetric("max_session_rate", "Maximum observed number of sessions per second.", nil),
-			38: newServerMetric("check_duration_milliseconds", "Previously run health check duration, in milliseconds", nil),
-			39: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "1xx"}),
-			40: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "2xx"}),
-			41: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "3xx"}),
-			42: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "4xx"}),
-			43: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "5xx"}),
-			44: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "other"}),
-			58: newServerMetric("http_average_queue_latency_milliseconds", "Average latency to be dequeued of the last 1024 requests in milliseconds.", nil),
-			59: newServerMetric("http_average_connect_latency_milliseconds", "Average connect latency of the last 1024 requests in milliseconds.", nil),
-			60: newServerMetric("http_average_response_latency_milliseconds", "Average response latency of the last 1024 requests in milliseconds.", nil),
-			85: newServerMetric("connections_reused_total", "Total number of connections reused.", nil),
+			2:  newServerMetric("current_queue", "Current number of queued requests assigned to this server.", nil, serverLabels),
+			3:  newServerMetric("max_queue", "Maximum observed number of queued requests assigned to this server.", nil, serverLabels),
+			4:  newServerMetric("current_sessions", "Current number of active sessions.", nil, serverLabels),
+			5:  newServerMetric("max_sessions", "Maximum observed number of active sessions.", nil, serverLabels),
+			6:  newServerMetric("limit_sessions", "Configured session limit.", nil, serverLabels),
+			7:  newServerMetric("connections_total", "Total number of connections.", nil, serverLabels),
+			8:  newServerMetric("bytes_in_total", "Current total of incoming bytes.", nil, serverLabels),
+			9:  newServerMetric("bytes_out_total", "Current total of outgoing bytes.", nil, serverLabels),
+			13: newServerMetric("connection_errors_total", "Total of connection errors.", nil, serverLabels),
+			14: newServerMetric("response_errors_total", "Total of response errors.", nil, serverLabels),
+			15: newServerMetric("retry_warnings_total", "Total of retry warnings.", nil, serverLabels),
+			16: newServerMetric("redispatch_warnings_total", "Total of redispatch warnings.", nil, serverLabels),
+			17: newServerMetric("up", "Current health status of the server (1 = UP, 0 = DOWN).", nil, serverLabels),
+			18: newServerMetric("weight", "Current weight of the server.", nil, serverLabels),
+			21: newServerMetric("check_failures_total", "Total number of failed health checks.", nil, serverLabels),
+			24: newServerMetric("downtime_seconds_total", "Total downtime in seconds.", nil, serverLabels),
+			33: newServerMetric("current_session_rate", "Current number of sessions per second over last elapsed second.", nil, serverLabels),
+			35: newServerMetric("max_session_rate", "Maximum observed number of sessions per second.", nil, serverLabels),
+			38: newServerMetric("check_duration_milliseconds", "Previously run health check duration, in milliseconds", nil, serverLabels),
+			39: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "1xx"}, serverLabels),
+			40: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "2xx"}, serverLabels),
+			41: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "3xx"}, serverLabels),
+			42: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "4xx"}, serverLabels),
+			43: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "5xx"}, serverLabels),
+			44: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "other"}, serverLabels),
+			58: newServerMetric("http_average_queue_latency_milliseconds", "Average latency to be dequeued of the last 1024 requests in milliseconds.", nil, serverLabels),
+			59: newServerMetric("http_average_connect_latency_milliseconds", "Average connect latency of the last 1024 requests in milliseconds.", nil, serverLabels),
+			60: newServerMetric("http_average_response_latency_milliseconds", "Average response latency of the last 1024 requests in milliseconds.", nil, serverLabels),
+			85: newServerMetric("connections_reused_total", "Total number of connections reused.", nil, serverLabels),
 		}),
 		counterIndices:   counterIndices,
 		counterIndexSize: counterIndexSize + 1,
-	}, nil
+	}
+
+	// the counters of the processes reached over the unix socket are
+	// tracked by process generation.
+	if u.Scheme == "unix" {
+		e.processes = unixProcesses(opts.ScrapeURI, opts.MasterURI, opts.Timeout, opts.ScrapeMode, fetch)
+		e.counters = newProcessCounters(counterIndices, e.counterIndexSize)
+	}
+	return e, nil
 }
 
 // Describe describes all the metrics ever exported by the HAProxy exporter. It
@@ -358,6 +436,8 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 }
 
 // CollectNow performs a collection immediately. The next Collect() will report the metrics.
+// It is invoked before the reloads of HAProxy so that the counters of the process being
+// replaced are as recent as possible.
 func (e *Exporter) CollectNow() {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
@@ -387,7 +467,11 @@ func fetchHTTP(uri string, timeout time.Duration) func() (io.ReadCloser, error)
 	}
 }
 
-func fetchUnix(u *url.URL, timeout time.Duration) func() (io.ReadCloser, error) {
+func fetchUnix(u *url.URL, timeout time.Duration, mode string) func() (io.ReadCloser, error) {
+	cmd := "show stat\n"
+	if mode == ScrapeModeTyped {
+		cmd = "show stat typed\n"
+	}
 	return func() (io.ReadCloser, error) {
 		f, err := net.DialTimeout("unix", u.Path, timeout)
 		if err != nil {
@@ -397,7 +481,6 @@ func fetchUnix(u *url.URL, timeout time.Duration) func() (io.ReadCloser, error)
 			f.Close()
 			return nil, err
 		}
-		cmd := "show stat\n"
 		n, err := io.WriteString(f, cmd)
 		if err != nil {
 			f.Close()
@@ -415,11 +498,24 @@ func (e *Exporter) scrape(record bool) {
 	e.totalScrapes.Inc()
 
 	var updatedValues counterValuesByMetric
-	if record {
+	if record && e.counters == nil {
 		updatedValues = make(counterValuesByMetric)
 	}
 
-	body, err := e.fetch()
+	e.routes = make(map[string]routeLookup)
+
+	fetch := e.fetch
+	if e.counters != nil {
+		current, err := e.scrapeProcesses()
+		if err != nil {
+			e.up.Set(0)
+			utilruntime.HandleError(fmt.Errorf("can't scrape HAProxy: %v", err))
+			return
+		}
+		e.currentPid, fetch = current.pid, current.fetch
+	}
+
+	body, err := fetch()
 	if err != nil {
 		e.up.Set(0)
 		utilruntime.HandleError(fmt.Errorf("can't scrape HAProxy: %v", err))
@@ -428,9 +524,7 @@ func (e *Exporter) scrape(record bool) {
 	defer body.Close()
 	e.up.Set(1)
 
-	reader := csv.NewReader(body)
-	reader.TrailingComma = true
-	reader.Comment = '#'
+	reader := e.newRowReader(body)
 
 	rows, servers := 0, 0
 loop:
@@ -441,12 +535,13 @@ loop:
 		case io.EOF:
 			break loop
 		default:
-			if _, ok := err.(*csv.ParseError); ok {
-				utilruntime.HandleError(fmt.Errorf("can't read CSV: %v", err))
+			switch err.(type) {
+			case *csv.ParseError, *typedParseError:
+				utilruntime.HandleError(fmt.Errorf("can't read stats: %v", err))
 				e.csvParseFailures.Inc()
 				continue loop
 			}
-			utilruntime.HandleError(fmt.Errorf("unexpected error while reading CSV: %v", err))
+			utilruntime.HandleError(fmt.Errorf("unexpected error while reading stats: %v", err))
 			e.up.Set(0)
 			break loop
 		}
@@ -462,9 +557,13 @@ loop:
 			e.counterIndices = append(e.counterIndices, make([]byte, len(row)-len(e.counterIndices))...)
 		}
 
+		if e.counters != nil {
+			e.counters.record(e.currentPid, row)
+		}
+
 		// If we exceed the server threshold, ignore the rest of the servers because we will be
 		// displaying only backends and frontends.
-		if row[32] == serverType {
+		if row[statFieldIndices["type"]] == serverType {
 			servers++
 			if servers > e.opts.ServerThreshold {
 				continue
@@ -476,9 +575,12 @@ loop:
 	}
 
 	// swap the counter values
-	if record {
+	if updatedValues != nil {
 		e.counterValues = updatedValues
 	}
+	if e.counters != nil {
+		e.counters.prune()
+	}
 
 	e.serverLimited = servers > e.opts.ServerThreshold
 	e.serverThresholdCurrent.Set(float64(servers))
@@ -488,6 +590,67 @@ loop:
 	e.nextScrapeInterval.Set(float64(e.scrapeInterval / time.Second))
 }
 
+// newRowReader returns a reader of the "show stat" rows of the configured
+// scrape mode.
+func (e *Exporter) newRowReader(body io.Reader) interface{ Read() ([]string, error) } {
+	if e.opts.ScrapeMode == ScrapeModeTyped {
+		return newTypedReader(body)
+	}
+	reader := csv.NewReader(body)
+	reader.TrailingComma = true
+	reader.Comment = '#'
+	return reader
+}
+
+// scrapeProcesses updates the process generations, records the counters of
+// the old workers and returns the current process.
+func (e *Exporter) scrapeProcesses() (haproxyProcess, error) {
+	processes, err := e.processes()
+	if err != nil {
+		return haproxyProcess{}, err
+	}
+	e.counters.update(processes)
+
+	var current haproxyProcess
+	for _, p := range processes {
+		if !p.old {
+			current = p
+			continue
+		}
+		if p.fetch == nil {
+			continue
+		}
+		body, err := p.fetch()
+		if err != nil {
+			log.V(4).Info("can't scrape old haproxy worker", "pid", p.pid, "error", err)
+			continue
+		}
+		reader := e.newRowReader(body)
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				switch err.(type) {
+				case *csv.ParseError, *typedParseError:
+					continue
+				}
+				log.V(4).Info("can't scrape old haproxy worker", "pid", p.pid, "error", err)
+				break
+			}
+			if len(row) >= expectedCsvFieldCount {
+				e.counters.record(p.pid, row)
+			}
+		}
+		body.Close()
+	}
+	if current.fetch == nil {
+		return haproxyProcess{}, errors.New("no current haproxy process")
+	}
+	return current, nil
+}
+
 func (e *Exporter) resetMetrics() {
 	for _, m := range e.frontendMetrics {
 		m.Reset()
@@ -515,6 +678,7 @@ func (e *Exporter) collectMetrics(metrics chan<- prometheus.Metric) {
 }
 
 // parseRow identifies which metrics to capture for a given row based on type and the value of pxname and svname. If the
+// route served by the proxy is known to the route lookup, the row is labelled with the route state. Otherwise, if the
 // proxy and server names match our conventions they are labelled to the given route, service, or pod - if they don't match
 // then a generic label set is applied. If updatedValues is non-nil then the map will be populated with the updated counter state
 // for each metric.
@@ -538,24 +702,67 @@ func (e *Exporter) parseRow(csvRow []string, updatedValues counterValuesByMetric
 		}
 		e.exportAndRecordRow(e.frontendMetrics, metricID{proxyType: serverType, proxyName: pxname}, updatedValues, csvRow, pxname)
 	case backendType:
-		if mode, value, ok := knownBackendSegment(pxname); ok {
+		mode, value, known := knownBackendSegment(pxname)
+		if route, ok := e.lookupRoute(pxname); ok {
+			labels := append([]string{mode, route.Namespace, route.Name, route.Service, route.Termination}, e.routeLabelValues(route)...)
+			e.exportAndRecordRow(e.backendMetrics, metricID{proxyType: serverType, proxyName: pxname}, updatedValues, csvRow, labels...)
+			return
+		}
+		if known {
 			if namespace, name, ok := parseNameSegment(value); ok {
-				e.exportAndRecordRow(e.backendMetrics, metricID{proxyType: serverType, proxyName: pxname}, updatedValues, csvRow, mode, namespace, name)
+				labels := append([]string{mode, namespace, name, "", backendTerminations[mode]}, e.routeLabelValues(RouteInfo{})...)
+				e.exportAndRecordRow(e.backendMetrics, metricID{proxyType: serverType, proxyName: pxname}, updatedValues, csvRow, labels...)
 				return
 			}
 		}
-		e.exportAndRecordRow(e.backendMetrics, metricID{proxyType: serverType, proxyName: pxname}, updatedValues, csvRow, "other/"+pxname, "", "")
+		labels := append([]string{"other/" + pxname, "", "", "", ""}, e.routeLabelValues(RouteInfo{})...)
+		e.exportAndRecordRow(e.backendMetrics, metricID{proxyType: serverType, proxyName: pxname}, updatedValues, csvRow, labels...)
 	case serverType:
 		pod, service, server, _ := knownServerSegment(svname)
 
-		if _, value, ok := knownBackendSegment(pxname); ok {
+		if route, ok := e.lookupRoute(pxname); ok {
+			// Servers added dynamically to pool backends have no
+			// service in their names.
+			if len(service) == 0 {
+				service = route.Service
+			}
+			labels := append([]string{server, route.Namespace, route.Name, pod, service, route.Termination}, e.routeLabelValues(route)...)
+			e.exportAndRecordRow(e.serverMetrics, metricID{serverType, pxname, svname}, updatedValues, csvRow, labels...)
+			return
+		}
+		if mode, value, ok := knownBackendSegment(pxname); ok {
 			if namespace, name, ok := parseNameSegment(value); ok {
-				e.exportAndRecordRow(e.serverMetrics, metricID{serverType, pxname, svname}, updatedValues, csvRow, server, namespace, name, pod, service)
+				labels := append([]string{server, namespace, name, pod, service, backendTerminations[mode]}, e.routeLabelValues(RouteInfo{})...)
+				e.exportAndRecordRow(e.serverMetrics, metricID{serverType, pxname, svname}, updatedValues, csvRow, labels...)
 				return
 			}
 		}
-		e.exportAndRecordRow(e.serverMetrics, metricID{proxyType: serverType, serverName: svname}, updatedValues, csvRow, server, "", "", pod, service)
+		labels := append([]string{server, "", "", pod, service, ""}, e.routeLabelValues(RouteInfo{})...)
+		e.exportAndRecordRow(e.serverMetrics, metricID{proxyType: serverType, serverName: svname}, updatedValues, csvRow, labels...)
+	}
+}
+
+// lookupRoute returns the route served by a backend using the configured
+// route lookup, if any. The lookups are cached for the current scrape.
+func (e *Exporter) lookupRoute(pxname string) (RouteInfo, bool) {
+	if e.opts.RouteLookup == nil {
+		return RouteInfo{}, false
+	}
+	lookup, ok := e.routes[pxname]
+	if !ok {
+		lookup.route, lookup.found = e.opts.RouteLookup(pxname)
+		e.routes[pxname] = lookup
 	}
+	return lookup.route, lookup.found
+}
+
+// routeLabelValues returns the values of the configured route labels.
+func (e *Exporter) routeLabelValues(route RouteInfo) []string {
+	values := make([]string, len(e.opts.RouteLabels))
+	for i, name := range e.opts.RouteLabels {
+		values[i] = route.Labels[name]
+	}
+	return values
 }
 
 // knownServerSegment takes a server name that has a known prefix and returns
@@ -628,6 +835,9 @@ func parseStatusField(value string) int64 {
 func (e *Exporter) exportAndRecordRow(metrics metrics, rowID metricID, updatedValues counterValuesByMetric, csvRow []string, labels ...string) {
 	var updatedBaseValues []int64
 	baseValues := e.counterValues[rowID]
+	if e.counters != nil {
+		baseValues = e.counters.offset(e.currentPid, rowKey(csvRow))
+	}
 	if updatedValues != nil {
 		updatedBaseValues = baseValues
 		if updatedBaseValues == nil {
@@ -709,8 +919,24 @@ type PrometheusOptions struct {
 	// to only using backend metrics. This reduces metrics load when there is a very large set
 	// of endpoints.
 	ServerThreshold int
-	// ExportedMetrics is a list of HAProxy stats to export.
+	// ExportedMetrics is a list of HAProxy stats to export, by "show stat" column.
+	// ParseMetricSelection returns the columns of the stats selected by name.
 	ExportedMetrics []int
+	// MasterURI is optional and is the unix URI of the HAProxy master CLI, used to
+	// scrape the old workers still draining their connections after a reload so
+	// that the counters account for them.
+	MasterURI string
+	// ScrapeMode is either ScrapeModeCSV, the default, to scrape the CSV output of
+	// "show stat", or ScrapeModeTyped to scrape "show stat typed", whose fields are
+	// named. ScrapeModeTyped requires a unix ScrapeURI.
+	ScrapeMode string
+	// RouteLookup is optional and returns the route served by an HAProxy backend, so
+	// that its metrics are labelled with the route state rather than with the parts
+	// of the backend name.
+	RouteLookup func(backend string) (RouteInfo, bool)
+	// RouteLabels is a list of route labels added to the backend and server metrics,
+	// requires RouteLookup.
+	RouteLabels []string
 }
 
 // NewPrometheusCollector starts collectors for prometheus metrics from the
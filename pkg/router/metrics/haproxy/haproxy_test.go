@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"flag"
 	"io"
+	"net"
 	_ "net/http/pprof"
 	"os"
 	"strings"
@@ -15,6 +16,8 @@ import (
 	client_model "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 	"k8s.io/klog/v2"
+
+	haproxytesting "github.com/openshift/router/pkg/router/template/configmanager/haproxy/testing"
 )
 
 func TestExporter_scrape(t *testing.T) {
@@ -205,6 +208,60 @@ be_edge_http:openshift-console:downloads,BACKEND,0,0,0,0,1,0,0,0,0,0,,0,0,0,0,UP
 	mustHaveMetric(t, f, "haproxy_server_connections_total", 245, map[string]string{"namespace": "openshift-console", "pod": "console-6db7cbb464-gr787", "route": "console", "server": "10.129.0.43:8443", "service": "console"})
 }
 
+func TestExporter_scrapeHAProxy(t *testing.T) {
+	const (
+		backend = "be_http:ns:web"
+		server  = "pod:web-1:web:port:10.0.0.1:8080"
+	)
+	labels := map[string]string{"namespace": "ns", "pod": "web-1", "route": "web", "server": "10.0.0.1:8080", "service": "web"}
+
+	haproxy := haproxytesting.StartFakeServerForTest(t)
+	defer haproxy.Stop()
+	haproxy.AddBackend(backend, haproxytesting.ServerState{Name: server, Address: "10.0.0.1", Port: 8080, Weight: 1, InitialWeight: 1, HealthCheck: true, Up: true})
+
+	e, err := NewExporter(defaultOptions(PrometheusOptions{ScrapeURI: "unix://" + haproxy.SocketFile()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := prometheus.NewRegistry()
+	if err := r.Register(e); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := haproxy.SetStat(backend, server, "stot", 10); err != nil {
+		t.Fatal(err)
+	}
+	f := gatherMetrics(t, r)
+	mustHaveMetric(t, f, "haproxy_up", 1)
+	mustHaveMetric(t, f, "haproxy_server_up", 1, labels)
+	mustHaveMetric(t, f, "haproxy_server_connections_total", 10, labels)
+	mustHaveMetric(t, f, "haproxy_backend_connections_total", 10, map[string]string{"namespace": "ns", "route": "web"})
+
+	// the counters restart from zero after a reload.
+	e.CollectNow()
+	haproxy.Reload(0)
+	if err := haproxy.SetServerHealth(backend, server, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := haproxy.SetStat(backend, server, "stot", 5); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("unix", haproxy.SocketFile()); err == nil {
+			conn.Close()
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("haproxy did not restart: %v", err)
+		}
+	}
+
+	e.lastScrape = nil
+	f = gatherMetrics(t, r)
+	mustHaveMetric(t, f, "haproxy_up", 1)
+	mustHaveMetric(t, f, "haproxy_server_up", 0, labels)
+	mustHaveMetric(t, f, "haproxy_server_connections_total", 15, labels)
+}
+
 func mustHaveMetric(t *testing.T, families []*client_model.MetricFamily, name string, value float64, labels ...map[string]string) {
 	t.Helper()
 	if !hasMetric(families, name, value, labels...) {
@@ -238,6 +295,8 @@ func hasMetric(families []*client_model.MetricFamily, metric string, value float
 				v = *m.Gauge.Value
 			case m.Untyped != nil:
 				v = *m.Untyped.Value
+			case m.Histogram != nil:
+				v = float64(*m.Histogram.SampleCount)
 			default:
 				continue
 			}
@@ -304,3 +363,48 @@ func hasName(family *client_model.MetricFamily, names []string) bool {
 	}
 	return false
 }
+
+func TestExporter_routeLookup(t *testing.T) {
+	const (
+		poolBackend = "be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-4"
+		backend     = "be_http:ns:api"
+	)
+
+	haproxy := haproxytesting.StartFakeServerForTest(t)
+	defer haproxy.Stop()
+	haproxy.AddBackend(backend, haproxytesting.ServerState{Name: "pod:api-1:api:port:10.0.0.1:8080", Address: "10.0.0.1", Port: 8080, Weight: 1, InitialWeight: 1, Up: true})
+	haproxy.AddBackend(poolBackend, haproxytesting.ServerState{Name: "_dynamic-pod-1", Address: "10.0.0.2", Port: 8080, Weight: 1, InitialWeight: 1, Up: true})
+
+	opts := defaultOptions(PrometheusOptions{ScrapeURI: "unix://" + haproxy.SocketFile()})
+	opts.RouteLabels = []string{"app.kubernetes.io/part-of"}
+	opts.RouteLookup = func(name string) (RouteInfo, bool) {
+		if name != poolBackend {
+			return RouteInfo{}, false
+		}
+		return RouteInfo{
+			Namespace:   "ns",
+			Name:        "web",
+			Service:     "web-svc",
+			Termination: "edge",
+			Labels:      map[string]string{"app.kubernetes.io/part-of": "shop", "app": "web"},
+		}, true
+	}
+	e, err := NewExporter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := prometheus.NewRegistry()
+	if err := r.Register(e); err != nil {
+		t.Fatal(err)
+	}
+
+	f := gatherMetrics(t, r)
+	// the pool backend reports the route it serves
+	web := map[string]string{"namespace": "ns", "route": "web", "service": "web-svc", "termination": "edge", "label_app_kubernetes_io_part_of": "shop"}
+	mustHaveMetric(t, f, "haproxy_backend_up", 1, web, map[string]string{"backend": "https-edge"})
+	mustHaveMetric(t, f, "haproxy_server_up", 1, web, map[string]string{"server": "_dynamic-pod-1", "pod": ""})
+	// unknown backends are labelled from their names
+	api := map[string]string{"namespace": "ns", "route": "api", "service": "api", "termination": "", "label_app_kubernetes_io_part_of": ""}
+	mustHaveMetric(t, f, "haproxy_server_up", 1, api, map[string]string{"server": "10.0.0.1:8080", "pod": "api-1"})
+	mustHaveMetric(t, f, "haproxy_backend_up", 0, map[string]string{"backend": "https", "namespace": "default", "route": "test-reencrypt", "termination": "reencrypt"})
+}
@@ -0,0 +1,276 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openshift/router/pkg/router/client"
+)
+
+// haproxyProcess is an HAProxy process whose statistics are scraped.
+type haproxyProcess struct {
+	pid int
+	// uptime is the uptime of the process in seconds, which tells a new
+	// process apart from a previous one with the same pid.
+	uptime int64
+	// old is set for the workers still draining their connections after
+	// a reload. Only their counters are scraped.
+	old bool
+	// fetch returns the "show stat" output of the process, nil when the
+	// process can't be scraped, in which case its last counters are kept.
+	fetch func() (io.ReadCloser, error)
+}
+
+// processState holds the last counters scraped from a process.
+type processState struct {
+	uptime int64
+	values counterValuesByMetric
+}
+
+// processCounters tracks the counters of the HAProxy processes by process
+// generation, so that the exported counters neither go backwards nor count
+// twice across reloads, whenever the reloads happen. The counters of each
+// row are the sum of the last counters of the retired processes and of the
+// current counters of the live processes, including the old workers still
+// draining connections. The rows are keyed by rowKey.
+type processCounters struct {
+	// indices and size are the counterIndices and counterIndexSize of the
+	// exporter.
+	indices []byte
+	size    int
+
+	// retired are the counters of the processes which exited.
+	retired counterValuesByMetric
+	// processes are the live processes, by pid.
+	processes map[int]*processState
+}
+
+func newProcessCounters(indices []byte, size int) *processCounters {
+	return &processCounters{
+		indices:   indices,
+		size:      size,
+		retired:   make(counterValuesByMetric),
+		processes: make(map[int]*processState),
+	}
+}
+
+// rowKey identifies a "show stat" row across processes.
+func rowKey(row []string) metricID {
+	return metricID{proxyType: row[statFieldIndices["type"]], proxyName: row[0], serverName: row[1]}
+}
+
+// update retires the processes which are not running anymore, or which were
+// replaced by a new process with the same pid.
+func (c *processCounters) update(processes []haproxyProcess) {
+	live := make(map[int]struct{}, len(processes))
+	for _, p := range processes {
+		live[p.pid] = struct{}{}
+		state, ok := c.processes[p.pid]
+		switch {
+		case !ok:
+			c.processes[p.pid] = &processState{uptime: p.uptime, values: make(counterValuesByMetric)}
+		case p.uptime < state.uptime:
+			log.V(4).Info("haproxy process restarted", "pid", p.pid)
+			c.retire(p.pid)
+			c.processes[p.pid] = &processState{uptime: p.uptime, values: make(counterValuesByMetric)}
+		default:
+			state.uptime = p.uptime
+		}
+	}
+	for pid := range c.processes {
+		if _, ok := live[pid]; !ok {
+			log.V(4).Info("haproxy process exited", "pid", pid)
+			c.retire(pid)
+		}
+	}
+}
+
+// retire adds the last counters of a process to the retired counters.
+func (c *processCounters) retire(pid int) {
+	for key, values := range c.processes[pid].values {
+		retired := c.retired[key]
+		if retired == nil {
+			retired = make([]int64, c.size)
+			c.retired[key] = retired
+		}
+		for i, v := range values {
+			retired[i] += v
+		}
+	}
+	delete(c.processes, pid)
+}
+
+// record stores the counters of a row scraped from a process.
+func (c *processCounters) record(pid int, row []string) {
+	state, ok := c.processes[pid]
+	if !ok {
+		return
+	}
+	values := make([]int64, c.size)
+	for field, idx := range c.indices {
+		if idx == 0 || field >= len(row) || len(row[field]) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseInt(row[field], 10, 64); err == nil {
+			values[idx] = v
+		}
+	}
+	state.values[rowKey(row)] = values
+}
+
+// offset returns the counters of a row to add to the counters of the given
+// process: the counters of the retired processes and of the other live
+// processes. It returns nil if there are none.
+func (c *processCounters) offset(pid int, key metricID) []int64 {
+	var offset []int64
+	add := func(values []int64) {
+		if values == nil {
+			return
+		}
+		if offset == nil {
+			offset = make([]int64, c.size)
+		}
+		for i, v := range values {
+			offset[i] += v
+		}
+	}
+	add(c.retired[key])
+	for p, state := range c.processes {
+		if p != pid {
+			add(state.values[key])
+		}
+	}
+	return offset
+}
+
+// prune forgets the retired counters of the rows no live process reports,
+// such as the rows of the removed backends.
+func (c *processCounters) prune() {
+	for key := range c.retired {
+		found := false
+		for _, state := range c.processes {
+			if _, ok := state.values[key]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			delete(c.retired, key)
+		}
+	}
+}
+
+// unixProcesses returns the processes of the HAProxy unix socket at
+// scrapeURI: the current worker identified with "show info", and the old
+// workers listed by "show proc" on the master CLI at masterURI, if set.
+func unixProcesses(scrapeURI, masterURI string, timeout time.Duration, mode string, fetch func() (io.ReadCloser, error)) func() ([]haproxyProcess, error) {
+	statCmd := "show stat"
+	if mode == ScrapeModeTyped {
+		statCmd = "show stat typed"
+	}
+	opts := client.ClientOpts{Timeout: timeout}
+
+	return func() ([]haproxyProcess, error) {
+		ctx := context.Background()
+		info, err := client.RunCommand(ctx, scrapeURI, "show info", opts)
+		if err != nil {
+			return nil, err
+		}
+		pid, uptime, err := parseShowInfo(info)
+		if err != nil {
+			return nil, err
+		}
+		processes := []haproxyProcess{{pid: pid, uptime: uptime, fetch: fetch}}
+		if len(masterURI) == 0 {
+			return processes, nil
+		}
+
+		output, err := client.RunCommand(ctx, masterURI, "show proc", opts)
+		if err != nil {
+			return nil, fmt.Errorf("can't list the haproxy workers: %v", err)
+		}
+		for _, oldPid := range parseOldWorkers(output) {
+			if oldPid == pid {
+				continue
+			}
+			old := haproxyProcess{pid: oldPid, old: true}
+			prefix := "@!" + strconv.Itoa(oldPid) + " "
+			if info, err := client.RunCommand(ctx, masterURI, prefix+"show info", opts); err != nil {
+				log.V(4).Info("can't scrape old haproxy worker", "pid", oldPid, "error", err)
+			} else if _, old.uptime, err = parseShowInfo(info); err != nil {
+				log.V(4).Info("can't scrape old haproxy worker", "pid", oldPid, "error", err)
+			} else {
+				old.fetch = func() (io.ReadCloser, error) {
+					output, err := client.RunCommand(ctx, masterURI, prefix+statCmd, opts)
+					if err != nil {
+						return nil, err
+					}
+					return io.NopCloser(strings.NewReader(output)), nil
+				}
+			}
+			processes = append(processes, old)
+		}
+		return processes, nil
+	}
+}
+
+// parseShowInfo returns the pid and the uptime in seconds of the "show info"
+// output of a process.
+func parseShowInfo(output string) (int, int64, error) {
+	pid, uptime := -1, int64(-1)
+	for _, line := range strings.Split(output, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch name {
+		case "Pid":
+			if v, err := strconv.Atoi(value); err == nil {
+				pid = v
+			}
+		case "Uptime_sec":
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+				uptime = v
+			}
+		}
+	}
+	if pid < 0 || uptime < 0 {
+		return 0, 0, fmt.Errorf("show info returned no pid or uptime: %q", output)
+	}
+	return pid, uptime, nil
+}
+
+// parseOldWorkers returns the pids of the old workers of the master CLI
+// "show proc" output:
+//
+//	#<PID>          <type>          <reloads>       <uptime>        <version>
+//	420054          master          1 [failed: 0]   0d00h07m14s     2.8.18-ae90be6
+//	# workers
+//	420060          worker          0               0d00h00m02s     2.8.18-ae90be6
+//	# old workers
+//	420056          worker          1               0d00h07m14s     2.8.18-ae90be6
+//	# programs
+func parseOldWorkers(output string) []int {
+	var pids []int
+	section := ""
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			section = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			continue
+		}
+		fields := strings.Fields(line)
+		if section != "old workers" || len(fields) == 0 {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[0]); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
@@ -0,0 +1,165 @@
+package haproxy
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseShowInfo(t *testing.T) {
+	pid, uptime, err := parseShowInfo("Name: HAProxy\nVersion: 2.8.18\nPid: 420056\nUptime: 0d00h07m14s\nUptime_sec: 434\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != 420056 || uptime != 434 {
+		t.Fatalf("unexpected pid %d and uptime %d", pid, uptime)
+	}
+
+	if _, _, err := parseShowInfo("Name: HAProxy\nPid: 420056\n"); err == nil {
+		t.Fatal("expected an error without uptime")
+	}
+}
+
+func TestParseOldWorkers(t *testing.T) {
+	output := `#<PID>          <type>          <reloads>       <uptime>        <version>
+420054          master          2 [failed: 0]   0d00h07m14s     2.8.18-ae90be6
+# workers
+420060          worker          0               0d00h00m02s     2.8.18-ae90be6
+# old workers
+420056          worker          1               0d00h07m14s     2.8.18-ae90be6
+420058          worker          2               0d00h01m10s     2.8.18-ae90be6
+# programs
+`
+	if pids, expected := parseOldWorkers(output), []int{420056, 420058}; !reflect.DeepEqual(pids, expected) {
+		t.Fatalf("expected %v, got %v", expected, pids)
+	}
+}
+
+// fakeProcess is a simulated HAProxy process serving a single server.
+type fakeProcess struct {
+	pid         int
+	uptime      int64
+	old         bool
+	unavailable bool
+	connections int64
+}
+
+func (p *fakeProcess) fetch() (io.ReadCloser, error) {
+	fields := make([]string, len(statFields))
+	fields[statFieldIndices["pxname"]] = "be_http:ns:web"
+	fields[statFieldIndices["svname"]] = "pod:web-1:web:port:10.0.0.1:8080"
+	fields[statFieldIndices["status"]] = "UP"
+	fields[statFieldIndices["type"]] = serverType
+	fields[statFieldIndices["pid"]] = strconv.Itoa(p.pid)
+	fields[statFieldIndices["stot"]] = strconv.FormatInt(p.connections, 10)
+	return io.NopCloser(strings.NewReader(strings.Join(fields, ",") + ",\n")), nil
+}
+
+func TestExporter_processGenerations(t *testing.T) {
+	labels := map[string]string{"namespace": "ns", "pod": "web-1", "route": "web", "server": "10.0.0.1:8080", "service": "web"}
+
+	e, err := NewExporter(defaultOptions(PrometheusOptions{ScrapeURI: "unix:///var/lib/haproxy/run/haproxy.sock"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var processes []*fakeProcess
+	var listErr error
+	e.processes = func() ([]haproxyProcess, error) {
+		var list []haproxyProcess
+		for _, p := range processes {
+			process := haproxyProcess{pid: p.pid, uptime: p.uptime, old: p.old}
+			if !p.unavailable {
+				process.fetch = p.fetch
+			}
+			list = append(list, process)
+		}
+		return list, listErr
+	}
+	r := prometheus.NewRegistry()
+	if err := r.Register(e); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name       string
+		processes  []*fakeProcess
+		listErr    error
+		collectNow bool
+		// expected is negative when the scrape fails.
+		expected float64
+	}{
+		{
+			name:      "first process",
+			processes: []*fakeProcess{{pid: 1, uptime: 10, connections: 10}},
+			expected:  10,
+		},
+		{
+			name:      "reload with the old worker draining connections",
+			processes: []*fakeProcess{{pid: 2, uptime: 0, connections: 3}, {pid: 1, uptime: 20, old: true, connections: 12}},
+			expected:  15,
+		},
+		{
+			name:      "old worker exited",
+			processes: []*fakeProcess{{pid: 2, uptime: 10, connections: 5}},
+			expected:  17,
+		},
+		{
+			name:       "collection before a reload which didn't happen",
+			processes:  []*fakeProcess{{pid: 2, uptime: 20, connections: 6}},
+			collectNow: true,
+			expected:   18,
+		},
+		{
+			name:      "stats source unavailable",
+			processes: []*fakeProcess{{pid: 2, uptime: 30, connections: 7}},
+			listErr:   errors.New("connection refused"),
+			expected:  -1,
+		},
+		{
+			name:      "new process reusing the pid",
+			processes: []*fakeProcess{{pid: 2, uptime: 0, connections: 1}},
+			expected:  19,
+		},
+		{
+			name:      "old worker unavailable",
+			processes: []*fakeProcess{{pid: 3, uptime: 0, connections: 2}, {pid: 2, uptime: 10, old: true, unavailable: true}},
+			expected:  21,
+		},
+		{
+			name:      "old worker available again",
+			processes: []*fakeProcess{{pid: 3, uptime: 10, connections: 2}, {pid: 2, uptime: 20, old: true, connections: 4}},
+			expected:  24,
+		},
+		{
+			name:      "several old workers",
+			processes: []*fakeProcess{{pid: 4, uptime: 0, connections: 1}, {pid: 3, uptime: 20, old: true, connections: 3}, {pid: 2, uptime: 30, old: true, connections: 4}},
+			expected:  26,
+		},
+		{
+			name:      "old workers exited",
+			processes: []*fakeProcess{{pid: 4, uptime: 10, connections: 2}},
+			expected:  27,
+		},
+	}
+
+	for _, step := range steps {
+		processes, listErr = step.processes, step.listErr
+		if step.collectNow {
+			e.CollectNow()
+		}
+		e.lastScrape = nil
+		f := gatherMetrics(t, r)
+		if step.expected < 0 {
+			mustHaveMetric(t, f, "haproxy_up", 0)
+			continue
+		}
+		if !hasMetric(f, "haproxy_server_connections_total", step.expected, labels) {
+			t.Fatalf("%s: expected %f connections:\n\n%s", step.name, step.expected, mustMetricsToString(f, "haproxy_server_connections_total"))
+		}
+	}
+}
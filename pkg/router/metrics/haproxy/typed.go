@@ -0,0 +1,189 @@
+package haproxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ScrapeModeCSV scrapes the CSV output of "show stat", whose fields are
+	// identified by their column.
+	ScrapeModeCSV = "csv"
+	// ScrapeModeTyped scrapes the output of "show stat typed", whose fields
+	// are identified by their name. It requires the unix domain socket.
+	ScrapeModeTyped = "typed"
+)
+
+// statFields are the names of the "show stat" fields, by column. The fields
+// are only ever appended by HAProxy, so the columns of the fields known by
+// all the supported versions are stable.
+var statFields = []string{
+	"pxname", "svname", "qcur", "qmax", "scur", "smax", "slim", "stot", "bin", "bout",
+	"dreq", "dresp", "ereq", "econ", "eresp", "wretr", "wredis", "status", "weight", "act",
+	"bck", "chkfail", "chkdown", "lastchg", "downtime", "qlimit", "pid", "iid", "sid", "throttle",
+	"lbtot", "tracked", "type", "rate", "rate_lim", "rate_max", "check_status", "check_code", "check_duration", "hrsp_1xx",
+	"hrsp_2xx", "hrsp_3xx", "hrsp_4xx", "hrsp_5xx", "hrsp_other", "hanafail", "req_rate", "req_rate_max", "req_tot", "cli_abrt",
+	"srv_abrt", "comp_in", "comp_out", "comp_byp", "comp_rsp", "lastsess", "last_chk", "last_agt", "qtime", "ctime",
+	"rtime", "ttime", "agent_status", "agent_code", "agent_duration", "check_desc", "agent_desc", "check_rise", "check_fall", "check_health",
+	"agent_rise", "agent_fall", "agent_health", "addr", "cookie", "mode", "algo", "conn_rate", "conn_rate_max", "conn_tot",
+	"intercepted", "dcon", "dses", "wrew", "connect", "reuse", "cache_lookups", "cache_hits", "srv_icur", "src_ilim",
+	"qtime_max", "ctime_max", "rtime_max", "ttime_max", "eint", "idle_conn_cur", "safe_conn_cur", "used_conn_cur", "need_conn_est",
+}
+
+// statFieldIndices are the columns of the "show stat" fields, by name.
+var statFieldIndices = func() map[string]int {
+	indices := make(map[string]int, len(statFields))
+	for i, name := range statFields {
+		indices[name] = i
+	}
+	return indices
+}()
+
+// typedObjectTypes are the "show stat" proxy types of the "show stat typed"
+// object types.
+var typedObjectTypes = map[string]string{
+	"F": frontendType,
+	"B": backendType,
+	"S": serverType,
+	"L": listenerType,
+}
+
+// ParseMetricSelection returns the columns of the selected "show stat"
+// fields, given either by column number or by field name such as "stot" or
+// "hrsp_5xx".
+func ParseMetricSelection(selection []string) ([]int, error) {
+	var columns []int
+	for _, s := range selection {
+		s = strings.TrimSpace(s)
+		if i, err := strconv.Atoi(s); err == nil {
+			columns = append(columns, i)
+			continue
+		}
+		i, ok := statFieldIndices[s]
+		if !ok {
+			return nil, fmt.Errorf("unknown HAProxy stat field %q", s)
+		}
+		columns = append(columns, i)
+	}
+	return columns, nil
+}
+
+// typedParseError is returned for the lines of the "show stat typed" output
+// which can't be parsed.
+type typedParseError struct {
+	line int
+	err  error
+}
+
+func (e *typedParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+// typedReader reads the output of "show stat typed" as rows of "show stat"
+// fields, so that it is parsed like the CSV output. Each line of the output
+// holds a single field of an object:
+//
+//	F.2.0.0.pxname.1:KNS:str:public
+//
+// made of the object type, the proxy id, the server or listener id, the
+// position and the name of the field and the process number, followed by
+// the tags, the type and the value of the field. The fields are placed by
+// name, and by position for the fields unknown to statFields.
+type typedReader struct {
+	scanner *bufio.Scanner
+	line    int
+
+	// pending holds the fields already read of the next row.
+	pendingKey string
+	pending    []string
+}
+
+func newTypedReader(r io.Reader) *typedReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &typedReader{scanner: scanner}
+}
+
+// Read returns the fields of the next object, or io.EOF when all the
+// objects have been read.
+func (r *typedReader) Read() ([]string, error) {
+	var key string
+	var row []string
+	if r.pending != nil {
+		key, row = r.pendingKey, r.pending
+		r.pending = nil
+	}
+
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+		lineKey, objType, pos, name, value, err := parseTypedLine(line)
+		if err != nil {
+			// the fields already read are returned by the next call
+			r.pendingKey, r.pending = key, row
+			return nil, &typedParseError{line: r.line, err: err}
+		}
+		if row != nil && lineKey != key {
+			r.pendingKey, r.pending = lineKey, setTypedField(newTypedRow(objType), pos, name, value)
+			return row, nil
+		}
+		if row == nil {
+			key, row = lineKey, newTypedRow(objType)
+		}
+		row = setTypedField(row, pos, name, value)
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, io.EOF
+	}
+	return row, nil
+}
+
+// parseTypedLine returns the object key, the proxy type, the position, the
+// name and the value of the field of a "show stat typed" line.
+func parseTypedLine(line string) (string, string, int, string, string, error) {
+	parts := strings.SplitN(line, ":", 4)
+	if len(parts) != 4 {
+		return "", "", 0, "", "", fmt.Errorf("expected 4 colon separated parts: %q", line)
+	}
+	id := strings.Split(parts[0], ".")
+	if len(id) < 5 {
+		return "", "", 0, "", "", fmt.Errorf("expected at least 5 dot separated identifiers: %q", parts[0])
+	}
+	objType, ok := typedObjectTypes[id[0]]
+	if !ok {
+		return "", "", 0, "", "", fmt.Errorf("unknown object type %q", id[0])
+	}
+	pos, err := strconv.Atoi(id[3])
+	if err != nil || pos < 0 {
+		return "", "", 0, "", "", fmt.Errorf("invalid field position %q", id[3])
+	}
+	return strings.Join(id[:3], "."), objType, pos, id[4], parts[3], nil
+}
+
+// newTypedRow returns an empty row of the given proxy type.
+func newTypedRow(objType string) []string {
+	row := make([]string, len(statFields))
+	row[statFieldIndices["type"]] = objType
+	return row
+}
+
+// setTypedField sets a field of a row, growing the row if needed.
+func setTypedField(row []string, pos int, name, value string) []string {
+	if i, ok := statFieldIndices[name]; ok {
+		pos = i
+	}
+	if pos >= len(row) {
+		row = append(row, make([]string, pos+1-len(row))...)
+	}
+	row[pos] = value
+	return row
+}
@@ -0,0 +1,141 @@
+package haproxy
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	haproxytesting "github.com/openshift/router/pkg/router/template/configmanager/haproxy/testing"
+)
+
+func TestParseMetricSelection(t *testing.T) {
+	columns, err := ParseMetricSelection([]string{"7", "stot", " hrsp_5xx", "reuse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{7, 7, 43, 85}; !reflect.DeepEqual(columns, expected) {
+		t.Fatalf("expected %v, got %v", expected, columns)
+	}
+
+	if _, err := ParseMetricSelection([]string{"stot", "unknown"}); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+}
+
+func TestTypedReader(t *testing.T) {
+	output := `F.2.0.0.pxname.1:KNS:str:public
+F.2.0.1.svname.1:KNS:str:FRONTEND
+F.2.0.7.stot.1:MCP:u64:162
+F.2.0.32.type.1:CGS:u32:0
+
+S.3.1.0.pxname.1:KNS:str:be_http:ns:web
+S.3.1.1.svname.1:KNS:str:pod:web-1:web:port:10.0.0.1:8080
+S.3.1.17.status.1:SGP:str:UP
+S.3.1.73.addr.1:CGS:str:10.0.0.1:8080
+S.3.1.120.new_field.1:MCP:u64:3
+B.3.0.0.pxname.1:KNS:str:be_http:ns:web
+B.3.0.1.svname.1:KNS:str:BACKEND
+not a field
+
+`
+	r := newTypedReader(strings.NewReader(output))
+
+	frontend, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frontend[0] != "public" || frontend[1] != "FRONTEND" || frontend[7] != "162" || frontend[32] != frontendType {
+		t.Fatalf("unexpected frontend row: %q", frontend)
+	}
+
+	server, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server[0] != "be_http:ns:web" || server[1] != "pod:web-1:web:port:10.0.0.1:8080" || server[17] != "UP" || server[73] != "10.0.0.1:8080" {
+		t.Fatalf("unexpected server row: %q", server)
+	}
+	// the type is set from the object type when missing, and the unknown
+	// fields are placed by position.
+	if server[32] != serverType || len(server) != 121 || server[120] != "3" {
+		t.Fatalf("unexpected server row: %q", server)
+	}
+
+	if _, err := r.Read(); err == nil {
+		t.Fatal("expected a parse error")
+	} else if _, ok := err.(*typedParseError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	backend, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend[0] != "be_http:ns:web" || backend[1] != "BACKEND" || backend[32] != backendType {
+		t.Fatalf("unexpected backend row: %q", backend)
+	}
+
+	if _, err := r.Read(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestExporter_scrapeTyped(t *testing.T) {
+	const (
+		backend = "be_http:ns:web"
+		server  = "pod:web-1:web:port:10.0.0.1:8080"
+	)
+	labels := map[string]string{"namespace": "ns", "pod": "web-1", "route": "web", "server": "10.0.0.1:8080", "service": "web"}
+
+	haproxy := haproxytesting.StartFakeServerForTest(t)
+	defer haproxy.Stop()
+	haproxy.AddBackend(backend, haproxytesting.ServerState{Name: server, Address: "10.0.0.1", Port: 8080, Weight: 1, InitialWeight: 1, HealthCheck: true, Up: true})
+	if err := haproxy.SetStat(backend, server, "stot", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := haproxy.SetStat(backend, server, "hrsp_5xx", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := ParseMetricSelection([]string{"status", "stot", "hrsp_5xx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gather := func(mode string) string {
+		e, err := NewExporter(defaultOptions(PrometheusOptions{ScrapeURI: "unix://" + haproxy.SocketFile(), ScrapeMode: mode, ExportedMetrics: exported}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := prometheus.NewRegistry()
+		if err := r.Register(e); err != nil {
+			t.Fatal(err)
+		}
+		f := gatherMetrics(t, r)
+		mustHaveMetric(t, f, "haproxy_up", 1)
+		mustHaveMetric(t, f, "haproxy_exporter_csv_parse_failures", 0)
+		mustHaveMetric(t, f, "haproxy_server_up", 1, labels)
+		mustHaveMetric(t, f, "haproxy_server_connections_total", 10, labels)
+		mustHaveMetric(t, f, "haproxy_server_http_responses_total", 2, labels, map[string]string{"code": "5xx"})
+		if hasMetric(f, "haproxy_server_bytes_in_total", 0) {
+			t.Fatal("unexpected metric not selected by name")
+		}
+		return mustMetricsToString(f)
+	}
+
+	// both scrape modes export the same metrics.
+	if csv, typed := gather(ScrapeModeCSV), gather(ScrapeModeTyped); csv != typed {
+		t.Fatalf("the typed scrape differs from the CSV scrape:\n\n%s\n\n%s", csv, typed)
+	}
+}
+
+func TestNewExporter_scrapeMode(t *testing.T) {
+	if _, err := NewExporter(defaultOptions(PrometheusOptions{ScrapeURI: "http://localhost", ScrapeMode: ScrapeModeTyped})); err == nil {
+		t.Fatal("expected an error for the typed scrape mode over HTTP")
+	}
+	if _, err := NewExporter(defaultOptions(PrometheusOptions{ScrapeURI: "unix:///var/lib/haproxy/run/haproxy.sock", ScrapeMode: "json"})); err == nil {
+		t.Fatal("expected an error for an unknown scrape mode")
+	}
+}
@@ -7,10 +7,14 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"k8s.io/apiserver/pkg/server/healthz"
 
+	"github.com/openshift/router/pkg/router/client"
+	"github.com/openshift/router/pkg/router/crl"
 	"github.com/openshift/router/pkg/router/metrics/probehttp"
 	templateplugin "github.com/openshift/router/pkg/router/template"
 )
@@ -67,6 +71,83 @@ func HasSynced(routerPtr **templateplugin.TemplatePlugin) (healthz.HealthChecker
 	}), nil
 }
 
+// configGenerationPrefix prefixes the configuration generation in the
+// description HAProxy reports with "show info".
+const configGenerationPrefix = "config-generation-"
+
+// ConfigLoaded returns a healthz check that verifies HAProxy loaded the
+// configuration written after the initial sync, or a later one, by
+// comparing the generation rendered into the configuration with the one
+// HAProxy reports on its admin socket. The check passes when HAProxy
+// doesn't report a generation, such as with a customized template.
+// routerPtr is a pointer because it may not yet be defined.
+func ConfigLoaded(routerPtr **templateplugin.TemplatePlugin, u *url.URL) (healthz.HealthChecker, error) {
+	if routerPtr == nil {
+		return nil, fmt.Errorf("Nil routerPtr passed to ConfigLoaded")
+	}
+
+	return healthz.NamedCheck("config-loaded", func(r *http.Request) error {
+		if *routerPtr == nil {
+			return fmt.Errorf("Router not synced")
+		}
+		expected, ok := (*routerPtr).SyncedConfigGeneration()
+		if !ok {
+			return fmt.Errorf("Router configuration not written since the initial sync")
+		}
+		info, err := client.RunCommand(r.Context(), u.String(), "show info", client.ClientOpts{Timeout: 2 * time.Second})
+		if err != nil {
+			return err
+		}
+		loaded, ok := parseConfigGeneration(info)
+		if !ok {
+			log.V(4).Info("haproxy reports no configuration generation", "url", u.String())
+			return nil
+		}
+		if loaded < expected {
+			return fmt.Errorf("haproxy serves configuration generation %d, waiting for %d", loaded, expected)
+		}
+		return nil
+	}), nil
+}
+
+// parseConfigGeneration returns the configuration generation of the "show
+// info" output of HAProxy, false if it reports none.
+func parseConfigGeneration(info string) (int64, bool) {
+	for _, line := range strings.Split(info, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || name != "description" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if !strings.HasPrefix(value, configGenerationPrefix) {
+			return 0, false
+		}
+		generation, err := strconv.ParseInt(strings.TrimPrefix(value, configGenerationPrefix), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return generation, true
+	}
+	return 0, false
+}
+
+// CRLsAvailable returns a healthz check that verifies HAProxy has valid CRLs
+// for all the mutual TLS client CAs which specify CRL distribution points,
+// as HAProxy rejects the clients of the CAs whose CRLs are missing or
+// expired.
+func CRLsAvailable() healthz.HealthChecker {
+	return healthz.NamedCheck("crls-available", func(r *http.Request) error {
+		missing, loaded := crl.MissingCRLs()
+		if !loaded {
+			return fmt.Errorf("Client CA bundle not loaded")
+		}
+		if len(missing) != 0 {
+			return fmt.Errorf("Missing or expired CRLs for client CAs: %s", strings.Join(missing, "; "))
+		}
+		return nil
+	})
+}
+
 func ControllerLive() healthz.HealthChecker {
 	return healthz.NamedCheck("controller", func(r *http.Request) error {
 		return nil
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/router/pkg/router/client/client_testutils"
+	templateplugin "github.com/openshift/router/pkg/router/template"
+)
+
+// generationRouter is a router reporting the generation of the synced
+// configuration.
+type generationRouter struct {
+	templateplugin.RouterInterface
+	generation int64
+}
+
+func (r *generationRouter) SyncedConfigGeneration() (int64, bool) {
+	return r.generation, r.generation > 0
+}
+
+func TestConfigLoaded(t *testing.T) {
+	testCases := []struct {
+		name       string
+		generation int64
+		info       string
+		expectErr  bool
+	}{
+		{
+			name:       "configuration not written since the sync",
+			generation: 0,
+			info:       "Name: HAProxy\ndescription: config-generation-1\n",
+			expectErr:  true,
+		},
+		{
+			name:       "initial configuration served",
+			generation: 2,
+			info:       "Name: HAProxy\ndescription: config-generation-1\n",
+			expectErr:  true,
+		},
+		{
+			name:       "synced configuration served",
+			generation: 2,
+			info:       "Name: HAProxy\ndescription: config-generation-2\n",
+		},
+		{
+			name:       "later configuration served",
+			generation: 2,
+			info:       "Name: HAProxy\ndescription: config-generation-5\n",
+		},
+		{
+			name:       "no generation reported",
+			generation: 2,
+			info:       "Name: HAProxy\nnode: router\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			socket, stopServer := client_testutils.CreateServerMock(t, map[string]string{"show info": tc.info})
+			defer stopServer()
+
+			plugin := &templateplugin.TemplatePlugin{Router: &generationRouter{generation: tc.generation}}
+			check, err := ConfigLoaded(&plugin, &url.URL{Scheme: "unix", Path: socket})
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodGet, "/healthz/ready", nil)
+			require.NoError(t, err)
+			err = check.Check(req)
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	var plugin *templateplugin.TemplatePlugin
+	check, err := ConfigLoaded(&plugin, &url.URL{Scheme: "unix", Path: "/nonexistent"})
+	require.NoError(t, err)
+	require.Error(t, check.Check(&http.Request{}), "the check must fail until the router is created")
+}
+
+func TestCRLsAvailable(t *testing.T) {
+	// The check passes when the CRLs are not managed.
+	check := CRLsAvailable()
+	require.NoError(t, check.Check(&http.Request{}))
+}
@@ -0,0 +1,206 @@
+package routeapihelpers
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/util/cert"
+)
+
+// ChainBuilder builds the chains served for route certificates, from the
+// certificate of the route and its CA certificate, which haproxy serves along
+// with it, up to a trusted root.
+type ChainBuilder struct {
+	// Intermediates are the CA certificates the incomplete chains are
+	// completed from. The self-signed ones are trusted roots, never served.
+	Intermediates []*x509.Certificate
+
+	// Roots are the root certificates trusted by the clients, which the
+	// served chains don't need to include.
+	Roots *x509.CertPool
+}
+
+// CertificateChain is the chain built for a route certificate.
+type CertificateChain struct {
+	// Certificate is the certificate of the route, in PEM format, with the
+	// intermediates added from the bundle after the chain of each of its
+	// leaf certificates.
+	Certificate string
+
+	// Added are the subjects of the intermediates added to the chain.
+	Added []string
+
+	// Missing are the subjects of the issuers which are neither served nor
+	// in the intermediates, of the certificates not issued by a trusted root.
+	Missing []string
+}
+
+// Complete returns true if the chain leads to a trusted root.
+func (c *CertificateChain) Complete() bool {
+	return len(c.Missing) == 0
+}
+
+// Build returns the chain served for certPEM, completed with the
+// intermediates missing from certPEM and caPEM.
+func (b *ChainBuilder) Build(certPEM, caPEM string) (*CertificateChain, error) {
+	certs, err := cert.ParseCertsPEM([]byte(certPEM))
+	if err != nil {
+		return nil, err
+	}
+	var cas []*x509.Certificate
+	if len(caPEM) > 0 {
+		if cas, err = cert.ParseCertsPEM([]byte(caPEM)); err != nil {
+			return nil, err
+		}
+	}
+
+	leaves := dualLeafIndexes(certs)
+	if leaves == nil {
+		leaves = []int{0}
+	}
+	chain := &CertificateChain{}
+	// added holds the intermediates to add after the last certificate of
+	// the chain of each leaf, by the index of that certificate.
+	added := make(map[int][]*x509.Certificate)
+	for n, i := range leaves {
+		end := len(certs)
+		if n+1 < len(leaves) {
+			end = leaves[n+1]
+		}
+		served := append(append([]*x509.Certificate{}, certs[i:end]...), cas...)
+		intermediates, missing := b.complete(certs[i], served)
+		for _, c := range intermediates {
+			chain.Added = append(chain.Added, c.Subject.String())
+		}
+		if len(missing) > 0 {
+			chain.Missing = append(chain.Missing, missing)
+		}
+		added[end-1] = intermediates
+	}
+
+	if len(chain.Added) == 0 {
+		chain.Certificate = certPEM
+		return chain, nil
+	}
+	// The blocks of certPEM are kept as they are, along with any private
+	// key it holds.
+	var buf bytes.Buffer
+	index := 0
+	for rest := []byte(certPEM); ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if err := pem.Encode(&buf, block); err != nil {
+			return nil, err
+		}
+		if block.Type != cert.CertificateBlockType {
+			continue
+		}
+		for _, c := range added[index] {
+			if err := pem.Encode(&buf, &pem.Block{Type: cert.CertificateBlockType, Bytes: c.Raw}); err != nil {
+				return nil, err
+			}
+		}
+		index++
+	}
+	chain.Certificate = buf.String()
+	return chain, nil
+}
+
+// complete follows the issuers of leaf through the served certificates, then
+// through the intermediates, until a self-signed certificate or a certificate
+// issued by a trusted root. Returns the intermediates to serve along with
+// leaf, and the subject of the issuer that is missing, if any.
+func (b *ChainBuilder) complete(leaf *x509.Certificate, served []*x509.Certificate) ([]*x509.Certificate, string) {
+	var added []*x509.Certificate
+	current := leaf
+	// Each certificate can only appear once in a chain.
+	for range len(served) + len(b.Intermediates) + 1 {
+		if isSelfSignedCert(current) {
+			return added, ""
+		}
+		if issuer := findIssuer(current, served); issuer != nil {
+			current = issuer
+			continue
+		}
+		if b.issuedByRoot(current) {
+			return added, ""
+		}
+		issuer := findIssuer(current, b.Intermediates)
+		if issuer == nil {
+			return added, current.Issuer.String()
+		}
+		if !isSelfSignedCert(issuer) {
+			added = append(added, issuer)
+			served = append(served, issuer)
+		}
+		current = issuer
+	}
+	return added, current.Issuer.String()
+}
+
+// issuedByRoot returns true if c is issued by a trusted root. As for
+// validateCertificatePEM, expired certificates are accepted.
+func (b *ChainBuilder) issuedByRoot(c *x509.Certificate) bool {
+	if b.Roots == nil {
+		return false
+	}
+	_, err := c.Verify(x509.VerifyOptions{Roots: b.Roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if invalidErr, ok := err.(x509.CertificateInvalidError); ok && invalidErr.Reason == x509.Expired {
+		return true
+	}
+	return err == nil
+}
+
+// findIssuer returns the certificate of candidates which issued c, or nil.
+func findIssuer(c *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if bytes.Equal(candidate.Raw, c.Raw) || !bytes.Equal(candidate.RawSubject, c.RawIssuer) {
+			continue
+		}
+		if c.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// ValidateCertificateHost checks that the leaf certificates of certPEM are
+// valid for host or, for a wildcard route, for the subdomains of the parent
+// domain of host.
+func ValidateCertificateHost(certPEM, host string, wildcard bool) error {
+	certs, err := cert.ParseCertsPEM([]byte(certPEM))
+	if err != nil {
+		return err
+	}
+	leaves := dualLeafIndexes(certs)
+	if leaves == nil {
+		leaves = []int{0}
+	}
+
+	name := host
+	if wildcard {
+		if i := strings.IndexByte(host, '.'); i > 0 {
+			name = "*" + host[i:]
+		}
+	}
+	for _, i := range leaves {
+		valid := false
+		if wildcard {
+			// x509 only verifies host names, not wildcard names.
+			for _, dnsName := range certs[i].DNSNames {
+				valid = valid || strings.EqualFold(dnsName, name)
+			}
+		} else {
+			valid = certs[i].VerifyHostname(name) == nil
+		}
+		if !valid {
+			return fmt.Errorf("certificate %q is not valid for %q", certs[i].Subject.String(), name)
+		}
+	}
+	return nil
+}
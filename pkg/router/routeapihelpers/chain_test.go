@@ -0,0 +1,263 @@
+package routeapihelpers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/cert"
+)
+
+// testIssuer is a CA certificate and its key.
+type testIssuer struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// newTestCertificate returns an ECDSA certificate issued by issuer,
+// self-signed if issuer is nil.
+func newTestCertificate(t *testing.T, issuer *testIssuer, commonName string, isCA bool, dnsNames ...string) *testIssuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestCertificateWithKey(t, issuer, key, commonName, isCA, dnsNames...)
+}
+
+// newTestCertificateWithKey returns a certificate of key issued by issuer,
+// self-signed if issuer is nil.
+func newTestCertificateWithKey(t *testing.T, issuer *testIssuer, key crypto.Signer, commonName string, isCA bool, dnsNames ...string) *testIssuer {
+	t.Helper()
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	parent, parentKey := template, key
+	if issuer != nil {
+		parent, parentKey = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIssuer{cert: c, key: key}
+}
+
+func encodeCertificates(certs ...*testIssuer) string {
+	var data []byte
+	for _, c := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})...)
+	}
+	return string(data)
+}
+
+func TestChainBuilder(t *testing.T) {
+	root := newTestCertificate(t, nil, "Root CA", true)
+	intermediate := newTestCertificate(t, root, "Intermediate CA", true)
+	issuing := newTestCertificate(t, intermediate, "Issuing CA", true)
+	leaf := newTestCertificate(t, issuing, "www.example.com", false, "www.example.com")
+	selfSigned := newTestCertificate(t, nil, "www.example.com", false, "www.example.com")
+	privateRoot := newTestCertificate(t, nil, "Private CA", true)
+	privateLeaf := newTestCertificate(t, privateRoot, "www.example.com", false, "www.example.com")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateRSALeaf := newTestCertificateWithKey(t, privateRoot, rsaKey, "www.example.com", false, "www.example.com")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	testCases := []struct {
+		name          string
+		certificate   string
+		caCertificate string
+		intermediates []*testIssuer
+		expected      string
+		added         []string
+		missing       []string
+	}{
+		{
+			name:        "complete chain",
+			certificate: encodeCertificates(leaf, issuing, intermediate),
+			expected:    encodeCertificates(leaf, issuing, intermediate),
+		},
+		{
+			name:          "chain completed by the CA certificate",
+			certificate:   encodeCertificates(leaf),
+			caCertificate: encodeCertificates(issuing, intermediate),
+			expected:      encodeCertificates(leaf),
+		},
+		{
+			name:        "self-signed certificate",
+			certificate: encodeCertificates(selfSigned),
+			expected:    encodeCertificates(selfSigned),
+		},
+		{
+			name:        "missing intermediates",
+			certificate: encodeCertificates(leaf, issuing),
+			expected:    encodeCertificates(leaf, issuing),
+			missing:     []string{"CN=Intermediate CA"},
+		},
+		{
+			name:          "missing intermediate completed from the bundle",
+			certificate:   encodeCertificates(leaf, issuing),
+			intermediates: []*testIssuer{privateRoot, intermediate},
+			expected:      encodeCertificates(leaf, issuing, intermediate),
+			added:         []string{"CN=Intermediate CA"},
+		},
+		{
+			name:          "missing intermediates completed from the bundle",
+			certificate:   encodeCertificates(leaf),
+			intermediates: []*testIssuer{intermediate, issuing},
+			expected:      encodeCertificates(leaf, issuing, intermediate),
+			added:         []string{"CN=Issuing CA", "CN=Intermediate CA"},
+		},
+		{
+			name:          "partially completed chain",
+			certificate:   encodeCertificates(leaf),
+			intermediates: []*testIssuer{issuing},
+			expected:      encodeCertificates(leaf, issuing),
+			added:         []string{"CN=Issuing CA"},
+			missing:       []string{"CN=Intermediate CA"},
+		},
+		{
+			name:        "private root",
+			certificate: encodeCertificates(privateLeaf),
+			expected:    encodeCertificates(privateLeaf),
+			missing:     []string{"CN=Private CA"},
+		},
+		{
+			name:          "private root in the bundle",
+			certificate:   encodeCertificates(privateLeaf),
+			intermediates: []*testIssuer{privateRoot},
+			expected:      encodeCertificates(privateLeaf),
+		},
+		{
+			name:          "private root in the CA certificate",
+			certificate:   encodeCertificates(privateLeaf),
+			caCertificate: encodeCertificates(privateRoot),
+			expected:      encodeCertificates(privateLeaf),
+		},
+		{
+			name:          "dual certificate",
+			certificate:   encodeCertificates(privateRSALeaf, leaf),
+			intermediates: []*testIssuer{intermediate, issuing},
+			expected:      encodeCertificates(privateRSALeaf, leaf, issuing, intermediate),
+			added:         []string{"CN=Issuing CA", "CN=Intermediate CA"},
+			missing:       []string{"CN=Private CA"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &ChainBuilder{Roots: roots}
+			for _, c := range tc.intermediates {
+				b.Intermediates = append(b.Intermediates, c.cert)
+			}
+			chain, err := b.Build(tc.certificate, tc.caCertificate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chain.Certificate != tc.expected {
+				certs, _ := cert.ParseCertsPEM([]byte(chain.Certificate))
+				subjects := []string{}
+				for _, c := range certs {
+					subjects = append(subjects, c.Subject.String())
+				}
+				t.Errorf("unexpected chain %v", subjects)
+			}
+			if !reflect.DeepEqual(chain.Added, tc.added) {
+				t.Errorf("expected added intermediates %v, got %v", tc.added, chain.Added)
+			}
+			if !reflect.DeepEqual(chain.Missing, tc.missing) {
+				t.Errorf("expected missing issuers %v, got %v", tc.missing, chain.Missing)
+			}
+			if chain.Complete() != (len(tc.missing) == 0) {
+				t.Errorf("unexpected completeness %v", chain.Complete())
+			}
+		})
+	}
+}
+
+// TestChainBuilderKeepsPrivateKey ensures that the private key a certificate
+// holds is kept when its chain is completed.
+func TestChainBuilderKeepsPrivateKey(t *testing.T) {
+	root := newTestCertificate(t, nil, "Root CA", true)
+	issuing := newTestCertificate(t, root, "Issuing CA", true)
+	leaf := newTestCertificate(t, issuing, "www.example.com", false, "www.example.com")
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}))
+
+	b := &ChainBuilder{Intermediates: []*x509.Certificate{root.cert, issuing.cert}}
+	chain, err := b.Build(keyPEM+encodeCertificates(leaf), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := keyPEM + encodeCertificates(leaf, issuing); chain.Certificate != expected {
+		t.Errorf("expected %q, got %q", expected, chain.Certificate)
+	}
+}
+
+func TestValidateCertificateHost(t *testing.T) {
+	root := newTestCertificate(t, nil, "Root CA", true)
+	leaf := newTestCertificate(t, root, "www.example.com", false, "www.example.com")
+	wildcard := newTestCertificate(t, root, "*.example.com", false, "*.example.com")
+	other := newTestCertificate(t, root, "www.example.org", false, "www.example.org")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaLeaf := newTestCertificateWithKey(t, root, rsaKey, "www.example.com", false, "www.example.com")
+	rsaOther := newTestCertificateWithKey(t, root, rsaKey, "www.example.org", false, "www.example.org")
+
+	testCases := []struct {
+		name        string
+		certificate string
+		host        string
+		wildcard    bool
+		expectErr   bool
+	}{
+		{name: "host", certificate: encodeCertificates(leaf, root), host: "www.example.com"},
+		{name: "host in another case", certificate: encodeCertificates(leaf), host: "WWW.example.com"},
+		{name: "host of a wildcard certificate", certificate: encodeCertificates(wildcard), host: "shop.example.com"},
+		{name: "other host", certificate: encodeCertificates(leaf), host: "shop.example.com", expectErr: true},
+		{name: "other domain", certificate: encodeCertificates(other), host: "www.example.com", expectErr: true},
+		{name: "wildcard route", certificate: encodeCertificates(wildcard), host: "www.example.com", wildcard: true},
+		{name: "wildcard route without a wildcard certificate", certificate: encodeCertificates(leaf), host: "www.example.com", wildcard: true, expectErr: true},
+		{name: "dual certificate", certificate: encodeCertificates(rsaLeaf, wildcard), host: "www.example.com"},
+		{name: "dual certificate with another host", certificate: encodeCertificates(leaf, rsaOther), host: "www.example.com", expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateCertificateHost(tc.certificate, tc.host, tc.wildcard)
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
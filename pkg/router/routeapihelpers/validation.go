@@ -10,12 +10,15 @@ import (
 	"encoding/pem"
 	"fmt"
 	"strings"
+	"time"
 
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/client-go/util/cert"
 
 	routev1 "github.com/openshift/api/route/v1"
 	"github.com/openshift/library-go/pkg/authorization/authorizationutil"
+	"github.com/openshift/router/pkg/router/accesslog"
+	"github.com/openshift/router/pkg/router/template/util/tlsprofile"
 
 	authorizationv1 "k8s.io/api/authorization/v1"
 	kapi "k8s.io/api/core/v1"
@@ -169,6 +172,101 @@ func splitCertKey(data []byte) ([]byte, []byte, error) {
 	return publicBuf.Bytes(), privateBuf.Bytes(), nil
 }
 
+// KeyPair is a certificate chain and the private key of its leaf certificate,
+// in PEM format.
+type KeyPair struct {
+	// KeyType is the type of the key of a dual certificate, "rsa" or
+	// "ecdsa". It is empty for a single certificate.
+	KeyType     string
+	Certificate []byte
+	Key         []byte
+}
+
+// SplitKeyPairs splits the certificate and key of a route into its key pairs.
+// A route provides either a single certificate, which is returned as is, or a
+// dual certificate: an RSA and an ECDSA certificate for the same host, each
+// followed by its chain, along with both of their private keys in any order.
+func SplitKeyPairs(certPEM, keyPEM []byte) ([]KeyPair, error) {
+	var certBlocks []*pem.Block
+	var certs []*x509.Certificate
+	var keys [][]byte
+	for _, data := range [][]byte{certPEM, keyPEM} {
+		var block *pem.Block
+		for len(data) > 0 {
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			switch block.Type {
+			case "CERTIFICATE":
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				certBlocks = append(certBlocks, block)
+				certs = append(certs, cert)
+			case "PRIVATE KEY", "EC PRIVATE KEY", "RSA PRIVATE KEY":
+				keys = append(keys, pem.EncodeToMemory(block))
+			}
+		}
+	}
+
+	leaves := dualLeafIndexes(certs)
+	if leaves == nil {
+		return []KeyPair{{Certificate: certPEM, Key: keyPEM}}, nil
+	}
+	pairs := make([]KeyPair, 0, len(leaves))
+	for i, start := range leaves {
+		end := len(certs)
+		if i+1 < len(leaves) {
+			end = leaves[i+1]
+		}
+		chain := &bytes.Buffer{}
+		for _, block := range certBlocks[start:end] {
+			if err := pem.Encode(chain, block); err != nil {
+				return nil, err
+			}
+		}
+		keyType := "rsa"
+		if certs[start].PublicKeyAlgorithm == x509.ECDSA {
+			keyType = "ecdsa"
+		}
+		var key []byte
+		for _, candidate := range keys {
+			if _, err := tls.X509KeyPair(chain.Bytes(), candidate); err == nil {
+				key = candidate
+				break
+			}
+		}
+		if key == nil {
+			return nil, fmt.Errorf("no private key matches the %s certificate", strings.ToUpper(keyType))
+		}
+		pairs = append(pairs, KeyPair{KeyType: keyType, Certificate: chain.Bytes(), Key: key})
+	}
+	return pairs, nil
+}
+
+// dualLeafIndexes returns the indexes of the RSA and ECDSA leaf certificates
+// of a dual certificate, or nil if certs is a single certificate chain. The
+// leaf certificates are the first certificate and the certificates which are
+// not CAs, any other certificate belongs to the chain of the leaf it follows.
+func dualLeafIndexes(certs []*x509.Certificate) []int {
+	var leaves []int
+	for i, cert := range certs {
+		if i == 0 || !cert.IsCA {
+			leaves = append(leaves, i)
+		}
+	}
+	if len(leaves) != 2 {
+		return nil
+	}
+	first, second := certs[leaves[0]].PublicKeyAlgorithm, certs[leaves[1]].PublicKeyAlgorithm
+	if (first == x509.RSA && second == x509.ECDSA) || (first == x509.ECDSA && second == x509.RSA) {
+		return leaves
+	}
+	return nil
+}
+
 // ExtendedValidateRoute performs an extended validation on the route
 // including checking that the TLS config is valid. It also sanitizes
 // the contents of valid certificates by removing any data that
@@ -177,6 +275,10 @@ func ExtendedValidateRoute(route *routev1.Route) field.ErrorList {
 	tlsConfig := route.Spec.TLS
 	result := field.ErrorList{}
 
+	if errs := accesslog.Validate(route.Annotations, field.NewPath("metadata", "annotations")); len(errs) != 0 {
+		result = append(result, errs...)
+	}
+
 	if tlsConfig == nil {
 		return result
 	}
@@ -259,16 +361,31 @@ func ExtendedValidateRoute(route *routev1.Route) field.ErrorList {
 				if err := validatePEMContent(certBytes, "CERTIFICATE"); err != nil {
 					result = append(result, field.Invalid(tlsFieldPath.Child("certificate"), "redacted certificate data", err.Error()))
 				}
-				// Validate if the keypair is valid (eg.: the leaf certificate should be the first on certBytes)
-				if _, err := tls.X509KeyPair(certBytes, keyBytes); err != nil {
+				// Validate if the keypairs are valid (eg.: the leaf certificate should be the first on certBytes)
+				if pairs, err := SplitKeyPairs(certBytes, keyBytes); err != nil {
 					result = append(result, field.Invalid(tlsFieldPath.Child("key"), "redacted key data", err.Error()))
 				} else {
-					tlsConfig.Certificate, tlsConfig.Key = string(certBytes), string(keyBytes)
+					valid := true
+					for _, pair := range pairs {
+						if _, err := tls.X509KeyPair(pair.Certificate, pair.Key); err != nil {
+							result = append(result, field.Invalid(tlsFieldPath.Child("key"), "redacted key data", err.Error()))
+							valid = false
+						}
+					}
+					if valid {
+						tlsConfig.Certificate, tlsConfig.Key = string(certBytes), string(keyBytes)
+					}
 				}
 			}
 		}
 	}
 
+	if tlsConfig.Termination == routev1.TLSTerminationEdge || tlsConfig.Termination == routev1.TLSTerminationReencrypt {
+		if errs := tlsprofile.Validate(route.Annotations, field.NewPath("metadata", "annotations")); len(errs) != 0 {
+			result = append(result, errs...)
+		}
+	}
+
 	if len(tlsConfig.DestinationCACertificate) > 0 {
 		if certs, err := cert.ParseCertsPEM([]byte(tlsConfig.DestinationCACertificate)); err != nil {
 			errmsg := fmt.Sprintf("failed to parse destination CA certificate: %v", err)
@@ -362,9 +479,44 @@ func validateTLS(route *routev1.Route, fldPath *field.Path) field.ErrorList {
 		result = append(result, err)
 	}
 
+	if err := validateDualCertificate(tls, fldPath); err != nil {
+		result = append(result, err)
+	}
+
 	return result
 }
 
+// validateDualCertificate checks that a dual certificate, i.e. an RSA and an
+// ECDSA certificate for the same host, comes with the private keys of both of
+// its certificates.
+func validateDualCertificate(tls *routev1.TLSConfig, fldPath *field.Path) *field.Error {
+	if tls.Termination != routev1.TLSTerminationEdge && tls.Termination != routev1.TLSTerminationReencrypt {
+		return nil
+	}
+	// Invalid certificates are reported by ExtendedValidateRoute.
+	certs, err := cert.ParseCertsPEM([]byte(tls.Certificate))
+	if err != nil || dualLeafIndexes(certs) == nil {
+		return nil
+	}
+	keys := 0
+	for _, data := range [][]byte{[]byte(tls.Certificate), []byte(tls.Key)} {
+		var block *pem.Block
+		for len(data) > 0 {
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+				keys++
+			}
+		}
+	}
+	if keys != 2 {
+		return field.Invalid(fldPath.Child("key"), "redacted key data", fmt.Sprintf("a dual certificate requires the private keys of both its RSA and ECDSA certificates, found %d private key(s)", keys))
+	}
+	return nil
+}
+
 // validateInsecureEdgeTerminationPolicy tests fields for different types of
 // insecure options. Called by validateTLS.
 func validateInsecureEdgeTerminationPolicy(tls *routev1.TLSConfig, fldPath *field.Path) *field.Error {
@@ -491,10 +643,17 @@ func validateCertificatePEM(certPEM string, options *x509.VerifyOptions) ([]*x50
 		// still serve an expired/valid-in-the-future certificate
 		// and lets the client to control if it can tolerate that
 		// (just like for self-signed certs).
-		_, err = certs[0].Verify(*options)
-		if err != nil {
-			if invalidErr, ok := err.(x509.CertificateInvalidError); !ok || invalidErr.Reason != x509.Expired {
-				return certs, fmt.Errorf("error verifying certificate: %s", err.Error())
+		// Both leaf certificates of a dual certificate are verified.
+		leaves := dualLeafIndexes(certs)
+		if leaves == nil {
+			leaves = []int{0}
+		}
+		for _, i := range leaves {
+			_, err = certs[i].Verify(*options)
+			if err != nil {
+				if invalidErr, ok := err.(x509.CertificateInvalidError); !ok || invalidErr.Reason != x509.Expired {
+					return certs, fmt.Errorf("error verifying certificate: %s", err.Error())
+				}
 			}
 		}
 	}
@@ -502,6 +661,22 @@ func validateCertificatePEM(certPEM string, options *x509.VerifyOptions) ([]*x50
 	return certs, nil
 }
 
+// CertificateExpiry returns the earliest expiry time of the certificates in
+// a PEM bundle, i.e. the time a served chain stops being valid.
+func CertificateExpiry(certPEM string) (time.Time, error) {
+	certs, err := cert.ParseCertsPEM([]byte(certPEM))
+	if err != nil {
+		return time.Time{}, err
+	}
+	expiry := certs[0].NotAfter
+	for _, c := range certs[1:] {
+		if c.NotAfter.Before(expiry) {
+			expiry = c.NotAfter
+		}
+	}
+	return expiry, nil
+}
+
 // UpgradeRouteValidation performs an upgrade validation for
 // a route. This checks for issues that will cause failures in the next
 // OpenShift version.
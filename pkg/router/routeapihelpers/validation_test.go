@@ -2,12 +2,23 @@ package routeapihelpers
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	routev1 "github.com/openshift/api/route/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/cert"
 )
 
 const (
@@ -2594,6 +2605,71 @@ func TestExtendedValidateRoute(t *testing.T) {
 			},
 			expectedErrors: 3,
 		},
+		{
+			name: "Edge termination with a valid TLS profile",
+			route: &routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						"haproxy.router.openshift.io/tls-profile":     "custom",
+						"haproxy.router.openshift.io/tls-min-version": "TLSv1.0",
+						"haproxy.router.openshift.io/tls-curves":      "X25519:prime256v1",
+					},
+				},
+				Spec: routev1.RouteSpec{
+					TLS: &routev1.TLSConfig{
+						Termination: routev1.TLSTerminationEdge,
+					},
+				},
+			},
+			expectedErrors: 0,
+		},
+		{
+			name: "Edge termination with an invalid TLS profile",
+			route: &routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						"haproxy.router.openshift.io/tls-profile":     "custom",
+						"haproxy.router.openshift.io/tls-min-version": "TLSv1.3",
+						"haproxy.router.openshift.io/tls-max-version": "TLSv1.2",
+						"haproxy.router.openshift.io/tls-ciphers":     "AES128-SHA AES256-SHA",
+					},
+				},
+				Spec: routev1.RouteSpec{
+					TLS: &routev1.TLSConfig{
+						Termination: routev1.TLSTerminationReencrypt,
+					},
+				},
+			},
+			expectedErrors: 2,
+		},
+		{
+			name: "Passthrough termination ignores the TLS profile",
+			route: &routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						"haproxy.router.openshift.io/tls-profile": "unknown",
+					},
+				},
+				Spec: routev1.RouteSpec{
+					TLS: &routev1.TLSConfig{
+						Termination: routev1.TLSTerminationPassthrough,
+					},
+				},
+			},
+			expectedErrors: 0,
+		},
+		{
+			name: "Insecure route with invalid access log annotations",
+			route: &routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						"haproxy.router.openshift.io/access-log":                "maybe",
+						"haproxy.router.openshift.io/access-log-sample-percent": "150",
+					},
+				},
+			},
+			expectedErrors: 2,
+		},
 		{
 			name: "When both Certificate and Key are empty, should not report an error",
 			route: &routev1.Route{
@@ -2657,3 +2733,157 @@ func TestExtendedValidateRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestCertificateExpiry(t *testing.T) {
+	testCases := []struct {
+		name        string
+		certPEM     string
+		expected    time.Time
+		expectedErr bool
+	}{
+		{
+			name:     "certificate",
+			certPEM:  testCertificate,
+			expected: time.Date(2036, time.March, 12, 4, 21, 3, 0, time.UTC),
+		},
+		{
+			name:     "bundle expires with its earliest certificate",
+			certPEM:  testCertificate + "\n" + testExpiredCert,
+			expected: time.Date(2023, time.January, 27, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "no certificate",
+			certPEM:     "not a certificate",
+			expectedErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expiry, err := CertificateExpiry(tc.certPEM)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got expiry %v", expiry)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !expiry.Equal(tc.expected) {
+				t.Errorf("expected expiry %v, got %v", tc.expected, expiry)
+			}
+		})
+	}
+}
+
+// newDualCertificate returns the PEM encoded RSA and ECDSA certificates of
+// host, each followed by the CA certificate which issued it, and their private
+// keys in the reverse order.
+func newDualCertificate(t *testing.T, host string) (string, []string) {
+	t.Helper()
+	var certPEM string
+	var keys []string
+	for _, generate := range []func() (crypto.Signer, error){
+		func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) },
+		func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+	} {
+		caKey, err := generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ca := newTestCertificateWithKey(t, nil, caKey, "Test CA", true)
+		certPEM += encodeCertificates(newTestCertificateWithKey(t, ca, key, host, false, host), ca)
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append([]string{string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))}, keys...)
+	}
+	return certPEM, keys
+}
+
+func TestSplitKeyPairs(t *testing.T) {
+	certPEM, keys := newDualCertificate(t, "www.example.com")
+	pairs, err := SplitKeyPairs([]byte(certPEM), []byte(keys[0]+keys[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 || pairs[0].KeyType != "rsa" || pairs[1].KeyType != "ecdsa" {
+		t.Fatalf("unexpected key pairs %+v", pairs)
+	}
+	for _, pair := range pairs {
+		if _, err := tls.X509KeyPair(pair.Certificate, pair.Key); err != nil {
+			t.Errorf("invalid %s key pair: %v", pair.KeyType, err)
+		}
+		if certs, err := cert.ParseCertsPEM(pair.Certificate); err != nil || len(certs) != 2 {
+			t.Errorf("expected the %s certificate and its CA, got %d certificate(s): %v", pair.KeyType, len(certs), err)
+		}
+	}
+
+	if _, err := SplitKeyPairs([]byte(certPEM), []byte(keys[0])); err == nil {
+		t.Error("expected an error for a missing private key")
+	}
+
+	// A single certificate is returned as is.
+	pairs, err = SplitKeyPairs([]byte(testCertificate), []byte(testPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 1 || pairs[0].KeyType != "" || string(pairs[0].Certificate) != testCertificate || string(pairs[0].Key) != testPrivateKey {
+		t.Errorf("unexpected key pairs %+v", pairs)
+	}
+}
+
+// TestExtendedValidateRouteDualCertificate ensures that a route can provide
+// both an RSA and an ECDSA certificate, with both of their private keys.
+func TestExtendedValidateRouteDualCertificate(t *testing.T) {
+	certPEM, keys := newDualCertificate(t, "www.example.com")
+	tests := []struct {
+		name           string
+		certificate    string
+		key            string
+		expectedErrors int
+	}{
+		{
+			name:        "both keys",
+			certificate: certPEM,
+			key:         keys[0] + keys[1],
+		},
+		{
+			name:        "keys in the certificate",
+			certificate: keys[1] + certPEM + keys[0],
+		},
+		{
+			name:           "missing key",
+			certificate:    certPEM,
+			key:            keys[1],
+			expectedErrors: 2,
+		},
+		{
+			name:           "extra key",
+			certificate:    certPEM,
+			key:            keys[0] + keys[1] + keys[1],
+			expectedErrors: 1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			route := &routev1.Route{
+				Spec: routev1.RouteSpec{
+					TLS: &routev1.TLSConfig{
+						Termination: routev1.TLSTerminationEdge,
+						Certificate: tc.certificate,
+						Key:         tc.key,
+					},
+				},
+			}
+			if errs := ExtendedValidateRoute(route); len(errs) != tc.expectedErrors {
+				t.Errorf("expected %d error(s), got %d. %v", tc.expectedErrors, len(errs), errs)
+			}
+		})
+	}
+}
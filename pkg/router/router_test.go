@@ -448,6 +448,87 @@ func TestConfigTemplate(t *testing.T) {
 				},
 			},
 		},
+		"Access log disabled": {
+			mustCreateWithConfig{
+				mustCreateRoute: mustCreateRoute{
+					name: "al1",
+					host: "al1example.com",
+					path: "",
+					time: start,
+					annotations: map[string]string{
+						"haproxy.router.openshift.io/access-log": "false",
+					},
+					tlsTermination: routev1.TLSTerminationEdge,
+				},
+				mustMatchConfig: mustMatchConfig{
+					section:     "backend",
+					sectionName: edgeBackendName(h.namespace, "al1"),
+					attribute:   "http-request",
+					value:       `set-log-level debug`,
+				},
+			},
+		},
+		"Access log sampled": {
+			mustCreateWithConfig{
+				mustCreateRoute: mustCreateRoute{
+					name: "al2",
+					host: "al2example.com",
+					path: "",
+					time: start,
+					annotations: map[string]string{
+						"haproxy.router.openshift.io/access-log-sample-percent": "25",
+					},
+					tlsTermination: routev1.TLSTerminationEdge,
+				},
+				mustMatchConfig: mustMatchConfig{
+					section:     "backend",
+					sectionName: edgeBackendName(h.namespace, "al2"),
+					attribute:   "http-request",
+					value:       `set-log-level debug if { rand(1000000) ge 250000 }`,
+				},
+			},
+		},
+		"Access log of errors only": {
+			mustCreateWithConfig{
+				mustCreateRoute: mustCreateRoute{
+					name: "al3",
+					host: "al3example.com",
+					path: "",
+					time: start,
+					annotations: map[string]string{
+						"haproxy.router.openshift.io/access-log-errors-only": "true",
+					},
+					tlsTermination: routev1.TLSTerminationEdge,
+				},
+				mustMatchConfig: mustMatchConfig{
+					section:     "backend",
+					sectionName: edgeBackendName(h.namespace, "al3"),
+					attribute:   "http-response",
+					value:       `set-log-level debug if { status lt 500 }`,
+				},
+			},
+		},
+		"Invalid access log sampling": {
+			mustCreateWithConfig{
+				mustCreateRoute: mustCreateRoute{
+					name: "al4",
+					host: "al4example.com",
+					path: "",
+					time: start,
+					annotations: map[string]string{
+						"haproxy.router.openshift.io/access-log-sample-percent": "150",
+					},
+					tlsTermination: routev1.TLSTerminationEdge,
+				},
+				mustMatchConfig: mustMatchConfig{
+					section:     "backend",
+					sectionName: edgeBackendName(h.namespace, "al4"),
+					attribute:   "http-request",
+					value:       `set-log-level debug if { rand(1000000) ge 1500000 }`,
+					notFound:    true,
+				},
+			},
+		},
 		// test cases to be revised once HSTS pattern is fully compliant to RFC6797#section-6.1
 		"Wrong HSTS header directive": {
 			mustCreateWithConfig{
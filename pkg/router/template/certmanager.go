@@ -7,18 +7,32 @@ import (
 	"path/filepath"
 
 	routev1 "github.com/openshift/api/route/v1"
+	"github.com/openshift/router/pkg/router/routeapihelpers"
 )
 
 // certificateFile represents a certificate file.
 type certificateFile struct {
 	certDir string
 	id      string
+	// keyType is the key type of a certificate of a multi-cert bundle.
+	keyType string
 }
 
 // Tag generates a certificate file tag/name. This is used to index into the
 // the map of deleted certificates.
 func (cf certificateFile) Tag() string {
-	return filepath.Join(cf.certDir, cf.id+".pem")
+	return certificateFileName(cf.certDir, cf.id, cf.keyType)
+}
+
+// certificateFileName returns the name of the file of the certificate <id> in
+// <directory>: <id>.pem, or <id>.pem.<keyType> for the certificate of a
+// multi-cert bundle, following the haproxy naming of the bundles.
+func certificateFileName(directory, id, keyType string) string {
+	fileName := filepath.Join(directory, id+".pem")
+	if len(keyType) > 0 {
+		fileName += "." + keyType
+	}
+	return fileName
 }
 
 // simpleCertificateManager is the default implementation of a certificateManager
@@ -76,7 +90,14 @@ func (cm *simpleCertificateManager) WriteCertificatesForConfig(config *ServiceAl
 			certKey := cm.cfg.certKeyFunc(config)
 			certObj, ok := config.Certificates[certKey]
 
-			if ok {
+			caCertKey := cm.cfg.caCertKeyFunc(config)
+			caCertObj, caOk := config.Certificates[caCertKey]
+
+			if ok && len(certObj.Bundle) > 0 {
+				if err := cm.writeBundle(certObj, caCertObj.Contents); err != nil {
+					return err
+				}
+			} else if ok {
 				newLine := []byte("\n")
 
 				//initialize with key and append the newline and cert
@@ -84,9 +105,6 @@ func (cm *simpleCertificateManager) WriteCertificatesForConfig(config *ServiceAl
 				buffer.Write(newLine)
 				buffer.Write([]byte(certObj.Contents))
 
-				caCertKey := cm.cfg.caCertKeyFunc(config)
-				caCertObj, caOk := config.Certificates[caCertKey]
-
 				if caOk {
 					buffer.Write(newLine)
 					buffer.Write([]byte(caCertObj.Contents))
@@ -116,6 +134,33 @@ func (cm *simpleCertificateManager) WriteCertificatesForConfig(config *ServiceAl
 	return nil
 }
 
+// writeBundle writes the certificates of a dual certificate as a multi-cert
+// bundle: one <id>.pem.<key type> file per key type, each one with its key,
+// its chain and the ca cert.
+func (cm *simpleCertificateManager) writeBundle(certObj Certificate, caCert string) error {
+	pairs, err := routeapihelpers.SplitKeyPairs([]byte(certObj.Contents), []byte(certObj.PrivateKey))
+	if err != nil {
+		return fmt.Errorf("error splitting the certificate %s: %v", certObj.ID, err)
+	}
+	newLine := []byte("\n")
+	for _, pair := range pairs {
+		buffer := bytes.NewBuffer(pair.Key)
+		buffer.Write(newLine)
+		buffer.Write(pair.Certificate)
+		if len(caCert) > 0 {
+			buffer.Write(newLine)
+			buffer.Write([]byte(caCert))
+		}
+
+		certFile := certificateFile{certDir: cm.cfg.certDir, id: certObj.ID, keyType: pair.KeyType}
+		delete(cm.deletedCertificates, certFile.Tag())
+		if err := cm.w.WriteBundleCertificate(cm.cfg.certDir, certObj.ID, pair.KeyType, buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // DeleteCertificatesForConfig will delete all certificates for the ServiceAliasConfig
 func (cm *simpleCertificateManager) DeleteCertificatesForConfig(config *ServiceAliasConfig) error {
 	if config == nil {
@@ -127,8 +172,15 @@ func (cm *simpleCertificateManager) DeleteCertificatesForConfig(config *ServiceA
 			certObj, ok := config.Certificates[certKey]
 
 			if ok {
-				certFile := certificateFile{certDir: cm.cfg.certDir, id: certObj.ID}
-				cm.deletedCertificates[certFile.Tag()] = certFile
+				if len(certObj.Bundle) > 0 {
+					for _, keyType := range certObj.Bundle {
+						certFile := certificateFile{certDir: cm.cfg.certDir, id: certObj.ID, keyType: keyType}
+						cm.deletedCertificates[certFile.Tag()] = certFile
+					}
+				} else {
+					certFile := certificateFile{certDir: cm.cfg.certDir, id: certObj.ID}
+					cm.deletedCertificates[certFile.Tag()] = certFile
+				}
 			}
 		}
 
@@ -152,7 +204,12 @@ func (cm *simpleCertificateManager) Commit() error {
 	// reload because the config is invalid, so we _do_ need to "stage"
 	// or commit the removals. Remove all the deleted certificates.
 	for _, certFile := range cm.deletedCertificates {
-		err := cm.w.DeleteCertificate(certFile.certDir, certFile.id)
+		var err error
+		if len(certFile.keyType) > 0 {
+			err = cm.w.DeleteBundleCertificate(certFile.certDir, certFile.id, certFile.keyType)
+		} else {
+			err = cm.w.DeleteCertificate(certFile.certDir, certFile.id)
+		}
 		if err != nil {
 			// Log a warning if the delete fails but proceed on.
 			log.V(0).Info("ignoring error deleting certificate file", "certFile", certFile.Tag(), "error", err)
@@ -180,7 +237,16 @@ func newSimpleCertificateWriter() certificateWriter {
 // WriteCertificate creates and writes the file identified by <id> in <directory>.  The file extension
 // .pem will be added to id.
 func (cm *simpleCertificateWriter) WriteCertificate(directory string, id string, cert []byte) error {
-	fileName := filepath.Join(directory, id+".pem")
+	return cm.writeFile(certificateFileName(directory, id, ""), cert)
+}
+
+// WriteBundleCertificate creates and writes the file of the certificate of type <keyType> of the multi-cert bundle
+// <id> in <directory>. The file extension .pem.<keyType> will be added to id.
+func (cm *simpleCertificateWriter) WriteBundleCertificate(directory, id, keyType string, cert []byte) error {
+	return cm.writeFile(certificateFileName(directory, id, keyType), cert)
+}
+
+func (cm *simpleCertificateWriter) writeFile(fileName string, cert []byte) error {
 	err := os.WriteFile(fileName, cert, 0644)
 
 	if err != nil {
@@ -193,7 +259,16 @@ func (cm *simpleCertificateWriter) WriteCertificate(directory string, id string,
 // DeleteCertificate deletes certificates identified by <id> in <directory> with the .pem extension added.
 // this will not return an error if the file does not exist
 func (cm *simpleCertificateWriter) DeleteCertificate(directory, id string) error {
-	fileName := filepath.Join(directory, id+".pem")
+	return cm.deleteFile(certificateFileName(directory, id, ""))
+}
+
+// DeleteBundleCertificate deletes the certificate of type <keyType> of the multi-cert bundle <id> in <directory>.
+// this will not return an error if the file does not exist
+func (cm *simpleCertificateWriter) DeleteBundleCertificate(directory, id, keyType string) error {
+	return cm.deleteFile(certificateFileName(directory, id, keyType))
+}
+
+func (cm *simpleCertificateWriter) deleteFile(fileName string) error {
 	if _, err := os.Stat(fileName); os.IsNotExist(err) {
 		log.V(4).Info("attempted to delete file but it does not exist", "fileName", fileName)
 		return nil
@@ -1,15 +1,61 @@
 package templaterouter
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	routev1 "github.com/openshift/api/route/v1"
 )
 
+// newDualCertificate returns the PEM encoded RSA and ECDSA self-signed
+// certificates of host, followed by their private keys in the reverse order.
+func newDualCertificate(t *testing.T, host string) (string, string) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certPEM, keyPEM []byte
+	for i, key := range []crypto.Signer{rsaKey, ecdsaKey} {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 1)),
+			Subject:      pkix.Name{CommonName: host},
+			DNSNames:     []string{host},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyPEM = append(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), keyPEM...)
+	}
+	return string(certPEM), string(keyPEM)
+}
+
 func TestCertManager(t *testing.T) {
 	cfg := newFakeCertificateManagerConfig()
+	dualCert, dualKey := newDualCertificate(t, "www.example.com")
 	fakeCertWriter := &fakeCertWriter{}
 	certManager, _ := newSimpleCertificateManager(cfg, fakeCertWriter)
 
@@ -57,6 +103,23 @@ func TestCertManager(t *testing.T) {
 			expectedAdds:    []string{},
 			expectedDeletes: []string{},
 		},
+		"add cert edge dual": {
+			//expect that the dual certificate is written as a multi-cert bundle
+			cfg: &ServiceAliasConfig{
+				Host:           "www.example.com",
+				TLSTermination: routev1.TLSTerminationEdge,
+				Certificates: map[string]Certificate{
+					"www.example.com": {
+						ID:         "testCert",
+						Contents:   dualCert,
+						PrivateKey: dualKey,
+						Bundle:     []string{"rsa", "ecdsa"},
+					},
+				},
+			},
+			expectedAdds:    []string{cfg.certDir + "testCert.ecdsa", cfg.certDir + "testCert.rsa"},
+			expectedDeletes: []string{cfg.certDir + "testCert.ecdsa", cfg.certDir + "testCert.rsa"},
+		},
 		"add cert reencrypt": {
 			//expect that we have 2 adds/deletes.  1 for the regular cert/ca and 1 for the destination cert
 			cfg: &ServiceAliasConfig{
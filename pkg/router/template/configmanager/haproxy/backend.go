@@ -39,6 +39,15 @@ const (
 	// showBackendHeader is the haproxy backend list csv output header.
 	showBackendHeader = "name"
 
+	// serverAdminStateMaint is the set of srv_admin_state flags telling
+	// that a server is in maintenance mode: forced, inherited, configured,
+	// resolution and hostname related.
+	serverAdminStateMaint = 0x01 | 0x02 | 0x04 | 0x20 | 0x40
+
+	// serverAdminStateDrain is the set of srv_admin_state flags telling
+	// that a server is draining: forced or inherited.
+	serverAdminStateDrain = 0x08 | 0x10
+
 	// serverStateHeader is the haproxy server state csv output header.
 	serversStateHeader = "be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port"
 )
@@ -75,12 +84,30 @@ type serverStateInfo struct {
 	Port int    `csv:"srv_port"`
 }
 
+// serverInfo returns the backend server info for this server state.
+func (v *serverStateInfo) serverInfo() BackendServerInfo {
+	return BackendServerInfo{
+		Name:          v.Name,
+		IPAddress:     v.IPAddress,
+		Port:          v.Port,
+		CurrentWeight: v.UserVisibleWeight,
+		Maintenance:   v.AdministrativeState&serverAdminStateMaint != 0,
+		Draining:      v.AdministrativeState&serverAdminStateDrain != 0,
+	}
+}
+
 // BackendServerInfo represents a server [endpoint] for a haproxy backend.
 type BackendServerInfo struct {
 	Name          string
 	IPAddress     string
 	Port          int
 	CurrentWeight int32
+
+	// Maintenance indicates the server is in maintenance mode.
+	Maintenance bool
+
+	// Draining indicates the server is draining.
+	Draining bool
 }
 
 // Backend represents a specific haproxy backend.
@@ -89,6 +116,9 @@ type Backend struct {
 	servers map[string]*backendServer
 
 	client HAProxyClient
+
+	// tx is the transaction the server changes are added to, if any.
+	tx *Transaction
 }
 
 // backendServer is internally used for managing a haproxy backend server.
@@ -132,9 +162,11 @@ func (b *Backend) Name() templaterouter.ServiceAliasConfigKey {
 	return b.name
 }
 
-// Reset resets the cached server info in this haproxy backend.
+// Reset resets the cached server info and any pending transaction in this
+// haproxy backend.
 func (b *Backend) Reset() {
 	b.servers = make(map[string]*backendServer)
+	b.tx = nil
 }
 
 // Refresh refreshs our internal state for this haproxy backend.
@@ -149,51 +181,49 @@ func (b *Backend) Refresh() error {
 
 	b.servers = make(map[string]*backendServer)
 	for _, v := range entries {
-		info := BackendServerInfo{
-			Name:          v.Name,
-			IPAddress:     v.IPAddress,
-			Port:          v.Port,
-			CurrentWeight: v.UserVisibleWeight,
-		}
-
-		b.servers[v.Name] = newBackendServer(info)
+		b.servers[v.Name] = newBackendServer(v.serverInfo())
 	}
 
 	return nil
 }
 
+// backendServersState returns the servers of all the haproxy backends,
+// keyed by backend name, using a single dynamic config API command.
+func backendServersState(c HAProxyClient) (map[templaterouter.ServiceAliasConfigKey][]BackendServerInfo, error) {
+	entries := []*serverStateInfo{}
+	converter := NewCSVConverter(serversStateHeader, &entries, stripVersionNumber)
+	if _, err := c.RunCommand(GetServersStateCommand, converter); err != nil {
+		return nil, err
+	}
+
+	servers := make(map[templaterouter.ServiceAliasConfigKey][]BackendServerInfo)
+	for _, v := range entries {
+		name := templaterouter.ServiceAliasConfigKey(v.BackendName)
+		servers[name] = append(servers[name], v.serverInfo())
+	}
+
+	return servers, nil
+}
+
 // SetRoutingKey sets the cookie routing key for the haproxy backend.
 func (b *Backend) SetRoutingKey(k string) error {
 	log.V(4).Info("setting routing key", "backend", b.name)
 
+	// The routing key of a pool backend is only used once a route is
+	// mapped to it, so there is nothing to roll back.
 	cmd := fmt.Sprintf("set dynamic-cookie-key backend %s %s", b.name, k)
-	if err := b.executeCommand(cmd); err != nil {
+	if err := b.run(apiSetDynamicCookie, cmd, []*transactionCommand{}); err != nil {
 		return fmt.Errorf("setting routing key for backend %s: %v", b.name, err)
 	}
 
 	cmd = fmt.Sprintf("enable dynamic-cookie backend %s", b.name)
-	if err := b.executeCommand(cmd); err != nil {
+	if err := b.run(apiSetDynamicCookie, cmd, []*transactionCommand{}); err != nil {
 		return fmt.Errorf("enabling routing key for backend %s: %v", b.name, err)
 	}
 
 	return nil
 }
 
-// executeCommand runs a command using the haproxy dynamic config api client.
-func (b *Backend) executeCommand(cmd string) error {
-	responseBytes, err := b.client.Execute(cmd)
-	if err != nil {
-		return err
-	}
-
-	response := strings.TrimSpace(string(responseBytes))
-	if len(response) > 0 {
-		return errors.New(response)
-	}
-
-	return nil
-}
-
 // Disable stops serving traffic for all servers for a haproxy backend.
 func (b *Backend) Disable() error {
 	if _, err := b.Servers(); err != nil {
@@ -221,21 +251,51 @@ func (b *Backend) DisableServer(name string) error {
 	return b.UpdateServerState(name, BackendServerStateMaint)
 }
 
+// Begin starts a transaction on this haproxy backend: the server changes
+// made until Commit is called are sent together and are rolled back if any
+// of them fails.
+func (b *Backend) Begin() error {
+	return b.beginTransaction(newTransaction(b.client))
+}
+
+// beginTransaction adds the server changes made to this haproxy backend to
+// tx, which may be shared with other backends, until tx is committed.
+func (b *Backend) beginTransaction(tx *Transaction) error {
+	// The current state is needed to know how to roll back the changes.
+	if err := b.Refresh(); err != nil {
+		return err
+	}
+
+	b.tx = tx
+	return nil
+}
+
 // Commit commits all the pending changes made to a haproxy backend.
 func (b *Backend) Commit() error {
-	for _, s := range b.servers {
-		if err := s.ApplyChanges(b.name, b.client); err != nil {
-			return err
-		}
+	if b.tx == nil {
+		b.tx = newTransaction(b.client)
 	}
+	tx := b.stageChanges()
 
+	err := tx.Commit()
 	b.Reset()
-	return nil
+	return err
+}
+
+// stageChanges adds the pending server changes to the transaction of this
+// haproxy backend, and returns it.
+func (b *Backend) stageChanges() *Transaction {
+	for _, s := range b.servers {
+		s.addChanges(b.name, b.tx)
+	}
+	return b.tx
 }
 
 // Servers returns the servers for this haproxy backend.
 func (b *Backend) Servers() ([]BackendServerInfo, error) {
-	if len(b.servers) == 0 {
+	// Within a transaction, the servers are the ones refreshed when it
+	// began, along with the ones added and deleted since.
+	if len(b.servers) == 0 && b.tx == nil {
 		if err := b.Refresh(); err != nil {
 			return []BackendServerInfo{}, err
 		}
@@ -307,6 +367,15 @@ func (b *Backend) FindServer(id string) (*backendServer, error) {
 // It returns a failure in case HAProxy refuses to dynamically add the server for any reason, or if the existing server
 // cannot be removed, e.g., it still have active or steady and established connection(s) to its backend server endpoint.
 func (b *Backend) AddServer(cfg *templaterouter.ServiceAliasConfig, svc *templaterouter.ServiceUnit, ep templaterouter.Endpoint, weight int32, workingDir, defaultDestinationCA string) error {
+	if _, exists := b.servers[ep.ID]; exists && b.tx != nil {
+		// Responses are only known on commit, so remove the server left behind beforehand.
+		if err := b.innerSetServerState(ep, false, 0); err != nil {
+			return err
+		}
+		if err := b.innerDeleteServer(ep); err != nil {
+			return err
+		}
+	}
 	if err := b.innerAddServer(cfg, svc, ep, weight, workingDir, defaultDestinationCA); err != nil {
 		if !strings.Contains(err.Error(), "Already exists a server ") {
 			return err
@@ -324,6 +393,10 @@ func (b *Backend) AddServer(cfg *templaterouter.ServiceAliasConfig, svc *templat
 	if err := b.innerSetServerState(ep, true, weight); err != nil {
 		return err
 	}
+	if b.tx != nil {
+		port, _ := strconv.Atoi(ep.Port)
+		b.servers[ep.ID] = newBackendServer(BackendServerInfo{Name: ep.ID, IPAddress: ep.IP, Port: port, CurrentWeight: weight, Draining: weight <= 0})
+	}
 
 	// health check is disabled by default on new backend servers, its enablement is handled via cm.ReplaceRouteEndpoints(),
 	// since that method has a better view of former and current active backend servers.
@@ -354,12 +427,22 @@ func (b *Backend) DisableHealthCheck(ep templaterouter.Endpoint) error {
 // DeleteServer dynamically removes the backend server from the load balance. The backend server is put in maintenance mode
 // and returns `removed` as false in case it has active or steady and established connections, so these connections continue
 // to be handled and new ones are directed to other servers. An error only happens if the server cannot be put in maintenance
-// mode, any failure trying to remove the server is logged and just return removed as false.
+// mode, any failure trying to remove the server is logged and just return removed as false. Within a transaction the
+// removal is only known on commit, and `removed` is always false.
 func (b *Backend) DeleteServer(ep templaterouter.Endpoint) (removed bool, err error) {
 	// put in maintenance mode first, this is a pre-requisite to remove a backend server.
 	if err := b.innerSetServerState(ep, false, 0); err != nil {
 		return false, err
 	}
+	if b.tx != nil {
+		b.tx.add(&transactionCommand{
+			api:      apiDelServer,
+			cmd:      fmt.Sprintf("del server %s/%s", b.name, ep.ID),
+			optional: true,
+		})
+		delete(b.servers, ep.ID)
+		return false, nil
+	}
 	if err := b.innerDeleteServer(ep); err != nil {
 		log.Info("disabling backend server instead of deleting due to a delete failure", "server", ep.ID, "error", err.Error())
 		return false, nil
@@ -416,12 +499,22 @@ func (b *Backend) innerAddServer(cfg *templaterouter.ServiceAliasConfig, svc *te
 		cmd += " maxconn " + podMaxConn
 	}
 
-	return execCommand(b.client, apiAddServer, cmd)
+	return b.run(apiAddServer, cmd, []*transactionCommand{
+		{api: apiSetServerState, cmd: fmt.Sprintf("set server %s/%s state maint", b.name, ep.ID)},
+		{api: apiDelServer, cmd: fmt.Sprintf("del server %s/%s", b.name, ep.ID)},
+	})
 }
 
 func (b *Backend) innerUpdateServerAddr(ep templaterouter.Endpoint) error {
 	cmd := fmt.Sprintf("set server %s/%s addr %s port %s", b.name, ep.ID, ep.IP, ep.Port)
-	return execCommand(b.client, apiSetServerAddr, cmd)
+	var rollback []*transactionCommand
+	if s, ok := b.servers[ep.ID]; ok {
+		rollback = append(rollback, &transactionCommand{
+			api: apiSetServerAddr,
+			cmd: fmt.Sprintf("set server %s/%s addr %s port %d", b.name, ep.ID, s.IPAddress, s.Port),
+		})
+	}
+	return b.run(apiSetServerAddr, cmd, rollback)
 }
 
 func (b *Backend) innerUpdateServerWeight(ep templaterouter.Endpoint, weight int32, isPassthrough bool) error {
@@ -432,7 +525,14 @@ func (b *Backend) innerUpdateServerWeight(ep templaterouter.Endpoint, weight int
 	} else {
 		cmd = fmt.Sprintf("%s weight %d", cmd, weight)
 	}
-	return execCommand(b.client, apiSetServerWeight, cmd)
+	var rollback []*transactionCommand
+	if s, ok := b.servers[ep.ID]; ok {
+		rollback = append(rollback, &transactionCommand{
+			api: apiSetServerWeight,
+			cmd: fmt.Sprintf("set server %s/%s weight %d", b.name, ep.ID, s.CurrentWeight),
+		})
+	}
+	return b.run(apiSetServerWeight, cmd, rollback)
 }
 
 func (b *Backend) innerSetHealthCheck(ep templaterouter.Endpoint, enable bool) error {
@@ -441,7 +541,15 @@ func (b *Backend) innerSetHealthCheck(ep templaterouter.Endpoint, enable bool) e
 		enableStr = "disable"
 	}
 	cmd := fmt.Sprintf("%s health %s/%s", enableStr, b.name, ep.ID)
-	return execCommand(b.client, apiSetHealth, cmd)
+	// The health check state is not tracked, reverting means toggling it back.
+	revertStr := "disable"
+	if !enable {
+		revertStr = "enable"
+	}
+	rollback := []*transactionCommand{
+		{api: apiSetHealth, cmd: fmt.Sprintf("%s health %s/%s", revertStr, b.name, ep.ID)},
+	}
+	return b.run(apiSetHealth, cmd, rollback)
 }
 
 func (b *Backend) innerSetServerState(ep templaterouter.Endpoint, ready bool, weight int32) error {
@@ -452,12 +560,37 @@ func (b *Backend) innerSetServerState(ep templaterouter.Endpoint, ready bool, we
 		state = "drain"
 	}
 	cmd := fmt.Sprintf("set server %s/%s state %s", b.name, ep.ID, state)
-	return execCommand(b.client, apiSetServerState, cmd)
+	var rollback []*transactionCommand
+	if s, ok := b.servers[ep.ID]; ok {
+		rollback = append(rollback, &transactionCommand{
+			api: apiSetServerState,
+			cmd: fmt.Sprintf("set server %s/%s state %s", b.name, ep.ID, s.state()),
+		})
+	}
+	return b.run(apiSetServerState, cmd, rollback)
 }
 
 func (b *Backend) innerDeleteServer(ep templaterouter.Endpoint) error {
 	cmd := fmt.Sprintf("del server %s/%s", b.name, ep.ID)
-	return execCommand(b.client, apiDelServer, cmd)
+	// a deleted server cannot be added back without its configuration.
+	return b.run(apiDelServer, cmd, nil)
+}
+
+// run executes a dynamic config API command, or adds it to the backend
+// transaction if one was started. rollback are the commands reverting
+// the command, nil if it cannot be reverted. Commands on servers that
+// are not known yet are reverted by removing the server added within
+// the same transaction, so they need no rollback.
+func (b *Backend) run(api apiType, cmd string, rollback []*transactionCommand) error {
+	if b.tx == nil {
+		return execCommand(b.client, api, cmd)
+	}
+
+	if rollback == nil && api != apiDelServer {
+		rollback = []*transactionCommand{}
+	}
+	b.tx.add(&transactionCommand{api: api, cmd: cmd, rollback: rollback})
+	return nil
 }
 
 // newBackendServer returns a BackendServer representing a haproxy backend server.
@@ -472,62 +605,44 @@ func newBackendServer(info BackendServerInfo) *backendServer {
 	}
 }
 
-// ApplyChanges applies all the local backend server changes.
-func (s *backendServer) ApplyChanges(backendName templaterouter.ServiceAliasConfigKey, client HAProxyClient) error {
-	// Build the haproxy dynamic config API commands.
-	commands := []string{}
+// state returns the current state of the backend server.
+func (s *backendServer) state() BackendServerState {
+	switch {
+	case s.Maintenance:
+		return BackendServerStateMaint
+	case s.Draining:
+		return BackendServerStateDrain
+	}
+	return BackendServerStateReady
+}
 
+// addChanges adds all the local backend server changes to a transaction.
+func (s *backendServer) addChanges(backendName templaterouter.ServiceAliasConfigKey, tx *Transaction) {
 	cmdPrefix := fmt.Sprintf("%s %s/%s", SetServerCommand, string(backendName), s.Name)
 
 	if s.updatedIPAddress != s.IPAddress || s.updatedPort != s.Port {
 		cmd := fmt.Sprintf("%s addr %s", cmdPrefix, s.updatedIPAddress)
+		rollbackCmd := fmt.Sprintf("%s addr %s", cmdPrefix, s.IPAddress)
 		if s.updatedPort != s.Port {
 			cmd = fmt.Sprintf("%s port %v", cmd, s.updatedPort)
+			rollbackCmd = fmt.Sprintf("%s port %v", rollbackCmd, s.Port)
 		}
-		commands = append(commands, cmd)
+		tx.add(&transactionCommand{api: apiSetServerAddr, cmd: cmd, rollback: []*transactionCommand{
+			{api: apiSetServerAddr, cmd: rollbackCmd},
+		}})
 	}
 
 	if s.updatedWeight != strconv.Itoa(int(s.CurrentWeight)) {
-		// Build and execute the haproxy dynamic config API command.
-		cmd := fmt.Sprintf("%s weight %s", cmdPrefix, s.updatedWeight)
-		commands = append(commands, cmd)
+		tx.add(&transactionCommand{api: apiSetServerWeight, cmd: fmt.Sprintf("%s weight %s", cmdPrefix, s.updatedWeight), rollback: []*transactionCommand{
+			{api: apiSetServerWeight, cmd: fmt.Sprintf("%s weight %d", cmdPrefix, s.CurrentWeight)},
+		}})
 	}
 
 	if s.updatedState != "" {
-		cmd := fmt.Sprintf("%s state %s", cmdPrefix, s.updatedState)
-		commands = append(commands, cmd)
-	}
-
-	// Execute all the commands.
-	for _, cmd := range commands {
-		if err := s.executeCommand(cmd, client); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// executeCommand runs a server change command and handles the response.
-func (s *backendServer) executeCommand(cmd string, client HAProxyClient) error {
-	responseBytes, err := client.Execute(cmd)
-	if err != nil {
-		return err
-	}
-
-	response := strings.TrimSpace(string(responseBytes))
-	if len(response) == 0 {
-		return nil
+		tx.add(&transactionCommand{api: apiSetServerState, cmd: fmt.Sprintf("%s state %s", cmdPrefix, s.updatedState), rollback: []*transactionCommand{
+			{api: apiSetServerState, cmd: fmt.Sprintf("%s state %s", cmdPrefix, s.state())},
+		}})
 	}
-
-	okPrefixes := []string{"IP changed from", "no need to change"}
-	for _, prefix := range okPrefixes {
-		if strings.HasPrefix(response, prefix) {
-			return nil
-		}
-	}
-
-	return fmt.Errorf("setting server info with %s : %s", cmd, response)
 }
 
 // stripVersionNumber strips off the first line if it is a version number.
@@ -562,6 +677,7 @@ const (
 	apiSetServerAddr
 	apiSetServerWeight
 	apiSetServerState
+	apiSetDynamicCookie
 )
 
 func execCommand(client HAProxyClient, api apiType, cmd string) error {
@@ -569,7 +685,12 @@ func execCommand(client HAProxyClient, api apiType, cmd string) error {
 	if err != nil {
 		return err
 	}
-	response := strings.TrimSpace(string(responseRaw))
+	return validateResponse(api, string(responseRaw))
+}
+
+// validateResponse checks the response of a dynamic config API command.
+func validateResponse(api apiType, response string) error {
+	response = strings.TrimSpace(response)
 	if len(response) == 0 {
 		return nil
 	}
@@ -582,7 +703,7 @@ func execCommand(client HAProxyClient, api apiType, cmd string) error {
 		valid = response == "Server deleted."
 	case apiSetServerAddr:
 		valid = response == "nothing changed" || strings.HasPrefix(response, "IP changed from ") || strings.HasPrefix(response, "port changed from ") || strings.HasPrefix(response, "no need to change ")
-	case apiSetHealth, apiSetServerWeight, apiSetServerState:
+	case apiSetHealth, apiSetServerWeight, apiSetServerState, apiSetDynamicCookie:
 		valid = false // any response from these api calls mean there is a failure
 	default:
 		// fail fast in case of a dev error
@@ -63,6 +63,10 @@ func (cm *fakeConfigManager) RemoveRouteEndpoints(id templaterouter.ServiceAlias
 func (cm *fakeConfigManager) Notify(event templaterouter.RouterEventType) {
 }
 
+func (cm *fakeConfigManager) Commit() error {
+	return nil
+}
+
 func routeKey(route *routev1.Route) templaterouter.ServiceAliasConfigKey {
 	return templaterouter.ServiceAliasConfigKey(fmt.Sprintf("%s:%s", route.Name, route.Namespace))
 }
@@ -1,7 +1,9 @@
 package haproxy
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	templaterouter "github.com/openshift/router/pkg/router/template"
 	haproxytesting "github.com/openshift/router/pkg/router/template/configmanager/haproxy/testing"
@@ -78,6 +80,48 @@ func TestClientRunCommand(t *testing.T) {
 	}
 }
 
+// TestClientRunCommandReload tests client command execution while haproxy reloads.
+func TestClientRunCommandReload(t *testing.T) {
+	testCases := []struct {
+		name            string
+		downtime        time.Duration
+		failureExpected bool
+	}{
+		{
+			name:            "command retried during the reload",
+			downtime:        5 * time.Millisecond,
+			failureExpected: false,
+		},
+		{
+			name:            "reload longer than the retries",
+			downtime:        5 * time.Second,
+			failureExpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		server := haproxytesting.StartFakeServerForTest(t)
+		client := NewClient(server.SocketFile(), 1)
+
+		server.Reload(tc.downtime)
+		response, err := client.RunCommand("show info", nil)
+		if tc.failureExpected && err == nil {
+			t.Errorf("TestClientRunCommandReload test case %s expected a failure but got none, response=%s",
+				tc.name, string(response))
+		}
+		if !tc.failureExpected {
+			if err != nil {
+				t.Errorf("TestClientRunCommandReload test case %s expected no failure but got one: %v", tc.name, err)
+			}
+			if !strings.Contains(string(response), "Pid: 85\n") {
+				t.Errorf("TestClientRunCommandReload test case %s expected a response from the new process, response=%s",
+					tc.name, string(response))
+			}
+		}
+		server.Stop()
+	}
+}
+
 // TestClientRunInfoCommandConverter tests client show info command execution with a converter.
 func TestClientRunInfoCommandConverter(t *testing.T) {
 	testCases := []struct {
@@ -12,12 +12,14 @@ import (
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilwait "k8s.io/apimachinery/pkg/util/wait"
 
 	routev1 "github.com/openshift/api/route/v1"
 
 	"github.com/openshift/router/pkg/router/routeapihelpers"
 	templaterouter "github.com/openshift/router/pkg/router/template"
 	templateutil "github.com/openshift/router/pkg/router/template/util"
+	"github.com/openshift/router/pkg/router/template/util/tlsprofile"
 
 	logf "github.com/openshift/router/log"
 )
@@ -96,6 +98,14 @@ type haproxyConfigManager struct {
 	// dynamic configuration API.
 	commitInterval time.Duration
 
+	// reconcileInterval controls how often the running haproxy state is
+	// verified against the router state. Zero disables it.
+	reconcileInterval time.Duration
+
+	// endpointsOnly restricts the manager to endpoint changes on the
+	// existing backends, route changes always require a reload.
+	endpointsOnly bool
+
 	// blueprintRoutes are the blueprint routes used for pre-allocation.
 	blueprintRoutes []*routev1.Route
 
@@ -137,6 +147,21 @@ type haproxyConfigManager struct {
 	// corresponding routes.
 	poolUsage map[templaterouter.ServiceAliasConfigKey]templaterouter.ServiceAliasConfigKey
 
+	// generation is incremented on every change made or notified to the
+	// config manager, used to detect changes while reconciling.
+	generation uint64
+
+	// tx is the transaction holding the backend changes made since the
+	// last commit, nil if there are none.
+	tx *Transaction
+
+	// txBackends are the backends changed within tx.
+	txBackends map[templaterouter.ServiceAliasConfigKey]*Backend
+
+	// stagedMaps are the haproxy maps with changes staged since the last
+	// commit, by name.
+	stagedMaps map[string]*HAProxyMap
+
 	// lock is a mutex used to prevent concurrent config changes.
 	lock sync.Mutex
 
@@ -149,11 +174,21 @@ func NewHAProxyConfigManager(options templaterouter.ConfigManagerOptions) *hapro
 	client := NewClient(options.ConnectionInfo, haproxyConnectionTimeout)
 
 	log.V(4).Info("creating new manager", "manager", haproxyManagerName, "options", options)
+	registerTransactionMetrics()
+
+	// Without dynamic route changes, there is no use for the pre-allocated
+	// blueprint route pools.
+	var blueprintRoutes []*routev1.Route
+	if !options.DynamicEndpointsOnly {
+		blueprintRoutes = buildBlueprintRoutes(options.BlueprintRoutes, options.ExtendedValidation)
+	}
 
 	return &haproxyConfigManager{
 		connectionInfo:         options.ConnectionInfo,
 		commitInterval:         options.CommitInterval,
-		blueprintRoutes:        buildBlueprintRoutes(options.BlueprintRoutes, options.ExtendedValidation),
+		reconcileInterval:      options.ReconcileInterval,
+		endpointsOnly:          options.DynamicEndpointsOnly,
+		blueprintRoutes:        blueprintRoutes,
 		blueprintRoutePoolSize: options.BlueprintRoutePoolSize,
 		wildcardRoutesAllowed:  options.WildcardRoutesAllowed,
 		extendedValidation:     options.ExtendedValidation,
@@ -191,11 +226,25 @@ func (cm *haproxyConfigManager) Initialize(router templaterouter.RouterInterface
 		cm.provisionRoutePool(r)
 	}
 
-	log.V(2).Info("haproxy Config Manager router will flush out any dynamically configured changes within some interval of each other", "interval", cm.commitInterval.String())
+	if cm.endpointsOnly {
+		log.V(2).Info("haproxy Config Manager will only dynamically configure endpoint changes, route changes will reload the router")
+	} else {
+		log.V(2).Info("haproxy Config Manager router will flush out any dynamically configured changes within some interval of each other", "interval", cm.commitInterval.String())
+	}
+
+	if cm.reconcileInterval > 0 {
+		registerReconcileMetrics()
+		log.V(2).Info("haproxy Config Manager will reconcile dynamically configured changes", "interval", cm.reconcileInterval.String())
+		go utilwait.Until(cm.reconcile, cm.reconcileInterval, utilwait.NeverStop)
+	}
 }
 
 // AddBlueprint adds a new (or replaces an existing) route blueprint.
 func (cm *haproxyConfigManager) AddBlueprint(route *routev1.Route) error {
+	if cm.endpointsOnly {
+		return fmt.Errorf("blueprint route %s/%s ignored, only endpoint changes are dynamically configured", route.Namespace, route.Name)
+	}
+
 	newRoute := route.DeepCopy()
 	newRoute.Namespace = blueprintRoutePoolNamespace
 	newRoute.Spec.Host = ""
@@ -291,6 +340,7 @@ func (cm *haproxyConfigManager) Register(id templaterouter.ServiceAliasConfigKey
 
 	entry.BuildMapAssociations(route)
 	cm.backendEntries[id] = entry
+	cm.generation++
 }
 
 // AddRoute adds a new route or updates an existing route.
@@ -299,6 +349,10 @@ func (cm *haproxyConfigManager) AddRoute(id templaterouter.ServiceAliasConfigKey
 		return fmt.Errorf("Router reload in progress, cannot dynamically add route %s", id)
 	}
 
+	if cm.endpointsOnly {
+		return fmt.Errorf("only endpoint changes are dynamically configured, cannot dynamically add route %s", id)
+	}
+
 	log.V(4).Info("adding route", "id", id)
 
 	if cm.isManagedPoolRoute(route) {
@@ -316,6 +370,8 @@ func (cm *haproxyConfigManager) AddRoute(id templaterouter.ServiceAliasConfigKey
 		cm.scheduleRouterReload()
 	}()
 
+	cm.generation++
+
 	slotName, err := cm.findFreeBackendPoolSlot(matchedBlueprint)
 	if err != nil {
 		return fmt.Errorf("finding free backend pool slot for route %s: %v", id, err)
@@ -347,6 +403,10 @@ func (cm *haproxyConfigManager) AddRoute(id templaterouter.ServiceAliasConfigKey
 		return err
 	}
 
+	if err := cm.beginBackend(backend); err != nil {
+		return err
+	}
+
 	log.V(4).Info("setting routing key", "name", backendName)
 	if err := backend.SetRoutingKey(routingKey); err != nil {
 		return err
@@ -363,6 +423,10 @@ func (cm *haproxyConfigManager) RemoveRoute(id templaterouter.ServiceAliasConfig
 		return fmt.Errorf("Router reload in progress, cannot dynamically remove route id %s", id)
 	}
 
+	if cm.endpointsOnly {
+		return fmt.Errorf("only endpoint changes are dynamically configured, cannot dynamically remove route id %s", id)
+	}
+
 	if cm.isManagedPoolRoute(route) {
 		return fmt.Errorf("managed pool blueprint route %s ignored", id)
 	}
@@ -373,6 +437,8 @@ func (cm *haproxyConfigManager) RemoveRoute(id templaterouter.ServiceAliasConfig
 		cm.scheduleRouterReload()
 	}()
 
+	cm.generation++
+
 	entry, ok := cm.backendEntries[id]
 	if !ok {
 		// Not registered - return error back.
@@ -402,6 +468,10 @@ func (cm *haproxyConfigManager) RemoveRoute(id templaterouter.ServiceAliasConfig
 		return err
 	}
 
+	if err := cm.beginBackend(backend); err != nil {
+		return err
+	}
+
 	log.V(4).Info("deleting all servers for backend", "backend", backendName)
 	servers, err := backend.Servers()
 	if err != nil {
@@ -413,8 +483,7 @@ func (cm *haproxyConfigManager) RemoveRoute(id templaterouter.ServiceAliasConfig
 		}
 	}
 
-	log.V(4).Info("committing changes made to backend", "backend", backendName)
-	return backend.Commit()
+	return nil
 }
 
 // ReplaceRouteEndpoints dynamically replaces a subset of the endpoints for
@@ -434,6 +503,8 @@ func (cm *haproxyConfigManager) ReplaceRouteEndpoints(id templaterouter.ServiceA
 		}
 	}()
 
+	cm.generation++
+
 	entry, ok := cm.backendEntries[id]
 	if !ok {
 		// Not registered - return error back.
@@ -483,8 +554,12 @@ func (cm *haproxyConfigManager) ReplaceRouteEndpoints(id templaterouter.ServiceA
 
 	log.V(4).Info("processing endpoint changes", "added", addedEndpoints, "deleted", deletedEndpoints, "modified", modifiedEndpoints)
 
-	// Aggregating errors instead of failing fast in the first API error. This ensures that the old
-	// process has a more accurate configuration in case it lives longer due to persistent connections.
+	// All the changes are sent to haproxy in a single transaction on commit, which is rolled back
+	// if any of them fails so the backends are never left half-updated.
+	if err := cm.beginBackend(backend); err != nil {
+		return err
+	}
+
 	var errs []error
 
 	for name, ep := range deletedEndpoints {
@@ -549,6 +624,8 @@ func (cm *haproxyConfigManager) RemoveRouteEndpoints(id templaterouter.ServiceAl
 		cm.scheduleRouterReload()
 	}()
 
+	cm.generation++
+
 	entry, ok := cm.backendEntries[id]
 	if !ok {
 		// Not registered - return error back.
@@ -562,6 +639,10 @@ func (cm *haproxyConfigManager) RemoveRouteEndpoints(id templaterouter.ServiceAl
 		return err
 	}
 
+	if err := cm.beginBackend(backend); err != nil {
+		return err
+	}
+
 	var errs []error
 	for _, ep := range endpoints {
 		log.V(4).Info("deleting server for endpoint", "endpoint", ep.ID)
@@ -573,6 +654,15 @@ func (cm *haproxyConfigManager) RemoveRouteEndpoints(id templaterouter.ServiceAl
 	return errors.Join(errs...)
 }
 
+// PoolBackendRoute returns the id of the route served by a pool backend.
+func (cm *haproxyConfigManager) PoolBackendRoute(backend templaterouter.ServiceAliasConfigKey) (templaterouter.ServiceAliasConfigKey, bool) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	id, ok := cm.poolUsage[backend]
+	return id, ok
+}
+
 // Notify informs the config manager of any template router state changes.
 // We only care about the reload specific events.
 func (cm *haproxyConfigManager) Notify(event templaterouter.RouterEventType) {
@@ -581,6 +671,7 @@ func (cm *haproxyConfigManager) Notify(event templaterouter.RouterEventType) {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
 
+	cm.generation++
 	switch event {
 	case templaterouter.RouterEventReloadStart:
 		cm.reloadInProgress = true
@@ -592,17 +683,80 @@ func (cm *haproxyConfigManager) Notify(event templaterouter.RouterEventType) {
 	}
 }
 
-// Commit commits the configuration and reloads the associated router.
-func (cm *haproxyConfigManager) Commit() {
-	log.V(4).Info("committing dynamic config manager changes")
-	cm.commitRouterConfig()
+// Commit sends the backend changes made since the last commit to haproxy
+// in a single transaction, which is rolled back if any of them fails. Then
+// it replaces each changed haproxy map once, with a single map version.
+func (cm *haproxyConfigManager) Commit() error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	if cm.tx == nil && len(cm.stagedMaps) == 0 {
+		return nil
+	}
+	if cm.reloadInProgress {
+		// The changes are either written out by the reload or, if it
+		// fails, committed next time.
+		return nil
+	}
+
+	log.V(4).Info("committing dynamic config manager changes", "backends", len(cm.txBackends), "maps", len(cm.stagedMaps))
+	tx, maps := cm.tx, cm.stagedMaps
+	for _, backend := range cm.txBackends {
+		backend.stageChanges()
+		backend.Reset()
+	}
+	cm.tx = nil
+	cm.txBackends = nil
+	cm.stagedMaps = nil
+
+	// The servers are updated first, so the routes mapped to the
+	// backends are served once the maps are replaced.
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			for _, ham := range maps {
+				ham.abort()
+			}
+			return err
+		}
+	}
+
+	var errs []error
+	for name, ham := range maps {
+		if err := ham.Commit(); err != nil {
+			errs = append(errs, fmt.Errorf("committing map %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// beginBackend adds the changes made to a backend until the next commit to
+// the pending transaction. Must be called while holding cm.lock.
+func (cm *haproxyConfigManager) beginBackend(backend *Backend) error {
+	if _, ok := cm.txBackends[backend.Name()]; ok {
+		return nil
+	}
+	if cm.tx == nil {
+		cm.tx = newTransaction(cm.client)
+		cm.txBackends = make(map[templaterouter.ServiceAliasConfigKey]*Backend)
+	}
+	if err := backend.beginTransaction(cm.tx); err != nil {
+		return err
+	}
+
+	cm.txBackends[backend.Name()] = backend
+	return nil
 }
 
 // scheduleRouterReload schedules a reload by deferring commit on the
-// associated template router using a internal flush timer.
+// associated template router using a internal flush timer. Endpoint
+// changes alone don't need to be flushed out: the router state already
+// holds them and they get written out with the next route change.
 func (cm *haproxyConfigManager) scheduleRouterReload() {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
+	if cm.endpointsOnly {
+		return
+	}
 	if cm.commitTimer == nil {
 		cm.commitTimer = time.AfterFunc(cm.commitInterval, cm.commitRouterConfig)
 	}
@@ -665,7 +819,12 @@ func (cm *haproxyConfigManager) removeRoutePool(blueprint *routev1.Route) {
 	}
 }
 
-// processMapAssociations processes all the map associations for a backend.
+// processMapAssociations stages all the map associations for a backend,
+// applied on commit. Must be called while holding cm.lock.
+//
+// Only AddRoute and RemoveRoute stage map changes, and the template router
+// does not call them while dynamicallyAddRoute and dynamicallyRemoveRoute
+// are disabled, so the route changes are applied by a reload meanwhile.
 func (cm *haproxyConfigManager) processMapAssociations(associations haproxyMapAssociation, add bool) error {
 	log.V(4).Info("processing map associations", "associations", associations)
 
@@ -677,10 +836,12 @@ func (cm *haproxyConfigManager) processMapAssociations(associations haproxyMapAs
 	for _, ham := range haproxyMaps {
 		name := path.Base(ham.Name())
 		if entries, ok := associations[name]; ok {
-			log.V(4).Info("applying to map", "name", name, "entries", entries)
-			if err := ham.SyncEntries(entries, add); err != nil {
-				return err
+			log.V(4).Info("staging map entries", "name", name, "entries", entries)
+			ham.StageEntries(entries, add)
+			if cm.stagedMaps == nil {
+				cm.stagedMaps = make(map[string]*HAProxyMap)
 			}
+			cm.stagedMaps[ham.Name()] = ham
 		}
 	}
 
@@ -730,6 +891,11 @@ func (cm *haproxyConfigManager) reset() {
 		entry.poolRouteBackendName = ""
 	}
 
+	// Drop the pending changes, written out by the reload.
+	cm.tx = nil
+	cm.txBackends = nil
+	cm.stagedMaps = nil
+
 	// Reset the client - clear its caches.
 	cm.client.Reset()
 }
@@ -990,6 +1156,8 @@ func modAnnotationsList(termination routev1.TLSTerminationType) []string {
 		"haproxy.router.openshift.io/rate-limit-connections.rate-http",
 		"haproxy.router.openshift.io/pod-concurrent-connections",
 		"router.openshift.io/haproxy.health.check.interval",
+		"haproxy.router.openshift.io/access-log",
+		"haproxy.router.openshift.io/access-log-sample-percent",
 	}
 
 	if termination == routev1.TLSTerminationPassthrough {
@@ -1001,5 +1169,8 @@ func modAnnotationsList(termination routev1.TLSTerminationType) []string {
 	annotations = append(annotations, "haproxy.router.openshift.io/hsts_header")
 	annotations = append(annotations, "haproxy.router.openshift.io/rewrite-target")
 	annotations = append(annotations, "router.openshift.io/cookie-same-site")
+	annotations = append(annotations, "haproxy.router.openshift.io/access-log-errors-only")
+	annotations = append(annotations, "haproxy.router.openshift.io/tracing-sampling-ratio")
+	annotations = append(annotations, tlsprofile.Annotations...)
 	return annotations
 }
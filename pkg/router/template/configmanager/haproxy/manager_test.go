@@ -0,0 +1,240 @@
+package haproxy
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	templaterouter "github.com/openshift/router/pkg/router/template"
+	haproxytesting "github.com/openshift/router/pkg/router/template/configmanager/haproxy/testing"
+)
+
+// TestDynamicEndpointsOnly tests that a config manager restricted to
+// endpoint changes applies them without blueprints nor reloads.
+func TestDynamicEndpointsOnly(t *testing.T) {
+	const backendName = "be_http:ns:web"
+
+	server := haproxytesting.StartFakeServerForTest(t)
+	defer server.Stop()
+
+	server.AddBackend(backendName, haproxytesting.ServerState{Name: "ep1", Address: "10.0.0.1", Port: 8080, Weight: 1, InitialWeight: 1, HealthCheck: true, Up: true})
+
+	cm := NewHAProxyConfigManager(templaterouter.ConfigManagerOptions{
+		ConnectionInfo:       "unix://" + server.SocketFile(),
+		DynamicEndpointsOnly: true,
+	})
+	require.Empty(t, cm.blueprintRoutes)
+
+	// No blueprint route pool is provisioned, the fake router would panic.
+	cm.Initialize(&fakeDesiredStateRouter{}, "")
+
+	route := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "web"}}
+	cfg := &templaterouter.ServiceAliasConfig{Namespace: "ns"}
+	cm.Register("ns:web", cfg, route)
+
+	require.Error(t, cm.AddRoute("ns:web", "key", route))
+	require.Error(t, cm.RemoveRoute("ns:web", route))
+
+	oldEndpoints := []templaterouter.Endpoint{{ID: "ep1", IP: "10.0.0.1", Port: "8080"}}
+	newEndpoints := []templaterouter.Endpoint{
+		{ID: "ep1", IP: "10.0.0.3", Port: "8080"},
+		{ID: "ep2", IP: "10.0.0.2", Port: "8080"},
+	}
+	require.NoError(t, cm.ReplaceRouteEndpoints("ns:web", &templaterouter.ServiceUnit{}, oldEndpoints, newEndpoints, 1))
+
+	// The changes are only applied on commit.
+	ep1, found := server.Server(backendName, "ep1")
+	require.True(t, found)
+	assert.Equal(t, "10.0.0.1", ep1.Address)
+	require.NoError(t, cm.Commit())
+
+	ep1, found = server.Server(backendName, "ep1")
+	require.True(t, found)
+	assert.Equal(t, "10.0.0.3", ep1.Address)
+	ep2, found := server.Server(backendName, "ep2")
+	require.True(t, found)
+	assert.False(t, ep2.Maintenance)
+
+	require.NoError(t, cm.RemoveRouteEndpoints("ns:web", newEndpoints[1:]))
+	require.NoError(t, cm.Commit())
+	_, found = server.Server(backendName, "ep2")
+	assert.False(t, found)
+
+	assert.Nil(t, cm.commitTimer, "endpoint changes must not schedule a reload")
+}
+
+// TestCommitTransaction tests that the changes made to several backends
+// until a commit are applied together, and rolled back together.
+func TestCommitTransaction(t *testing.T) {
+	const (
+		webBackend = "be_http:ns:web"
+		apiBackend = "be_http:ns:api"
+	)
+
+	testCases := map[string]struct {
+		failCommand    string
+		deleteServer   bool
+		errExpected    bool
+		reloadRequired bool
+	}{
+		"changes are applied": {
+			deleteServer: true,
+		},
+		"changes of all the backends are rolled back": {
+			failCommand: "set server " + apiBackend + "/api2 state",
+			errExpected: true,
+		},
+		"irreversible changes require a reload": {
+			failCommand:    "set server " + apiBackend + "/api2 state",
+			deleteServer:   true,
+			errExpected:    true,
+			reloadRequired: true,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := haproxytesting.StartFakeServerForTest(t)
+			defer server.Stop()
+
+			server.AddBackend(webBackend, haproxytesting.ServerState{Name: "web1", Address: "10.0.0.1", Port: 8080, Weight: 1, InitialWeight: 1, Up: true})
+			server.AddBackend(apiBackend,
+				haproxytesting.ServerState{Name: "api1", Address: "10.0.1.1", Port: 8080, Weight: 1, InitialWeight: 1, HealthCheck: true, Up: true},
+				haproxytesting.ServerState{Name: "api3", Address: "10.0.1.3", Port: 8080, Weight: 1, InitialWeight: 1, HealthCheck: true, Up: true})
+			if len(test.failCommand) > 0 {
+				server.FailCommand(test.failCommand, "No such server.", 1)
+			}
+			initialWeb, initialAPI := server.Servers(webBackend), server.Servers(apiBackend)
+
+			cm := NewHAProxyConfigManager(templaterouter.ConfigManagerOptions{
+				ConnectionInfo:       "unix://" + server.SocketFile(),
+				DynamicEndpointsOnly: true,
+			})
+			cm.Initialize(&fakeDesiredStateRouter{}, "")
+			for _, name := range []string{"web", "api"} {
+				route := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name}}
+				cm.Register(templaterouter.ServiceAliasConfigKey("ns:"+name), &templaterouter.ServiceAliasConfig{Namespace: "ns"}, route)
+			}
+
+			svc := &templaterouter.ServiceUnit{}
+			webEndpoints := []templaterouter.Endpoint{{ID: "web1", IP: "10.0.0.1", Port: "8080"}}
+			require.NoError(t, cm.ReplaceRouteEndpoints("ns:web", svc, webEndpoints, []templaterouter.Endpoint{{ID: "web1", IP: "10.0.0.2", Port: "8080"}}, 1))
+			apiEndpoints := []templaterouter.Endpoint{{ID: "api1", IP: "10.0.1.1", Port: "8080"}, {ID: "api3", IP: "10.0.1.3", Port: "8080"}}
+			require.NoError(t, cm.ReplaceRouteEndpoints("ns:api", svc, apiEndpoints, append(apiEndpoints, templaterouter.Endpoint{ID: "api2", IP: "10.0.1.2", Port: "8080"}), 1))
+			if test.deleteServer {
+				require.NoError(t, cm.RemoveRouteEndpoints("ns:api", apiEndpoints[:1]))
+			}
+
+			err := cm.Commit()
+			if !test.errExpected {
+				require.NoError(t, err)
+				web1, found := server.Server(webBackend, "web1")
+				require.True(t, found)
+				assert.Equal(t, "10.0.0.2", web1.Address)
+				_, found = server.Server(apiBackend, "api1")
+				assert.False(t, found)
+				_, found = server.Server(apiBackend, "api2")
+				assert.True(t, found)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Equal(t, test.reloadRequired, errors.Is(err, errReloadRequired))
+			if !test.reloadRequired {
+				assert.Equal(t, initialWeb, server.Servers(webBackend))
+				assert.Equal(t, initialAPI, server.Servers(apiBackend))
+			}
+
+			// The failed changes are dropped.
+			require.NoError(t, cm.Commit())
+		})
+	}
+}
+
+// TestCommitMapChanges tests that the map changes of the routes added and
+// removed until a commit are applied with a single version of each map.
+func TestCommitMapChanges(t *testing.T) {
+	const httpMap = "/var/lib/haproxy/conf/os_http_be.map"
+
+	server := haproxytesting.StartFakeServerForTest(t)
+	defer server.Stop()
+
+	cm := NewHAProxyConfigManager(templaterouter.ConfigManagerOptions{
+		ConnectionInfo:         "unix://" + server.SocketFile(),
+		CommitInterval:         time.Hour,
+		BlueprintRoutePoolSize: 3,
+	})
+	cm.router = &fakeDesiredStateRouter{}
+
+	routes := map[templaterouter.ServiceAliasConfigKey]*routev1.Route{}
+	for _, name := range []string{"web", "api"} {
+		route := &routev1.Route{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name},
+			Spec: routev1.RouteSpec{
+				Host: name + ".example.com",
+				TLS:  &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge, InsecureEdgeTerminationPolicy: routev1.InsecureEdgeTerminationPolicyAllow},
+			},
+		}
+		id := templaterouter.ServiceAliasConfigKey("ns:" + name)
+		routes[id] = route
+		cm.Register(id, &templaterouter.ServiceAliasConfig{Namespace: "ns", Host: route.Spec.Host, TLSTermination: routev1.TLSTerminationEdge}, route)
+		require.NoError(t, cm.AddRoute(id, "key", route))
+	}
+
+	// countPrepared returns the number of versions prepared by map since
+	// the first command.
+	countPrepared := func(first int) map[string]int {
+		prepared := map[string]int{}
+		for _, cmd := range server.Commands()[first:] {
+			if name, ok := strings.CutPrefix(cmd, "prepare map "); ok {
+				prepared[path.Base(name)]++
+			}
+		}
+		return prepared
+	}
+	// countRoutes returns the number of routes of the http map.
+	countRoutes := func() int {
+		count := 0
+		for _, line := range server.ReadMapContent(httpMap) {
+			if strings.Contains(line, `\.example\.com`) {
+				count++
+			}
+		}
+		return count
+	}
+	assert.Empty(t, countPrepared(0), "map changes must only be applied on commit")
+	assert.Equal(t, 0, countRoutes())
+
+	require.NoError(t, cm.Commit())
+	prepared := countPrepared(0)
+	assert.Equal(t, 1, prepared["os_http_be.map"])
+	assert.Equal(t, 1, prepared["os_edge_reencrypt_be.map"])
+	for name, count := range prepared {
+		assert.Equal(t, 1, count, "map %s must be replaced once", name)
+	}
+	assert.Equal(t, 2, countRoutes())
+
+	first := len(server.Commands())
+	require.NoError(t, cm.RemoveRoute("ns:web", routes["ns:web"]))
+	require.NoError(t, cm.RemoveRoute("ns:api", routes["ns:api"]))
+	assert.Empty(t, countPrepared(first), "map changes must only be applied on commit")
+	assert.Equal(t, 2, countRoutes())
+
+	require.NoError(t, cm.Commit())
+	prepared = countPrepared(first)
+	assert.Equal(t, 1, prepared["os_http_be.map"])
+	assert.Equal(t, 1, prepared["os_edge_reencrypt_be.map"])
+	for name, count := range prepared {
+		assert.Equal(t, 1, count, "map %s must be replaced once", name)
+	}
+	assert.Equal(t, 0, countRoutes())
+}
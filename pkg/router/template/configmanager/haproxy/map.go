@@ -55,6 +55,16 @@ type HAProxyMap struct {
 
 	// dirty indicates the state of the map.
 	dirty bool
+
+	// pending are the changes to apply to the map on commit.
+	pending []mapChange
+}
+
+// mapChange is a set of entries to add to (or update in) or to remove from
+// an haproxy map.
+type mapChange struct {
+	entries configEntryMap
+	add     bool
 }
 
 // buildHAProxyMaps builds and returns a list of haproxy maps.
@@ -102,11 +112,64 @@ func (m *HAProxyMap) Refresh() error {
 	return nil
 }
 
-// Commit commits all the pending changes made to this haproxy map.
-// We do map changes "in-band" as that's handled dynamically by haproxy.
+// Commit commits all the pending changes made to this haproxy map. All
+// the changes are applied at once, atomically replacing the map content.
 func (m *HAProxyMap) Commit() error {
-	// noop
-	return nil
+	if len(m.pending) == 0 {
+		return nil
+	}
+
+	pending := m.pending
+	m.pending = nil
+
+	if m.dirty {
+		if err := m.Refresh(); err != nil {
+			return err
+		}
+	}
+
+	// m.entries[].(id;name(key);value) is a slice with the current state,
+	// the pending changes are merged on top of it in order.
+	type mapLine struct {
+		key, value string
+	}
+	lines := make([]mapLine, 0, len(m.entries))
+	for _, entry := range m.entries {
+		lines = append(lines, mapLine{key: entry.Name, value: entry.Value})
+	}
+	for _, change := range pending {
+		var merged []mapLine
+		added := sets.NewString()
+		for _, line := range lines {
+			if value, found := change.entries[line.key]; found {
+				if !change.add {
+					// if removing, remove from the final output
+					continue
+				}
+				// if adding, use the new content and mark as already added
+				line.value = string(value)
+				added.Insert(line.key)
+			}
+			merged = append(merged, line)
+		}
+		if change.add {
+			for k, v := range change.entries {
+				if !added.Has(k) {
+					merged = append(merged, mapLine{key: k, value: string(v)})
+				}
+			}
+		}
+		lines = merged
+	}
+
+	content := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line.value != "" {
+			content = append(content, line.key+" "+line.value)
+		}
+	}
+
+	return m.replace(content)
 }
 
 // Name returns the name of this map.
@@ -138,46 +201,26 @@ func (m *HAProxyMap) Find(k string) ([]HAProxyMapEntry, error) {
 	return found, nil
 }
 
+// StageEntries adds a change made in a route resource (newEntries) to the
+// pending changes of this haproxy map, applied on Commit.
+func (m *HAProxyMap) StageEntries(newEntries configEntryMap, add bool) {
+	m.pending = append(m.pending, mapChange{entries: newEntries, add: add})
+}
+
+// abort drops the pending changes of this haproxy map.
+func (m *HAProxyMap) abort() {
+	m.pending = nil
+}
+
 // SyncEntries merges current content from a HAProxy map, and changes applied in a route resource (newEntries).
 // The new content is applied atomically, and in the correct order to avoid wrong match in case of path overlap.
 func (m *HAProxyMap) SyncEntries(newEntries configEntryMap, add bool) error {
-	if m.dirty {
-		if err := m.Refresh(); err != nil {
-			return err
-		}
-	}
-
-	// m.entries[].(id;name(key);value) is a slice with the current state,
-	// newEntries[k]v is a hashmap with the entries to be added/replaced or removed.
-	// merge them together, based on `add` flag, and store the result on `lines[]`.
-
-	var lines []string
-	added := sets.NewString()
-	for _, entry := range m.entries {
-		currentValue := entry.Value
-		if value, found := newEntries[entry.Name]; found {
-			if add {
-				// if adding, use the new content from the newEntries and mark as already added
-				currentValue = string(value)
-				// flag instead of delete() so we preserve the hashmap content
-				added.Insert(entry.Name)
-			} else {
-				// if removing, remove from the final output
-				currentValue = ""
-			}
-		}
-		if currentValue != "" {
-			lines = append(lines, entry.Name+" "+currentValue)
-		}
-	}
-	if add {
-		for k, v := range newEntries {
-			if !added.Has(k) {
-				lines = append(lines, k+" "+string(v))
-			}
-		}
-	}
+	m.StageEntries(newEntries, add)
+	return m.Commit()
+}
 
+// replace atomically replaces the content of this haproxy map.
+func (m *HAProxyMap) replace(lines []string) error {
 	// Sort entries to avoid wrong match, see https://issues.redhat.com/browse/OCPBUGS-75009
 	lines = templateutil.SortMapPaths(lines, `^[^\.]*\.`)
 
@@ -528,3 +528,111 @@ func TestHAProxyMapSyncEntries(t *testing.T) {
 		})
 	}
 }
+
+// TestHAProxyMapStageEntries tests that the staged changes of a haproxy map
+// are applied as a single new map version on commit.
+func TestHAProxyMapStageEntries(t *testing.T) {
+	server := haproxytesting.StartFakeServerForTest(t)
+	defer server.Stop()
+
+	type stagedChange struct {
+		entries configEntryMap
+		add     bool
+	}
+
+	testCases := []struct {
+		name            string
+		currentEntries  []string
+		changes         []stagedChange
+		expectedEntries []string
+		expectedVersion int
+	}{
+		{
+			name:           "no changes",
+			currentEntries: []string{"1 k v"},
+			expectedEntries: []string{
+				"1 k v",
+			},
+		},
+		{
+			name:           "add and remove",
+			currentEntries: []string{"1 k1 v1", "2 k2 v2"},
+			changes: []stagedChange{
+				{entries: configEntryMap{"k1": "v1"}, add: false},
+				{entries: configEntryMap{"k3": "v3"}, add: true},
+			},
+			expectedEntries: []string{"1 k3 v3", "2 k2 v2"},
+			expectedVersion: 1,
+		},
+		{
+			name:           "changes are applied in order",
+			currentEntries: []string{"1 k v1"},
+			changes: []stagedChange{
+				{entries: configEntryMap{"k": "v1"}, add: false},
+				{entries: configEntryMap{"k": "v2"}, add: true},
+				{entries: configEntryMap{"k": "v3"}, add: true},
+			},
+			expectedEntries: []string{"1 k v3"},
+			expectedVersion: 1,
+		},
+		{
+			name:           "later removal wins",
+			currentEntries: []string{"1 k1 v1"},
+			changes: []stagedChange{
+				{entries: configEntryMap{"k2": "v2"}, add: true},
+				{entries: configEntryMap{"k2": "v2"}, add: false},
+			},
+			expectedEntries: []string{"1 k1 v1"},
+			expectedVersion: 1,
+		},
+	}
+
+	const customMapName = "custom.map"
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewClient(server.SocketFile(), 0)
+
+			// Ensure server is in clean state for test.
+			server.Reset()
+			server.SetCustomMap(customMapName, tc.currentEntries)
+
+			m := newHAProxyMap(customMapName, client)
+			for _, change := range tc.changes {
+				m.StageEntries(change.entries, change.add)
+			}
+			// Nothing is sent to haproxy before the commit.
+			require.Empty(t, server.Commands())
+
+			require.NoError(t, m.Commit())
+			require.Equal(t, tc.expectedEntries, server.ReadMapContent(customMapName))
+			require.Equal(t, tc.expectedVersion, server.MapVersion(customMapName))
+
+			// The staged changes are cleared once committed.
+			commands := len(server.Commands())
+			require.NoError(t, m.Commit())
+			require.Len(t, server.Commands(), commands)
+		})
+	}
+}
+
+// TestHAProxyMapCommitFailure tests that a haproxy map is left unchanged
+// when the new version fails to commit.
+func TestHAProxyMapCommitFailure(t *testing.T) {
+	server := haproxytesting.StartFakeServerForTest(t)
+	defer server.Stop()
+
+	const customMapName = "custom.map"
+	server.SetCustomMap(customMapName, []string{"1 k1 v1"})
+	server.FailCommand("commit map", "Unknown version specified.", 1)
+
+	m := newHAProxyMap(customMapName, NewClient(server.SocketFile(), 0))
+	m.StageEntries(configEntryMap{"k2": "v2"}, true)
+	require.Error(t, m.Commit())
+	require.Equal(t, []string{"1 k1 v1"}, server.ReadMapContent(customMapName))
+
+	// The next commit prepares a new version from the current content.
+	require.NoError(t, m.SyncEntries(configEntryMap{"k3": "v3"}, true))
+	require.Equal(t, []string{"1 k3 v3", "2 k1 v1"}, server.ReadMapContent(customMapName))
+	require.Equal(t, 2, server.MapVersion(customMapName))
+}
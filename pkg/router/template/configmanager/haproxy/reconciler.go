@@ -0,0 +1,375 @@
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	templaterouter "github.com/openshift/router/pkg/router/template"
+)
+
+// driftType is the kind of difference found between the running haproxy
+// state and the state expected by the router.
+type driftType string
+
+const (
+	// driftMissingServer is a server expected by the router but not
+	// found in the backend.
+	driftMissingServer driftType = "missing_server"
+
+	// driftStaleServer is a server found in the backend but no longer
+	// expected by the router.
+	driftStaleServer driftType = "stale_server"
+
+	// driftServerState is a server in maintenance or draining mode that
+	// should be serving traffic.
+	driftServerState driftType = "server_state"
+
+	// driftServerAddress is a server pointing to a different address or
+	// port than its endpoint.
+	driftServerAddress driftType = "server_address"
+
+	// driftServerWeight is a server with a different weight than the
+	// one expected by the router.
+	driftServerWeight driftType = "server_weight"
+
+	// driftMissingMapEntry is a map entry either missing or with a
+	// different value than the one expected by the router.
+	driftMissingMapEntry driftType = "missing_map_entry"
+
+	// driftStaleMapEntry is a map entry pointing to a backend which is
+	// no longer expected by the router.
+	driftStaleMapEntry driftType = "stale_map_entry"
+)
+
+// driftTypes are all the known drift types.
+var driftTypes = []driftType{
+	driftMissingServer,
+	driftStaleServer,
+	driftServerState,
+	driftServerAddress,
+	driftServerWeight,
+	driftMissingMapEntry,
+	driftStaleMapEntry,
+}
+
+var (
+	// metricDrift is the number of differences found on the last
+	// reconciliation, by drift type.
+	metricDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "template_router",
+		Subsystem: "dynamic_config",
+		Name:      "drift",
+		Help:      "Number of differences found between the running haproxy state and the router state on the last reconciliation.",
+	}, []string{"type"})
+
+	// metricDriftRepairFailures is the number of differences that could
+	// not be repaired, by drift type.
+	metricDriftRepairFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "template_router",
+		Subsystem: "dynamic_config",
+		Name:      "drift_repair_failures_total",
+		Help:      "Number of differences between the running haproxy state and the router state which failed to be repaired.",
+	}, []string{"type"})
+
+	// metricReconcile measures the time spent reconciling.
+	metricReconcile = prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace: "template_router",
+		Subsystem: "dynamic_config",
+		Name:      "reconcile_seconds",
+		Help:      "Measures the time spent reconciling the running haproxy state with the router state in seconds.",
+	})
+
+	registerReconcileMetricsOnce sync.Once
+)
+
+// registerReconcileMetrics registers the reconciliation metrics.
+func registerReconcileMetrics() {
+	registerReconcileMetricsOnce.Do(func() {
+		prometheus.MustRegister(metricDrift, metricDriftRepairFailures, metricReconcile)
+	})
+}
+
+// desiredStateRouter is a router able to report the state the dynamically
+// configured haproxy backends are expected to have.
+type desiredStateRouter interface {
+	DesiredBackends(ids []templaterouter.ServiceAliasConfigKey) (map[templaterouter.ServiceAliasConfigKey]templaterouter.DesiredBackend, bool)
+}
+
+// serverRepair is a change needed on a backend server to match the state
+// expected by the router.
+type serverRepair struct {
+	// drift is the kind of difference found.
+	drift driftType
+
+	// server is the expected server, only the endpoint ID is set for
+	// stale servers.
+	server templaterouter.DesiredServer
+}
+
+// reconcile verifies that the running haproxy state matches the router
+// state and repairs any drift found, e.g. due to a dynamic config API
+// command that failed or was lost.
+func (cm *haproxyConfigManager) reconcile() {
+	cm.lock.Lock()
+	router, ok := cm.router.(desiredStateRouter)
+	if !ok || cm.reloadInProgress {
+		cm.lock.Unlock()
+		return
+	}
+	generation := cm.generation
+	ids := make([]templaterouter.ServiceAliasConfigKey, 0, len(cm.backendEntries))
+	for id, entry := range cm.backendEntries {
+		if entry.reconcilable() {
+			ids = append(ids, id)
+		}
+	}
+	cm.lock.Unlock()
+
+	// Ensure this is done outside of the lock as the router calls into
+	// the manager code while holding its own lock.
+	desired, ok := router.DesiredBackends(ids)
+	if !ok {
+		log.V(4).Info("skipping reconciliation, router has pending changes")
+		return
+	}
+
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	if cm.reloadInProgress || cm.generation != generation || cm.tx != nil || len(cm.stagedMaps) > 0 {
+		log.V(4).Info("skipping reconciliation, config manager changed")
+		return
+	}
+
+	start := time.Now()
+	drift, err := cm.repairDrift(desired)
+	metricReconcile.Observe(float64(time.Since(start)) / float64(time.Second))
+	if err != nil {
+		log.Error(err, "reconciling dynamic configuration")
+		return
+	}
+
+	for _, t := range driftTypes {
+		metricDrift.WithLabelValues(string(t)).Set(float64(drift[t]))
+	}
+	log.V(4).Info("reconciled dynamic configuration", "drift", drift, "duration", time.Since(start).String())
+}
+
+// repairDrift compares the running haproxy state with the desired one,
+// repairing any differences found. Returns the number of differences
+// found by drift type. Must be called while holding cm.lock.
+func (cm *haproxyConfigManager) repairDrift(desired map[templaterouter.ServiceAliasConfigKey]templaterouter.DesiredBackend) (map[driftType]int, error) {
+	drift := make(map[driftType]int)
+	repairFailed := func(t driftType, err error, msg string, kv ...interface{}) {
+		metricDriftRepairFailures.WithLabelValues(string(t)).Inc()
+		log.Error(err, msg, kv...)
+	}
+
+	liveServers, err := backendServersState(cm.client)
+	if err != nil {
+		return nil, err
+	}
+
+	desiredMaps := make(map[string]map[string]string)
+	staleBackends := sets.NewString()
+	for id, entry := range cm.backendEntries {
+		if !entry.reconcilable() {
+			continue
+		}
+
+		backendName := entry.BackendName()
+		state, ok := desired[id]
+		if !ok {
+			// Registered but no longer expected by the router.
+			staleBackends.Insert(string(backendName))
+			continue
+		}
+
+		backend, err := cm.client.FindBackend(backendName)
+		if err != nil {
+			// Not in the running config, a reload is needed anyway.
+			log.V(4).Info("skipping reconciliation of backend", "backend", backendName, "error", err)
+			continue
+		}
+
+		for name, mapEntry := range state.MapEntries {
+			m, ok := desiredMaps[name]
+			if !ok {
+				m = make(map[string]string)
+				desiredMaps[name] = m
+			}
+			m[mapEntry.Key] = mapEntry.Value
+		}
+
+		isPassthrough := entry.termination == routev1.TLSTerminationPassthrough
+		repairs := diffBackendServers(state.Servers, liveServers[backendName], isPassthrough)
+		for _, repair := range repairs {
+			drift[repair.drift]++
+			ep := repair.server.Endpoint
+			log.V(2).Info("repairing backend server", "backend", backendName, "server", ep.ID, "drift", repair.drift)
+
+			var err error
+			switch repair.drift {
+			case driftMissingServer:
+				svc := &templaterouter.ServiceUnit{Hostname: repair.server.ServiceHostname}
+				err = backend.AddServer(entry.backend, svc, ep, repair.server.Weight, cm.workingDir, cm.defaultDestinationCA)
+				if err == nil && len(state.Servers) > 1 && !ep.NoHealthCheck {
+					err = backend.EnableHealthCheck(ep)
+				}
+			case driftStaleServer:
+				_, err = backend.DeleteServer(ep)
+			case driftServerAddress:
+				err = backend.innerUpdateServerAddr(ep)
+			case driftServerWeight:
+				err = backend.innerUpdateServerWeight(ep, repair.server.Weight, isPassthrough)
+			case driftServerState:
+				err = backend.innerSetServerState(ep, true, repair.server.Weight)
+			}
+			if err != nil {
+				repairFailed(repair.drift, err, "repairing backend server", "backend", backendName, "server", ep.ID)
+			}
+		}
+		if len(repairs) > 0 {
+			backend.Reset()
+		}
+	}
+
+	haproxyMaps, err := cm.client.Maps()
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, ham := range haproxyMaps {
+		name := path.Base(ham.Name())
+		if err := ham.Refresh(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		added, removed := diffMapEntries(desiredMaps[name], ham.entries, staleBackends)
+		drift[driftMissingMapEntry] += len(added)
+		drift[driftStaleMapEntry] += len(removed)
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+
+		// Both the missing and the stale entries are replaced in a single
+		// map version, so no request is routed to a partially repaired map.
+		log.V(2).Info("repairing map entries", "map", name, "added", added, "removed", removed)
+		ham.StageEntries(removed, false)
+		ham.StageEntries(added, true)
+		if err := ham.Commit(); err != nil {
+			if len(added) > 0 {
+				repairFailed(driftMissingMapEntry, err, "repairing map entries", "map", name)
+			}
+			if len(removed) > 0 {
+				repairFailed(driftStaleMapEntry, err, "removing stale map entries", "map", name)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("reading haproxy maps: %w", errors.Join(errs...))
+	}
+
+	return drift, nil
+}
+
+// reconcilable indicates if the backend of a route is reconciled. Blueprint
+// pool backends and the routes using them are rewritten on the next commit.
+func (entry *routeBackendEntry) reconcilable() bool {
+	return entry.backend.Namespace != blueprintRoutePoolNamespace && len(entry.poolRouteBackendName) == 0
+}
+
+// diffBackendServers returns the changes needed on the live servers of a
+// backend to match the desired ones.
+func diffBackendServers(desired []templaterouter.DesiredServer, live []BackendServerInfo, isPassthrough bool) []serverRepair {
+	liveServers := make(map[string]BackendServerInfo, len(live))
+	for _, s := range live {
+		liveServers[s.Name] = s
+	}
+
+	desiredServers := make([]templaterouter.DesiredServer, len(desired))
+	copy(desiredServers, desired)
+	sort.Slice(desiredServers, func(i, j int) bool {
+		return desiredServers[i].ID < desiredServers[j].ID
+	})
+
+	var repairs []serverRepair
+	expected := sets.NewString()
+	for _, server := range desiredServers {
+		expected.Insert(server.ID)
+		s, ok := liveServers[server.ID]
+		if !ok {
+			repairs = append(repairs, serverRepair{drift: driftMissingServer, server: server})
+			continue
+		}
+		if s.IPAddress != server.IP || fmt.Sprint(s.Port) != server.Port {
+			repairs = append(repairs, serverRepair{drift: driftServerAddress, server: server})
+		}
+		// Passthrough servers use a relative weight, see UpdateServer.
+		if !isPassthrough && s.CurrentWeight != server.Weight {
+			repairs = append(repairs, serverRepair{drift: driftServerWeight, server: server})
+		}
+		if s.Maintenance || (s.Draining && server.Weight > 0) {
+			repairs = append(repairs, serverRepair{drift: driftServerState, server: server})
+		}
+	}
+
+	names := make([]string, 0, len(live))
+	for _, s := range live {
+		// Servers in maintenance mode are the ones that could not be
+		// deleted due to in-flight connections.
+		if !expected.Has(s.Name) && !s.Maintenance {
+			names = append(names, s.Name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		server := templaterouter.DesiredServer{Endpoint: templaterouter.Endpoint{ID: name}}
+		repairs = append(repairs, serverRepair{drift: driftStaleServer, server: server})
+	}
+
+	return repairs
+}
+
+// diffMapEntries returns the entries to add to (or update in) and to remove
+// from a live haproxy map to match the desired entries. Only the live
+// entries pointing to one of the stale backends are removed.
+func diffMapEntries(desired map[string]string, live []*HAProxyMapEntry, staleBackends sets.String) (added, removed configEntryMap) {
+	added = make(configEntryMap)
+	removed = make(configEntryMap)
+
+	liveValues := make(map[string][]string)
+	for _, entry := range live {
+		value := strings.TrimSpace(entry.Value)
+		liveValues[entry.Name] = append(liveValues[entry.Name], value)
+		if _, ok := desired[entry.Name]; !ok && staleBackends.Has(value) {
+			removed[entry.Name] = templaterouter.ServiceAliasConfigKey(value)
+		}
+	}
+
+	for k, v := range desired {
+		values, ok := liveValues[k]
+		differs := slices.ContainsFunc(values, func(value string) bool {
+			return value != v
+		})
+		if !ok || differs {
+			added[k] = templaterouter.ServiceAliasConfigKey(v)
+		}
+	}
+
+	return added, removed
+}
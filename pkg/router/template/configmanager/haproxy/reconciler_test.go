@@ -0,0 +1,283 @@
+package haproxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	routev1 "github.com/openshift/api/route/v1"
+
+	templaterouter "github.com/openshift/router/pkg/router/template"
+	haproxytesting "github.com/openshift/router/pkg/router/template/configmanager/haproxy/testing"
+	haproxyutil "github.com/openshift/router/pkg/router/template/util/haproxy"
+)
+
+func desiredServer(id, ip, port string, weight int32) templaterouter.DesiredServer {
+	return templaterouter.DesiredServer{
+		Endpoint: templaterouter.Endpoint{ID: id, IP: ip, Port: port},
+		Weight:   weight,
+	}
+}
+
+func TestDiffBackendServers(t *testing.T) {
+	testCases := map[string]struct {
+		desired       []templaterouter.DesiredServer
+		live          []BackendServerInfo
+		isPassthrough bool
+		expected      map[string]driftType
+	}{
+		"no drift": {
+			desired: []templaterouter.DesiredServer{desiredServer("s1", "10.0.0.1", "8080", 1)},
+			live:    []BackendServerInfo{{Name: "s1", IPAddress: "10.0.0.1", Port: 8080, CurrentWeight: 1}},
+		},
+		"missing server": {
+			desired:  []templaterouter.DesiredServer{desiredServer("s1", "10.0.0.1", "8080", 1)},
+			expected: map[string]driftType{"s1": driftMissingServer},
+		},
+		"stale server": {
+			live:     []BackendServerInfo{{Name: "s1", IPAddress: "10.0.0.1", Port: 8080, CurrentWeight: 1}},
+			expected: map[string]driftType{"s1": driftStaleServer},
+		},
+		"stale server in maintenance": {
+			live: []BackendServerInfo{{Name: "s1", IPAddress: "10.0.0.1", Port: 8080, Maintenance: true}},
+		},
+		"server in maintenance": {
+			desired:  []templaterouter.DesiredServer{desiredServer("s1", "10.0.0.1", "8080", 1)},
+			live:     []BackendServerInfo{{Name: "s1", IPAddress: "10.0.0.1", Port: 8080, CurrentWeight: 1, Maintenance: true}},
+			expected: map[string]driftType{"s1": driftServerState},
+		},
+		"draining server": {
+			desired:  []templaterouter.DesiredServer{desiredServer("s1", "10.0.0.1", "8080", 1)},
+			live:     []BackendServerInfo{{Name: "s1", IPAddress: "10.0.0.1", Port: 8080, CurrentWeight: 1, Draining: true}},
+			expected: map[string]driftType{"s1": driftServerState},
+		},
+		"draining server with zero weight": {
+			desired: []templaterouter.DesiredServer{desiredServer("s1", "10.0.0.1", "8080", 0)},
+			live:    []BackendServerInfo{{Name: "s1", IPAddress: "10.0.0.1", Port: 8080, Draining: true}},
+		},
+		"server address": {
+			desired:  []templaterouter.DesiredServer{desiredServer("s1", "10.0.0.1", "8080", 1)},
+			live:     []BackendServerInfo{{Name: "s1", IPAddress: "10.0.0.2", Port: 8080, CurrentWeight: 1}},
+			expected: map[string]driftType{"s1": driftServerAddress},
+		},
+		"server port": {
+			desired:  []templaterouter.DesiredServer{desiredServer("s1", "10.0.0.1", "8080", 1)},
+			live:     []BackendServerInfo{{Name: "s1", IPAddress: "10.0.0.1", Port: 8443, CurrentWeight: 1}},
+			expected: map[string]driftType{"s1": driftServerAddress},
+		},
+		"server weight": {
+			desired:  []templaterouter.DesiredServer{desiredServer("s1", "10.0.0.1", "8080", 1)},
+			live:     []BackendServerInfo{{Name: "s1", IPAddress: "10.0.0.1", Port: 8080, CurrentWeight: 256}},
+			expected: map[string]driftType{"s1": driftServerWeight},
+		},
+		"passthrough server weight": {
+			desired:       []templaterouter.DesiredServer{desiredServer("s1", "10.0.0.1", "8080", 1)},
+			live:          []BackendServerInfo{{Name: "s1", IPAddress: "10.0.0.1", Port: 8080, CurrentWeight: 256}},
+			isPassthrough: true,
+		},
+		"mixed": {
+			desired: []templaterouter.DesiredServer{
+				desiredServer("s1", "10.0.0.1", "8080", 1),
+				desiredServer("s2", "10.0.0.2", "8080", 1),
+			},
+			live: []BackendServerInfo{
+				{Name: "s1", IPAddress: "10.0.0.1", Port: 8080, CurrentWeight: 1, Maintenance: true},
+				{Name: "s3", IPAddress: "10.0.0.3", Port: 8080, CurrentWeight: 1},
+			},
+			expected: map[string]driftType{
+				"s1": driftServerState,
+				"s2": driftMissingServer,
+				"s3": driftStaleServer,
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			repairs := diffBackendServers(test.desired, test.live, test.isPassthrough)
+			actual := make(map[string]driftType)
+			for _, repair := range repairs {
+				actual[repair.server.ID] = repair.drift
+			}
+			if test.expected == nil {
+				test.expected = map[string]driftType{}
+			}
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestDiffMapEntries(t *testing.T) {
+	testCases := map[string]struct {
+		desired         map[string]string
+		live            []*HAProxyMapEntry
+		staleBackends   []string
+		expectedAdded   configEntryMap
+		expectedRemoved configEntryMap
+	}{
+		"no drift": {
+			desired: map[string]string{"^a$": "be_http:ns:a"},
+			live:    []*HAProxyMapEntry{{ID: "1", Name: "^a$", Value: "be_http:ns:a"}},
+		},
+		"no drift with leading space": {
+			desired: map[string]string{"^a$": "be_http:ns:a"},
+			live:    []*HAProxyMapEntry{{ID: "1", Name: "^a$", Value: " be_http:ns:a"}},
+		},
+		"missing entry": {
+			desired:       map[string]string{"^a$": "be_http:ns:a"},
+			expectedAdded: configEntryMap{"^a$": "be_http:ns:a"},
+		},
+		"wrong value": {
+			desired:       map[string]string{"^a$": "be_http:ns:a"},
+			live:          []*HAProxyMapEntry{{ID: "1", Name: "^a$", Value: "be_http:ns:b"}},
+			expectedAdded: configEntryMap{"^a$": "be_http:ns:a"},
+		},
+		"stale entry": {
+			live:            []*HAProxyMapEntry{{ID: "1", Name: "^b$", Value: "be_http:ns:b"}},
+			staleBackends:   []string{"be_http:ns:b"},
+			expectedRemoved: configEntryMap{"^b$": "be_http:ns:b"},
+		},
+		"unknown entry is kept": {
+			live: []*HAProxyMapEntry{{ID: "1", Name: "^b$", Value: "be_http:ns:b"}},
+		},
+		"stale backend with expected key is kept": {
+			desired:       map[string]string{"^b$": "be_http:ns:c"},
+			live:          []*HAProxyMapEntry{{ID: "1", Name: "^b$", Value: "be_http:ns:b"}},
+			staleBackends: []string{"be_http:ns:b"},
+			expectedAdded: configEntryMap{"^b$": "be_http:ns:c"},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			added, removed := diffMapEntries(test.desired, test.live, sets.NewString(test.staleBackends...))
+			if test.expectedAdded == nil {
+				test.expectedAdded = configEntryMap{}
+			}
+			if test.expectedRemoved == nil {
+				test.expectedRemoved = configEntryMap{}
+			}
+			assert.Equal(t, test.expectedAdded, added)
+			assert.Equal(t, test.expectedRemoved, removed)
+		})
+	}
+}
+
+// fakeDesiredStateRouter is a router reporting a fixed desired state.
+type fakeDesiredStateRouter struct {
+	templaterouter.RouterInterface
+
+	backends map[templaterouter.ServiceAliasConfigKey]templaterouter.DesiredBackend
+	pending  bool
+}
+
+func (r *fakeDesiredStateRouter) DesiredBackends(ids []templaterouter.ServiceAliasConfigKey) (map[templaterouter.ServiceAliasConfigKey]templaterouter.DesiredBackend, bool) {
+	if r.pending {
+		return nil, false
+	}
+	backends := make(map[templaterouter.ServiceAliasConfigKey]templaterouter.DesiredBackend)
+	for _, id := range ids {
+		if b, ok := r.backends[id]; ok {
+			backends[id] = b
+		}
+	}
+	return backends, true
+}
+
+func TestReconcile(t *testing.T) {
+	const (
+		backendName = "be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1"
+		httpMap     = "/var/lib/haproxy/conf/os_http_be.map"
+	)
+
+	testCases := map[string]struct {
+		pending          bool
+		reloading        bool
+		expectedCommands []string
+	}{
+		"repairs drift": {
+			expectedCommands: []string{
+				"set server " + backendName + "/_dynamic-pod-1 state ready",
+				"add server " + backendName + "/missing-pod 10.0.0.1:8080 weight 1 check inter 5000ms",
+				"set server " + backendName + "/missing-pod state ready",
+			},
+		},
+		"skips if router has pending changes": {
+			pending: true,
+		},
+		"skips while reloading": {
+			reloading: true,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := haproxytesting.StartFakeServerForTest(t)
+			defer server.Stop()
+
+			router := &fakeDesiredStateRouter{
+				pending: test.pending,
+				backends: map[templaterouter.ServiceAliasConfigKey]templaterouter.DesiredBackend{
+					"ns:route": {
+						Servers: []templaterouter.DesiredServer{
+							desiredServer("_dynamic-pod-1", "172.17.0.3", "8080", 256),
+							desiredServer("missing-pod", "10.0.0.1", "8080", 1),
+						},
+						MapEntries: map[string]haproxyutil.HAProxyMapEntry{
+							"os_http_be.map": {Key: `^route\.new\.test(:[0-9]+)?(/.*)?$`, Value: "be_http:ns:route"},
+						},
+					},
+				},
+			}
+
+			cm := NewHAProxyConfigManager(templaterouter.ConfigManagerOptions{ConnectionInfo: "unix://" + server.SocketFile()})
+			cm.router = router
+			cm.reloadInProgress = test.reloading
+			cm.backendEntries["ns:route"] = &routeBackendEntry{
+				id:          "ns:route",
+				backend:     &templaterouter.ServiceAliasConfig{Namespace: "ns", TLSTermination: routev1.TLSTerminationEdge},
+				termination: routev1.TLSTerminationEdge,
+				backendName: backendName,
+			}
+			cm.backendEntries["default:test-http-allow"] = &routeBackendEntry{
+				id:          "default:test-http-allow",
+				backend:     &templaterouter.ServiceAliasConfig{Namespace: "default"},
+				termination: routev1.TLSTerminationEdge,
+				backendName: "be_edge_http:default:test-http-allow",
+			}
+
+			cm.reconcile()
+
+			var serverCommands []string
+			for _, cmd := range server.Commands() {
+				if strings.HasPrefix(cmd, "set server") || strings.HasPrefix(cmd, "add server") {
+					serverCommands = append(serverCommands, cmd)
+				}
+			}
+			assert.Equal(t, test.expectedCommands, serverCommands)
+
+			for _, name := range []string{"_dynamic-pod-1", "missing-pod"} {
+				state, found := server.Server(backendName, name)
+				if test.expectedCommands == nil {
+					assert.Equal(t, name == "_dynamic-pod-1", found, name)
+					continue
+				}
+				require.True(t, found, name)
+				assert.False(t, state.Maintenance, name)
+				assert.True(t, state.HealthCheck, name)
+			}
+
+			mapContent := server.ReadMapContent(httpMap)
+			if test.expectedCommands == nil {
+				require.Equal(t, []string{`0x559a137b4c10 ^route\.allow-http\.test(:[0-9]+)?(/.*)?$ be_edge_http:default:test-http-allow`}, mapContent)
+				return
+			}
+			// The stale entry of the removed route is replaced with the missing one.
+			require.Equal(t, []string{`1 ^route\.new\.test(:[0-9]+)?(/.*)?$ be_http:ns:route`}, mapContent)
+		})
+	}
+}
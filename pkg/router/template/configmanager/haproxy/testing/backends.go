@@ -0,0 +1,627 @@
+package testing
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Server administrative state flags, see srv_admin_state.
+	adminForcedMaint     = 0x01
+	adminInheritedMaint  = 0x02
+	adminConfiguredMaint = 0x04
+	adminForcedDrain     = 0x08
+	adminInheritedDrain  = 0x10
+
+	adminMaint = adminForcedMaint | adminInheritedMaint | adminConfiguredMaint
+	adminDrain = adminForcedDrain | adminInheritedDrain
+
+	// Server operational states, see srv_op_state.
+	opStopped  = 0
+	opStarting = 1
+	opRunning  = 2
+	opStopping = 3
+
+	// maxServerWeight is the maximum weight of a server.
+	maxServerWeight = 256
+
+	showServersStateHeader = "# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port"
+)
+
+// ServerState is the state of a simulated haproxy backend server.
+type ServerState struct {
+	Name    string
+	Address string
+	Port    int
+
+	// Weight is the current weight, InitialWeight the one the server was
+	// configured with.
+	Weight        int
+	InitialWeight int
+
+	// Maintenance and Draining are the administrative state.
+	Maintenance bool
+	Draining    bool
+
+	// HealthCheck indicates the server is health checked, Up the result
+	// of the checks.
+	HealthCheck bool
+	Up          bool
+
+	// Connections is the number of connections to the server.
+	Connections int
+}
+
+type fakeFrontend struct {
+	name  string
+	stats map[string]int64
+}
+
+type fakeBackend struct {
+	id      int
+	name    string
+	servers []*fakeServer
+
+	dynamicCookie    bool
+	dynamicCookieKey string
+
+	stats map[string]int64
+}
+
+type fakeServer struct {
+	id   int
+	name string
+	addr string
+	port int
+
+	opState    int
+	adminState int
+	uweight    int
+	iweight    int
+	lastChange time.Time
+
+	check        bool
+	checkEnabled bool
+	connections  int
+
+	stats map[string]int64
+}
+
+// state returns the state of this server.
+func (s *fakeServer) state() ServerState {
+	return ServerState{
+		Name:          s.name,
+		Address:       s.addr,
+		Port:          s.port,
+		Weight:        s.uweight,
+		InitialWeight: s.iweight,
+		Maintenance:   s.adminState&adminMaint != 0,
+		Draining:      s.adminState&adminDrain != 0,
+		HealthCheck:   s.check && s.checkEnabled,
+		Up:            s.opState == opRunning,
+		Connections:   s.connections,
+	}
+}
+
+// initializeBackends sets the initial backends and servers. Must be called
+// while holding p.lock.
+func (p *fakeHAProxy) initializeBackends() {
+	p.frontends = nil
+	for _, name := range []string{"public", "public_ssl", "fe_sni", "fe_no_sni"} {
+		p.frontends = append(p.frontends, &fakeFrontend{name: name, stats: make(map[string]int64)})
+	}
+
+	now := time.Now()
+	server := func(id int, name, addr string, port, opState, adminState, uweight, iweight int, since time.Duration) *fakeServer {
+		return &fakeServer{
+			id:           id,
+			name:         name,
+			addr:         addr,
+			port:         port,
+			opState:      opState,
+			adminState:   adminState,
+			uweight:      uweight,
+			iweight:      iweight,
+			lastChange:   now.Add(-since),
+			check:        true,
+			checkEnabled: true,
+			stats:        make(map[string]int64),
+		}
+	}
+	staticServer := func(name, addr string, port int) []*fakeServer {
+		s := server(1, name, addr, port, opRunning, 0, 1, 1, 0)
+		s.check = false
+		return []*fakeServer{s}
+	}
+	onePodAndOneDynamicServer := func() []*fakeServer {
+		return []*fakeServer{
+			server(1, "pod:test-1-l8x8w:test-service:172.17.0.3:1234", "172.17.0.3", 1234, opRunning, adminConfiguredMaint, 256, 1, 8117*time.Second),
+			server(2, serverName, "172.4.0.4", 1234, opRunning, adminConfiguredMaint, 256, 1, 8117*time.Second),
+		}
+	}
+
+	backends := []struct {
+		name    string
+		servers []*fakeServer
+	}{
+		{name: "be_sni", servers: staticServer("fe_sni", "127.0.0.1", 10444)},
+		{name: "be_no_sni", servers: staticServer("fe_no_sni", "127.0.0.1", 10443)},
+		{name: "openshift_default"},
+	}
+	for _, name := range []string{
+		"be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1",
+		"be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-2",
+		"be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-3",
+		"be_http:_hapcm_blueprint_pool:_blueprint-http-route-1",
+		"be_http:_hapcm_blueprint_pool:_blueprint-http-route-2",
+		"be_http:_hapcm_blueprint_pool:_blueprint-http-route-3",
+		"be_tcp:_hapcm_blueprint_pool:_blueprint-passthrough-route-1",
+		"be_tcp:_hapcm_blueprint_pool:_blueprint-passthrough-route-2",
+		"be_tcp:_hapcm_blueprint_pool:_blueprint-passthrough-route-3",
+		"be_edge_http:blueprints:blueprint-redirect-to-https",
+		"be_secure:blueprints:blueprint-reencrypt",
+		"be_edge_http:default:example-route",
+		"be_edge_http:default:test-http-allow",
+		"be_edge_http:default:test-https",
+		"be_edge_http:default:test-https-only",
+		"be_tcp:default:test-passthrough",
+		"be_secure:default:test-reencrypt",
+		"be_edge_http:default:wildcard-redirect-to-https",
+	} {
+		servers := onePodAndOneDynamicServer()
+		if name == p.backendName {
+			servers = []*fakeServer{
+				server(1, "_dynamic-pod-1", "172.17.0.3", 8080, opRunning, adminConfiguredMaint, 256, 1, 8117*time.Second),
+				server(2, "_dynamic-pod-2", "172.17.0.3", 8080, opRunning, adminForcedMaint|adminConfiguredMaint, 256, 1, 8117*time.Second),
+				server(3, "_dynamic-pod-3", "172.4.0.4", 8765, opStopped, adminForcedMaint|adminConfiguredMaint, 1, 1, 8206*time.Second),
+				server(4, "_dynamic-pod-4", "172.4.0.4", 8765, opStopped, adminForcedMaint|adminConfiguredMaint, 1, 1, 8206*time.Second),
+				server(5, "_dynamic-pod-5", "172.17.0.2", 8080, opRunning, adminConfiguredMaint, 256, 1, 8206*time.Second),
+			}
+		}
+		backends = append(backends, struct {
+			name    string
+			servers []*fakeServer
+		}{name: name, servers: servers})
+	}
+
+	p.backends = nil
+	for i, b := range backends {
+		p.backends = append(p.backends, &fakeBackend{
+			id:      i + 4,
+			name:    b.name,
+			servers: b.servers,
+			stats:   make(map[string]int64),
+		})
+	}
+}
+
+// AddBackend adds a backend with the given servers, replacing any backend
+// with the same name.
+func (p *fakeHAProxy) AddBackend(name string, servers ...ServerState) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	be := &fakeBackend{name: name, stats: make(map[string]int64)}
+	for i, s := range servers {
+		server := &fakeServer{
+			id:           i + 1,
+			name:         s.Name,
+			addr:         s.Address,
+			port:         s.Port,
+			opState:      opStopped,
+			uweight:      s.Weight,
+			iweight:      s.InitialWeight,
+			lastChange:   time.Now(),
+			check:        s.HealthCheck,
+			checkEnabled: s.HealthCheck,
+			connections:  s.Connections,
+			stats:        make(map[string]int64),
+		}
+		if s.Up {
+			server.opState = opRunning
+		}
+		if s.Maintenance {
+			server.adminState |= adminForcedMaint
+		}
+		if s.Draining {
+			server.adminState |= adminForcedDrain
+		}
+		be.servers = append(be.servers, server)
+	}
+
+	for i, b := range p.backends {
+		if b.name == name {
+			be.id = b.id
+			p.backends[i] = be
+			return
+		}
+	}
+	be.id = len(p.backends) + 4
+	p.backends = append(p.backends, be)
+}
+
+// Servers returns the state of the servers of a backend, nil if the backend
+// does not exist.
+func (p *fakeHAProxy) Servers(backend string) []ServerState {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	be := p.findBackend(backend)
+	if be == nil {
+		return nil
+	}
+	servers := make([]ServerState, 0, len(be.servers))
+	for _, s := range be.servers {
+		servers = append(servers, s.state())
+	}
+	return servers
+}
+
+// Server returns the state of a backend server.
+func (p *fakeHAProxy) Server(backend, server string) (ServerState, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	_, s := p.findServer(backend, server)
+	if s == nil {
+		return ServerState{}, false
+	}
+	return s.state(), true
+}
+
+// SetServerHealth sets the result of the health checks of a server.
+func (p *fakeHAProxy) SetServerHealth(backend, server string, up bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	_, s := p.findServer(backend, server)
+	if s == nil {
+		return fmt.Errorf("no server %s/%s", backend, server)
+	}
+	s.setOpState(map[bool]int{true: opRunning, false: opStopped}[up])
+	return nil
+}
+
+// SetServerConnections sets the number of connections to a server, which
+// can't be deleted while there is any.
+func (p *fakeHAProxy) SetServerConnections(backend, server string, connections int) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	_, s := p.findServer(backend, server)
+	if s == nil {
+		return fmt.Errorf("no server %s/%s", backend, server)
+	}
+	s.connections = connections
+	return nil
+}
+
+// DynamicCookieKey returns the dynamic cookie key of a backend and whether
+// dynamic cookies are enabled.
+func (p *fakeHAProxy) DynamicCookieKey(backend string) (string, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	be := p.findBackend(backend)
+	if be == nil {
+		return "", false
+	}
+	return be.dynamicCookieKey, be.dynamicCookie
+}
+
+// findBackend returns a backend by name. Must be called while holding p.lock.
+func (p *fakeHAProxy) findBackend(name string) *fakeBackend {
+	for _, be := range p.backends {
+		if be.name == name {
+			return be
+		}
+	}
+	return nil
+}
+
+// findServer returns a backend server by name. Must be called while holding
+// p.lock.
+func (p *fakeHAProxy) findServer(backend, server string) (*fakeBackend, *fakeServer) {
+	be := p.findBackend(backend)
+	if be == nil {
+		return nil, nil
+	}
+	for _, s := range be.servers {
+		if s.name == server {
+			return be, s
+		}
+	}
+	return be, nil
+}
+
+// lookupServer resolves a "backend/server" command argument, returning the
+// error response if not found. Must be called while holding p.lock.
+func (p *fakeHAProxy) lookupServer(args []string) (*fakeBackend, *fakeServer, string) {
+	if len(args) == 0 {
+		return nil, nil, "Require 'backend/server'.\n"
+	}
+	backend, server, found := strings.Cut(args[0], "/")
+	if !found {
+		return nil, nil, "Require 'backend/server'.\n"
+	}
+	be, s := p.findServer(backend, server)
+	if be == nil {
+		return nil, nil, "No such backend.\n"
+	}
+	if s == nil {
+		return be, nil, "No such server.\n"
+	}
+	return be, s, ""
+}
+
+func (s *fakeServer) setOpState(state int) {
+	if s.opState != state {
+		s.opState = state
+		s.lastChange = time.Now()
+	}
+}
+
+func (s *fakeServer) setAdminState(state int) {
+	if s.adminState != state {
+		s.adminState = state
+		s.lastChange = time.Now()
+	}
+}
+
+func (p *fakeHAProxy) listBackends() string {
+	lines := []string{"# name"}
+	for _, be := range p.backends {
+		lines = append(lines, be.name)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (p *fakeHAProxy) showServers(name string) string {
+	backends := p.backends
+	if len(name) > 0 {
+		be := p.findBackend(name)
+		if be == nil {
+			return "Can't find backend.\n"
+		}
+		backends = []*fakeBackend{be}
+	}
+
+	lines := []string{"1", showServersStateHeader}
+	for _, be := range backends {
+		for _, s := range be.servers {
+			checkStatus, checkResult, checkHealth, checkState := 6, 3, 4, 6
+			if s.opState != opRunning {
+				checkStatus, checkResult, checkHealth, checkState = 1, 0, 0, 14
+			}
+			lines = append(lines, fmt.Sprintf("%d %s %d %s %s %d %d %d %d %d %d %d %d %d 0 0 0 - %d",
+				be.id, be.name, s.id, s.name, s.addr, s.opState, s.adminState, s.uweight, s.iweight,
+				int(time.Since(s.lastChange).Seconds()), checkStatus, checkResult, checkHealth, checkState, s.port))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (p *fakeHAProxy) setServer(args []string) string {
+	_, s, errResponse := p.lookupServer(args)
+	if s == nil {
+		return errResponse
+	}
+	if len(args) < 3 {
+		return "'set server <srv>' only supports 'agent', 'health', 'state', 'weight', 'addr', 'fqdn', 'check-addr' and 'check-port'.\n"
+	}
+
+	switch args[1] {
+	case "state":
+		switch args[2] {
+		case "ready":
+			s.setAdminState(s.adminState &^ (adminForcedMaint | adminConfiguredMaint | adminForcedDrain))
+		case "drain":
+			s.setAdminState(s.adminState&^(adminForcedMaint|adminConfiguredMaint) | adminForcedDrain)
+		case "maint":
+			s.setAdminState(s.adminState | adminForcedMaint)
+		default:
+			return "'set server <srv> state' expects 'ready', 'drain' and 'maint'.\n"
+		}
+		return "\n"
+
+	case "weight":
+		weight, err := parseWeight(args[2], s.iweight)
+		if err != "" {
+			return err
+		}
+		s.uweight = weight
+		return "\n"
+
+	case "health":
+		switch args[2] {
+		case "up":
+			s.setOpState(opRunning)
+		case "stopping":
+			s.setOpState(opStopping)
+		case "down":
+			s.setOpState(opStopped)
+		default:
+			return "'set server <srv> health' expects 'up', 'stopping', or 'down'.\n"
+		}
+		return "\n"
+
+	case "addr":
+		addr := args[2]
+		if net.ParseIP(addr) == nil {
+			return fmt.Sprintf("Invalid addr '%s'.\n", addr)
+		}
+		port := s.port
+		if len(args) >= 5 && args[3] == "port" {
+			var err error
+			if port, err = strconv.Atoi(args[4]); err != nil {
+				return fmt.Sprintf("Invalid port '%s'.\n", args[4])
+			}
+		}
+
+		var changes []string
+		if addr != s.addr {
+			changes = append(changes, fmt.Sprintf("IP changed from '%s' to '%s'", s.addr, addr))
+		} else {
+			changes = append(changes, "no need to change the addr")
+		}
+		if port != s.port {
+			changes = append(changes, fmt.Sprintf("port changed from '%d' to '%d'", s.port, port))
+		} else {
+			changes = append(changes, "no need to change the port")
+		}
+		s.addr, s.port = addr, port
+		return strings.Join(changes, ", ") + " by 'stats socket command'\n"
+	}
+
+	return "'set server <srv>' only supports 'agent', 'health', 'state', 'weight', 'addr', 'fqdn', 'check-addr' and 'check-port'.\n"
+}
+
+// parseWeight parses an absolute or relative server weight, returning an
+// error response if not valid.
+func parseWeight(value string, initialWeight int) (int, string) {
+	if pct, relative := strings.CutSuffix(value, "%"); relative {
+		w, err := strconv.Atoi(pct)
+		if err != nil || w < 0 {
+			return 0, "Relative weight must be positive.\n"
+		}
+		return min(initialWeight*w/100, maxServerWeight), ""
+	}
+
+	w, err := strconv.Atoi(value)
+	if err != nil || w < 0 || w > maxServerWeight {
+		return 0, "Absolute weight can only be between 0 and 256 inclusive.\n"
+	}
+	return w, ""
+}
+
+func (p *fakeHAProxy) addServer(args []string) string {
+	if len(args) < 2 {
+		return "'server' expects <name> and <addr>[:<port>] as arguments.\n"
+	}
+	backend, name, found := strings.Cut(args[0], "/")
+	if !found {
+		return "Require 'backend/server'.\n"
+	}
+	be, s := p.findServer(backend, name)
+	if be == nil {
+		return "No such backend.\n"
+	}
+	if s != nil {
+		return "Already exists a server with the same name in backend.\n"
+	}
+
+	host, portStr, err := net.SplitHostPort(args[1])
+	if err != nil || net.ParseIP(host) == nil {
+		return fmt.Sprintf("invalid address: '%s'\n", args[1])
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	// Dynamic servers are created in maintenance mode.
+	s = &fakeServer{
+		name:       name,
+		addr:       host,
+		port:       port,
+		opState:    opRunning,
+		adminState: adminForcedMaint,
+		uweight:    1,
+		iweight:    1,
+		lastChange: time.Now(),
+		stats:      make(map[string]int64),
+	}
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "weight":
+			if i+1 < len(args) {
+				w, errResponse := parseWeight(args[i+1], 1)
+				if errResponse != "" {
+					return errResponse
+				}
+				s.uweight, s.iweight = w, w
+				i++
+			}
+		case "check":
+			s.check = true
+			// Checks of dynamic servers must be enabled explicitly.
+			s.checkEnabled = false
+		}
+	}
+	for _, srv := range be.servers {
+		s.id = max(s.id, srv.id)
+	}
+	s.id++
+	be.servers = append(be.servers, s)
+	return "New server registered.\n"
+}
+
+func (p *fakeHAProxy) delServer(args []string) string {
+	be, s, errResponse := p.lookupServer(args)
+	if s == nil {
+		return errResponse
+	}
+	if s.adminState&adminMaint == 0 {
+		return "Only servers in maintenance mode can be deleted.\n"
+	}
+	if s.connections > 0 {
+		return "Server still has connections attached to it, cannot remove it.\n"
+	}
+
+	for i, srv := range be.servers {
+		if srv == s {
+			be.servers = append(be.servers[:i], be.servers[i+1:]...)
+			break
+		}
+	}
+	return "Server deleted.\n"
+}
+
+func (p *fakeHAProxy) setServerMaint(args []string, maint bool) string {
+	_, s, errResponse := p.lookupServer(args)
+	if s == nil {
+		return errResponse
+	}
+	if maint {
+		s.setAdminState(s.adminState | adminForcedMaint)
+	} else {
+		s.setAdminState(s.adminState &^ (adminForcedMaint | adminConfiguredMaint))
+	}
+	return "\n"
+}
+
+func (p *fakeHAProxy) setServerHealthCheck(args []string, enable bool) string {
+	_, s, errResponse := p.lookupServer(args)
+	if s == nil {
+		return errResponse
+	}
+	if !s.check {
+		return "Health checks are not configured on this server, cannot enable.\n"
+	}
+	s.checkEnabled = enable
+	return "\n"
+}
+
+func (p *fakeHAProxy) setDynamicCookieKey(args []string) string {
+	if len(args) < 2 {
+		return "String value expected.\n"
+	}
+	be := p.findBackend(args[0])
+	if be == nil {
+		return "No such backend.\n"
+	}
+	be.dynamicCookieKey = args[1]
+	return "\n"
+}
+
+func (p *fakeHAProxy) setDynamicCookie(args []string, enable bool) string {
+	if len(args) < 1 {
+		return "No such backend.\n"
+	}
+	be := p.findBackend(args[0])
+	if be == nil {
+		return "No such backend.\n"
+	}
+	be.dynamicCookie = enable
+	return "\n"
+}
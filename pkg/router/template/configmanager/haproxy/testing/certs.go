@@ -0,0 +1,302 @@
+package testing
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// defaultCrtList is the crt-list loaded by the initial configuration.
+	defaultCrtList = "/var/lib/haproxy/conf/cert_config.map"
+
+	// certTimeFormat is the format of the certificate validity dates.
+	certTimeFormat = "Jan _2 15:04:05 2006 GMT"
+)
+
+// fakeCert is an ssl certificate loaded in haproxy.
+type fakeCert struct {
+	// pem is the certificate, key and chain content, empty for a new
+	// certificate.
+	pem string
+}
+
+// fakeCertTransaction is the ongoing update of a certificate. haproxy
+// allows a single certificate transaction at a time.
+type fakeCertTransaction struct {
+	name string
+	pem  string
+}
+
+// initializeCerts sets the initial certificates. Must be called while
+// holding p.lock.
+func (p *fakeHAProxy) initializeCerts() {
+	p.certs = make(map[string]*fakeCert)
+	p.certTx = nil
+	p.crtLists = map[string][]string{defaultCrtList: nil}
+}
+
+// SetCertificate loads a certificate as if it was part of the haproxy
+// configuration, and adds it to the default crt-list.
+func (p *fakeHAProxy) SetCertificate(name, pemContent string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.certs[name]; !ok {
+		p.crtLists[defaultCrtList] = append(p.crtLists[defaultCrtList], name)
+	}
+	p.certs[name] = &fakeCert{pem: pemContent}
+}
+
+// Certificate returns the committed content of a certificate.
+func (p *fakeHAProxy) Certificate(name string) (string, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	cert, ok := p.certs[name]
+	if !ok {
+		return "", false
+	}
+	return cert.pem, true
+}
+
+// CrtList returns the certificates of a crt-list.
+func (p *fakeHAProxy) CrtList(name string) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string(nil), p.crtLists[name]...)
+}
+
+// certInUse checks if a certificate is referenced by a crt-list. Must be
+// called while holding p.lock.
+func (p *fakeHAProxy) certInUse(name string) bool {
+	for _, certs := range p.crtLists {
+		for _, c := range certs {
+			if c == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *fakeHAProxy) showSSLCert(args []string) string {
+	if len(args) == 0 {
+		lines := []string{"# transaction"}
+		if p.certTx != nil {
+			lines = append(lines, "*"+p.certTx.name)
+		}
+		lines = append(lines, "# filename")
+		names := make([]string, 0, len(p.certs))
+		for name := range p.certs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		lines = append(lines, names...)
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	name := args[0]
+	content := ""
+	if txName, isTx := strings.CutPrefix(name, "*"); isTx {
+		if p.certTx == nil || p.certTx.name != txName {
+			return "No ongoing transaction!\n"
+		}
+		name, content = txName, p.certTx.pem
+	} else {
+		cert, ok := p.certs[name]
+		if !ok {
+			return "Can't display the certificate: Not found or the certificate is a bundle!\n"
+		}
+		content = cert.pem
+	}
+
+	status := "Unused"
+	if p.certInUse(name) {
+		status = "Used"
+	}
+	lines := []string{"Filename: " + name, "Status: " + status}
+	if cert := parseCertificate(content); cert != nil {
+		fingerprint := sha1.Sum(cert.Raw)
+		lines = append(lines,
+			"Serial: "+strings.ToUpper(cert.SerialNumber.Text(16)),
+			"notBefore: "+cert.NotBefore.UTC().Format(certTimeFormat),
+			"notAfter: "+cert.NotAfter.UTC().Format(certTimeFormat),
+		)
+		if len(cert.DNSNames) > 0 {
+			lines = append(lines, "Subject Alternative Name: DNS:"+strings.Join(cert.DNSNames, ", DNS:"))
+		}
+		lines = append(lines,
+			"SHA1 FingerPrint: "+strings.ToUpper(hex.EncodeToString(fingerprint[:])),
+			"Subject: /CN="+cert.Subject.CommonName,
+			"Issuer: /CN="+cert.Issuer.CommonName,
+		)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// parseCertificate returns the first certificate of a pem content, if any.
+func parseCertificate(content string) *x509.Certificate {
+	rest := []byte(content)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		return cert
+	}
+}
+
+func (p *fakeHAProxy) newSSLCert(args []string) string {
+	if len(args) != 1 {
+		return "'new ssl cert' expects a filename\n"
+	}
+	name := args[0]
+	if _, ok := p.certs[name]; ok {
+		return fmt.Sprintf("Certificate '%s' already exists!\n", name)
+	}
+	p.certs[name] = &fakeCert{}
+	return fmt.Sprintf("New empty certificate store '%s'!\n", name)
+}
+
+func (p *fakeHAProxy) setSSLCert(args, payload []string) string {
+	if len(args) != 1 || len(payload) == 0 {
+		return "'set ssl cert' expects a filename and a certificate as a payload\n"
+	}
+	name := args[0]
+	if _, ok := p.certs[name]; !ok {
+		return "Can't replace a certificate which is not referenced by the configuration!\n"
+	}
+	if p.certTx != nil && p.certTx.name != name {
+		return fmt.Sprintf("The ongoing transaction is about '%s' but you are trying to set '%s'\n", p.certTx.name, name)
+	}
+
+	content := strings.Join(payload, "\n") + "\n"
+	if parseCertificate(content) == nil {
+		return fmt.Sprintf("unable to load certificate from file '%s'.\nCan't update %s!\n", path.Base(name), name)
+	}
+
+	if p.certTx != nil {
+		p.certTx.pem = content
+		return fmt.Sprintf("Transaction updated for certificate %s!\n", name)
+	}
+	p.certTx = &fakeCertTransaction{name: name, pem: content}
+	return fmt.Sprintf("Transaction created for certificate %s!\n", name)
+}
+
+func (p *fakeHAProxy) commitSSLCert(args []string) string {
+	if len(args) != 1 {
+		return "'commit ssl cert' expects a filename\n"
+	}
+	name := args[0]
+	if p.certTx == nil {
+		return "No ongoing transaction! !\n"
+	}
+	if p.certTx.name != name {
+		return fmt.Sprintf("The ongoing transaction is about '%s' but you are trying to set '%s'\n", p.certTx.name, name)
+	}
+
+	p.certs[name] = &fakeCert{pem: p.certTx.pem}
+	p.certTx = nil
+	return fmt.Sprintf("Committing %s\nSuccess!\n", name)
+}
+
+func (p *fakeHAProxy) abortSSLCert(args []string) string {
+	if len(args) != 1 {
+		return "'abort ssl cert' expects a filename\n"
+	}
+	name := args[0]
+	if p.certTx == nil || p.certTx.name != name {
+		return "No ongoing transaction!\n"
+	}
+	p.certTx = nil
+	return fmt.Sprintf("Transaction aborted for certificate '%s'!\n", name)
+}
+
+func (p *fakeHAProxy) delSSLCert(args []string) string {
+	if len(args) != 1 {
+		return "'del ssl cert' expects a certificate name\n"
+	}
+	name := args[0]
+	if _, ok := p.certs[name]; !ok {
+		return fmt.Sprintf("certificate '%s' doesn't exist!\n", name)
+	}
+	if p.certTx != nil && p.certTx.name == name {
+		return "Can't suppress a certificate which is in a transaction!\n"
+	}
+	if p.certInUse(name) {
+		return fmt.Sprintf("certificate '%s' in use, can't be deleted!\n", name)
+	}
+	delete(p.certs, name)
+	return fmt.Sprintf("Certificate '%s' deleted!\n", name)
+}
+
+func (p *fakeHAProxy) showCrtList(args []string) string {
+	if len(args) == 0 {
+		names := make([]string, 0, len(p.crtLists))
+		for name := range p.crtLists {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, "\n") + "\n"
+	}
+
+	name := args[len(args)-1]
+	certs, ok := p.crtLists[name]
+	if !ok {
+		return fmt.Sprintf("didn't find the specified filename '%s'\n", name)
+	}
+	lines := []string{"# " + name}
+	lines = append(lines, certs...)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (p *fakeHAProxy) addCrtList(args []string) string {
+	if len(args) != 2 {
+		return "'add ssl crt-list' expects a filename and a certificate name\n"
+	}
+	list, name := args[0], args[1]
+	if _, ok := p.crtLists[list]; !ok {
+		return fmt.Sprintf("'%s' is not a valid crt-list or directory!\n", list)
+	}
+	cert, ok := p.certs[name]
+	if !ok {
+		return fmt.Sprintf("Can't edit the crt-list: certificate '%s' does not exist!\n", name)
+	}
+	if len(cert.pem) == 0 {
+		return fmt.Sprintf("Can't edit the crt-list: certificate '%s' is empty!\n", name)
+	}
+	p.crtLists[list] = append(p.crtLists[list], name)
+	return fmt.Sprintf("Inserting certificate '%s' in crt-list '%s'.\nSuccess!\n", name, list)
+}
+
+func (p *fakeHAProxy) delCrtList(args []string) string {
+	if len(args) != 2 {
+		return "'del ssl crt-list' expects a filename and a certificate name\n"
+	}
+	list, name := args[0], args[1]
+	certs, ok := p.crtLists[list]
+	if !ok {
+		return fmt.Sprintf("'%s' is not a valid crt-list or directory!\n", list)
+	}
+	for i, c := range certs {
+		if c == name {
+			p.crtLists[list] = append(certs[:i], certs[i+1:]...)
+			return fmt.Sprintf("Entry '%s' deleted in crtlist '%s'!\n", name, list)
+		}
+	}
+	return fmt.Sprintf("Can't delete the entry: '%s' not found in '%s'!\n", name, list)
+}
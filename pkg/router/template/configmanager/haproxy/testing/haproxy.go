@@ -1,12 +1,10 @@
 package testing
 
 import (
-	"bytes"
+	"bufio"
 	"fmt"
 	"net"
 	"os"
-	"path"
-	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -18,6 +16,20 @@ const (
 
 	serverName = "_dynamic-pod-1"
 
+	// commandSeparator separates the commands sent together on one line.
+	commandSeparator = ";"
+
+	// bufferSize is the default haproxy tune.bufsize. haproxy cannot read
+	// a longer command line and closes the connection instead.
+	bufferSize = 16384
+
+	// payloadMarker ends a command line followed by a payload. The
+	// payload ends with an empty line.
+	payloadMarker = "<<"
+
+	// unknownCommandResponse is the response to an unsupported command.
+	unknownCommandResponse = "Unknown command. Please enter one of the following commands only :\nhelp\n...\n"
+
 	OnePodAndOneDynamicServerBackendTemplate = `1
 # be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port
 9 %s 1 pod:test-1-l8x8w:test-service:172.17.0.3:1234 172.17.0.3 2 4 256 1 8117 6 3 4 6 0 0 0 - 1234
@@ -25,24 +37,52 @@ const (
 `
 )
 
-type haproxyMapEntry struct {
-	id, key, value string
-}
+// commandFailure is a failure injected in the commands starting with a
+// prefix.
+type commandFailure struct {
+	prefix string
+
+	// response is sent instead of running the command, if not dropping.
+	response string
 
-type CustomHAProxyMap struct {
-	Name    string
-	Entries []haproxyMapEntry
+	// drop runs the command but closes the connection without sending
+	// the response back, as if haproxy was stopped meanwhile.
+	drop bool
+
+	// times is the number of commands left to fail, negative for always.
+	times int
 }
 
+// fakeHAProxy simulates the haproxy runtime API on a unix socket. It
+// models the backends and their servers, the maps and their versions, the
+// ssl certificates and the statistics of a running haproxy, and records
+// every command it receives. Failures and latency can be injected to
+// exercise the error handling of its clients.
 type fakeHAProxy struct {
 	socketFile  string
 	backendName string
+
+	lock     sync.Mutex
+	listener *net.UnixListener
+	stopped  bool
+	commands []string
+
+	pid     int
+	started time.Time
+
+	frontends []*fakeFrontend
+	backends  []*fakeBackend
+
 	maps        map[string][]haproxyMapEntry
 	pendingMaps map[string][]haproxyMapEntry
-	backends    map[string]string
-	lock        sync.Mutex
-	shutdown    bool
-	commands    []string
+	mapVersions map[string]int
+
+	certs    map[string]*fakeCert
+	certTx   *fakeCertTransaction
+	crtLists map[string][]string
+
+	failures []*commandFailure
+	latency  time.Duration
 }
 
 func startFakeHAProxyServer(prefix string) (*fakeHAProxy, error) {
@@ -74,11 +114,8 @@ func newFakeHAProxy(sockFile, backendName string) *fakeHAProxy {
 	p := &fakeHAProxy{
 		socketFile:  sockFile,
 		backendName: backendName,
-		maps:        make(map[string][]haproxyMapEntry, 0),
-		pendingMaps: make(map[string][]haproxyMapEntry, 0),
-		backends:    make(map[string]string, 0),
-		shutdown:    false,
 		commands:    make([]string, 0),
+		pid:         84,
 	}
 	p.initialize()
 	return p
@@ -90,417 +127,357 @@ func (p *fakeHAProxy) SocketFile() string {
 	return p.socketFile
 }
 
+// Reset clears the recorded commands and the injected failures, and
+// restores the initial haproxy state.
 func (p *fakeHAProxy) Reset() {
 	p.lock.Lock()
 	p.commands = make([]string, 0)
-	p.maps = make(map[string][]haproxyMapEntry)
-	p.pendingMaps = make(map[string][]haproxyMapEntry)
+	p.failures = nil
+	p.latency = 0
 	p.lock.Unlock()
 	p.initialize()
 }
 
+// Commands returns the commands received so far. The commands sent
+// together are recorded one by one.
 func (p *fakeHAProxy) Commands() []string {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	return p.commands
+	return append([]string(nil), p.commands...)
 }
 
 func (p *fakeHAProxy) Start() {
-	started := make(chan bool)
-	listenErr := make(chan error, 1)
-	go func() error {
-		listener, err := net.Listen("unix", p.socketFile)
-		if err != nil {
-			listenErr <- err
-			return err
-		}
+	if err := p.listen(); err != nil {
+		panic(fmt.Sprintf("fakeHAProxy: failed to listen on %s: %v", p.socketFile, err))
+	}
+}
+
+// listen starts accepting connections on the socket file.
+func (p *fakeHAProxy) listen() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// A stale socket file is left behind by a reload.
+	os.Remove(p.socketFile)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: p.socketFile, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	p.listener = listener
 
-		started <- true
+	go func() {
 		for {
-			p.lock.Lock()
-			shutdown := p.shutdown
-			p.lock.Unlock()
-			if shutdown {
-				return nil
-			}
 			conn, err := listener.Accept()
 			if err != nil {
-				return err
+				return
 			}
 			go p.process(conn)
 		}
 	}()
-
-	// wait for server to indicate it started up or failed.
-	select {
-	case <-started:
-	case err := <-listenErr:
-		panic(fmt.Sprintf("fakeHAProxy: failed to listen on %s: %v", p.socketFile, err))
-	}
+	return nil
 }
 
 func (p *fakeHAProxy) Stop() {
-	p.lock.Lock()
-	p.shutdown = true
-	sockFile := p.socketFile
-	p.lock.Unlock()
-	go func() {
-		timeout := time.Duration(10) * time.Second
-		net.DialTimeout("unix", sockFile, timeout)
-		if len(sockFile) > 0 {
-			os.Remove(sockFile)
-		}
-	}()
-}
-
-func (p *fakeHAProxy) SetCustomMap(filename string, lines []string) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	var entries []haproxyMapEntry
-	for _, line := range lines {
-		params := strings.SplitN(line, " ", 3)
-		entries = append(entries, haproxyMapEntry{
-			id:    params[0],
-			key:   params[1],
-			value: params[2],
-		})
+	p.stopped = true
+	if p.listener != nil {
+		p.listener.Close()
+		p.listener = nil
 	}
-	p.maps[filename] = entries
-}
-
-func (p *fakeHAProxy) ReadMapContent(filename string) []string {
-	p.lock.Lock()
-	defer p.lock.Unlock()
-	var response []string
-	for _, entry := range p.maps[filename] {
-		response = append(response, entry.id+" "+entry.key+" "+entry.value)
+	if len(p.socketFile) > 0 {
+		os.Remove(p.socketFile)
 	}
-	return response
 }
 
-func (p *fakeHAProxy) initialize() {
-	redirectMap := []haproxyMapEntry{
-		{id: "0x559a137bb720", key: `^route\.edge\.test(:[0-9]+)?(/.*)?$`, value: `be_edge_http:ns1:edge-redirect-to-https`},
-		{id: "0x559a137bb7e0", key: `^redirect\.blueprints\.test(:[0-9]+)?(/.*)?$`, value: `be_edge_http:blueprints:blueprint-redirect-to-https`},
-	}
-
-	passthruMap := []haproxyMapEntry{
-		{id: "0x559a137bf730", key: `^route\.passthrough\.test(:[0-9]+)?(/.*)?$`, value: `1`},
-	}
-
-	httpMap := []haproxyMapEntry{
-		{id: "0x559a137b4c10", key: `^route\.allow-http\.test(:[0-9]+)?(/.*)?$`, value: `be_edge_http:default:test-http-allow`},
+// Reload simulates a reload of haproxy: the socket refuses connections for
+// the downtime, and the new process starts with fresh statistics, no
+// ongoing map or certificate transaction and no connection to the servers.
+// The dynamic changes made to the servers and maps are kept, as the router
+// writes them to the new configuration as well. The socket listens again
+// once the downtime elapsed.
+func (p *fakeHAProxy) Reload(downtime time.Duration) {
+	p.lock.Lock()
+	if p.listener != nil {
+		// Keep the socket file so that connections are refused.
+		p.listener.SetUnlinkOnClose(false)
+		p.listener.Close()
+		p.listener = nil
 	}
-
-	tcpMap := []haproxyMapEntry{
-		{id: "0x559a137b4700", key: `^route\.reencrypt\.test(:[0-9]+)?(/.*)?$`, value: ` be_secure:default:test-reencrypt`},
-		{id: "0x559a1400f8a0", key: `^reencrypt\.blueprints\.org(:[0-9]+)?(/.*)?$`, value: `be_secure:blueprints:blueprint-reencrypt`},
-		{id: "0x559a1400f960", key: `^route\.passthrough\.test(:[0-9]+)?(/.*)?$`, value: `be_tcp:default:test-passthrough`},
+	p.pid++
+	p.started = time.Now()
+	p.pendingMaps = make(map[string][]haproxyMapEntry)
+	p.certTx = nil
+	for _, fe := range p.frontends {
+		fe.stats = make(map[string]int64)
 	}
-
-	edgeReencryptMap := []haproxyMapEntry{
-		{id: "0x559a140103e0", key: `^www\.example2\.com(:[0-9]+)?(/.*)?$`, value: `be_edge_http:default:example-route`},
-		{id: "0x559a14010450", key: `^something\.edge\.test(:[0-9]+)?(/.*)?$`, value: `be_edge_http:default:wildcard-redirect-to-https`},
-		{id: "0x559a14010510", key: `^route\.reencrypt\.test(:[0-9]+)?(/.*)?$`, value: ` be_secure:default:test-reencrypt`},
-		{id: "0x559a140105c0", key: `^reencrypt\.blueprints\.org(:[0-9]+)?(/.*)?$`, value: `be_secure:blueprints:blueprint-reencrypt`},
-		{id: "0x559a140109a0", key: `^redirect\.blueprints\.org(:[0-9]+)?(/.*)?$`, value: `be_edge_http:default:test-https`},
-		{id: "0x559a140109a0", key: `^route\.edge\.test(:[0-9]+)?(/.*)?$`, value: `be_edge_http:default:test-https`},
+	for _, be := range p.backends {
+		be.stats = make(map[string]int64)
+		for _, s := range be.servers {
+			s.stats = make(map[string]int64)
+			s.connections = 0
+		}
 	}
+	p.lock.Unlock()
 
-	mapNames := map[string][]haproxyMapEntry{
-		"os_route_http_redirect.map": redirectMap,
-		"os_sni_passthrough.map":     passthruMap,
-		"os_http_be.map":             httpMap,
-		"os_tcp_be.map":              tcpMap,
-		"os_edge_reencrypt_be.map":   edgeReencryptMap,
-	}
+	time.AfterFunc(downtime, func() {
+		p.lock.Lock()
+		stopped := p.stopped
+		p.lock.Unlock()
+		if !stopped {
+			if err := p.listen(); err != nil {
+				panic(fmt.Sprintf("fakeHAProxy: failed to listen on %s after reload: %v", p.socketFile, err))
+			}
+		}
+	})
+}
 
+// FailCommand responds to the next commands starting with prefix with the
+// given response instead of running them. times is the number of commands
+// to fail, negative to fail all of them.
+func (p *fakeHAProxy) FailCommand(prefix, response string, times int) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	for k, v := range mapNames {
-		name := path.Join(haproxyConfigDir, k)
-		p.maps[name] = v
-	}
-}
-
-func (p *fakeHAProxy) showInfo() string {
-	return `Name: HAProxy
-Version: 1.8.1
-Release_date: 2017/12/03
-Nbproc: 1
-Process_num: 1
-Pid: 84
-Uptime: 0d5h23m33s
-Uptime_sec: 19413
-Memmax_MB: 0
-PoolAlloc_MB: 0
-PoolUsed_MB: 0
-PoolFailed: 0
-Ulimit-n: 40260
-Maxsock: 40260
-Maxconn: 20000
-Hard_maxconn: 20000
-CurrConns: 0
-CumConns: 3945
-CumReq: 3947
-MaxSslConns: 0
-CurrSslConns: 0
-CumSslConns: 7765
-Maxpipes: 0
-PipesUsed: 0
-PipesFree: 0
-ConnRate: 0
-ConnRateLimit: 0
-MaxConnRate: 2
-SessRate: 0
-SessRateLimit: 0
-MaxSessRate: 2
-SslRate: 0
-SslRateLimit: 0
-MaxSslRate: 1
-SslFrontendKeyRate: 0
-SslFrontendMaxKeyRate: 1
-SslFrontendSessionReuse_pct: 0
-SslBackendKeyRate: 0
-SslBackendMaxKeyRate: 2
-SslCacheLookups: 0
-SslCacheMisses: 0
-CompressBpsIn: 0
-CompressBpsOut: 0
-CompressBpsRateLim: 0
-ZlibMemUsage: 0
-MaxZlibMemUsage: 0
-Tasks: 278
-Run_queue: 0
-Idle_pct: 100
-node: f27
-`
-}
-
-func (p *fakeHAProxy) listMaps() string {
-	return `# id (file) description
-1 (/var/lib/haproxy/conf/os_route_http_redirect.map) pattern loaded from file '/var/lib/haproxy/conf/os_route_http_redirect.map' used by map at file '/var/lib/haproxy/conf/haproxy.config' line 68
-5 (/var/lib/haproxy/conf/os_sni_passthrough.map) pattern loaded from file '/var/lib/haproxy/conf/os_sni_passthrough.map' used by map at file '/var/lib/haproxy/conf/haproxy.config' line 87
--1 (/var/lib/haproxy/conf/os_http_be.map) pattern loaded from file '/var/lib/haproxy/conf/os_http_be.map' used by map at file '/var/lib/haproxy/conf/haproxy.config' line 71
--1 (/var/lib/haproxy/conf/os_tcp_be.map) pattern loaded from file '/var/lib/haproxy/conf/os_tcp_be.map' used by map at file '/var/lib/haproxy/conf/haproxy.config' line 88
--1 (/var/lib/haproxy/conf/os_edge_reencrypt_be.map) pattern loaded from file '/var/lib/haproxy/conf/os_edge_reencrypt_be.map' used by map at file '/var/lib/haproxy/conf/haproxy.config' line 127, by map at file '/var/lib/haproxy/conf/haproxy.config' line 163
-
-`
+	p.failures = append(p.failures, &commandFailure{prefix: prefix, response: response, times: times})
 }
 
-func (p *fakeHAProxy) showMap(name string) string {
-	lines := []string{}
+// DropResponse runs the next commands starting with prefix but closes the
+// connection without responding. times is the number of commands to drop
+// the response of, negative to drop all of them.
+func (p *fakeHAProxy) DropResponse(prefix string, times int) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	if m, ok := p.maps[name]; ok {
-		for _, v := range m {
-			lines = append(lines, v.id+" "+v.key+" "+v.value)
-		}
-	} else {
-		lines = append(lines, "Unknown map identifier. Please use #<id> or <file>.")
-		lines = append(lines, "")
-	}
-
-	return strings.Join(lines, "\n")
-}
-
-func (p *fakeHAProxy) prepareMap() string {
-	return "New version created: 1\n"
+	p.failures = append(p.failures, &commandFailure{prefix: prefix, drop: true, times: times})
 }
 
-func (p *fakeHAProxy) addMapPayload(name string, newlines []string) string {
+// SetLatency delays the response of every command by latency.
+func (p *fakeHAProxy) SetLatency(latency time.Duration) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
+	p.latency = latency
+}
 
-	var response []string
-	if _, ok := p.maps[name]; !ok {
-		response = append(response, "Unknown map identifier. Please use #<id> or <file>.")
-		response = append(response, "")
-	} else {
-		m := make([]haproxyMapEntry, 0, len(newlines))
-		for _, line := range newlines {
-			key, value, _ := strings.Cut(line, " ")
-			id := strconv.Itoa(len(m) + 1)
-			m = append(m, haproxyMapEntry{id: id, key: key, value: value})
+// failure returns the failure injected in a command, if any. Must be called
+// while holding p.lock.
+func (p *fakeHAProxy) failure(cmd string) *commandFailure {
+	for i, f := range p.failures {
+		if !strings.HasPrefix(cmd, f.prefix) {
+			continue
+		}
+		if f.times > 0 {
+			f.times--
+			if f.times == 0 {
+				p.failures = append(p.failures[:i], p.failures[i+1:]...)
+			}
 		}
-		p.pendingMaps[name] = m
-		response = append(response, "")
+		return f
 	}
-
-	return strings.Join(response, "\n")
+	return nil
 }
 
-func (p *fakeHAProxy) commitMap(name string) string {
+// initialize sets the initial haproxy state.
+func (p *fakeHAProxy) initialize() {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	entries, found := p.pendingMaps[name]
-	if !found {
-		return "Unknown map identifier. Please use #<id> or <file>."
-	}
-	p.maps[name] = entries
-	delete(p.pendingMaps, name)
-	return "\n"
-}
-
-func (p *fakeHAProxy) listBackends() string {
-	return `# name
-be_sni
-be_no_sni
-openshift_default
-be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1
-be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-2
-be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-3
-be_http:_hapcm_blueprint_pool:_blueprint-http-route-1
-be_http:_hapcm_blueprint_pool:_blueprint-http-route-2
-be_http:_hapcm_blueprint_pool:_blueprint-http-route-3
-be_tcp:_hapcm_blueprint_pool:_blueprint-passthrough-route-1
-be_tcp:_hapcm_blueprint_pool:_blueprint-passthrough-route-2
-be_tcp:_hapcm_blueprint_pool:_blueprint-passthrough-route-3
-be_edge_http:blueprints:blueprint-redirect-to-https
-be_secure:blueprints:blueprint-reencrypt
-be_edge_http:default:example-route
-be_edge_http:default:test-http-allow
-be_edge_http:default:test-https
-be_edge_http:default:test-https-only
-be_tcp:default:test-passthrough
-be_secure:default:test-reencrypt
-be_edge_http:default:wildcard-redirect-to-https
-`
+	p.started = time.Now()
+	p.initializeBackends()
+	p.initializeMaps()
+	p.initializeCerts()
 }
 
-func (p *fakeHAProxy) showServers(name string) string {
-	p.lock.Lock()
-	defer p.lock.Unlock()
-
-	onePodAndOneDynamicServerBackends := map[string]string{
-		"be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1": "",
-		"be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-2": "",
-		"be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-3": "",
-
-		"be_http:_hapcm_blueprint_pool:_blueprint-http-route-1": "",
-		"be_http:_hapcm_blueprint_pool:_blueprint-http-route-2": "",
-		"be_http:_hapcm_blueprint_pool:_blueprint-http-route-3": "",
-
-		"be_tcp:_hapcm_blueprint_pool:_blueprint-passthrough-route-1": "",
-		"be_tcp:_hapcm_blueprint_pool:_blueprint-passthrough-route-2": "",
-		"be_tcp:_hapcm_blueprint_pool:_blueprint-passthrough-route-3": "",
-
-		"be_edge_http:blueprints:blueprint-redirect-to-https": "",
-		"be_secure:blueprints:blueprint-reencrypt":            "",
-		"be_edge_http:default:example-route":                  "",
-
-		"be_edge_http:default:test-http-allow": "",
-		"be_edge_http:default:test-https":      "",
-		"be_edge_http:default:test-https-only": "",
-
-		"be_tcp:default:test-passthrough":  "",
-		"be_secure:default:test-reencrypt": "",
-
-		"be_edge_http:default:wildcard-redirect-to-https": "",
+// readCommand reads a command line from a connection, and its payload if
+// any.
+func readCommand(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return "", err
+	}
+	line = strings.Trim(strings.TrimRight(line, "\r\n"), " ")
+	if !strings.HasSuffix(line, payloadMarker) {
+		return line, nil
 	}
 
-	if name != p.backendName {
-		if _, ok := onePodAndOneDynamicServerBackends[name]; ok {
-			return fmt.Sprintf(OnePodAndOneDynamicServerBackendTemplate, name, name)
+	lines := []string{line}
+	for {
+		payloadLine, err := r.ReadString('\n')
+		payloadLine = strings.TrimRight(payloadLine, "\r\n")
+		if len(payloadLine) == 0 {
+			break
 		}
-		if len(name) > 0 {
-			return fmt.Sprintf("Can't find backend.\n")
+		lines = append(lines, payloadLine)
+		if err != nil {
+			break
 		}
 	}
-
-	return `1
-# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port
-9 be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1 1 _dynamic-pod-1 172.17.0.3 2 4 256 1 8117 6 3 4 6 0 0 0 - 8080
-9 be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1 2 _dynamic-pod-2 172.17.0.3 2 5 256 1 8117 6 3 0 14 0 0 0 - 8080
-9 be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1 3 _dynamic-pod-3 172.4.0.4 0 5 1 1 8206 1 0 0 14 0 0 0 - 8765
-9 be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1 4 _dynamic-pod-4 172.4.0.4 0 5 1 1 8206 1 0 0 14 0 0 0 - 8765
-9 be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1 5 _dynamic-pod-5 172.17.0.2 2 4 256 1 8206 6 3 4 6 0 0 0 - 8080
-`
+	return strings.Join(lines, "\n"), nil
 }
 
-func (p *fakeHAProxy) setServer(name string, options []string) string {
-	if len(name) == 0 {
-		return fmt.Sprintf("Require 'backend/server'.\n")
+// splitCommands splits a command line into the commands sent together. The
+// payload, if any, belongs to the last command.
+func splitCommands(cmd string) []string {
+	line, payload, hasPayload := strings.Cut(cmd, "\n")
+	var cmds []string
+	for _, c := range strings.Split(line, commandSeparator) {
+		if c = strings.TrimSpace(c); len(c) > 0 {
+			cmds = append(cmds, c)
+		}
 	}
-
-	p.lock.Lock()
-	defer p.lock.Unlock()
-	existingServer := fmt.Sprintf("%s/%s", p.backendName, serverName)
-	if name != existingServer {
-		return fmt.Sprintf("No such server.\n")
+	if hasPayload && len(cmds) > 0 {
+		cmds[len(cmds)-1] += "\n" + payload
 	}
-
-	return fmt.Sprintf("\n")
+	return cmds
 }
 
 func (p *fakeHAProxy) process(conn net.Conn) error {
-	// making it simple by using a large buffer instead of
-	// handle the end of the reading of an open stream
-	const bufsize = 4096
-	readBuffer := make([]byte, bufsize)
-	nread, err := conn.Read(readBuffer)
-	if nread == bufsize {
-		return fmt.Errorf("response larger than buffer")
-	}
+	defer conn.Close()
+
+	cmd, err := readCommand(bufio.NewReader(conn))
 	if err != nil {
 		response := fmt.Sprintf("error: %v", err)
 		conn.Write([]byte(response))
 		return err
 	}
+	if line, _, _ := strings.Cut(cmd, "\n"); len(line) >= bufferSize {
+		return fmt.Errorf("command line of %d bytes exceeds the buffer", len(line))
+	}
+
+	cmds := splitCommands(cmd)
+	responses := make([]string, 0, len(cmds))
+	drop := false
 
-	response := ""
-	cmd := string(bytes.Trim(readBuffer[0:nread], " "))
-	cmd = strings.Trim(cmd, "\n")
 	p.lock.Lock()
-	p.commands = append(p.commands, cmd)
+	latency := p.latency
+	for _, c := range cmds {
+		p.commands = append(p.commands, c)
+		response := ""
+		failure := p.failure(c)
+		switch {
+		case failure == nil:
+			response = p.execute(c)
+		case failure.drop:
+			p.execute(c)
+			drop = true
+		default:
+			response = failure.response
+		}
+		responses = append(responses, response)
+	}
 	p.lock.Unlock()
 
-	if strings.HasPrefix(cmd, "show info") {
-		response = p.showInfo()
-	} else if strings.HasPrefix(cmd, "show map") {
-		name := strings.Trim(cmd[len("show map"):], " ")
-		if len(name) == 0 {
-			response = p.listMaps()
-		} else {
-			response = p.showMap(name)
-		}
-	} else if strings.HasPrefix(cmd, "show backend") {
-		response = p.listBackends()
-	} else if strings.HasPrefix(cmd, "prepare map") {
-		response = p.prepareMap()
-	} else if strings.HasPrefix(cmd, "add map @") {
-		lines := strings.Split(cmd, "\n")
-		params := strings.Split(strings.TrimPrefix(lines[0], "add map @"), " ")
-		if len(params) != 3 {
-			response = "'add map' expects three parameters (map identifier, key and value) or one parameter (map identifier) and a payload"
-		} else {
-			response = p.addMapPayload(params[1], lines[1:])
-		}
-	} else if strings.HasPrefix(cmd, "commit map @") {
-		params := strings.Split(strings.TrimPrefix(cmd, "commit map @"), " ")
-		if len(params) != 2 {
-			response = "Missing map identifier."
-		} else {
-			response = p.commitMap(params[1])
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if drop {
+		return nil
+	}
+
+	response := ""
+	if len(responses) == 1 {
+		response = responses[0]
+	} else {
+		// The output of each command ends with an empty line.
+		for _, r := range responses {
+			if r = strings.TrimRight(r, "\n"); len(r) > 0 {
+				response += r + "\n"
+			}
+			response += "\n"
 		}
-	} else if strings.HasPrefix(cmd, "show servers state") {
-		name := strings.Trim(cmd[len("show servers state"):], " ")
-		response = p.showServers(name)
-	} else if strings.HasPrefix(cmd, "set server") {
-		params := strings.Trim(cmd[len("set server"):], " ")
-		name := ""
-		vals := strings.Split(params, " ")
-		if len(vals) > 0 {
-			name = vals[0]
+	}
+
+	_, err = conn.Write([]byte(response))
+	return err
+}
+
+// execute runs a command and returns its response. Must be called while
+// holding p.lock.
+func (p *fakeHAProxy) execute(cmd string) string {
+	line, payload, _ := strings.Cut(cmd, "\n")
+	var lines []string
+	if len(payload) > 0 {
+		lines = strings.Split(payload, "\n")
+	}
+	args := strings.Fields(line)
+	if len(args) > 0 && args[len(args)-1] == payloadMarker {
+		args = args[:len(args)-1]
+	}
+
+	switch {
+	case hasArgs(args, "echo"):
+		return strings.Join(args[1:], " ") + "\n"
+	case hasArgs(args, "show", "info"):
+		return p.showInfo()
+	case hasArgs(args, "show", "stat", "typed"):
+		return p.showStat(true)
+	case hasArgs(args, "show", "stat"):
+		return p.showStat(false)
+	case hasArgs(args, "show", "map"):
+		if len(args) == 2 {
+			return p.listMaps()
 		}
-		response = p.setServer(name, vals[1:])
-	} else {
-		response = fmt.Sprintf("Unknown command. Please enter one of the following commands only :\nhelp\n...\n")
+		return p.showMap(args[len(args)-1])
+	case hasArgs(args, "show", "backend"):
+		return p.listBackends()
+	case hasArgs(args, "show", "servers", "state"):
+		return p.showServers(strings.Join(args[3:], " "))
+	case hasArgs(args, "prepare", "map"):
+		return p.prepareMap(strings.Join(args[2:], " "))
+	case hasArgs(args, "add", "map"):
+		return p.addMap(args[2:], lines)
+	case hasArgs(args, "del", "map"):
+		return p.delMap(args[2:])
+	case hasArgs(args, "set", "map"):
+		return p.setMap(args[2:])
+	case hasArgs(args, "clear", "map"):
+		return p.clearMap(args[2:])
+	case hasArgs(args, "commit", "map"):
+		return p.commitMap(args[2:])
+	case hasArgs(args, "set", "server"):
+		return p.setServer(args[2:])
+	case hasArgs(args, "add", "server"):
+		return p.addServer(args[2:])
+	case hasArgs(args, "del", "server"):
+		return p.delServer(args[2:])
+	case hasArgs(args, "enable", "server"), hasArgs(args, "disable", "server"):
+		return p.setServerMaint(args[2:], args[0] == "disable")
+	case hasArgs(args, "enable", "health"), hasArgs(args, "disable", "health"):
+		return p.setServerHealthCheck(args[2:], args[0] == "enable")
+	case hasArgs(args, "set", "dynamic-cookie-key", "backend"):
+		return p.setDynamicCookieKey(args[3:])
+	case hasArgs(args, "enable", "dynamic-cookie", "backend"), hasArgs(args, "disable", "dynamic-cookie", "backend"):
+		return p.setDynamicCookie(args[3:], args[0] == "enable")
+	case hasArgs(args, "show", "ssl", "cert"):
+		return p.showSSLCert(args[3:])
+	case hasArgs(args, "new", "ssl", "cert"):
+		return p.newSSLCert(args[3:])
+	case hasArgs(args, "set", "ssl", "cert"):
+		return p.setSSLCert(args[3:], lines)
+	case hasArgs(args, "commit", "ssl", "cert"):
+		return p.commitSSLCert(args[3:])
+	case hasArgs(args, "abort", "ssl", "cert"):
+		return p.abortSSLCert(args[3:])
+	case hasArgs(args, "del", "ssl", "cert"):
+		return p.delSSLCert(args[3:])
+	case hasArgs(args, "show", "ssl", "crt-list"):
+		return p.showCrtList(args[3:])
+	case hasArgs(args, "add", "ssl", "crt-list"):
+		return p.addCrtList(args[3:])
+	case hasArgs(args, "del", "ssl", "crt-list"):
+		return p.delCrtList(args[3:])
 	}
 
-	if _, err := conn.Write([]byte(response)); err != nil {
-		return err
+	return unknownCommandResponse
+}
+
+// hasArgs checks if a command starts with the given keywords.
+func hasArgs(args []string, keywords ...string) bool {
+	if len(args) < len(keywords) {
+		return false
+	}
+	for i, k := range keywords {
+		if args[i] != k {
+			return false
+		}
 	}
-	return conn.Close()
+	return true
 }
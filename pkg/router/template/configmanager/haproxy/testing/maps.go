@@ -0,0 +1,282 @@
+package testing
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// unknownMapResponse is the response to a command on an unknown map.
+const unknownMapResponse = "Unknown map identifier. Please use #<id> or <file>.\n"
+
+type haproxyMapEntry struct {
+	id, key, value string
+}
+
+type CustomHAProxyMap struct {
+	Name    string
+	Entries []haproxyMapEntry
+}
+
+func (p *fakeHAProxy) SetCustomMap(filename string, lines []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var entries []haproxyMapEntry
+	for _, line := range lines {
+		params := strings.SplitN(line, " ", 3)
+		entries = append(entries, haproxyMapEntry{
+			id:    params[0],
+			key:   params[1],
+			value: params[2],
+		})
+	}
+	p.maps[filename] = entries
+}
+
+func (p *fakeHAProxy) ReadMapContent(filename string) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var response []string
+	for _, entry := range p.maps[filename] {
+		response = append(response, entry.id+" "+entry.key+" "+entry.value)
+	}
+	return response
+}
+
+// MapVersion returns the last version prepared of a map.
+func (p *fakeHAProxy) MapVersion(name string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.mapVersions[name]
+}
+
+// initializeMaps sets the initial maps. Must be called while holding p.lock.
+func (p *fakeHAProxy) initializeMaps() {
+	redirectMap := []haproxyMapEntry{
+		{id: "0x559a137bb720", key: `^route\.edge\.test(:[0-9]+)?(/.*)?$`, value: `be_edge_http:ns1:edge-redirect-to-https`},
+		{id: "0x559a137bb7e0", key: `^redirect\.blueprints\.test(:[0-9]+)?(/.*)?$`, value: `be_edge_http:blueprints:blueprint-redirect-to-https`},
+	}
+
+	passthruMap := []haproxyMapEntry{
+		{id: "0x559a137bf730", key: `^route\.passthrough\.test(:[0-9]+)?(/.*)?$`, value: `1`},
+	}
+
+	httpMap := []haproxyMapEntry{
+		{id: "0x559a137b4c10", key: `^route\.allow-http\.test(:[0-9]+)?(/.*)?$`, value: `be_edge_http:default:test-http-allow`},
+	}
+
+	tcpMap := []haproxyMapEntry{
+		{id: "0x559a137b4700", key: `^route\.reencrypt\.test(:[0-9]+)?(/.*)?$`, value: ` be_secure:default:test-reencrypt`},
+		{id: "0x559a1400f8a0", key: `^reencrypt\.blueprints\.org(:[0-9]+)?(/.*)?$`, value: `be_secure:blueprints:blueprint-reencrypt`},
+		{id: "0x559a1400f960", key: `^route\.passthrough\.test(:[0-9]+)?(/.*)?$`, value: `be_tcp:default:test-passthrough`},
+	}
+
+	edgeReencryptMap := []haproxyMapEntry{
+		{id: "0x559a140103e0", key: `^www\.example2\.com(:[0-9]+)?(/.*)?$`, value: `be_edge_http:default:example-route`},
+		{id: "0x559a14010450", key: `^something\.edge\.test(:[0-9]+)?(/.*)?$`, value: `be_edge_http:default:wildcard-redirect-to-https`},
+		{id: "0x559a14010510", key: `^route\.reencrypt\.test(:[0-9]+)?(/.*)?$`, value: ` be_secure:default:test-reencrypt`},
+		{id: "0x559a140105c0", key: `^reencrypt\.blueprints\.org(:[0-9]+)?(/.*)?$`, value: `be_secure:blueprints:blueprint-reencrypt`},
+		{id: "0x559a140109a0", key: `^redirect\.blueprints\.org(:[0-9]+)?(/.*)?$`, value: `be_edge_http:default:test-https`},
+		{id: "0x559a140109a0", key: `^route\.edge\.test(:[0-9]+)?(/.*)?$`, value: `be_edge_http:default:test-https`},
+	}
+
+	mapNames := map[string][]haproxyMapEntry{
+		"os_route_http_redirect.map": redirectMap,
+		"os_sni_passthrough.map":     passthruMap,
+		"os_http_be.map":             httpMap,
+		"os_tcp_be.map":              tcpMap,
+		"os_edge_reencrypt_be.map":   edgeReencryptMap,
+	}
+
+	p.maps = make(map[string][]haproxyMapEntry)
+	p.pendingMaps = make(map[string][]haproxyMapEntry)
+	p.mapVersions = make(map[string]int)
+	for k, v := range mapNames {
+		name := path.Join(haproxyConfigDir, k)
+		p.maps[name] = v
+	}
+}
+
+func (p *fakeHAProxy) listMaps() string {
+	names := make([]string, 0, len(p.maps))
+	for name := range p.maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{"# id (file) description"}
+	for i, name := range names {
+		lines = append(lines, fmt.Sprintf("%d (%s) pattern loaded from file '%s' used by map at file '%s' line %d",
+			i+1, name, name, path.Join(haproxyConfigDir, "haproxy.config"), 60+i))
+	}
+	return strings.Join(lines, "\n") + "\n\n"
+}
+
+func (p *fakeHAProxy) showMap(name string) string {
+	m, ok := p.maps[name]
+	if !ok {
+		return unknownMapResponse
+	}
+
+	lines := []string{}
+	for _, v := range m {
+		lines = append(lines, v.id+" "+v.key+" "+v.value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mapVersion parses an optional "@<version>" map command argument,
+// returning the remaining arguments. Must be called while holding p.lock.
+func (p *fakeHAProxy) mapVersion(args []string) (version string, rest []string) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "@") {
+		return strings.TrimPrefix(args[0], "@"), args[1:]
+	}
+	return "", args
+}
+
+// checkMapVersion checks that a version is the last prepared one of a map,
+// returning the error response otherwise. Must be called while holding
+// p.lock.
+func (p *fakeHAProxy) checkMapVersion(name, version string) string {
+	if _, ok := p.maps[name]; !ok {
+		return unknownMapResponse
+	}
+	if p.mapVersions[name] == 0 || version != strconv.Itoa(p.mapVersions[name]) {
+		return "Unknown version specified.\n"
+	}
+	return ""
+}
+
+func (p *fakeHAProxy) prepareMap(name string) string {
+	if _, ok := p.maps[name]; !ok {
+		return unknownMapResponse
+	}
+
+	// A new version discards the uncommitted content of the previous one.
+	p.mapVersions[name]++
+	p.pendingMaps[name] = nil
+	return fmt.Sprintf("New version created: %d\n", p.mapVersions[name])
+}
+
+func (p *fakeHAProxy) addMap(args, payload []string) string {
+	version, args := p.mapVersion(args)
+	if len(args) != 1 && len(args) != 3 {
+		return "'add map' expects three parameters (map identifier, key and value) or one parameter (map identifier) and a payload\n"
+	}
+	name := args[0]
+	if _, ok := p.maps[name]; !ok {
+		return unknownMapResponse
+	}
+
+	lines := payload
+	if len(args) == 3 {
+		lines = []string{args[1] + " " + args[2]}
+	}
+
+	if len(version) == 0 {
+		for _, line := range lines {
+			key, value, _ := strings.Cut(line, " ")
+			id := fmt.Sprintf("0x%x", len(p.maps[name])+1)
+			p.maps[name] = append(p.maps[name], haproxyMapEntry{id: id, key: key, value: value})
+		}
+		return "\n"
+	}
+
+	if errResponse := p.checkMapVersion(name, version); errResponse != "" {
+		return errResponse
+	}
+	m := p.pendingMaps[name]
+	for _, line := range lines {
+		key, value, _ := strings.Cut(line, " ")
+		id := strconv.Itoa(len(m) + 1)
+		m = append(m, haproxyMapEntry{id: id, key: key, value: value})
+	}
+	p.pendingMaps[name] = m
+	return "\n"
+}
+
+func (p *fakeHAProxy) delMap(args []string) string {
+	if len(args) != 2 {
+		return "This command expects two parameters: map identifier and key.\n"
+	}
+	m, ok := p.maps[args[0]]
+	if !ok {
+		return unknownMapResponse
+	}
+
+	var entries []haproxyMapEntry
+	for _, e := range m {
+		if e.key != args[1] && e.id != strings.TrimPrefix(args[1], "#") {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == len(m) {
+		return "Key not found.\n"
+	}
+	p.maps[args[0]] = entries
+	return "\n"
+}
+
+func (p *fakeHAProxy) setMap(args []string) string {
+	if len(args) != 3 {
+		return "'set map' expects three parameters: map identifier, key and value.\n"
+	}
+	m, ok := p.maps[args[0]]
+	if !ok {
+		return unknownMapResponse
+	}
+
+	found := false
+	for i, e := range m {
+		if e.key == args[1] || e.id == strings.TrimPrefix(args[1], "#") {
+			m[i].value = args[2]
+			found = true
+		}
+	}
+	if !found {
+		return "Unable to find key\n"
+	}
+	return "\n"
+}
+
+func (p *fakeHAProxy) clearMap(args []string) string {
+	version, args := p.mapVersion(args)
+	if len(args) != 1 {
+		return "Missing map identifier.\n"
+	}
+	name := args[0]
+	if _, ok := p.maps[name]; !ok {
+		return unknownMapResponse
+	}
+
+	if len(version) == 0 {
+		p.maps[name] = nil
+		return "\n"
+	}
+	if errResponse := p.checkMapVersion(name, version); errResponse != "" {
+		return errResponse
+	}
+	p.pendingMaps[name] = nil
+	return "\n"
+}
+
+func (p *fakeHAProxy) commitMap(args []string) string {
+	version, args := p.mapVersion(args)
+	if len(version) == 0 || len(args) != 1 {
+		return "Missing map identifier.\n"
+	}
+	name := args[0]
+	if errResponse := p.checkMapVersion(name, version); errResponse != "" {
+		return errResponse
+	}
+
+	entries, found := p.pendingMaps[name]
+	if !found {
+		return "Unknown version specified.\n"
+	}
+	p.maps[name] = entries
+	delete(p.pendingMaps, name)
+	return "\n"
+}
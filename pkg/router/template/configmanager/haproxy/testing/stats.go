@@ -0,0 +1,295 @@
+package testing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Proxy types of the "show stat" rows.
+	statTypeFrontend = "0"
+	statTypeBackend  = "1"
+	statTypeServer   = "2"
+
+	// StatFrontend and StatBackend are the service names of the frontend
+	// and backend "show stat" rows of a proxy.
+	StatFrontend = "FRONTEND"
+	StatBackend  = "BACKEND"
+)
+
+// statFields are the "show stat" fields.
+var statFields = []string{
+	"pxname", "svname", "qcur", "qmax", "scur", "smax", "slim", "stot", "bin", "bout",
+	"dreq", "dresp", "ereq", "econ", "eresp", "wretr", "wredis", "status", "weight", "act",
+	"bck", "chkfail", "chkdown", "lastchg", "downtime", "qlimit", "pid", "iid", "sid", "throttle",
+	"lbtot", "tracked", "type", "rate", "rate_lim", "rate_max", "check_status", "check_code", "check_duration", "hrsp_1xx",
+	"hrsp_2xx", "hrsp_3xx", "hrsp_4xx", "hrsp_5xx", "hrsp_other", "hanafail", "req_rate", "req_rate_max", "req_tot", "cli_abrt",
+	"srv_abrt", "comp_in", "comp_out", "comp_byp", "comp_rsp", "lastsess", "last_chk", "last_agt", "qtime", "ctime",
+	"rtime", "ttime", "agent_status", "agent_code", "agent_duration", "check_desc", "agent_desc", "check_rise", "check_fall", "check_health",
+	"agent_rise", "agent_fall", "agent_health", "addr", "cookie", "mode", "algo", "conn_rate", "conn_rate_max", "conn_tot",
+	"intercepted", "dcon", "dses", "wrew", "connect", "reuse", "cache_lookups", "cache_hits", "srv_icur", "src_ilim",
+	"qtime_max", "ctime_max", "rtime_max", "ttime_max", "eint", "idle_conn_cur", "safe_conn_cur", "used_conn_cur", "need_conn_est",
+}
+
+// statCounters are the "show stat" counters of a backend summing up the
+// ones of its servers.
+var statCounters = []string{
+	"qcur", "scur", "stot", "bin", "bout", "econ", "eresp", "wretr", "wredis", "lbtot",
+	"hrsp_1xx", "hrsp_2xx", "hrsp_3xx", "hrsp_4xx", "hrsp_5xx", "hrsp_other", "cli_abrt", "srv_abrt", "connect", "reuse",
+}
+
+// SetStat sets the value of a "show stat" field of a proxy. service is
+// StatFrontend, StatBackend or the name of a backend server. The
+// statistics are cleared on reload.
+func (p *fakeHAProxy) SetStat(proxy, service, field string, value int64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var stats map[string]int64
+	switch service {
+	case StatFrontend:
+		for _, fe := range p.frontends {
+			if fe.name == proxy {
+				stats = fe.stats
+			}
+		}
+	case StatBackend:
+		if be := p.findBackend(proxy); be != nil {
+			stats = be.stats
+		}
+	default:
+		if _, s := p.findServer(proxy, service); s != nil {
+			stats = s.stats
+		}
+	}
+	if stats == nil {
+		return fmt.Errorf("no proxy %s/%s", proxy, service)
+	}
+	stats[field] = value
+	return nil
+}
+
+// statObjectTypes are the "show stat typed" object types of the proxy types.
+var statObjectTypes = map[string]string{
+	statTypeFrontend: "F",
+	statTypeBackend:  "B",
+	statTypeServer:   "S",
+}
+
+// statRow builds the fields of a "show stat" row.
+func statRow(values map[string]string, stats map[string]int64) []string {
+	fields := make([]string, len(statFields))
+	for i, name := range statFields {
+		if v, ok := stats[name]; ok {
+			fields[i] = strconv.FormatInt(v, 10)
+		}
+		if v, ok := values[name]; ok {
+			fields[i] = v
+		}
+	}
+	return fields
+}
+
+// typedStatRow formats the fields of a "show stat" row as "show stat typed"
+// lines, omitting the empty fields.
+func typedStatRow(fields []string) []string {
+	typeIndex, iidIndex, sidIndex := 32, 27, 28
+	var lines []string
+	for i, v := range fields {
+		if len(v) == 0 {
+			continue
+		}
+		fieldType := "u64"
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			fieldType = "str"
+		}
+		lines = append(lines, fmt.Sprintf("%s.%s.%s.%d.%s.1:MGP:%s:%s", statObjectTypes[fields[typeIndex]], fields[iidIndex], fields[sidIndex], i, statFields[i], fieldType, v))
+	}
+	return lines
+}
+
+// serverStatus returns the "show stat" status of a server.
+func (s *fakeServer) serverStatus() string {
+	switch {
+	case s.adminState&adminMaint != 0:
+		return "MAINT"
+	case s.adminState&adminDrain != 0:
+		return "DRAIN"
+	case !s.check || !s.checkEnabled:
+		return "no check"
+	case s.opState == opRunning:
+		return "UP"
+	case s.opState == opStopping:
+		return "NOLB"
+	}
+	return "DOWN"
+}
+
+// showStat returns the output of "show stat", or of "show stat typed" when
+// typed is set.
+func (p *fakeHAProxy) showStat(typed bool) string {
+	pid := strconv.Itoa(p.pid)
+	var rows [][]string
+
+	iid := 1
+	for _, fe := range p.frontends {
+		iid++
+		rows = append(rows, statRow(map[string]string{
+			"pxname": fe.name,
+			"svname": StatFrontend,
+			"slim":   "20000",
+			"status": "OPEN",
+			"pid":    pid,
+			"iid":    strconv.Itoa(iid),
+			"sid":    "0",
+			"type":   statTypeFrontend,
+			"mode":   "http",
+		}, withDefaults(fe.stats)))
+	}
+
+	for _, be := range p.backends {
+		totals := make(map[string]int64)
+		weight, active := 0, 0
+		for _, s := range be.servers {
+			status := s.serverStatus()
+			if status == "UP" || status == "no check" {
+				weight += s.uweight
+				active++
+			}
+			stats := withDefaults(s.stats)
+			stats["scur"] += int64(s.connections)
+			for _, c := range statCounters {
+				totals[c] += stats[c]
+			}
+			rows = append(rows, statRow(map[string]string{
+				"pxname":  be.name,
+				"svname":  s.name,
+				"status":  status,
+				"weight":  strconv.Itoa(s.uweight),
+				"act":     "1",
+				"bck":     "0",
+				"lastchg": strconv.Itoa(int(time.Since(s.lastChange).Seconds())),
+				"pid":     pid,
+				"iid":     strconv.Itoa(be.id),
+				"sid":     strconv.Itoa(s.id),
+				"type":    statTypeServer,
+				"addr":    fmt.Sprintf("%s:%d", s.addr, s.port),
+				"mode":    "http",
+			}, stats))
+		}
+
+		stats := withDefaults(be.stats)
+		for c, v := range totals {
+			stats[c] += v
+		}
+		status := "UP"
+		if active == 0 {
+			status = "DOWN"
+		}
+		rows = append(rows, statRow(map[string]string{
+			"pxname": be.name,
+			"svname": StatBackend,
+			"slim":   "2000",
+			"status": status,
+			"weight": strconv.Itoa(weight),
+			"act":    strconv.Itoa(active),
+			"bck":    "0",
+			"pid":    pid,
+			"iid":    strconv.Itoa(be.id),
+			"sid":    "0",
+			"type":   statTypeBackend,
+			"mode":   "http",
+			"algo":   "leastconn",
+		}, stats))
+	}
+
+	if typed {
+		var lines []string
+		for _, row := range rows {
+			lines = append(lines, typedStatRow(row)...)
+		}
+		return strings.Join(lines, "\n") + "\n\n"
+	}
+	lines := []string{"# " + strings.Join(statFields, ",") + ","}
+	for _, row := range rows {
+		lines = append(lines, strings.Join(row, ",")+",")
+	}
+	return strings.Join(lines, "\n") + "\n\n"
+}
+
+// withDefaults returns a copy of the stats with the missing counters set to
+// zero.
+func withDefaults(stats map[string]int64) map[string]int64 {
+	values := make(map[string]int64, len(statCounters)+len(stats))
+	for _, c := range statCounters {
+		values[c] = 0
+	}
+	for k, v := range stats {
+		values[k] = v
+	}
+	return values
+}
+
+func (p *fakeHAProxy) showInfo() string {
+	uptime := time.Since(p.started)
+	connections := 0
+	for _, be := range p.backends {
+		for _, s := range be.servers {
+			connections += s.connections
+		}
+	}
+
+	return fmt.Sprintf(`Name: HAProxy
+Version: 1.8.1
+Release_date: 2017/12/03
+Nbproc: 1
+Process_num: 1
+Pid: %d
+Uptime: %dd%dh%02dm%02ds
+Uptime_sec: %d
+Memmax_MB: 0
+PoolAlloc_MB: 0
+PoolUsed_MB: 0
+PoolFailed: 0
+Ulimit-n: 40260
+Maxsock: 40260
+Maxconn: 20000
+Hard_maxconn: 20000
+CurrConns: %d
+CumConns: 3945
+CumReq: 3947
+MaxSslConns: 0
+CurrSslConns: 0
+CumSslConns: 7765
+Maxpipes: 0
+PipesUsed: 0
+PipesFree: 0
+ConnRate: 0
+ConnRateLimit: 0
+MaxConnRate: 2
+SessRate: 0
+SessRateLimit: 0
+MaxSessRate: 2
+SslRate: 0
+SslRateLimit: 0
+MaxSslRate: 1
+SslFrontendKeyRate: 0
+SslFrontendMaxKeyRate: 1
+SslFrontendSessionReuse_pct: 0
+SslBackendKeyRate: 0
+SslBackendMaxKeyRate: 2
+SslCacheLookups: 0
+SslCacheMisses: 0
+CompressBpsIn: 0
+CompressBpsOut: 0
+CompressBpsRateLim: 0
+ZlibMemUsage: 0
+MaxZlibMemUsage: 0
+Tasks: 278
+Run_queue: 0
+Idle_pct: 100
+node: f27
+`, p.pid, int(uptime.Hours())/24, int(uptime.Hours())%24, int(uptime.Minutes())%60, int(uptime.Seconds())%60, int(uptime.Seconds()), connections)
+}
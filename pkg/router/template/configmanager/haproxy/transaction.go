@@ -0,0 +1,259 @@
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// transactionCommandSeparator separates the commands sent together
+	// to haproxy, which runs them in order over the same connection.
+	transactionCommandSeparator = "; "
+
+	// transactionResponseDelimiter is echoed by haproxy after each command
+	// of a transaction, delimiting the responses of the commands whatever
+	// their output, even an empty one.
+	transactionResponseDelimiter = "--- end of response ---"
+
+	// transactionBatchSize is the maximum length of the commands sent
+	// together. haproxy reads a command line into a buffer of tune.bufsize
+	// bytes, 16384 by default, and drops the longer ones, so the commands
+	// of a transaction are sent in batches fitting half of it.
+	transactionBatchSize = 8192
+
+	// transactionOutcomeRolledBack is a failed transaction whose applied
+	// commands were reverted.
+	transactionOutcomeRolledBack = "rolled_back"
+
+	// transactionOutcomeReload is a failed transaction which left haproxy
+	// in an unknown or partially updated state.
+	transactionOutcomeReload = "reload"
+)
+
+var (
+	// metricTransaction measures the time spent committing transactions.
+	metricTransaction = prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace: "template_router",
+		Subsystem: "dynamic_config",
+		Name:      "transaction_seconds",
+		Help:      "Measures the time spent committing a transaction of dynamic config API commands in seconds.",
+	})
+
+	// metricTransactionFailures is the number of failed transactions, by
+	// outcome: either rolled back or requiring a reload.
+	metricTransactionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "template_router",
+		Subsystem: "dynamic_config",
+		Name:      "transaction_failures_total",
+		Help:      "Number of transactions of dynamic config API commands which failed, by outcome.",
+	}, []string{"outcome"})
+
+	registerTransactionMetricsOnce sync.Once
+)
+
+// registerTransactionMetrics registers the transaction metrics.
+func registerTransactionMetrics() {
+	registerTransactionMetricsOnce.Do(func() {
+		prometheus.MustRegister(metricTransaction, metricTransactionFailures)
+	})
+}
+
+// errReloadRequired indicates haproxy was left in a state that only a
+// reload can fix.
+var errReloadRequired = errors.New("haproxy reload required")
+
+// transactionCommand is a dynamic config API command part of a transaction.
+type transactionCommand struct {
+	// api is the type of command, used to validate its response.
+	api apiType
+
+	// cmd is the command.
+	cmd string
+
+	// optional indicates that a failure of this command does not fail
+	// the transaction.
+	optional bool
+
+	// rollback are the commands reverting this one. nil means the
+	// command cannot be reverted.
+	rollback []*transactionCommand
+}
+
+// Transaction is a set of haproxy dynamic config API commands sent together
+// over a single connection. If any of the commands fails, the ones that were
+// applied are rolled back so that haproxy is never left half-updated. When
+// that is not possible, the returned error wraps errReloadRequired.
+type Transaction struct {
+	client   HAProxyClient
+	commands []*transactionCommand
+}
+
+// newTransaction returns a new empty transaction.
+func newTransaction(client HAProxyClient) *Transaction {
+	return &Transaction{client: client}
+}
+
+// add adds a command to this transaction.
+func (t *Transaction) add(cmd *transactionCommand) {
+	t.commands = append(t.commands, cmd)
+}
+
+// Len returns the number of commands in this transaction.
+func (t *Transaction) Len() int {
+	return len(t.commands)
+}
+
+// Commit sends all the commands of this transaction to haproxy, rolling
+// back the applied ones on failure.
+func (t *Transaction) Commit() error {
+	if len(t.commands) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	defer func() {
+		metricTransaction.Observe(float64(time.Since(start)) / float64(time.Second))
+	}()
+
+	log.V(4).Info("committing transaction", "commands", len(t.commands))
+	commands := t.commands
+	t.commands = nil
+
+	applied, err := runCommands(t.client, commands)
+	if err == nil {
+		return nil
+	}
+	if applied == nil {
+		// Unknown which commands were applied, if any.
+		metricTransactionFailures.WithLabelValues(transactionOutcomeReload).Inc()
+		return fmt.Errorf("%w: %v", errReloadRequired, err)
+	}
+
+	if rollbackErr := rollback(t.client, applied); rollbackErr != nil {
+		metricTransactionFailures.WithLabelValues(transactionOutcomeReload).Inc()
+		return fmt.Errorf("%w: %v, rolling back: %v", errReloadRequired, err, rollbackErr)
+	}
+
+	log.V(2).Info("transaction rolled back", "error", err.Error())
+	metricTransactionFailures.WithLabelValues(transactionOutcomeRolledBack).Inc()
+	return fmt.Errorf("transaction rolled back: %w", err)
+}
+
+// rollback reverts the applied commands, in the reverse order.
+func rollback(client HAProxyClient, applied []*transactionCommand) error {
+	var commands []*transactionCommand
+	for i := len(applied) - 1; i >= 0; i-- {
+		if applied[i].rollback == nil {
+			return fmt.Errorf("cannot revert %q", applied[i].cmd)
+		}
+		commands = append(commands, applied[i].rollback...)
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+
+	_, err := runCommands(client, commands)
+	return err
+}
+
+// runCommands sends the commands to haproxy in batches of at most
+// transactionBatchSize bytes, in order, and validates their responses. No
+// further batch is sent once a command failed. It returns the commands that
+// were applied, which is nil if unknown.
+func runCommands(client HAProxyClient, commands []*transactionCommand) ([]*transactionCommand, error) {
+	applied := make([]*transactionCommand, 0, len(commands))
+	for _, batch := range batchCommands(commands) {
+		batchApplied, err := runBatch(client, batch)
+		if batchApplied == nil {
+			// Unknown which commands of the batch were applied, if any.
+			return nil, err
+		}
+		applied = append(applied, batchApplied...)
+		if err != nil {
+			return applied, err
+		}
+	}
+
+	return applied, nil
+}
+
+// batchCommands splits the commands into batches whose command line, with
+// the echoed delimiters and the separators, fits in transactionBatchSize. A
+// command too long on its own is sent alone.
+func batchCommands(commands []*transactionCommand) [][]*transactionCommand {
+	var batches [][]*transactionCommand
+	start, size := 0, 0
+	for i, c := range commands {
+		cmdSize := len(c.cmd) + len("echo "+transactionResponseDelimiter) + 2*len(transactionCommandSeparator)
+		if i > start && size+cmdSize > transactionBatchSize {
+			batches = append(batches, commands[start:i])
+			start, size = i, 0
+		}
+		size += cmdSize
+	}
+	if start < len(commands) {
+		batches = append(batches, commands[start:])
+	}
+
+	return batches
+}
+
+// runBatch sends a batch of commands together to haproxy and validates their
+// responses. It returns the commands that were applied, which is nil if
+// unknown.
+func runBatch(client HAProxyClient, commands []*transactionCommand) ([]*transactionCommand, error) {
+	cmds := make([]string, 0, 2*len(commands))
+	for _, c := range commands {
+		cmds = append(cmds, c.cmd, "echo "+transactionResponseDelimiter)
+	}
+
+	response, err := client.Execute(strings.Join(cmds, transactionCommandSeparator))
+	if err != nil {
+		return nil, err
+	}
+
+	responses := splitResponses(string(response))
+	if len(responses) != len(commands) {
+		return nil, fmt.Errorf("got %d responses for %d commands: %q", len(responses), len(commands), string(response))
+	}
+
+	applied := make([]*transactionCommand, 0, len(commands))
+	var errs []error
+	for i, c := range commands {
+		if err := validateResponse(c.api, responses[i]); err != nil {
+			if c.optional {
+				log.V(0).Info("ignoring failure of optional command", "command", c.cmd, "error", err.Error())
+				continue
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", c.cmd, err))
+			continue
+		}
+		applied = append(applied, c)
+	}
+
+	return applied, errors.Join(errs...)
+}
+
+// splitResponses splits the output of a set of commands, each followed by
+// the echoed transactionResponseDelimiter. Any output after the last
+// delimiter belongs to a command whose response is incomplete, and is
+// dropped.
+func splitResponses(response string) []string {
+	var responses []string
+	var block []string
+	for _, line := range strings.Split(response, "\n") {
+		if line == transactionResponseDelimiter {
+			responses = append(responses, strings.TrimSpace(strings.Join(block, "\n")))
+			block = nil
+			continue
+		}
+		block = append(block, line)
+	}
+
+	return responses
+}
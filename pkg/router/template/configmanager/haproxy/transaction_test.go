@@ -0,0 +1,435 @@
+package haproxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	templaterouter "github.com/openshift/router/pkg/router/template"
+	haproxytesting "github.com/openshift/router/pkg/router/template/configmanager/haproxy/testing"
+)
+
+// fakeTransactionClient runs each of the commands sent together, responding
+// with the configured response of the command, if any.
+type fakeTransactionClient struct {
+	responses    map[string]string
+	executedCmds []string
+}
+
+func (c *fakeTransactionClient) RunCommand(cmd string, converter Converter) ([]byte, error) {
+	response, err := c.Execute(cmd)
+	if err != nil || converter == nil {
+		return response, err
+	}
+	return converter.Convert(response)
+}
+
+func (c *fakeTransactionClient) Execute(cmd string) ([]byte, error) {
+	c.executedCmds = append(c.executedCmds, cmd)
+	if response, ok := c.responses[cmd]; ok && strings.HasPrefix(cmd, GetServersStateCommand) {
+		return []byte(response), nil
+	}
+
+	response := &strings.Builder{}
+	for _, c2 := range strings.Split(cmd, transactionCommandSeparator) {
+		if text, ok := strings.CutPrefix(c2, "echo "); ok {
+			response.WriteString(text + "\n")
+		} else if r := c.responses[c2]; r != "" {
+			response.WriteString(r + "\n")
+		}
+		response.WriteString("\n")
+	}
+	return []byte(response.String()), nil
+}
+
+// pipelined returns the commands as sent together by a transaction.
+func pipelined(cmds ...string) string {
+	var delimited []string
+	for _, cmd := range cmds {
+		delimited = append(delimited, cmd, "echo "+transactionResponseDelimiter)
+	}
+	return strings.Join(delimited, transactionCommandSeparator)
+}
+
+func TestSplitResponses(t *testing.T) {
+	const delimiter = transactionResponseDelimiter + "\n"
+
+	testCases := map[string]struct {
+		response string
+		expected []string
+	}{
+		"empty": {
+			response: "",
+		},
+		"single empty response": {
+			response: "\n" + delimiter + "\n",
+			expected: []string{""},
+		},
+		"single response": {
+			response: "New server registered.\n\n" + delimiter + "\n",
+			expected: []string{"New server registered."},
+		},
+		"empty responses without terminator": {
+			response: delimiter + delimiter,
+			expected: []string{"", ""},
+		},
+		"multiple responses": {
+			response: "New server registered.\n\n" + delimiter + "\n" + "\n" + delimiter + "\n" + "No such server.\n\n" + delimiter + "\n",
+			expected: []string{"New server registered.", "", "No such server."},
+		},
+		"multiline response": {
+			response: "Require 'backend/server'.\n\nsecond line\n\n" + delimiter + "\n" + delimiter,
+			expected: []string{"Require 'backend/server'.\n\nsecond line", ""},
+		},
+		"incomplete response": {
+			response: "New server registered.\n\n" + delimiter + "\n" + "Server deleted.\n",
+			expected: []string{"New server registered."},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, splitResponses(test.response))
+		})
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	addServer := &transactionCommand{
+		api: apiAddServer,
+		cmd: "add server be/s1 10.0.0.1:8080 weight 1",
+		rollback: []*transactionCommand{
+			{api: apiSetServerState, cmd: "set server be/s1 state maint"},
+			{api: apiDelServer, cmd: "del server be/s1"},
+		},
+	}
+	setWeight := &transactionCommand{
+		api: apiSetServerWeight,
+		cmd: "set server be/s2 weight 10",
+		rollback: []*transactionCommand{
+			{api: apiSetServerWeight, cmd: "set server be/s2 weight 1"},
+		},
+	}
+	delServer := &transactionCommand{
+		api: apiDelServer,
+		cmd: "del server be/s3",
+	}
+	optionalDelServer := &transactionCommand{
+		api:      apiDelServer,
+		cmd:      "del server be/s3",
+		optional: true,
+	}
+
+	testCases := map[string]struct {
+		commands       []*transactionCommand
+		responses      map[string]string
+		expectedCmds   []string
+		errExpected    bool
+		reloadRequired bool
+	}{
+		"empty transaction": {},
+		"commands are sent together": {
+			commands: []*transactionCommand{addServer, setWeight},
+			responses: map[string]string{
+				addServer.cmd: "New server registered.",
+			},
+			expectedCmds: []string{
+				pipelined("add server be/s1 10.0.0.1:8080 weight 1", "set server be/s2 weight 10"),
+			},
+		},
+		"applied commands are rolled back": {
+			commands: []*transactionCommand{addServer, setWeight},
+			responses: map[string]string{
+				addServer.cmd: "New server registered.",
+				setWeight.cmd: "No such server.",
+			},
+			expectedCmds: []string{
+				pipelined("add server be/s1 10.0.0.1:8080 weight 1", "set server be/s2 weight 10"),
+				pipelined("set server be/s1 state maint", "del server be/s1"),
+			},
+			errExpected: true,
+		},
+		"commands applied after the failure are rolled back": {
+			commands: []*transactionCommand{setWeight, addServer},
+			responses: map[string]string{
+				addServer.cmd: "New server registered.",
+				setWeight.cmd: "No such server.",
+			},
+			expectedCmds: []string{
+				pipelined("set server be/s2 weight 10", "add server be/s1 10.0.0.1:8080 weight 1"),
+				pipelined("set server be/s1 state maint", "del server be/s1"),
+			},
+			errExpected: true,
+		},
+		"irreversible commands require a reload": {
+			commands: []*transactionCommand{delServer, setWeight},
+			responses: map[string]string{
+				delServer.cmd: "Server deleted.",
+				setWeight.cmd: "No such server.",
+			},
+			expectedCmds: []string{
+				pipelined("del server be/s3", "set server be/s2 weight 10"),
+			},
+			errExpected:    true,
+			reloadRequired: true,
+		},
+		"failed rollback requires a reload": {
+			commands: []*transactionCommand{addServer, setWeight},
+			responses: map[string]string{
+				addServer.cmd:      "New server registered.",
+				setWeight.cmd:      "No such server.",
+				"del server be/s1": "Server still has connections attached to it, cannot remove it.",
+			},
+			expectedCmds: []string{
+				pipelined("add server be/s1 10.0.0.1:8080 weight 1", "set server be/s2 weight 10"),
+				pipelined("set server be/s1 state maint", "del server be/s1"),
+			},
+			errExpected:    true,
+			reloadRequired: true,
+		},
+		"optional command failures are ignored": {
+			commands: []*transactionCommand{optionalDelServer, setWeight},
+			responses: map[string]string{
+				optionalDelServer.cmd: "Server still has connections attached to it, cannot remove it.",
+			},
+			expectedCmds: []string{
+				pipelined("del server be/s3", "set server be/s2 weight 10"),
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &fakeTransactionClient{responses: test.responses}
+			tx := newTransaction(client)
+			for _, c := range test.commands {
+				tx.add(c)
+			}
+			require.Equal(t, len(test.commands), tx.Len())
+
+			err := tx.Commit()
+			if test.errExpected {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, test.reloadRequired, errors.Is(err, errReloadRequired))
+			assert.Equal(t, test.expectedCmds, client.executedCmds)
+			assert.Equal(t, 0, tx.Len())
+		})
+	}
+}
+
+func TestBatchCommands(t *testing.T) {
+	var commands []*transactionCommand
+	for i := 0; i < 500; i++ {
+		commands = append(commands, &transactionCommand{api: apiSetServerWeight, cmd: fmt.Sprintf("set server be/s%d weight 10", i)})
+	}
+	tooLong := &transactionCommand{api: apiSetServerWeight, cmd: "set server be/" + strings.Repeat("s", transactionBatchSize) + " weight 10"}
+	commands = append(commands, tooLong, commands[0])
+
+	batches := batchCommands(commands)
+	require.Greater(t, len(batches), 2)
+
+	var batched []*transactionCommand
+	for _, batch := range batches[:len(batches)-2] {
+		cmds := make([]string, 0, len(batch))
+		for _, c := range batch {
+			cmds = append(cmds, c.cmd)
+		}
+		assert.LessOrEqual(t, len(pipelined(cmds...)), transactionBatchSize)
+		batched = append(batched, batch...)
+	}
+	assert.Equal(t, []*transactionCommand{tooLong}, batches[len(batches)-2], "a command too long for a batch must be sent alone")
+	batched = append(batched, batches[len(batches)-2]...)
+	batched = append(batched, batches[len(batches)-1]...)
+	assert.Equal(t, commands, batched)
+}
+
+// TestTransactionBatchesHAProxy tests a transaction whose commands exceed the
+// haproxy command line buffer.
+func TestTransactionBatchesHAProxy(t *testing.T) {
+	const backendName = "be_edge_http:default:test-https"
+	const serverName = backendName + "/_dynamic-pod-1"
+
+	testCases := map[string]struct {
+		failCommand string
+		errExpected bool
+	}{
+		"all the batches are applied": {},
+		"a failure in the last batch rolls back the earlier batches": {
+			failCommand: "set server " + backendName + "/s3 weight",
+			errExpected: true,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := haproxytesting.StartFakeServerForTest(t)
+			defer server.Stop()
+
+			initial, found := server.Server(backendName, "_dynamic-pod-1")
+			require.True(t, found)
+
+			tx := newTransaction(NewClient(server.SocketFile(), 1))
+			// Send twice the default haproxy tune.bufsize of commands.
+			size := 0
+			for i := 1; size <= 2*16384; i++ {
+				cmd := fmt.Sprintf("set server %s weight %d", serverName, i%256)
+				tx.add(&transactionCommand{api: apiSetServerWeight, cmd: cmd, rollback: []*transactionCommand{
+					{api: apiSetServerWeight, cmd: fmt.Sprintf("set server %s weight %d", serverName, initial.Weight)},
+				}})
+				size += len(pipelined(cmd))
+			}
+			if len(test.failCommand) > 0 {
+				tx.add(&transactionCommand{api: apiSetServerWeight, cmd: test.failCommand + " 10"})
+			}
+			expectedWeight := tx.Len() % 256
+			if test.errExpected {
+				expectedWeight = initial.Weight
+			}
+
+			err := tx.Commit()
+			if test.errExpected {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.False(t, errors.Is(err, errReloadRequired))
+
+			s, found := server.Server(backendName, "_dynamic-pod-1")
+			require.True(t, found)
+			assert.Equal(t, expectedWeight, s.Weight)
+		})
+	}
+}
+
+func TestBackendTransaction(t *testing.T) {
+	const serversState = `1
+# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port
+9 be 1 s1 10.0.0.1 2 0 1 1 8117 6 3 4 6 0 0 0 - 8080
+9 be 2 s2 10.0.0.2 2 0 1 1 8117 6 3 4 6 0 0 0 - 8080
+`
+
+	client := &fakeTransactionClient{
+		responses: map[string]string{
+			"show servers state be": serversState,
+			"add server be/s3 10.0.0.3:8080 weight 1 check inter 5000ms": "New server registered.",
+			"set server be/s2 addr 10.0.0.4 port 8080":                   "IP changed from '10.0.0.2' to '10.0.0.4' by 'stats socket command'",
+			"set server be/s3 state ready":                               "No such server.",
+			"del server be/s1":                                           "Server still has connections attached to it, cannot remove it.",
+		},
+	}
+
+	b := newBackend("be", client)
+	require.NoError(t, b.Begin())
+
+	cfg := &templaterouter.ServiceAliasConfig{}
+	svc := &templaterouter.ServiceUnit{}
+	s2 := templaterouter.Endpoint{ID: "s2", IP: "10.0.0.4", Port: "8080"}
+	s3 := templaterouter.Endpoint{ID: "s3", IP: "10.0.0.3", Port: "8080"}
+	require.NoError(t, b.UpdateServer(s2, 1, false))
+	require.NoError(t, b.AddServer(cfg, svc, s3, 1, "/tmp", ""))
+	_, err := b.DeleteServer(templaterouter.Endpoint{ID: "s1"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"show servers state be"}, client.executedCmds)
+
+	err = b.Commit()
+	require.Error(t, err)
+	require.False(t, errors.Is(err, errReloadRequired))
+	assert.Equal(t, []string{
+		"show servers state be",
+		pipelined(
+			"set server be/s2 addr 10.0.0.4 port 8080",
+			"set server be/s2 weight 1",
+			"add server be/s3 10.0.0.3:8080 weight 1 check inter 5000ms",
+			"set server be/s3 state ready",
+			"set server be/s1 state maint",
+			"del server be/s1",
+		),
+		pipelined(
+			"set server be/s1 state ready",
+			"set server be/s3 state maint",
+			"del server be/s3",
+			"set server be/s2 weight 1",
+			"set server be/s2 addr 10.0.0.2 port 8080",
+		),
+	}, client.executedCmds)
+}
+
+func TestBackendTransactionHAProxy(t *testing.T) {
+	const backendName = "be_edge_http:default:test-https"
+
+	testCases := map[string]struct {
+		failCommand    string
+		dropResponse   bool
+		errExpected    bool
+		reloadRequired bool
+		applied        bool
+	}{
+		"changes are applied": {
+			applied: true,
+		},
+		"changes are rolled back": {
+			failCommand: "set server " + backendName + "/s3 state",
+			errExpected: true,
+		},
+		"lost response requires a reload": {
+			failCommand:    "set server " + backendName + "/s3 state",
+			dropResponse:   true,
+			errExpected:    true,
+			reloadRequired: true,
+			applied:        true,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := haproxytesting.StartFakeServerForTest(t)
+			defer server.Stop()
+
+			initial := server.Servers(backendName)
+			if test.dropResponse {
+				server.DropResponse(test.failCommand, 1)
+			} else if len(test.failCommand) > 0 {
+				server.FailCommand(test.failCommand, "No such server.", 1)
+			}
+
+			b := newBackend(backendName, NewClient(server.SocketFile(), 1))
+			require.NoError(t, b.Begin())
+
+			cfg := &templaterouter.ServiceAliasConfig{}
+			svc := &templaterouter.ServiceUnit{}
+			updated := templaterouter.Endpoint{ID: "_dynamic-pod-1", IP: "10.0.0.4", Port: "8080"}
+			added := templaterouter.Endpoint{ID: "s3", IP: "10.0.0.3", Port: "8080"}
+			require.NoError(t, b.UpdateServer(updated, 10, false))
+			require.NoError(t, b.AddServer(cfg, svc, added, 1, "/tmp", ""))
+
+			err := b.Commit()
+			if test.errExpected {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, test.reloadRequired, errors.Is(err, errReloadRequired))
+
+			if !test.applied {
+				assert.Equal(t, initial, server.Servers(backendName))
+				return
+			}
+			s, found := server.Server(backendName, "_dynamic-pod-1")
+			require.True(t, found)
+			assert.Equal(t, "10.0.0.4", s.Address)
+			assert.Equal(t, 8080, s.Port)
+			assert.Equal(t, 10, s.Weight)
+			s, found = server.Server(backendName, "s3")
+			require.True(t, found)
+			assert.Equal(t, "10.0.0.3", s.Address)
+			assert.False(t, s.Maintenance)
+		})
+	}
+}
@@ -45,6 +45,16 @@ func (fcw *fakeCertWriter) DeleteCertificate(directory, id string) error {
 	return nil
 }
 
+func (fcw *fakeCertWriter) WriteBundleCertificate(directory, id, keyType string, cert []byte) error {
+	fcw.addedCerts = append(fcw.addedCerts, directory+id+"."+keyType)
+	return nil
+}
+
+func (fcw *fakeCertWriter) DeleteBundleCertificate(directory, id, keyType string) error {
+	fcw.deletedCerts = append(fcw.deletedCerts, directory+id+"."+keyType)
+	return nil
+}
+
 func newFakeCertificateManagerConfig() *certificateManagerConfig {
 	return &certificateManagerConfig{
 		certKeyFunc:     generateCertKey,
@@ -48,6 +48,7 @@ type CoalescingSerializingRateLimiter struct {
 }
 
 func NewCoalescingSerializingRateLimiter(interval time.Duration, handlerFunc HandlerFunc) *CoalescingSerializingRateLimiter {
+	registerMetrics()
 	limiter := &CoalescingSerializingRateLimiter{
 		handlerFunc:    handlerFunc,
 		callInterval:   interval,
@@ -97,10 +98,15 @@ func (csrl *CoalescingSerializingRateLimiter) changeWorker(userChanged bool) {
 		return
 	}
 
-	if userChanged && csrl.changeReqTime == nil {
-		// They just registered a change manually (and we aren't in the middle of a change)
-		now := time.Now()
-		csrl.changeReqTime = &now
+	if userChanged {
+		if csrl.changeReqTime == nil {
+			// They just registered a change manually (and we aren't in the middle of a change)
+			now := time.Now()
+			csrl.changeReqTime = &now
+		} else {
+			// The change will be applied by the pending run
+			metricCoalescedChanges.Inc()
+		}
 	}
 
 	if csrl.handlerRunning {
@@ -140,6 +146,7 @@ func (csrl *CoalescingSerializingRateLimiter) changeWorker(userChanged bool) {
 
 	// Otherwise we can reload immediately... let's do it!
 	log.V(8).Info("calling the handler function", "invokeTime", csrl.changeReqTime)
+	metricWait.Observe(now.Sub(*csrl.changeReqTime).Seconds())
 	csrl.handlerRunning = true
 	csrl.changeReqTime = nil
 	csrl.lastStart = now
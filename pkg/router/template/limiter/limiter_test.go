@@ -1,10 +1,16 @@
 package limiter
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	client_model "github.com/prometheus/client_model/go"
+
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 type handler struct {
@@ -73,3 +79,43 @@ func TestCoalescingSerializingRateLimiter(t *testing.T) {
 		}
 	}
 }
+
+func TestCoalescingSerializingRateLimiterMetrics(t *testing.T) {
+	coalesced := testutil.ToFloat64(metricCoalescedChanges)
+	waits := waitSampleCount(t)
+
+	release := make(chan struct{})
+	h := &handler{}
+	rlf := NewCoalescingSerializingRateLimiter(0, func() error {
+		<-release
+		return h.handle()
+	})
+	defer rlf.Stop()
+
+	// The first change runs the handler, the second one waits for it to
+	// complete and the third one is coalesced with the second one.
+	rlf.RegisterChange()
+	rlf.RegisterChange()
+	rlf.RegisterChange()
+	close(release)
+
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return h.counter() == 2, nil
+	}); err != nil {
+		t.Fatalf("expected 2 handler runs, got %d", h.counter())
+	}
+	if got := testutil.ToFloat64(metricCoalescedChanges) - coalesced; got != 1 {
+		t.Errorf("expected 1 coalesced change, got %v", got)
+	}
+	if got := waitSampleCount(t) - waits; got != 2 {
+		t.Errorf("expected 2 observed waits, got %d", got)
+	}
+}
+
+func waitSampleCount(t *testing.T) uint64 {
+	var m client_model.Metric
+	if err := metricWait.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
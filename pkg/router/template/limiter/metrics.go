@@ -0,0 +1,40 @@
+package limiter
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// metricCoalescedChanges is the number of changes folded into a
+	// pending run of the handler.
+	metricCoalescedChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "template_router",
+		Subsystem: "rate_limiter",
+		Name:      "coalesced_changes_total",
+		Help:      "Number of changes coalesced into an already pending router commit.",
+	})
+
+	// metricWait measures the time from the first change registered to the
+	// start of the handler run applying it.
+	metricWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "template_router",
+		Subsystem: "rate_limiter",
+		Name:      "wait_seconds",
+		Help:      "Measures the time changes wait for the router commit applying them in seconds.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+
+	registerMetricsOnce sync.Once
+)
+
+// registerMetrics registers the rate limiter metrics.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(
+			metricCoalescedChanges,
+			metricWait,
+		)
+	})
+}
@@ -18,6 +18,7 @@ import (
 
 	routev1 "github.com/openshift/api/route/v1"
 
+	"github.com/openshift/router/pkg/router/defaultcert"
 	unidlingapi "github.com/openshift/router/pkg/router/unidling"
 )
 
@@ -53,7 +54,10 @@ type TemplatePluginConfig struct {
 	DefaultCertificate            string
 	DefaultCertificatePath        string
 	DefaultCertificateDir         string
+	DefaultCertificates           []defaultcert.Certificate
 	DefaultDestinationCAPath      string
+	ACMEChallengeAddress          string
+	TLSTicketKeysPath             string
 	StatsPort                     int
 	StatsUsername                 string
 	StatsPassword                 string
@@ -158,6 +162,9 @@ func NewTemplatePlugin(cfg TemplatePluginConfig, lookupSvc ServiceLookup) (*Temp
 		defaultCertificate:            cfg.DefaultCertificate,
 		defaultCertificatePath:        cfg.DefaultCertificatePath,
 		defaultCertificateDir:         cfg.DefaultCertificateDir,
+		defaultCertificates:           cfg.DefaultCertificates,
+		acmeChallengeAddress:          cfg.ACMEChallengeAddress,
+		tlsTicketKeysPath:             cfg.TLSTicketKeysPath,
 		defaultDestinationCAPath:      cfg.DefaultDestinationCAPath,
 		statsUser:                     cfg.StatsUsername,
 		statsPassword:                 cfg.StatsPassword,
@@ -245,6 +252,66 @@ func (p *TemplatePlugin) HandleNamespaces(namespaces sets.String) error {
 	return nil
 }
 
+// backendRouteRouter is a router able to tell the route served by an
+// haproxy backend.
+type backendRouteRouter interface {
+	BackendRoute(backend string) (BackendRoute, bool)
+}
+
+// BackendRoute returns the route served by an haproxy backend, if the
+// router is able to tell.
+func (p *TemplatePlugin) BackendRoute(backend string) (BackendRoute, bool) {
+	router, ok := p.Router.(backendRouteRouter)
+	if !ok {
+		return BackendRoute{}, false
+	}
+	return router.BackendRoute(backend)
+}
+
+type defaultCertificateRouter interface {
+	DefaultCertificatePath() string
+}
+
+// DefaultCertificatePath returns the path of the certificate served for the
+// routes which don't specify their own, empty if the router is unable to
+// tell.
+func (p *TemplatePlugin) DefaultCertificatePath() string {
+	router, ok := p.Router.(defaultCertificateRouter)
+	if !ok {
+		return ""
+	}
+	return router.DefaultCertificatePath()
+}
+
+type acmeHostsRouter interface {
+	SetACMEHosts(hosts []string)
+}
+
+// SetACMEHosts sets the hosts of the routes requesting a certificate from an
+// ACME server, sorted. haproxy only forwards the HTTP-01 challenges of these
+// hosts to the ACME challenge server, the challenges of the other hosts reach
+// their routes.
+func (p *TemplatePlugin) SetACMEHosts(hosts []string) {
+	if router, ok := p.Router.(acmeHostsRouter); ok {
+		router.SetACMEHosts(hosts)
+	}
+}
+
+type configGenerationRouter interface {
+	SyncedConfigGeneration() (int64, bool)
+}
+
+// SyncedConfigGeneration returns the generation of the first configuration
+// written after the initial sync, false if it wasn't written yet or if the
+// router is unable to tell.
+func (p *TemplatePlugin) SyncedConfigGeneration() (int64, bool) {
+	router, ok := p.Router.(configGenerationRouter)
+	if !ok {
+		return 0, false
+	}
+	return router.SyncedConfigGeneration()
+}
+
 func (p *TemplatePlugin) Commit() error {
 	p.Router.Commit()
 	return nil
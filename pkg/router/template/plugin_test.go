@@ -534,6 +534,10 @@ func (r *fakeStatusRecorder) RecordRouteUnservableInFutureVersions(route *routev
 func (r *fakeStatusRecorder) RecordRouteUpdate(route *routev1.Route, reason, message string) {
 	panic("not implemented")
 }
+func (r *fakeStatusRecorder) RecordRouteCondition(route *routev1.Route, conditionType routev1.RouteIngressConditionType, reason, message string) {
+}
+func (r *fakeStatusRecorder) RecordRouteConditionClear(route *routev1.Route, conditionType routev1.RouteIngressConditionType) {
+}
 func (r *fakeStatusRecorder) RecordRouteUnservableInFutureVersionsClear(route *routev1.Route) {
 	var unservableInFutureVersions []status
 	for _, entry := range r.unservableInFutureVersions {
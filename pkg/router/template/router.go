@@ -6,10 +6,12 @@ import (
 	"crypto/md5"
 	"encoding/pem"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,7 +28,10 @@ import (
 	logf "github.com/openshift/router/log"
 	"github.com/openshift/router/pkg/router/client"
 	"github.com/openshift/router/pkg/router/crl"
+	"github.com/openshift/router/pkg/router/defaultcert"
+	"github.com/openshift/router/pkg/router/routeapihelpers"
 	"github.com/openshift/router/pkg/router/template/limiter"
+	haproxyutil "github.com/openshift/router/pkg/router/template/util/haproxy"
 )
 
 var log = logf.Logger.WithName("template")
@@ -75,11 +80,23 @@ type templateRouter struct {
 	defaultCertificatePath string
 	// if the default certificate is in a secret this will be filled in so it can be passed to the templates
 	defaultCertificateDir string
+	// defaultCertificates are the additional default certificates, each one served instead of the default certificate
+	// for the routes without their own certificate whose host it matches
+	defaultCertificates []defaultcert.Certificate
 	// defaultDestinationCAPath is a path to a CA bundle that should be used by the underlying implementation as the default
 	// destination CA if no certificate is resolved by the normal matching mechanisms. This is usually the service serving
 	// certificate CA (/var/run/secrets/kubernetes.io/serviceaccount/serving_ca.crt) that the infrastructure uses to
 	// generate certificates for services by name.
 	defaultDestinationCAPath string
+	// acmeChallengeAddress is the address of the server of the ACME HTTP-01 challenges, empty if the router doesn't
+	// request certificates from ACME servers
+	acmeChallengeAddress string
+	// acmeHosts are the hosts of the routes requesting a certificate from an ACME server, the only hosts whose
+	// HTTP-01 challenges are forwarded to the acmeChallengeAddress
+	acmeHosts []string
+	// tlsTicketKeysPath is the path of the file of the TLS session ticket keys shared by the router replicas, empty if
+	// haproxy generates its own keys
+	tlsTicketKeysPath string
 	// if the router can expose statistics it should expose them with this user for auth
 	statsUser string
 	// if the router can expose statistics it should expose them with this password for auth
@@ -99,6 +116,13 @@ type templateRouter struct {
 	synced bool
 	// whether a state change has occurred
 	stateChanged bool
+	// configGeneration is incremented every time the configuration is
+	// written, and rendered into it so that the generation loaded by the
+	// underlying router can be told.
+	configGeneration int64
+	// syncedConfigGeneration is the generation of the first configuration
+	// written after the initial sync, zero until then.
+	syncedConfigGeneration int64
 	// metricReload tracks reloads
 	metricReload prometheus.Summary
 	// metricReloadFailure tracks reload failures
@@ -126,6 +150,9 @@ type templateRouter struct {
 	haveClientCA bool
 	// haveCRLs specifies if the crl file has been generated for client auth
 	haveCRLs bool
+	// ignoreExpiredCRLs specifies if the crl file has expired CRLs which the
+	// CRL policy of their CA allows serving
+	ignoreExpiredCRLs bool
 	// httpResponseHeaders allows users to set or delete custom HTTP response headers.
 	httpResponseHeaders []HTTPHeader
 	// httpRequestHeaders allows users to set or delete custom HTTP request headers.
@@ -144,7 +171,10 @@ type templateRouterCfg struct {
 	defaultCertificate            string
 	defaultCertificatePath        string
 	defaultCertificateDir         string
+	defaultCertificates           []defaultcert.Certificate
 	defaultDestinationCAPath      string
+	acmeChallengeAddress          string
+	tlsTicketKeysPath             string
 	statsUser                     string
 	statsPassword                 string
 	statsPort                     int
@@ -171,8 +201,16 @@ type templateData struct {
 	ServiceUnits map[ServiceUnitKey]ServiceUnit
 	// full path and file name to the default certificate
 	DefaultCertificate string
+	// the additional default certificates, selected by route host
+	DefaultCertificates []defaultcert.Certificate
 	// full path and file name to the default destination certificate
 	DefaultDestinationCA string
+	// the address the ACME HTTP-01 challenges are forwarded to, empty if disabled
+	ACMEChallengeAddress string
+	// the hosts whose ACME HTTP-01 challenges are forwarded to the ACMEChallengeAddress
+	ACMEHosts []string
+	// full path and file name to the TLS session ticket keys, empty if haproxy generates its own keys
+	TLSTicketKeysFile string
 	//username to expose stats with (if the template supports it)
 	StatsUser string
 	//password to expose stats with (if the template supports it)
@@ -201,6 +239,9 @@ type templateData struct {
 	HaveClientCA bool
 	// HaveCRLs specifies if the crl file is present
 	HaveCRLs bool
+	// IgnoreExpiredCRLs specifies if HAProxy must accept the expired CRLs of
+	// the crl file, which the CRL policy of their CA allows serving
+	IgnoreExpiredCRLs bool
 	// HTTPResponseHeaders allows users to set/delete custom HTTP response
 	HTTPResponseHeaders []HTTPHeader
 	// HTTPRequestHeaders allows users to set/delete custom HTTP request
@@ -209,6 +250,9 @@ type templateData struct {
 	// that a certificate has been observed over various routes, used to
 	// detect duplicate certificates.
 	CertificateIndex map[string]int
+	// ConfigGeneration identifies the written configuration. It is
+	// incremented every time the configuration is written.
+	ConfigGeneration int64
 }
 
 func newTemplateRouter(cfg templateRouterCfg) (*templateRouter, error) {
@@ -260,6 +304,9 @@ func newTemplateRouter(cfg templateRouterCfg) (*templateRouter, error) {
 		defaultCertificate:            cfg.defaultCertificate,
 		defaultCertificatePath:        cfg.defaultCertificatePath,
 		defaultCertificateDir:         cfg.defaultCertificateDir,
+		defaultCertificates:           cfg.defaultCertificates,
+		acmeChallengeAddress:          cfg.acmeChallengeAddress,
+		tlsTicketKeysPath:             cfg.tlsTicketKeysPath,
 		defaultDestinationCAPath:      cfg.defaultDestinationCAPath,
 		statsUser:                     cfg.statsUser,
 		statsPassword:                 cfg.statsPassword,
@@ -472,6 +519,75 @@ func (r *templateRouter) writeDefaultCert() error {
 	return nil
 }
 
+// DefaultCertificatePath returns the path of the certificate served for the
+// routes which don't specify their own.
+func (r *templateRouter) DefaultCertificatePath() string {
+	return r.defaultCertificatePath
+}
+
+// crlOptionsFromEnv returns the options to retrieve the CRLs of the mutual TLS
+// CA bundle, configured by the environment. Returns an error if a setting is
+// invalid.
+func crlOptionsFromEnv() (crl.Options, error) {
+	timeout := crl.DefaultFetchTimeout
+	if v := os.Getenv("ROUTER_MUTUAL_TLS_AUTH_CRL_FETCH_TIMEOUT"); len(v) != 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return crl.Options{}, fmt.Errorf("invalid ROUTER_MUTUAL_TLS_AUTH_CRL_FETCH_TIMEOUT %q", v)
+		}
+		timeout = d
+	}
+	maxSize := int64(crl.DefaultMaxCRLSize)
+	if v := os.Getenv("ROUTER_MUTUAL_TLS_AUTH_CRL_MAX_SIZE"); len(v) != 0 {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return crl.Options{}, fmt.Errorf("invalid ROUTER_MUTUAL_TLS_AUTH_CRL_MAX_SIZE %q", v)
+		}
+		maxSize = n
+	}
+	var proxyURL *url.URL
+	if v := os.Getenv("ROUTER_MUTUAL_TLS_AUTH_CRL_PROXY"); len(v) != 0 {
+		u, err := url.Parse(v)
+		if err != nil {
+			return crl.Options{}, fmt.Errorf("invalid ROUTER_MUTUAL_TLS_AUTH_CRL_PROXY %q: %v", v, err)
+		}
+		proxyURL = u
+	}
+	cacheDir := crl.DefaultCacheDirectory
+	if v, ok := os.LookupEnv("ROUTER_MUTUAL_TLS_AUTH_CRL_CACHE_DIR"); ok {
+		// an empty directory disables the cache
+		cacheDir = v
+	}
+	policy, err := crl.ParsePolicy(os.Getenv("ROUTER_MUTUAL_TLS_AUTH_CRL_POLICY"))
+	if err != nil {
+		return crl.Options{}, fmt.Errorf("invalid ROUTER_MUTUAL_TLS_AUTH_CRL_POLICY: %v", err)
+	}
+	gracePeriod := crl.DefaultGracePeriod
+	if v := os.Getenv("ROUTER_MUTUAL_TLS_AUTH_CRL_GRACE_PERIOD"); len(v) != 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return crl.Options{}, fmt.Errorf("invalid ROUTER_MUTUAL_TLS_AUTH_CRL_GRACE_PERIOD %q", v)
+		}
+		gracePeriod = d
+	}
+	var failOpenCAs []string
+	for _, keyID := range strings.Split(os.Getenv("ROUTER_MUTUAL_TLS_AUTH_CRL_FAIL_OPEN_CAS"), ",") {
+		if keyID = strings.TrimSpace(keyID); len(keyID) != 0 {
+			failOpenCAs = append(failOpenCAs, keyID)
+		}
+	}
+	return crl.Options{
+		Fetchers: map[string]crl.Fetcher{
+			"http": crl.NewHTTPFetcher(timeout, maxSize, proxyURL),
+			"ldap": crl.NewLDAPFetcher(timeout, maxSize),
+		},
+		CacheDirectory: cacheDir,
+		Policy:         policy,
+		GracePeriod:    gracePeriod,
+		FailOpenCAs:    failOpenCAs,
+	}, nil
+}
+
 // watchMutualTLSCert watches the directory containing the certificates for
 // mutual TLS and reloads the router if the directory contents change.
 func (r *templateRouter) watchMutualTLSCert() error {
@@ -488,12 +604,17 @@ func (r *templateRouter) watchMutualTLSCert() error {
 		}
 		r.haveCRLs = haveCRLs
 		caUpdateChannel := make(chan struct{})
-		crlReloadFn := func(haveCRLs bool) {
+		crlReloadFn := func(haveCRLs, ignoreExpiredCRLs bool) {
 			r.haveCRLs = haveCRLs
-			log.V(0).Info("reloading to get updated client CA CRL", "name", crl.CRLFilename, "have CRLs", haveCRLs)
+			r.ignoreExpiredCRLs = ignoreExpiredCRLs
+			log.V(0).Info("reloading to get updated client CA CRL", "name", crl.CRLFilename, "have CRLs", haveCRLs, "ignore expired CRLs", ignoreExpiredCRLs)
 			r.rateLimitedCommitFunction.RegisterChange()
 		}
-		crl.ManageCRLs(caPath, caUpdateChannel, crlReloadFn)
+		crlOptions, err := crlOptionsFromEnv()
+		if err != nil {
+			return err
+		}
+		crl.ManageCRLs(caPath, caUpdateChannel, crlOptions, crlReloadFn)
 		caReloadFn := func() {
 			// Send signal to CRL management goroutine that client CA has been changed
 			caUpdateChannel <- struct{}{}
@@ -520,6 +641,13 @@ func (r *templateRouter) Commit() {
 		r.dynamicallyConfigured = false
 	}
 
+	if r.dynamicConfigManager != nil && r.dynamicallyConfigured {
+		if err := r.dynamicConfigManager.Commit(); err != nil {
+			log.Info("router will reload as the ConfigManager could not commit the dynamic changes", "error", err)
+			r.dynamicallyConfigured = false
+		}
+	}
+
 	needsCommit := r.stateChanged && !r.dynamicallyConfigured
 	r.lock.Unlock()
 
@@ -544,6 +672,9 @@ func (r *templateRouter) commitAndReload() error {
 		log.V(4).Info("writing the router config")
 		reloadStart := time.Now()
 		err := r.writeConfig()
+		if err == nil && r.synced && r.syncedConfigGeneration == 0 {
+			r.syncedConfigGeneration = r.configGeneration
+		}
 		r.metricWriteConfig.Observe(float64(time.Now().Sub(reloadStart)) / float64(time.Second))
 		log.V(4).Info("writeConfig", "duration", time.Now().Sub(reloadStart).String())
 		return err
@@ -619,6 +750,7 @@ func (r *templateRouter) writeConfig() error {
 	log.V(4).Info("router certificate manager config committed")
 
 	disableHTTP2, _ := strconv.ParseBool(os.Getenv("ROUTER_DISABLE_HTTP2"))
+	r.configGeneration++
 
 	for name, template := range r.templates {
 		filename := filepath.Join(r.dir, name)
@@ -635,6 +767,10 @@ func (r *templateRouter) writeConfig() error {
 			State:                         r.state,
 			ServiceUnits:                  r.serviceUnits,
 			DefaultCertificate:            r.defaultCertificatePath,
+			DefaultCertificates:           r.defaultCertificates,
+			ACMEChallengeAddress:          r.acmeChallengeAddress,
+			ACMEHosts:                     r.acmeHosts,
+			TLSTicketKeysFile:             r.tlsTicketKeysPath,
 			DefaultDestinationCA:          r.defaultDestinationCAPath,
 			StatsUser:                     r.statsUser,
 			StatsPassword:                 r.statsPassword,
@@ -648,9 +784,11 @@ func (r *templateRouter) writeConfig() error {
 			HTTPHeaderNameCaseAdjustments: r.httpHeaderNameCaseAdjustments,
 			HaveClientCA:                  r.haveClientCA,
 			HaveCRLs:                      r.haveCRLs,
+			IgnoreExpiredCRLs:             r.ignoreExpiredCRLs,
 			HTTPResponseHeaders:           r.httpResponseHeaders,
 			HTTPRequestHeaders:            r.httpRequestHeaders,
 			CertificateIndex:              certificateIndex,
+			ConfigGeneration:              r.configGeneration,
 		}
 		if err := template.Execute(file, data); err != nil {
 			file.Close()
@@ -753,6 +891,20 @@ func (r *templateRouter) FilterNamespaces(namespaces sets.String) {
 	}
 }
 
+// SetACMEHosts sets the hosts of the routes requesting a certificate from an
+// ACME server, sorted.
+func (r *templateRouter) SetACMEHosts(hosts []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if slices.Equal(r.acmeHosts, hosts) {
+		return
+	}
+	r.acmeHosts = hosts
+	r.stateChanged = true
+	r.dynamicallyConfigured = false
+}
+
 // CreateServiceUnit creates a new service named with the given id.
 func (r *templateRouter) CreateServiceUnit(id ServiceUnitKey) {
 	r.lock.Lock()
@@ -1099,6 +1251,7 @@ func (r *templateRouter) createServiceAliasConfig(route *routev1.Route, backendK
 		Path:                  route.Spec.Path,
 		IsWildcard:            wildcard,
 		Annotations:           route.Annotations,
+		Labels:                route.Labels,
 		ServiceUnits:          serviceUnits,
 		EndpointTable:         make(map[ServiceUnitKey][]Endpoint),
 		PrimaryServiceUnitKey: primaryServiceUnitKey,
@@ -1134,6 +1287,13 @@ func (r *templateRouter) createServiceAliasConfig(route *routev1.Route, backendK
 					Contents:   tls.Certificate,
 					PrivateKey: tls.Key,
 				}
+				if pairs, err := routeapihelpers.SplitKeyPairs([]byte(tls.Certificate), []byte(tls.Key)); err != nil {
+					log.V(0).Info("unable to split the certificate of the route", "route", backendKey, "error", err.Error())
+				} else if len(pairs) > 1 {
+					for _, pair := range pairs {
+						cert.Bundle = append(cert.Bundle, pair.KeyType)
+					}
+				}
 
 				config.Certificates[certKey] = cert
 			}
@@ -1382,6 +1542,110 @@ func (r *templateRouter) SyncedAtLeastOnce() bool {
 	return r.synced
 }
 
+// SyncedConfigGeneration returns the generation of the first configuration
+// written after the initial sync, false if it wasn't written yet. The
+// underlying router serves the synced state once it loaded this generation
+// or a later one.
+func (r *templateRouter) SyncedConfigGeneration() (int64, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.syncedConfigGeneration, r.syncedConfigGeneration > 0
+}
+
+// DesiredBackends returns the state that the backends of the given service
+// alias configs are expected to have. Unknown service alias configs are left
+// out. It returns false if the router has changes waiting for a reload, in
+// which case the running configuration is not expected to match.
+func (r *templateRouter) DesiredBackends(ids []ServiceAliasConfigKey) (map[ServiceAliasConfigKey]DesiredBackend, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.synced || (r.stateChanged && !r.dynamicallyConfigured) {
+		return nil, false
+	}
+
+	backends := make(map[ServiceAliasConfigKey]DesiredBackend, len(ids))
+	for _, id := range ids {
+		cfg, ok := r.state[id]
+		if !ok {
+			continue
+		}
+
+		backend := DesiredBackend{
+			Servers:    []DesiredServer{},
+			MapEntries: make(map[string]haproxyutil.HAProxyMapEntry),
+		}
+
+		weights := r.calculateServiceWeights(cfg.ServiceUnits, cfg.PreferPort)
+		for key, weight := range weights {
+			// This should always follow the template, which drops
+			// passthrough servers with no weight.
+			if weight == 0 && cfg.TLSTermination == routev1.TLSTerminationPassthrough {
+				continue
+			}
+			service, ok := r.findMatchingServiceUnit(key)
+			if !ok {
+				continue
+			}
+			for _, ep := range endpointsForAlias(cfg, service) {
+				backend.Servers = append(backend.Servers, DesiredServer{
+					Endpoint:        ep,
+					Weight:          weight,
+					ServiceHostname: service.Hostname,
+				})
+			}
+		}
+
+		for _, name := range runtimeMaps {
+			if entry := haproxyutil.GenerateMapEntry(name, backendConfig(string(id), cfg, false)); entry != nil {
+				backend.MapEntries[name] = *entry
+			}
+		}
+
+		backends[id] = backend
+	}
+
+	return backends, true
+}
+
+// poolBackendResolver is a config manager serving routes from pre-allocated
+// pool backends.
+type poolBackendResolver interface {
+	PoolBackendRoute(backend ServiceAliasConfigKey) (ServiceAliasConfigKey, bool)
+}
+
+// BackendRoute returns the route served by an haproxy backend. A pool
+// backend of the dynamic config manager returns the route it currently
+// serves.
+func (r *templateRouter) BackendRoute(backend string) (BackendRoute, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, key, ok := strings.Cut(backend, ":")
+	if !ok {
+		return BackendRoute{}, false
+	}
+	id := ServiceAliasConfigKey(key)
+	if resolver, ok := r.dynamicConfigManager.(poolBackendResolver); ok {
+		if routeID, ok := resolver.PoolBackendRoute(ServiceAliasConfigKey(backend)); ok {
+			id = routeID
+		}
+	}
+
+	cfg, ok := r.state[id]
+	if !ok {
+		return BackendRoute{}, false
+	}
+	_, service := getPartsFromEndpointsKey(cfg.PrimaryServiceUnitKey)
+	return BackendRoute{
+		Namespace:   cfg.Namespace,
+		Name:        cfg.Name,
+		Service:     service,
+		Termination: cfg.TLSTermination,
+		Labels:      cfg.Labels,
+	}, true
+}
+
 // hasRequiredEdgeCerts ensures that at least a host certificate and key are provided.
 // a ca cert is not required because it may be something that is in the root cert chain
 func hasRequiredEdgeCerts(cfg *ServiceAliasConfig) bool {
@@ -9,9 +9,11 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"text/template"
 
 	"github.com/google/go-cmp/cmp"
 	routev1 "github.com/openshift/api/route/v1"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -502,6 +504,36 @@ func TestCreateServiceAliasConfig(t *testing.T) {
 }
 
 // TestAddRoute validates that adding a route creates a service alias config and associated service units
+// TestCreateServiceAliasConfigDualCertificate verifies that the key types of
+// a dual certificate are recorded so that it is written as a multi-cert bundle.
+func TestCreateServiceAliasConfigDualCertificate(t *testing.T) {
+	router := NewFakeTemplateRouter()
+	certPEM, keyPEM := newDualCertificate(t, "www.example.com")
+	route := &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "foo",
+			Name:      "bar",
+		},
+		Spec: routev1.RouteSpec{
+			Host: "www.example.com",
+			To: routev1.RouteTargetReference{
+				Name: "TestService",
+			},
+			TLS: &routev1.TLSConfig{
+				Termination: routev1.TLSTerminationEdge,
+				Certificate: certPEM,
+				Key:         keyPEM,
+			},
+		},
+	}
+
+	config := *router.createServiceAliasConfig(route, "foo:bar")
+	cert := config.Certificates[generateCertKey(&config)]
+	if expected := []string{"rsa", "ecdsa"}; !reflect.DeepEqual(cert.Bundle, expected) {
+		t.Errorf("expected the bundle %q, got %q", expected, cert.Bundle)
+	}
+}
+
 func TestAddRoute(t *testing.T) {
 	router := NewFakeTemplateRouter()
 
@@ -762,6 +794,106 @@ func TestHasRequiredEdgeCerts(t *testing.T) {
 	}
 }
 
+// TestDesiredBackends tests the backend state expected by the router.
+func TestDesiredBackends(t *testing.T) {
+	router := NewFakeTemplateRouter()
+	router.synced = true
+	suKey := ServiceUnitKey("nsl/test")
+	router.CreateServiceUnit(suKey)
+	router.AddRoute(&routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "nsl",
+			Name:      "edge",
+		},
+		Spec: routev1.RouteSpec{
+			Host: "edge-nsl.foo.com",
+			To: routev1.RouteTargetReference{
+				Kind: "Service",
+				Name: "test",
+			},
+			TLS: &routev1.TLSConfig{
+				Termination: routev1.TLSTerminationEdge,
+			},
+		},
+	})
+	router.AddEndpoints(suKey, []Endpoint{
+		{ID: "ep1", IP: "10.0.0.1", Port: "8080"},
+		{ID: "ep2", IP: "10.0.0.2", Port: "8080"},
+	})
+
+	ids := []ServiceAliasConfigKey{"nsl:edge", "nsl:unknown"}
+	_, ok := router.DesiredBackends(ids)
+	require.False(t, ok, "expected no desired state while a reload is pending")
+
+	router.FakeReloadHandler()
+	backends, ok := router.DesiredBackends(ids)
+	require.True(t, ok)
+	require.Len(t, backends, 1)
+
+	backend := backends["nsl:edge"]
+	require.Len(t, backend.Servers, 2)
+	for _, server := range backend.Servers {
+		require.Equal(t, "test.nsl.svc", server.ServiceHostname)
+		require.Equal(t, int32(1), server.Weight)
+	}
+
+	values := make(map[string]string)
+	for name, entry := range backend.MapEntries {
+		require.Equal(t, `^edge-nsl\.foo\.com\.?(:[0-9]+)?(/.*)?$`, entry.Key)
+		values[name] = entry.Value
+	}
+	require.Equal(t, map[string]string{
+		"os_edge_reencrypt_be.map":   "be_edge_http:nsl:edge",
+		"os_route_http_redirect.map": "0",
+	}, values)
+}
+
+// fakePoolConfigManager is a config manager serving routes from pool backends.
+type fakePoolConfigManager struct {
+	ConfigManager
+
+	poolUsage map[ServiceAliasConfigKey]ServiceAliasConfigKey
+}
+
+func (cm *fakePoolConfigManager) PoolBackendRoute(backend ServiceAliasConfigKey) (ServiceAliasConfigKey, bool) {
+	id, ok := cm.poolUsage[backend]
+	return id, ok
+}
+
+func TestBackendRoute(t *testing.T) {
+	router := NewFakeTemplateRouter()
+	router.dynamicConfigManager = &fakePoolConfigManager{
+		poolUsage: map[ServiceAliasConfigKey]ServiceAliasConfigKey{
+			"be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1": "nsl:edge",
+		},
+	}
+	router.state["nsl:edge"] = ServiceAliasConfig{
+		Name:                  "edge",
+		Namespace:             "nsl",
+		TLSTermination:        routev1.TLSTerminationEdge,
+		PrimaryServiceUnitKey: "nsl/test",
+		Labels:                map[string]string{"app": "test"},
+	}
+
+	expected := BackendRoute{
+		Namespace:   "nsl",
+		Name:        "edge",
+		Service:     "test",
+		Termination: routev1.TLSTerminationEdge,
+		Labels:      map[string]string{"app": "test"},
+	}
+	for _, backend := range []string{"be_edge_http:nsl:edge", "be_edge_http:_hapcm_blueprint_pool:_blueprint-edge-route-1"} {
+		route, ok := router.BackendRoute(backend)
+		require.True(t, ok, backend)
+		require.Equal(t, expected, route, backend)
+	}
+
+	for _, backend := range []string{"be_edge_http:nsl:unknown", "openshift_default"} {
+		_, ok := router.BackendRoute(backend)
+		require.False(t, ok, backend)
+	}
+}
+
 func makeCertMap(host string, valid bool) map[string]Certificate {
 	privateKey := "private Key"
 	if !valid {
@@ -1688,3 +1820,118 @@ func TestReloadRouterSidecar(t *testing.T) {
 	})
 
 }
+
+func TestSyncedConfigGeneration(t *testing.T) {
+	router := NewFakeTemplateRouter()
+	router.dir = t.TempDir()
+	router.templates = map[string]*template.Template{
+		"haproxy.config": template.Must(template.New("haproxy.config").Parse("description config-generation-{{ .ConfigGeneration }}\n")),
+	}
+	router.reloadFn = func(shutdown bool) error { return nil }
+	router.metricReload = prometheus.NewSummary(prometheus.SummaryOpts{Name: "reload_seconds"})
+	router.metricReloadFailure = prometheus.NewGauge(prometheus.GaugeOpts{Name: "reload_failure"})
+	router.metricWriteConfig = prometheus.NewSummary(prometheus.SummaryOpts{Name: "write_config_seconds"})
+
+	commit := func(expected string) {
+		t.Helper()
+		require.NoError(t, router.commitAndReload())
+		config, err := os.ReadFile(filepath.Join(router.dir, "haproxy.config"))
+		require.NoError(t, err)
+		require.Equal(t, "description config-generation-"+expected+"\n", string(config))
+	}
+
+	// the configuration written before the sync doesn't serve the routes
+	commit("1")
+	_, ok := router.SyncedConfigGeneration()
+	require.False(t, ok)
+
+	router.synced = true
+	commit("2")
+	generation, ok := router.SyncedConfigGeneration()
+	require.True(t, ok)
+	require.Equal(t, int64(2), generation)
+
+	// the later configurations don't change the synced generation
+	commit("3")
+	generation, _ = router.SyncedConfigGeneration()
+	require.Equal(t, int64(2), generation)
+}
+
+// fakeCommitConfigManager is a config manager recording its commits.
+type fakeCommitConfigManager struct {
+	ConfigManager
+
+	commits int
+	err     error
+}
+
+func (cm *fakeCommitConfigManager) Commit() error {
+	cm.commits++
+	return cm.err
+}
+
+// TestCommitDynamicChanges tests that the dynamic changes are committed
+// along with the router, and that the router reloads when they fail.
+func TestCommitDynamicChanges(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		dynamicallyConfigured bool
+		err                   error
+		expectedCommits       int
+	}{
+		{
+			name:                  "dynamic changes are committed",
+			dynamicallyConfigured: true,
+			expectedCommits:       1,
+		},
+		{
+			name:                  "failed dynamic changes require a reload",
+			dynamicallyConfigured: true,
+			err:                   fmt.Errorf("transaction rolled back"),
+			expectedCommits:       1,
+		},
+		{
+			name: "changes requiring a reload are not committed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cm := &fakeCommitConfigManager{err: tc.err}
+			router := NewFakeTemplateRouter()
+			router.dynamicConfigManager = cm
+			router.synced = true
+			router.dynamicallyConfigured = tc.dynamicallyConfigured
+
+			router.Commit()
+
+			if cm.commits != tc.expectedCommits {
+				t.Errorf("expected %d commits, got %d", tc.expectedCommits, cm.commits)
+			}
+			if expected := tc.dynamicallyConfigured && tc.err == nil; router.dynamicallyConfigured != expected {
+				t.Errorf("expected dynamically configured %v, got %v", expected, router.dynamicallyConfigured)
+			}
+		})
+	}
+}
+
+// TestSetACMEHosts tests that a change of the ACME hosts requires a reload.
+func TestSetACMEHosts(t *testing.T) {
+	router := NewFakeTemplateRouter()
+	router.dynamicallyConfigured = true
+
+	router.SetACMEHosts([]string{"shop.example.com", "www.example.com"})
+	if !router.stateChanged || router.dynamicallyConfigured {
+		t.Errorf("expected a change of the ACME hosts to require a reload")
+	}
+	if expected := []string{"shop.example.com", "www.example.com"}; !reflect.DeepEqual(router.acmeHosts, expected) {
+		t.Errorf("expected the ACME hosts %v, got %v", expected, router.acmeHosts)
+	}
+
+	router.stateChanged = false
+	router.dynamicallyConfigured = true
+	router.SetACMEHosts([]string{"shop.example.com", "www.example.com"})
+	if router.stateChanged || !router.dynamicallyConfigured {
+		t.Errorf("expected unchanged ACME hosts not to require a reload")
+	}
+}
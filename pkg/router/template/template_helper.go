@@ -2,6 +2,7 @@ package templaterouter
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"net"
 	"os"
@@ -14,17 +15,31 @@ import (
 	"text/template"
 
 	routev1 "github.com/openshift/api/route/v1"
+	"github.com/openshift/router/pkg/router/accesslog"
+	"github.com/openshift/router/pkg/router/defaultcert"
 	"github.com/openshift/router/pkg/router/routeapihelpers"
 	templateutil "github.com/openshift/router/pkg/router/template/util"
 	haproxyutil "github.com/openshift/router/pkg/router/template/util/haproxy"
 	"github.com/openshift/router/pkg/router/template/util/haproxytime"
 	"github.com/openshift/router/pkg/router/template/util/rewritetarget"
+	"github.com/openshift/router/pkg/router/template/util/tlsprofile"
 )
 
 const (
 	certConfigMap = "cert_config.map"
 )
 
+// runtimeMaps are the haproxy maps whose entries can be changed at runtime.
+// The certificate config map is a crt-list and is left out.
+var runtimeMaps = []string{
+	"os_wildcard_domain.map",
+	"os_http_be.map",
+	"os_edge_reencrypt_be.map",
+	"os_route_http_redirect.map",
+	"os_tcp_be.map",
+	"os_sni_passthrough.map",
+}
+
 func isTrue(s string) bool {
 	v, _ := strconv.ParseBool(s)
 	return v
@@ -219,13 +234,52 @@ func generateHAProxyCertConfigMap(td templateData) []string {
 			hascert = ok && len(cert.Contents) > 0
 		}
 
+		profile, err := tlsprofile.FromAnnotations(cfg.Annotations)
+		if err != nil {
+			log.V(0).Info("generateHAProxyCertConfigMap ignoring invalid TLS profile", "route", k, "error", err.Error())
+		}
+
+		var certPaths []string
+		var host string
+		var options []string
 		backendConfig := backendConfig(string(k), cfg, hascert)
 		if entry := haproxyutil.GenerateMapEntry(certConfigMap, backendConfig); entry != nil {
 			fqCertPath := path.Join(td.WorkingDir, certDir, entry.Key)
-			if td.DisableHTTP2 || td.CertificateIndex[cert.Contents] > 1 {
-				lines = append(lines, strings.Join([]string{fqCertPath, entry.Value}, " "))
+			// The certificates of a multi-cert bundle get an entry each,
+			// haproxy presents the one the client supports.
+			certPaths = []string{fqCertPath}
+			if len(cert.Bundle) > 0 {
+				certPaths = certPaths[:0]
+				for _, keyType := range cert.Bundle {
+					certPaths = append(certPaths, fqCertPath+"."+keyType)
+				}
+			}
+			host = entry.Value
+			if !td.DisableHTTP2 && td.CertificateIndex[cert.Contents] <= 1 {
+				options = append(options, "alpn", "h2,http/1.1")
+			}
+		} else if defaultCert := matchDefaultCertificate(td.DefaultCertificates, &cfg, hascert); defaultCert != nil {
+			// The default certificates are shared, like the
+			// default certificate of the bind they don't get alpn.
+			certPaths = []string{defaultCert.Path}
+			host = templateutil.GenCertificateHostName(cfg.Host, cfg.IsWildcard)
+		} else if profile != nil && len(td.DefaultCertificate) > 0 && servesDefaultCertificate(&cfg, hascert) {
+			// The default certificate of the bind is served anyway,
+			// the entry applies the TLS profile of the route.
+			certPaths = []string{td.DefaultCertificate}
+			host = templateutil.GenCertificateHostName(cfg.Host, cfg.IsWildcard)
+		} else {
+			continue
+		}
+
+		if profile != nil {
+			options = append(options, profile.CrtListOptions()...)
+		}
+		for _, certPath := range certPaths {
+			if len(options) == 0 {
+				lines = append(lines, strings.Join([]string{certPath, host}, " "))
 			} else {
-				lines = append(lines, strings.Join([]string{fqCertPath, "[alpn h2,http/1.1]", entry.Value}, " "))
+				lines = append(lines, strings.Join([]string{certPath, "[" + strings.Join(options, " ") + "]", host}, " "))
 			}
 		}
 	}
@@ -234,6 +288,25 @@ func generateHAProxyCertConfigMap(td templateData) []string {
 	return lines
 }
 
+// matchDefaultCertificate returns the default certificate served for an edge
+// or reencrypt route without its own certificate, or nil if the route has its
+// own certificate or if its host matches none of the default certificates.
+func matchDefaultCertificate(certs []defaultcert.Certificate, cfg *ServiceAliasConfig, hascert bool) *defaultcert.Certificate {
+	if len(certs) == 0 || !servesDefaultCertificate(cfg, hascert) {
+		return nil
+	}
+	return defaultcert.Match(certs, cfg.Host, cfg.IsWildcard)
+}
+
+// servesDefaultCertificate returns whether a route is served a default
+// certificate, being an edge or reencrypt route without its own certificate.
+func servesDefaultCertificate(cfg *ServiceAliasConfig, hascert bool) bool {
+	if hascert || len(cfg.Host) == 0 {
+		return false
+	}
+	return cfg.TLSTermination == routev1.TLSTerminationEdge || cfg.TLSTermination == routev1.TLSTerminationReencrypt
+}
+
 // validateHAProxyAllowlist validates an allowlist for use with an haproxy acl.
 func validateHAProxyAllowlist(value string) bool {
 	_, valid := haproxyutil.ValidateAllowlist(value)
@@ -406,6 +479,82 @@ func parseIPList(list string) string {
 	return result
 }
 
+// samplingScale is the range of the random number haproxy compares to the
+// sampling thresholds, i.e. rand(samplingScale).
+const samplingScale = 1000000
+
+// traceSamplingThreshold returns the sampling threshold of the first valid
+// ratio among the given values. A ratio is a number between 0 and 1, a
+// request is sampled when a random number below samplingScale is lower than
+// the threshold. No valid ratio samples no request.
+func traceSamplingThreshold(ratios ...string) int {
+	for _, value := range ratios {
+		if len(value) == 0 {
+			continue
+		}
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(ratio) || ratio < 0 || ratio > 1 {
+			log.V(0).Info("traceSamplingThreshold ignoring invalid sampling ratio", "value", value)
+			continue
+		}
+		return int(math.Round(ratio * samplingScale))
+	}
+	return 0
+}
+
+// routeAccessLog is the access logging of the requests of a route.
+type routeAccessLog struct {
+	// Enabled is false if the requests are not logged.
+	Enabled bool
+	// Sampled is true if only a sample of the requests is logged: a request
+	// is logged when a random number below samplingScale is lower than
+	// SampleThreshold.
+	Sampled         bool
+	SampleThreshold int
+	// ErrorsOnly is true if only the requests failing with a 5xx status or
+	// without response, e.g. on timeouts, are logged.
+	ErrorsOnly bool
+}
+
+// parseRouteAccessLog returns the access logging of a route from its
+// annotations. The requests of the routes without the access-log
+// annotation are logged if defaultEnabled is true. Invalid annotations, which
+// the extended route validation rejects, are ignored.
+func parseRouteAccessLog(annotations map[string]string, defaultEnabled string) routeAccessLog {
+	var accessLog routeAccessLog
+
+	accessLog.Enabled = isTrue(defaultEnabled)
+	if enabled, err := strconv.ParseBool(annotations[accesslog.RouteAnnotation]); err == nil {
+		accessLog.Enabled = enabled
+	}
+
+	if value, ok := annotations[accesslog.SamplePercentAnnotation]; ok {
+		if percent, err := accesslog.ParseSamplePercent(value); err == nil && percent < 100 {
+			accessLog.Sampled = true
+			accessLog.SampleThreshold = int(math.Round(percent / 100 * samplingScale))
+		}
+	}
+
+	if errorsOnly, err := strconv.ParseBool(annotations[accesslog.ErrorsOnlyAnnotation]); err == nil {
+		accessLog.ErrorsOnly = errorsOnly
+	}
+	return accessLog
+}
+
+// accessLogJSONFormat returns the haproxy log-format of the JSON access logs
+// of the http frontends, capturing the given headers. The traceparent
+// headers are captured first when tracing is enabled.
+func accessLogJSONFormat(requestHeaders, responseHeaders []CaptureHTTPHeader, tracing bool) string {
+	opts := accesslog.FormatOptions{TraceCaptures: tracing}
+	for _, header := range requestHeaders {
+		opts.RequestHeaders = append(opts.RequestHeaders, header.Name)
+	}
+	for _, header := range responseHeaders {
+		opts.ResponseHeaders = append(opts.ResponseHeaders, header.Name)
+	}
+	return accesslog.HTTPLogFormat(opts)
+}
+
 var helperFunctions = template.FuncMap{
 	"endpointsForAlias":        endpointsForAlias,        //returns the list of valid endpoints
 	"processEndpointsForAlias": processEndpointsForAlias, //returns the list of valid endpoints after processing them
@@ -432,6 +581,12 @@ var helperFunctions = template.FuncMap{
 	"clipHAProxyTimeoutValue": clipHAProxyTimeoutValue, //clips extrodinarily high timeout values to be below the maximum allowed timeout value
 	"parseIPList":             parseIPList,             //parses the list of IPs/CIDRs (IPv4/IPv6)
 
+	"traceSamplingThreshold": traceSamplingThreshold, //returns the haproxy tracing sampling threshold of the first valid ratio
+	"parseRouteAccessLog":    parseRouteAccessLog,    //returns the access logging of a route from its annotations
+
+	"accessLogJSONFormat":    accessLogJSONFormat,    //returns the haproxy log-format of the JSON access logs of the http frontends
+	"accessLogTCPJSONFormat": accesslog.TCPLogFormat, //returns the haproxy log-format of the JSON access logs of the tcp frontends
+
 	"processRewriteTarget": rewritetarget.SanitizeInput,      //sanitizes `haproxy.router.openshift.io/rewrite-target` annotation
 	"escapeSingleQuotes":   rewritetarget.EscapeSingleQuotes, //escapes single quotes for safe use in single-quoted strings
 }
@@ -7,10 +7,13 @@ import (
 	"path"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 
 	routev1 "github.com/openshift/api/route/v1"
+	"github.com/openshift/router/pkg/router/accesslog"
+	"github.com/openshift/router/pkg/router/defaultcert"
 	templateutil "github.com/openshift/router/pkg/router/template/util"
 )
 
@@ -429,6 +432,166 @@ func TestGenerateHAProxyCertConfigMap(t *testing.T) {
 	}
 }
 
+// TestGenerateHAProxyCertConfigMapTLSProfile verifies that the TLS profile
+// of a route is rendered into the crt-list options of its certificate.
+func TestGenerateHAProxyCertConfigMapTLSProfile(t *testing.T) {
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		disableHTTP2 bool
+		expected     string
+	}{
+		{
+			name:     "no profile",
+			expected: "/path/to/router/certs/ns:route.pem [alpn h2,http/1.1] www.example.com",
+		},
+		{
+			name:         "no profile without http2",
+			disableHTTP2: true,
+			expected:     "/path/to/router/certs/ns:route.pem www.example.com",
+		},
+		{
+			name:        "modern profile",
+			annotations: map[string]string{"haproxy.router.openshift.io/tls-profile": "modern"},
+			expected:    "/path/to/router/certs/ns:route.pem [alpn h2,http/1.1 ssl-min-ver TLSv1.3 ciphersuites TLS_AES_128_GCM_SHA256:TLS_AES_256_GCM_SHA384:TLS_CHACHA20_POLY1305_SHA256 curves X25519:prime256v1:secp384r1] www.example.com",
+		},
+		{
+			name: "custom profile without http2",
+			annotations: map[string]string{
+				"haproxy.router.openshift.io/tls-profile":     "custom",
+				"haproxy.router.openshift.io/tls-min-version": "TLSv1.1",
+				"haproxy.router.openshift.io/tls-ciphers":     "ECDHE-RSA-AES128-SHA:AES128-SHA",
+			},
+			disableHTTP2: true,
+			expected:     "/path/to/router/certs/ns:route.pem [ssl-min-ver TLSv1.1 ciphers ECDHE-RSA-AES128-SHA:AES128-SHA] www.example.com",
+		},
+		{
+			name: "invalid profile",
+			annotations: map[string]string{
+				"haproxy.router.openshift.io/tls-profile": "custom",
+				"haproxy.router.openshift.io/tls-ciphers": "AES128-SHA] *.example.com",
+			},
+			expected: "/path/to/router/certs/ns:route.pem [alpn h2,http/1.1] www.example.com",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := buildServiceAliasConfig("route", "ns", "www.example.com", "", routev1.TLSTerminationEdge, routev1.InsecureEdgeTerminationPolicyNone, false)
+			cfg.Annotations = tc.annotations
+			td := templateData{
+				WorkingDir:   "/path/to",
+				State:        map[ServiceAliasConfigKey]ServiceAliasConfig{"ns:route": cfg},
+				ServiceUnits: make(map[ServiceUnitKey]ServiceUnit),
+				DisableHTTP2: tc.disableHTTP2,
+			}
+			lines := generateHAProxyCertConfigMap(td)
+			if len(lines) != 1 || lines[0] != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, lines)
+			}
+		})
+	}
+}
+
+// TestGenerateHAProxyCertConfigMapBundle verifies that each certificate of a
+// multi-cert bundle gets its own crt-list entry.
+func TestGenerateHAProxyCertConfigMapBundle(t *testing.T) {
+	cfg := buildServiceAliasConfig("route", "ns", "www.example.com", "", routev1.TLSTerminationReencrypt, routev1.InsecureEdgeTerminationPolicyNone, false)
+	cert := cfg.Certificates[cfg.Host]
+	cert.Bundle = []string{"rsa", "ecdsa"}
+	cfg.Certificates[cfg.Host] = cert
+	td := templateData{
+		WorkingDir:   "/path/to",
+		State:        map[ServiceAliasConfigKey]ServiceAliasConfig{"ns:route": cfg},
+		ServiceUnits: make(map[ServiceUnitKey]ServiceUnit),
+	}
+
+	expected := []string{
+		"/path/to/router/certs/ns:route.pem.rsa [alpn h2,http/1.1] www.example.com",
+		"/path/to/router/certs/ns:route.pem.ecdsa [alpn h2,http/1.1] www.example.com",
+	}
+	if lines := generateHAProxyCertConfigMap(td); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+}
+
+func TestGenerateHAProxyCertConfigMapDefaultCertificates(t *testing.T) {
+	state := map[ServiceAliasConfigKey]ServiceAliasConfig{
+		"ns:exact":     buildServiceAliasConfig("exact", "ns", "www.example.com", "", routev1.TLSTerminationEdge, routev1.InsecureEdgeTerminationPolicyNone, false),
+		"ns:subdomain": buildServiceAliasConfig("subdomain", "ns", "shop.apps.example.com", "", routev1.TLSTerminationReencrypt, routev1.InsecureEdgeTerminationPolicyNone, false),
+		"ns:wildcard":  buildServiceAliasConfig("wildcard", "ns", "www.apps.example.com", "", routev1.TLSTerminationEdge, routev1.InsecureEdgeTerminationPolicyNone, true),
+		"ns:nomatch":   buildServiceAliasConfig("nomatch", "ns", "www.example.org", "", routev1.TLSTerminationEdge, routev1.InsecureEdgeTerminationPolicyNone, false),
+	}
+	for k, cfg := range state {
+		cfg.Certificates = nil
+		state[k] = cfg
+	}
+	// A route with its own certificate keeps it.
+	state["ns:own"] = buildServiceAliasConfig("own", "ns", "own.apps.example.com", "", routev1.TLSTerminationEdge, routev1.InsecureEdgeTerminationPolicyNone, false)
+	td := templateData{
+		WorkingDir:   "/path/to",
+		State:        state,
+		ServiceUnits: make(map[ServiceUnitKey]ServiceUnit),
+		DefaultCertificates: []defaultcert.Certificate{
+			{Path: "/etc/pki/apps.pem", DNSNames: []string{"*.apps.example.com"}},
+			{Path: "/etc/pki/www.pem", DNSNames: []string{"www.example.com", "www.apps.example.com"}},
+		},
+	}
+
+	expected := []string{
+		"/etc/pki/apps.pem *.apps.example.com",
+		"/etc/pki/apps.pem shop.apps.example.com",
+		"/etc/pki/www.pem www.example.com",
+		"/path/to/router/certs/ns:own.pem [alpn h2,http/1.1] own.apps.example.com",
+	}
+	lines := generateHAProxyCertConfigMap(td)
+	sort.Strings(lines)
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+}
+
+// TestGenerateHAProxyCertConfigMapTLSProfileDefaultCertificate verifies that
+// the TLS profile of a route without its own certificate applies to the
+// default certificate it is served.
+func TestGenerateHAProxyCertConfigMapTLSProfileDefaultCertificate(t *testing.T) {
+	modern := map[string]string{"haproxy.router.openshift.io/tls-profile": "modern"}
+	state := map[ServiceAliasConfigKey]ServiceAliasConfig{
+		"ns:nocert":       buildServiceAliasConfig("nocert", "ns", "www.example.org", "", routev1.TLSTerminationEdge, routev1.InsecureEdgeTerminationPolicyNone, false),
+		"ns:noprofile":    buildServiceAliasConfig("noprofile", "ns", "noprofile.example.org", "", routev1.TLSTerminationEdge, routev1.InsecureEdgeTerminationPolicyNone, false),
+		"ns:match":        buildServiceAliasConfig("match", "ns", "shop.apps.example.com", "", routev1.TLSTerminationReencrypt, routev1.InsecureEdgeTerminationPolicyNone, false),
+		"ns:passthrough":  buildServiceAliasConfig("passthrough", "ns", "passthrough.example.org", "", routev1.TLSTerminationPassthrough, routev1.InsecureEdgeTerminationPolicyNone, false),
+		"ns:wildcardcert": buildServiceAliasConfig("wildcardcert", "ns", "www.wildcard.example.org", "", routev1.TLSTerminationEdge, routev1.InsecureEdgeTerminationPolicyNone, true),
+	}
+	for k, cfg := range state {
+		cfg.Certificates = nil
+		if k != "ns:noprofile" {
+			cfg.Annotations = modern
+		}
+		state[k] = cfg
+	}
+	td := templateData{
+		WorkingDir:         "/path/to",
+		State:              state,
+		ServiceUnits:       make(map[ServiceUnitKey]ServiceUnit),
+		DefaultCertificate: "/etc/pki/default.pem",
+		DefaultCertificates: []defaultcert.Certificate{
+			{Path: "/etc/pki/apps.pem", DNSNames: []string{"*.apps.example.com"}},
+		},
+	}
+
+	const options = "[ssl-min-ver TLSv1.3 ciphersuites TLS_AES_128_GCM_SHA256:TLS_AES_256_GCM_SHA384:TLS_CHACHA20_POLY1305_SHA256 curves X25519:prime256v1:secp384r1]"
+	expected := []string{
+		"/etc/pki/apps.pem " + options + " shop.apps.example.com",
+		"/etc/pki/default.pem " + options + " *.wildcard.example.org",
+		"/etc/pki/default.pem " + options + " www.example.org",
+	}
+	lines := generateHAProxyCertConfigMap(td)
+	sort.Strings(lines)
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+}
+
 func TestGenerateHAProxyMap(t *testing.T) {
 	td := templateData{
 		WorkingDir:   "/path/to",
@@ -1103,3 +1266,114 @@ func TestParseIPList(t *testing.T) {
 		})
 	}
 }
+
+func TestTraceSamplingThreshold(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ratios   []string
+		expected int
+	}{
+		{
+			name:     "no ratio",
+			expected: 0,
+		},
+		{
+			name:     "annotation overrides the default",
+			ratios:   []string{"0.5", "0.01"},
+			expected: 500000,
+		},
+		{
+			name:     "missing annotation",
+			ratios:   []string{"", "0.01"},
+			expected: 10000,
+		},
+		{
+			name:     "zero ratio samples no request",
+			ratios:   []string{"0", "0.01"},
+			expected: 0,
+		},
+		{
+			name:     "full ratio",
+			ratios:   []string{"1"},
+			expected: samplingScale,
+		},
+		{
+			name:     "invalid ratios are ignored",
+			ratios:   []string{"1.5", "-0.1", "NaN", "ten", "0.25"},
+			expected: 250000,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := traceSamplingThreshold(tc.ratios...); got != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseRouteAccessLog(t *testing.T) {
+	testCases := []struct {
+		name           string
+		annotations    map[string]string
+		defaultEnabled string
+		expected       routeAccessLog
+	}{
+		{
+			name:           "default",
+			defaultEnabled: "true",
+			expected:       routeAccessLog{Enabled: true},
+		},
+		{
+			name:           "disabled by default",
+			defaultEnabled: "false",
+			expected:       routeAccessLog{},
+		},
+		{
+			name:           "enabled by annotation",
+			annotations:    map[string]string{accesslog.RouteAnnotation: "true"},
+			defaultEnabled: "false",
+			expected:       routeAccessLog{Enabled: true},
+		},
+		{
+			name:           "disabled by annotation",
+			annotations:    map[string]string{accesslog.RouteAnnotation: "false"},
+			defaultEnabled: "true",
+			expected:       routeAccessLog{},
+		},
+		{
+			name: "sampled errors",
+			annotations: map[string]string{
+				accesslog.SamplePercentAnnotation: "0.5",
+				accesslog.ErrorsOnlyAnnotation:    "true",
+			},
+			defaultEnabled: "true",
+			expected:       routeAccessLog{Enabled: true, Sampled: true, SampleThreshold: 5000, ErrorsOnly: true},
+		},
+		{
+			name:           "full sample",
+			annotations:    map[string]string{accesslog.SamplePercentAnnotation: "100"},
+			defaultEnabled: "true",
+			expected:       routeAccessLog{Enabled: true},
+		},
+		{
+			name: "invalid annotations are ignored",
+			annotations: map[string]string{
+				accesslog.RouteAnnotation:         "maybe",
+				accesslog.SamplePercentAnnotation: "101",
+				accesslog.ErrorsOnlyAnnotation:    "sometimes",
+			},
+			defaultEnabled: "true",
+			expected:       routeAccessLog{Enabled: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parseRouteAccessLog(tc.annotations, tc.defaultEnabled); got != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
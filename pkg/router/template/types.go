@@ -5,6 +5,8 @@ import (
 	"time"
 
 	routev1 "github.com/openshift/api/route/v1"
+
+	haproxyutil "github.com/openshift/router/pkg/router/template/util/haproxy"
 )
 
 // ServiceUnit represents a service and its endpoints.
@@ -60,6 +62,9 @@ type ServiceAliasConfig struct {
 	// Annotations attached to this route
 	Annotations map[string]string
 
+	// Labels attached to this route
+	Labels map[string]string
+
 	// ServiceUnits is the weight for each service assigned to the route.
 	// It is used in calculating the weight for the server that is found in ServiceUnitNames
 	ServiceUnits map[ServiceUnitKey]int32
@@ -105,6 +110,11 @@ type Certificate struct {
 	ID         string
 	Contents   string
 	PrivateKey string
+	// Bundle is the key types, "rsa" and "ecdsa", of a dual certificate.
+	// A dual certificate is written as a multi-cert bundle, with one
+	// file per key type, so that haproxy presents the certificate
+	// supported by the client. Bundle is empty for a single certificate.
+	Bundle []string
 }
 
 // Endpoint is an internal representation of a k8s endpoint.
@@ -119,6 +129,45 @@ type Endpoint struct {
 	AppProtocol   string
 }
 
+// DesiredBackend is the state of a backend as expected by the router, used
+// to verify the changes applied to the underlying router without a reload.
+type DesiredBackend struct {
+	// Servers are the servers the backend should have.
+	Servers []DesiredServer
+
+	// MapEntries are the haproxy map entries of the backend, keyed by the
+	// map file name.
+	MapEntries map[string]haproxyutil.HAProxyMapEntry
+}
+
+// DesiredServer is the state of a backend server as expected by the router:
+// the endpoint it points to and the weight it should be configured with.
+type DesiredServer struct {
+	Endpoint
+
+	// Weight is the weight of the server, as rendered in the configuration.
+	Weight int32
+
+	// ServiceHostname is the hostname of the service this server belongs to.
+	ServiceHostname string
+}
+
+// BackendRoute is the route served by an haproxy backend, used to label
+// the backend metrics.
+type BackendRoute struct {
+	Namespace string
+	Name      string
+
+	// Service is the name of the primary service of the route.
+	Service string
+
+	// Termination is the route TLS termination.
+	Termination routev1.TLSTerminationType
+
+	// Labels are the route labels.
+	Labels map[string]string
+}
+
 // certificateManager provides the ability to write certificates for a ServiceAliasConfig
 type certificateManager interface {
 	// WriteCertificatesForConfig writes all certificates for all ServiceAliasConfigs in config
@@ -156,6 +205,8 @@ type certificateKeyFunc func(config *ServiceAliasConfig) string
 type certificateWriter interface {
 	WriteCertificate(directory string, id string, cert []byte) error
 	DeleteCertificate(directory, id string) error
+	WriteBundleCertificate(directory, id, keyType string, cert []byte) error
+	DeleteBundleCertificate(directory, id, keyType string) error
 }
 
 // ConfigManagerOptions is the options passed to a template router's
@@ -164,10 +215,21 @@ type ConfigManagerOptions struct {
 	// ConnectionInfo specifies how to connect to the underlying router.
 	ConnectionInfo string
 
+	// DynamicEndpointsOnly restricts the configuration manager to endpoint
+	// changes on the existing backends of the underlying router. Route
+	// changes are always applied with a reload and no blueprint routes
+	// are needed.
+	DynamicEndpointsOnly bool
+
 	// CommitInterval specifies how often to commit changes made to the
 	// underlying router via the configuration manager.
 	CommitInterval time.Duration
 
+	// ReconcileInterval specifies how often to verify that the changes
+	// made via the configuration manager match the router state, and to
+	// repair any drift found. Zero disables it.
+	ReconcileInterval time.Duration
+
 	// BlueprintRoutes are a list of routes blueprints pre-allocated by
 	// the config manager to dynamically manage route additions.
 	BlueprintRoutes []*routev1.Route
@@ -228,6 +290,10 @@ type ConfigManager interface {
 	// RemoveRouteEndpoints removes a set of endpoints from a route.
 	RemoveRouteEndpoints(id ServiceAliasConfigKey, endpoints []Endpoint) error
 
+	// Commit applies the changes made since the last commit. An error
+	// means the changes were not, or only partially, applied.
+	Commit() error
+
 	// Notify notifies a configuration manager of a router event.
 	// Currently the only ones that are received are on reload* events,
 	// which indicates whether or not the configuration manager should
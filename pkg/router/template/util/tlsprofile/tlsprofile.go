@@ -0,0 +1,228 @@
+package tlsprofile
+
+import (
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// ProfileAnnotation selects the TLS profile of a route, overriding the
+	// global SSL_MIN_VERSION, SSL_MAX_VERSION, ROUTER_CIPHERS,
+	// ROUTER_CIPHERSUITES and ROUTER_CURVES settings for the connections
+	// presenting the certificate of the route.
+	ProfileAnnotation = "haproxy.router.openshift.io/tls-profile"
+	// MinVersionAnnotation is the minimum TLS version of a custom profile.
+	MinVersionAnnotation = "haproxy.router.openshift.io/tls-min-version"
+	// MaxVersionAnnotation is the maximum TLS version of a custom profile.
+	MaxVersionAnnotation = "haproxy.router.openshift.io/tls-max-version"
+	// CiphersAnnotation is the colon separated list of the TLSv1.2 and
+	// lower ciphers of a custom profile.
+	CiphersAnnotation = "haproxy.router.openshift.io/tls-ciphers"
+	// CiphersuitesAnnotation is the colon separated list of the TLSv1.3
+	// cipher suites of a custom profile.
+	CiphersuitesAnnotation = "haproxy.router.openshift.io/tls-ciphersuites"
+	// CurvesAnnotation is the colon separated list of the key exchange
+	// groups of a custom profile.
+	CurvesAnnotation = "haproxy.router.openshift.io/tls-curves"
+)
+
+const (
+	// Old is the Mozilla "old" profile, for the legacy clients only.
+	Old = "old"
+	// Intermediate is the Mozilla "intermediate" profile.
+	Intermediate = "intermediate"
+	// Modern is the Mozilla "modern" profile, which only accepts TLSv1.3.
+	Modern = "modern"
+	// Custom is a profile whose settings are given by the other TLS
+	// annotations of the route. The settings which are not given keep their
+	// global value.
+	Custom = "custom"
+)
+
+// customAnnotations are the annotations giving the settings of a custom
+// profile.
+var customAnnotations = []string{
+	MinVersionAnnotation,
+	MaxVersionAnnotation,
+	CiphersAnnotation,
+	CiphersuitesAnnotation,
+	CurvesAnnotation,
+}
+
+// Annotations is the list of the annotations of a route which modify its TLS
+// profile.
+var Annotations = append([]string{ProfileAnnotation}, customAnnotations...)
+
+var (
+	// versions are the TLS versions accepted by haproxy, in increasing
+	// order.
+	versions = []string{"TLSv1.0", "TLSv1.1", "TLSv1.2", "TLSv1.3"}
+
+	// cipherListPattern matches an OpenSSL cipher list. It notably excludes
+	// the spaces and brackets, which would break the crt-list line the
+	// profile is rendered into.
+	cipherListPattern = regexp.MustCompile(`^[A-Za-z0-9_+!@=.-]+(:[A-Za-z0-9_+!@=.-]+)*$`)
+
+	// curveListPattern matches an OpenSSL list of groups.
+	curveListPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(:[A-Za-z0-9_-]+)*$`)
+)
+
+// Profile is the TLS protocol and cipher policy of a route. Empty settings
+// keep their global value.
+type Profile struct {
+	MinVersion   string
+	MaxVersion   string
+	Ciphers      string
+	Ciphersuites string
+	Curves       string
+}
+
+const (
+	mozillaCiphersuites = "TLS_AES_128_GCM_SHA256:TLS_AES_256_GCM_SHA384:TLS_CHACHA20_POLY1305_SHA256"
+	mozillaCurves       = "X25519:prime256v1:secp384r1"
+)
+
+// profiles are the named profiles, from the version 5.7 of the server side TLS
+// recommendations of https://wiki.mozilla.org/Security/Server_Side_TLS.
+var profiles = map[string]Profile{
+	Old: {
+		MinVersion: "TLSv1.0",
+		// OpenSSL 3 refuses TLSv1.0 and TLSv1.1, as well as the SHA1
+		// signatures they rely on, above the security level 0.
+		Ciphers:      "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES128-SHA:ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:DHE-RSA-AES128-SHA256:DHE-RSA-AES256-SHA256:AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:AES256-SHA256:AES128-SHA:AES256-SHA:DES-CBC3-SHA:@SECLEVEL=0",
+		Ciphersuites: mozillaCiphersuites,
+		Curves:       mozillaCurves,
+	},
+	Intermediate: {
+		MinVersion:   "TLSv1.2",
+		Ciphers:      "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305",
+		Ciphersuites: mozillaCiphersuites,
+		Curves:       mozillaCurves,
+	},
+	Modern: {
+		MinVersion:   "TLSv1.3",
+		Ciphersuites: mozillaCiphersuites,
+		Curves:       mozillaCurves,
+	},
+}
+
+// FromAnnotations returns the TLS profile selected by the annotations of a
+// route, or nil if the route uses the global settings. Returns an error if the
+// annotations are not valid.
+func FromAnnotations(annotations map[string]string) (*Profile, error) {
+	profile, errs := parse(annotations, field.NewPath("metadata", "annotations"))
+	if len(errs) != 0 {
+		return nil, errs.ToAggregate()
+	}
+	return profile, nil
+}
+
+// Validate validates the TLS profile annotations of a route.
+func Validate(annotations map[string]string, fldPath *field.Path) field.ErrorList {
+	_, errs := parse(annotations, fldPath)
+	return errs
+}
+
+// parse returns the TLS profile selected by annotations, and the errors of the
+// invalid annotations.
+func parse(annotations map[string]string, fldPath *field.Path) (*Profile, field.ErrorList) {
+	errs := field.ErrorList{}
+	name, ok := annotations[ProfileAnnotation]
+	if !ok {
+		for _, annotation := range customAnnotations {
+			if _, ok := annotations[annotation]; ok {
+				errs = append(errs, field.Forbidden(fldPath.Key(annotation), "only allowed with the "+Custom+" "+ProfileAnnotation))
+			}
+		}
+		return nil, errs
+	}
+
+	if name != Custom {
+		profile, ok := profiles[name]
+		if !ok {
+			errs = append(errs, field.NotSupported(fldPath.Key(ProfileAnnotation), name, []string{Old, Intermediate, Modern, Custom}))
+		}
+		for _, annotation := range customAnnotations {
+			if _, ok := annotations[annotation]; ok {
+				errs = append(errs, field.Forbidden(fldPath.Key(annotation), "only allowed with the "+Custom+" "+ProfileAnnotation))
+			}
+		}
+		if len(errs) != 0 {
+			return nil, errs
+		}
+		return &profile, nil
+	}
+
+	profile := &Profile{
+		MinVersion:   annotations[MinVersionAnnotation],
+		MaxVersion:   annotations[MaxVersionAnnotation],
+		Ciphers:      annotations[CiphersAnnotation],
+		Ciphersuites: annotations[CiphersuitesAnnotation],
+		Curves:       annotations[CurvesAnnotation],
+	}
+	if *profile == (Profile{}) {
+		errs = append(errs, field.Required(fldPath.Key(ProfileAnnotation), "the "+Custom+" profile requires at least one of "+strings.Join(customAnnotations, ", ")))
+	}
+	minIndex, maxIndex := -1, len(versions)
+	if len(profile.MinVersion) != 0 {
+		if minIndex = versionIndex(profile.MinVersion); minIndex < 0 {
+			errs = append(errs, field.NotSupported(fldPath.Key(MinVersionAnnotation), profile.MinVersion, versions))
+		}
+	}
+	if len(profile.MaxVersion) != 0 {
+		if maxIndex = versionIndex(profile.MaxVersion); maxIndex < 0 {
+			errs = append(errs, field.NotSupported(fldPath.Key(MaxVersionAnnotation), profile.MaxVersion, versions))
+		}
+	}
+	if minIndex >= 0 && maxIndex >= 0 && minIndex > maxIndex {
+		errs = append(errs, field.Invalid(fldPath.Key(MaxVersionAnnotation), profile.MaxVersion, "must not be lower than "+profile.MinVersion))
+	}
+	if _, ok := annotations[CiphersAnnotation]; ok && !cipherListPattern.MatchString(profile.Ciphers) {
+		errs = append(errs, field.Invalid(fldPath.Key(CiphersAnnotation), profile.Ciphers, "must be a colon separated list of ciphers"))
+	}
+	if _, ok := annotations[CiphersuitesAnnotation]; ok && !cipherListPattern.MatchString(profile.Ciphersuites) {
+		errs = append(errs, field.Invalid(fldPath.Key(CiphersuitesAnnotation), profile.Ciphersuites, "must be a colon separated list of cipher suites"))
+	}
+	if _, ok := annotations[CurvesAnnotation]; ok && !curveListPattern.MatchString(profile.Curves) {
+		errs = append(errs, field.Invalid(fldPath.Key(CurvesAnnotation), profile.Curves, "must be a colon separated list of curves"))
+	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+	return profile, nil
+}
+
+// versionIndex returns the index of version in versions, or -1 if it is not a
+// supported version.
+func versionIndex(version string) int {
+	for i, v := range versions {
+		if v == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// CrtListOptions returns the crt-list SSL options applying the profile to the
+// connections which select the certificate of the entry.
+func (p *Profile) CrtListOptions() []string {
+	var options []string
+	if len(p.MinVersion) != 0 {
+		options = append(options, "ssl-min-ver", p.MinVersion)
+	}
+	if len(p.MaxVersion) != 0 {
+		options = append(options, "ssl-max-ver", p.MaxVersion)
+	}
+	if len(p.Ciphers) != 0 {
+		options = append(options, "ciphers", p.Ciphers)
+	}
+	if len(p.Ciphersuites) != 0 {
+		options = append(options, "ciphersuites", p.Ciphersuites)
+	}
+	if len(p.Curves) != 0 {
+		options = append(options, "curves", p.Curves)
+	}
+	return options
+}
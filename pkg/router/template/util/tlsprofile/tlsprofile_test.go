@@ -0,0 +1,101 @@
+package tlsprofile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFromAnnotations(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    *Profile
+		expectErr   bool
+	}{
+		{
+			name: "no profile",
+		},
+		{
+			name:        "named profile",
+			annotations: map[string]string{ProfileAnnotation: Intermediate},
+			expected:    &Profile{MinVersion: "TLSv1.2", Ciphers: profiles[Intermediate].Ciphers, Ciphersuites: mozillaCiphersuites, Curves: mozillaCurves},
+		},
+		{
+			name:        "unknown profile",
+			annotations: map[string]string{ProfileAnnotation: "legacy"},
+			expectErr:   true,
+		},
+		{
+			name:        "named profile with custom settings",
+			annotations: map[string]string{ProfileAnnotation: Modern, CurvesAnnotation: "X25519"},
+			expectErr:   true,
+		},
+		{
+			name:        "custom settings without profile",
+			annotations: map[string]string{MinVersionAnnotation: "TLSv1.0"},
+			expectErr:   true,
+		},
+		{
+			name: "custom profile",
+			annotations: map[string]string{
+				ProfileAnnotation:      Custom,
+				MinVersionAnnotation:   "TLSv1.2",
+				MaxVersionAnnotation:   "TLSv1.2",
+				CiphersAnnotation:      "ECDHE-RSA-AES128-SHA:!aNULL:@SECLEVEL=0",
+				CiphersuitesAnnotation: "TLS_AES_128_GCM_SHA256",
+				CurvesAnnotation:       "secp384r1",
+			},
+			expected: &Profile{MinVersion: "TLSv1.2", MaxVersion: "TLSv1.2", Ciphers: "ECDHE-RSA-AES128-SHA:!aNULL:@SECLEVEL=0", Ciphersuites: "TLS_AES_128_GCM_SHA256", Curves: "secp384r1"},
+		},
+		{
+			name:        "empty custom profile",
+			annotations: map[string]string{ProfileAnnotation: Custom},
+			expectErr:   true,
+		},
+		{
+			name:        "unsupported version",
+			annotations: map[string]string{ProfileAnnotation: Custom, MinVersionAnnotation: "SSLv3"},
+			expectErr:   true,
+		},
+		{
+			name:        "inverted versions",
+			annotations: map[string]string{ProfileAnnotation: Custom, MinVersionAnnotation: "TLSv1.3", MaxVersionAnnotation: "TLSv1.2"},
+			expectErr:   true,
+		},
+		{
+			name:        "ciphers breaking the crt-list",
+			annotations: map[string]string{ProfileAnnotation: Custom, CiphersAnnotation: "AES128-SHA] *"},
+			expectErr:   true,
+		},
+		{
+			name:        "empty curves",
+			annotations: map[string]string{ProfileAnnotation: Custom, MinVersionAnnotation: "TLSv1.2", CurvesAnnotation: ""},
+			expectErr:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			profile, err := FromAnnotations(tc.annotations)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", profile)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(profile, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, profile)
+			}
+		})
+	}
+}
+
+func TestCrtListOptions(t *testing.T) {
+	profile := &Profile{MinVersion: "TLSv1.0", MaxVersion: "TLSv1.2", Ciphers: "AES128-SHA", Curves: "X25519"}
+	expected := []string{"ssl-min-ver", "TLSv1.0", "ssl-max-ver", "TLSv1.2", "ciphers", "AES128-SHA", "curves", "X25519"}
+	if options := profile.CrtListOptions(); !reflect.DeepEqual(options, expected) {
+		t.Errorf("expected %q, got %q", expected, options)
+	}
+}
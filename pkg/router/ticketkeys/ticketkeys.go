@@ -0,0 +1,186 @@
+// Package ticketkeys manages the TLS session ticket keys of haproxy. The keys
+// are derived from a seed shared by the router replicas, usually mounted from a
+// Secret, so that the sessions resume across the replicas and the reloads, and
+// they are rotated on a schedule through the runtime API, without a reload.
+package ticketkeys
+
+import (
+	"bytes"
+	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	logf "github.com/openshift/router/log"
+	"github.com/openshift/router/pkg/router/client"
+)
+
+var log = logf.Logger.WithName("ticketkeys")
+
+const (
+	// DefaultRotationInterval is the default period of the ticket keys.
+	DefaultRotationInterval = time.Hour
+
+	// MinSeedSize is the minimum size in bytes of the seed of the keys.
+	MinSeedSize = 32
+
+	// keySize is the size of an haproxy ticket key: the 16 bytes key name,
+	// followed by the 32 bytes AES and HMAC keys.
+	keySize = 80
+
+	// keyCount is the number of keys haproxy holds (TLS_TICKETS_NO): the
+	// penultimate one encrypts the tickets, all of them decrypt them.
+	keyCount = 3
+)
+
+// Manager writes the ticket keys file referenced by the haproxy bind lines,
+// and rotates the keys at the start of every period. Each period has its own
+// key; haproxy holds the keys of the previous, the current and the next
+// periods, and encrypts with the key of the current one. As the replicas
+// derive the same keys from the same seed at the same time, each one resumes
+// the sessions of the others, even if their clocks are slightly skewed.
+type Manager struct {
+	seedPath string
+	keysPath string
+	endpoint string
+	interval time.Duration
+
+	// runCommand and nowFn allow the manager to be tested.
+	runCommand func(ctx context.Context, endpoint, cmd string, opts client.ClientOpts) (string, error)
+	nowFn      func() time.Time
+}
+
+// NewManager returns a Manager deriving the keys from the seed read from
+// seedPath, writing them to keysPath and rotating them every interval through
+// the haproxy runtime API at endpoint.
+func NewManager(seedPath, keysPath, endpoint string, interval time.Duration) *Manager {
+	return &Manager{
+		seedPath:   seedPath,
+		keysPath:   keysPath,
+		endpoint:   endpoint,
+		interval:   interval,
+		runCommand: client.RunCommand,
+		nowFn:      time.Now,
+	}
+}
+
+// KeysPath returns the path of the ticket keys file.
+func (m *Manager) KeysPath() string {
+	return m.keysPath
+}
+
+// WriteKeys writes the keys of the current period to the keys file, which
+// haproxy reads when it starts or reloads.
+func (m *Manager) WriteKeys() error {
+	_, err := m.writeKeys(m.period(m.nowFn()))
+	return err
+}
+
+// Run rotates the keys at the start of every period until stopCh is closed.
+// A change of the seed is applied at the next rotation.
+func (m *Manager) Run(stopCh <-chan struct{}) {
+	for {
+		now := m.nowFn()
+		next := time.Unix((m.period(now)+1)*int64(m.interval/time.Second), 0)
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(next.Sub(now)):
+		}
+		// The rotation is retried until the next period, after which the
+		// keys of that period are applied.
+		period := m.period(next)
+		wait.ExponentialBackoffWithContext(wait.ContextForChannel(stopCh), wait.Backoff{Duration: time.Second, Factor: 2, Steps: 10, Cap: m.interval / 2}, func(ctx context.Context) (bool, error) {
+			if err := m.rotate(ctx, period); err != nil {
+				log.Error(err, "failed to rotate the TLS ticket keys", "period", period)
+				return m.period(m.nowFn()) != period, nil
+			}
+			return true, nil
+		})
+	}
+}
+
+// rotate writes the keys of period to the keys file and sets them in haproxy.
+func (m *Manager) rotate(ctx context.Context, period int64) error {
+	keys, err := m.writeKeys(period)
+	if err != nil {
+		return err
+	}
+	// Each key set through the runtime API becomes the last of the keys of
+	// the file, and the oldest one is dropped: setting all the keys in order
+	// leaves haproxy with the keys of the file, whichever keys it held.
+	commands := make([]string, 0, len(keys))
+	for _, key := range keys {
+		commands = append(commands, "set ssl tls-key "+m.keysPath+" "+key)
+	}
+	response, err := m.runCommand(ctx, m.endpoint, strings.Join(commands, ";"), client.ClientOpts{})
+	if err != nil {
+		return err
+	}
+	if updated := strings.Count(response, "TLS ticket key updated!"); updated != len(keys) {
+		return fmt.Errorf("unexpected response of haproxy: %q", response)
+	}
+	log.V(2).Info("rotated the TLS ticket keys", "period", period)
+	return nil
+}
+
+// writeKeys writes the keys of period to the keys file, replacing it
+// atomically. Returns the keys.
+func (m *Manager) writeKeys(period int64) ([]string, error) {
+	seed, err := os.ReadFile(m.seedPath)
+	if err != nil {
+		return nil, err
+	}
+	seed = bytes.TrimSpace(seed)
+	if len(seed) < MinSeedSize {
+		return nil, fmt.Errorf("the TLS ticket key seed in %s must be at least %d bytes long", m.seedPath, MinSeedSize)
+	}
+	keys, err := deriveKeys(seed, period)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.keysPath), ".tls-ticket-keys-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(keys, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), m.keysPath); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// period returns the number of the period of t.
+func (m *Manager) period(t time.Time) int64 {
+	return t.Unix() / int64(m.interval/time.Second)
+}
+
+// deriveKeys returns the base64 encoded keys of the previous, the current and
+// the next periods.
+func deriveKeys(seed []byte, period int64) ([]string, error) {
+	keys := make([]string, 0, keyCount)
+	for p := period - 1; p <= period+1; p++ {
+		key, err := hkdf.Key(sha256.New, seed, nil, "openshift-router tls ticket key "+strconv.FormatInt(p, 10), keySize)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, base64.StdEncoding.EncodeToString(key))
+	}
+	return keys, nil
+}
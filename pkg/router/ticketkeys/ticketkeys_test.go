@@ -0,0 +1,103 @@
+package ticketkeys
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/router/pkg/router/client"
+)
+
+func newTestManager(t *testing.T, seed string) (*Manager, *time.Time) {
+	dir := t.TempDir()
+	seedPath := filepath.Join(dir, "seed")
+	require.NoError(t, os.WriteFile(seedPath, []byte(seed), 0600))
+	now := time.Date(2026, time.October, 19, 10, 30, 0, 0, time.UTC)
+	m := NewManager(seedPath, filepath.Join(dir, "tls-ticket-keys"), "unix:///var/lib/haproxy/run/haproxy.sock", time.Hour)
+	m.nowFn = func() time.Time { return now }
+	return m, &now
+}
+
+func readKeys(t *testing.T, m *Manager) []string {
+	data, err := os.ReadFile(m.KeysPath())
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestWriteKeys(t *testing.T) {
+	seed := strings.Repeat("s", MinSeedSize)
+	m, now := newTestManager(t, seed+"\n")
+	require.NoError(t, m.WriteKeys())
+	keys := readKeys(t, m)
+	require.Len(t, keys, keyCount)
+	for _, key := range keys {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		require.NoError(t, err)
+		assert.Len(t, raw, keySize)
+	}
+	assert.Len(t, map[string]bool{keys[0]: true, keys[1]: true, keys[2]: true}, keyCount, "each period must have its own key")
+
+	// Another replica with the same seed writes the same keys within the
+	// same period, whatever the trailing whitespace of the seed.
+	other, otherNow := newTestManager(t, seed)
+	*otherNow = now.Add(29 * time.Minute)
+	require.NoError(t, other.WriteKeys())
+	assert.Equal(t, keys, readKeys(t, other))
+
+	// In the next period, the keys shift by one.
+	*otherNow = now.Add(30 * time.Minute)
+	require.NoError(t, other.WriteKeys())
+	assert.Equal(t, keys[1:], readKeys(t, other)[:keyCount-1])
+
+	// Another seed gives other keys.
+	other, _ = newTestManager(t, strings.Repeat("t", MinSeedSize))
+	require.NoError(t, other.WriteKeys())
+	assert.NotContains(t, readKeys(t, other), keys[1])
+}
+
+func TestWriteKeysShortSeed(t *testing.T) {
+	m, _ := newTestManager(t, strings.Repeat("s", MinSeedSize-1)+"\n")
+	assert.Error(t, m.WriteKeys())
+	_, err := os.Stat(m.KeysPath())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotate(t *testing.T) {
+	m, now := newTestManager(t, strings.Repeat("s", MinSeedSize))
+	var commands []string
+	var response string
+	var runErr error
+	m.runCommand = func(ctx context.Context, endpoint, cmd string, opts client.ClientOpts) (string, error) {
+		assert.Equal(t, "unix:///var/lib/haproxy/run/haproxy.sock", endpoint)
+		commands = append(commands, cmd)
+		return response, runErr
+	}
+
+	response = strings.Repeat("TLS ticket key updated!\n\n", keyCount)
+	*now = now.Add(30 * time.Minute)
+	require.NoError(t, m.rotate(context.Background(), m.period(*now)))
+	keys := readKeys(t, m)
+	require.Len(t, commands, 1)
+	expected := []string{}
+	for _, key := range keys {
+		expected = append(expected, "set ssl tls-key "+m.KeysPath()+" "+key)
+	}
+	assert.Equal(t, strings.Join(expected, ";"), commands[0])
+
+	response = "Unknown command.\n"
+	assert.Error(t, m.rotate(context.Background(), m.period(*now)))
+
+	runErr = errors.New("connection refused")
+	assert.Error(t, m.rotate(context.Background(), m.period(*now)))
+	// The keys file is written even if haproxy is not running, which
+	// reads it when it starts.
+	assert.Equal(t, keys, readKeys(t, m))
+}
@@ -0,0 +1,185 @@
+package tracing
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/openshift/router/pkg/router/accesslog"
+)
+
+const (
+	// acceptDateLayout is the layout of the haproxy accept date, e.g.
+	// "18/Oct/2026:10:01:02.123".
+	acceptDateLayout = "02/Jan/2006:15:04:05.000"
+
+	// traceParentVersion is the only supported version of the W3C
+	// traceparent header.
+	traceParentVersion = "00"
+)
+
+// Indexes of the haproxy http timers.
+const (
+	timerRequest = iota
+	timerQueue
+	timerConnect
+	timerResponse
+	timerActive
+)
+
+// accessLogEntry is a request of the haproxy access logs.
+type accessLogEntry struct {
+	start                     time.Time
+	frontend, backend, server string
+	// timers are the TR/Tw/Tc/Tr/Ta timers in milliseconds, negative when
+	// the step was not reached.
+	timers [5]int64
+	status int
+
+	method, path string
+
+	// parent is the traceparent header received by haproxy, empty if none.
+	// context is the traceparent header forwarded to the server, whose
+	// parent id is the router span id.
+	parent, context string
+}
+
+// parseAccessLogLine parses an access log line in the JSON format or in the
+// haproxy httplog format, possibly prefixed with a syslog header:
+//
+//	client:port [date] frontend backend/server TR/Tw/Tc/Tr/Ta status bytes ... {parent|context|...} "method uri version"
+//
+// The incoming and forwarded traceparent headers are the first two request
+// captures.
+func parseAccessLogLine(line string) (accessLogEntry, bool) {
+	if e, ok := accesslog.ParseJSON(line, "tracing"); ok {
+		return accessLogEntry{
+			start:    time.UnixMilli(e.AcceptTimeMS),
+			frontend: e.Frontend,
+			backend:  e.Backend,
+			server:   e.Server,
+			timers:   [5]int64{e.RequestMS, e.QueueMS, e.ConnectMS, e.ResponseMS, e.ActiveMS},
+			status:   e.Status,
+			method:   e.Method,
+			path:     e.Path,
+			parent:   e.TraceParent,
+			context:  e.TraceContext,
+		}, true
+	}
+
+	var entry accessLogEntry
+
+	fields := strings.Fields(line)
+	date := -1
+	for i, field := range fields {
+		if !strings.HasPrefix(field, "[") || !strings.HasSuffix(field, "]") {
+			continue
+		}
+		start, err := time.ParseInLocation(acceptDateLayout, field[1:len(field)-1], time.Local)
+		if err == nil {
+			entry.start = start
+			date = i
+			break
+		}
+	}
+	if date < 0 || len(fields) < date+5 {
+		return accessLogEntry{}, false
+	}
+
+	entry.frontend = fields[date+1]
+	backend, server, ok := strings.Cut(fields[date+2], "/")
+	if !ok {
+		return accessLogEntry{}, false
+	}
+	entry.backend, entry.server = backend, server
+
+	timers := strings.Split(fields[date+3], "/")
+	if len(timers) != len(entry.timers) {
+		return accessLogEntry{}, false
+	}
+	for i, timer := range timers {
+		v, err := strconv.ParseInt(strings.TrimPrefix(timer, "+"), 10, 64)
+		if err != nil {
+			return accessLogEntry{}, false
+		}
+		entry.timers[i] = v
+	}
+
+	status, err := strconv.Atoi(fields[date+4])
+	if err != nil {
+		return accessLogEntry{}, false
+	}
+	entry.status = status
+
+	// The captured headers and the request are found after the status, the
+	// captured values may contain spaces.
+	rest := line[strings.Index(line, fields[date+3])+len(fields[date+3]):]
+	if open := strings.Index(rest, "{"); open >= 0 {
+		if end := strings.Index(rest[open:], "}"); end >= 0 {
+			captures := strings.Split(rest[open+1:open+end], "|")
+			if len(captures) >= 2 {
+				entry.parent, entry.context = captures[0], captures[1]
+			}
+			rest = rest[open+end+1:]
+		}
+	}
+	if open, end := strings.Index(rest, `"`), strings.LastIndex(rest, `"`); open >= 0 && end > open {
+		request := strings.Fields(rest[open+1 : end])
+		if len(request) >= 2 {
+			entry.method, entry.path = request[0], requestPath(request[1])
+		}
+	}
+	return entry, true
+}
+
+// requestPath returns the path of a request uri, without the query.
+func requestPath(uri string) string {
+	if u, err := url.ParseRequestURI(uri); err == nil {
+		return u.Path
+	}
+	path, _, _ := strings.Cut(uri, "?")
+	return path
+}
+
+// parseTraceParent parses a W3C traceparent header value.
+func parseTraceParent(value string) (trace.SpanContext, bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 4 || parts[0] != traceParentVersion || len(parts[3]) != 2 {
+		return trace.SpanContext{}, false
+	}
+	traceID, err := trace.TraceIDFromHex(parts[1])
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	spanID, err := trace.SpanIDFromHex(parts[2])
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.TraceFlags(flags),
+		Remote:     true,
+	}), true
+}
+
+// routeName returns the namespace and name of the route of a backend, e.g.
+// "be_http:namespace:name".
+func routeName(backend string) (string, string, bool) {
+	_, route, ok := strings.Cut(backend, ":")
+	if !ok {
+		return "", "", false
+	}
+	namespace, name, ok := strings.Cut(route, ":")
+	if !ok || len(namespace) == 0 || len(name) == 0 {
+		return "", "", false
+	}
+	return namespace, name, true
+}
@@ -0,0 +1,224 @@
+// Package tracing exports the spans of the requests proxied by haproxy to an
+// OpenTelemetry collector.
+//
+// haproxy propagates or starts the W3C trace context of the requests and
+// logs the received and forwarded traceparent headers. The exporter receives
+// these access logs and emits a span for each sampled request, timed after
+// the haproxy timers, so that no haproxy tracing module is needed.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+
+	logf "github.com/openshift/router/log"
+	"github.com/openshift/router/pkg/router/accesslog"
+)
+
+var log = logf.Logger.WithName("tracing")
+
+const (
+	// tracerName is the instrumentation scope of the router spans.
+	tracerName = "github.com/openshift/router/pkg/router/tracing"
+
+	// shutdownTimeout bounds the export of the pending spans on Close.
+	shutdownTimeout = 5 * time.Second
+)
+
+// Options are the options of the span exporter.
+type Options struct {
+	// Endpoint is the host:port of the OTLP gRPC collector.
+	Endpoint string
+	// Insecure disables the TLS connection to the collector.
+	Insecure bool
+	// AccessLogAddress is the address haproxy sends the access logs to,
+	// either a host:port UDP address or the path of a unix datagram socket.
+	AccessLogAddress string
+	// ServiceName is the service name of the spans.
+	ServiceName string
+}
+
+// Exporter receives the haproxy access logs and exports a span for each
+// sampled request.
+type Exporter struct {
+	opts     Options
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	conn     net.PacketConn
+}
+
+// NewExporter returns an Exporter sending the spans to the configured OTLP
+// collector. The standard OTEL_EXPORTER_OTLP_* environment variables
+// apply to the connection to the collector.
+func NewExporter(opts Options) (*Exporter, error) {
+	clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+	}
+	spanExporter, err := otlptracegrpc.New(context.Background(), clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("can't export spans to %s: %v", opts.Endpoint, err)
+	}
+	return newExporter(opts, sdktrace.WithBatcher(spanExporter)), nil
+}
+
+// newExporter returns an Exporter sending the spans to the given span
+// processor.
+func newExporter(opts Options, processor sdktrace.TracerProviderOption) *Exporter {
+	if len(opts.ServiceName) == 0 {
+		opts.ServiceName = "openshift-router"
+	}
+	provider := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(opts.ServiceName))),
+		// haproxy made the sampling decision, only the sampled requests
+		// are exported.
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithIDGenerator(accessLogIDGenerator{}),
+	)
+	return &Exporter{
+		opts:     opts,
+		provider: provider,
+		tracer:   provider.Tracer(tracerName),
+	}
+}
+
+// Listen starts receiving the access logs on the configured address. The
+// datagrams are processed in a goroutine until Close is called.
+func (e *Exporter) Listen() error {
+	conn, err := accesslog.Listen(e.opts.AccessLogAddress, func(line string) { e.Export(line) })
+	if err != nil {
+		return err
+	}
+	e.conn = conn
+	return nil
+}
+
+// Close stops receiving the access logs and flushes the pending spans.
+func (e *Exporter) Close() error {
+	var err error
+	if e.conn != nil {
+		err = e.conn.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return errors.Join(err, e.provider.Shutdown(ctx))
+}
+
+// Export emits the span of an access log line, if its request was sampled.
+// It returns false if the line does not hold a sampled request.
+func (e *Exporter) Export(line string) bool {
+	entry, ok := parseAccessLogLine(line)
+	if !ok {
+		log.V(4).Info("ignoring unparseable access log line", "line", line)
+		return false
+	}
+	spanContext, ok := parseTraceParent(entry.context)
+	if !ok || !spanContext.IsSampled() {
+		return false
+	}
+
+	ctx := context.Background()
+	if parent, ok := parseTraceParent(entry.parent); ok && parent.TraceID() == spanContext.TraceID() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, parent)
+	}
+	// The router span id is the parent id haproxy forwarded to the server.
+	ctx = context.WithValue(ctx, spanIDsKey{}, spanContext)
+
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(entry.method),
+		semconv.URLPath(entry.path),
+		attribute.String("haproxy.frontend", entry.frontend),
+		attribute.String("haproxy.backend", entry.backend),
+		attribute.String("haproxy.server", entry.server),
+	}
+	name := entry.method
+	if namespace, route, ok := routeName(entry.backend); ok {
+		name = entry.method + " " + namespace + "/" + route
+		attrs = append(attrs, semconv.K8SNamespaceName(namespace), attribute.String("openshift.route.name", route))
+	}
+	if entry.status > 0 {
+		attrs = append(attrs, semconv.HTTPResponseStatusCode(entry.status))
+	}
+	for i, key := range []string{"request", "queue", "connect", "response"} {
+		if entry.timers[i] >= 0 {
+			attrs = append(attrs, attribute.Int64("haproxy.timer."+key+"_ms", entry.timers[i]))
+		}
+	}
+
+	_, span := e.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(entry.start),
+		trace.WithAttributes(attrs...),
+	)
+	if entry.status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(entry.status))
+	}
+	end := entry.start
+	if entry.timers[timerActive] > 0 {
+		end = end.Add(time.Duration(entry.timers[timerActive]) * time.Millisecond)
+	}
+	span.End(trace.WithTimestamp(end))
+	return true
+}
+
+// spanIDsKey is the context key of the span context haproxy generated for
+// the router span.
+type spanIDsKey struct{}
+
+// accessLogIDGenerator reuses the trace and span ids generated by haproxy,
+// falling back to random ids. It implements sdktrace.IDGenerator.
+type accessLogIDGenerator struct{}
+
+func (accessLogIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if sc, ok := ctx.Value(spanIDsKey{}).(trace.SpanContext); ok {
+		return sc.TraceID(), sc.SpanID()
+	}
+	var traceID trace.TraceID
+	for i := range traceID {
+		traceID[i] = byte(rand.Uint32())
+	}
+	return traceID, randomSpanID()
+}
+
+func (accessLogIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	if sc, ok := ctx.Value(spanIDsKey{}).(trace.SpanContext); ok {
+		return sc.SpanID()
+	}
+	return randomSpanID()
+}
+
+func randomSpanID() trace.SpanID {
+	var spanID trace.SpanID
+	for i := range spanID {
+		spanID[i] = byte(rand.Uint32())
+	}
+	return spanID
+}
+
+// NewListeningExporter returns an Exporter receiving the haproxy access logs
+// on the configured address.
+func NewListeningExporter(opts Options) (*Exporter, error) {
+	exporter, err := NewExporter(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := exporter.Listen(); err != nil {
+		exporter.Close()
+		return nil, err
+	}
+	return exporter, nil
+}
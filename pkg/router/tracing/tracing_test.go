@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	parentTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	routerTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01"
+)
+
+// recordingExporter records the exported spans.
+type recordingExporter struct {
+	lock  sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (e *recordingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (e *recordingExporter) Spans() []sdktrace.ReadOnlySpan {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]sdktrace.ReadOnlySpan(nil), e.spans...)
+}
+
+func newTestExporter(opts Options) (*Exporter, *recordingExporter) {
+	recorder := &recordingExporter{}
+	return newExporter(opts, sdktrace.WithSyncer(recorder)), recorder
+}
+
+func accessLogLine(status, captures string) string {
+	return `<158>Oct 18 10:01:02 haproxy[42]: 10.0.0.1:51234 [18/Oct/2026:10:01:02.120] fe_sni~ be_edge_http:ns:web/pod:web:web:ns:10.0.0.2:8080 1/2/3/40/50 ` +
+		status + ` 1234 - - ---- 1/1/0/0/0 0/0 {` + captures + `} "GET /api/items?id=3 HTTP/1.1"`
+}
+
+func TestParseAccessLogLine(t *testing.T) {
+	entry, ok := parseAccessLogLine(accessLogLine("200", parentTraceParent+"|"+routerTraceParent+"|example.com"))
+	require.True(t, ok)
+
+	assert.Equal(t, time.Date(2026, time.October, 18, 10, 1, 2, 120*int(time.Millisecond), time.Local), entry.start)
+	assert.Equal(t, "fe_sni~", entry.frontend)
+	assert.Equal(t, "be_edge_http:ns:web", entry.backend)
+	assert.Equal(t, "pod:web:web:ns:10.0.0.2:8080", entry.server)
+	assert.Equal(t, [5]int64{1, 2, 3, 40, 50}, entry.timers)
+	assert.Equal(t, 200, entry.status)
+	assert.Equal(t, "GET", entry.method)
+	assert.Equal(t, "/api/items", entry.path)
+	assert.Equal(t, parentTraceParent, entry.parent)
+	assert.Equal(t, routerTraceParent, entry.context)
+
+	entry, ok = parseAccessLogLine(`{"accept_time_ms":1760781662120,"frontend":"public","backend":"be_http:ns:web","server":"pod","method":"POST","path":"/api","status":201,"request_ms":0,"queue_ms":0,"connect_ms":1,"response_ms":9,"active_ms":10,"trace_parent":"","trace_context":"` + routerTraceParent + `"}`)
+	require.True(t, ok)
+	assert.Equal(t, time.UnixMilli(1760781662120), entry.start)
+	assert.Equal(t, [5]int64{0, 0, 1, 9, 10}, entry.timers)
+	assert.Equal(t, "POST", entry.method)
+	assert.Empty(t, entry.parent)
+	assert.Equal(t, routerTraceParent, entry.context)
+
+	_, ok = parseAccessLogLine("10.0.0.1:51234 [18/Oct/2026:10:01:02.120] public_ssl be_tcp:ns:db/pod 1/2/3 1234 --")
+	assert.False(t, ok, "tcp lines have no http timers")
+	_, ok = parseAccessLogLine("Proxy public started.")
+	assert.False(t, ok)
+}
+
+func TestParseTraceParent(t *testing.T) {
+	sc, ok := parseTraceParent(routerTraceParent)
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", sc.SpanID().String())
+	assert.True(t, sc.IsSampled())
+	assert.True(t, sc.IsRemote())
+
+	for _, value := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-1",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331",
+	} {
+		_, ok := parseTraceParent(value)
+		assert.False(t, ok, "%q must be rejected", value)
+	}
+}
+
+// TestExport tests that a span is exported for each sampled request with
+// the ids and timings haproxy logged.
+func TestExport(t *testing.T) {
+	exporter, recorder := newTestExporter(Options{})
+	defer exporter.Close()
+
+	// A request continuing the trace of the client.
+	require.True(t, exporter.Export(accessLogLine("503", parentTraceParent+"|"+routerTraceParent)))
+	// A request starting a trace at the router.
+	require.True(t, exporter.Export(accessLogLine("200", "|00-0af7651916cd43dd8448eb211c80319c-53995c3f42cd8ad8-01")))
+	// Requests not sampled or not traced.
+	require.False(t, exporter.Export(accessLogLine("200", "|00-0af7651916cd43dd8448eb211c80319c-53995c3f42cd8ad8-00")))
+	require.False(t, exporter.Export(accessLogLine("200", "")))
+
+	spans := recorder.Spans()
+	require.Len(t, spans, 2)
+
+	child := spans[0]
+	assert.Equal(t, "GET ns/web", child.Name())
+	assert.Equal(t, trace.SpanKindServer, child.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", child.SpanContext().TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", child.SpanContext().SpanID().String())
+	assert.Equal(t, "00f067aa0ba902b7", child.Parent().SpanID().String())
+	assert.True(t, child.Parent().IsRemote())
+	assert.Equal(t, 50*time.Millisecond, child.EndTime().Sub(child.StartTime()))
+	assert.Equal(t, codes.Error, child.Status().Code)
+	assert.Contains(t, child.Attributes(), attribute.Int("http.response.status_code", 503))
+	assert.Contains(t, child.Attributes(), attribute.String("openshift.route.name", "web"))
+	assert.Contains(t, child.Attributes(), attribute.Int64("haproxy.timer.response_ms", 40))
+	assert.Contains(t, child.Resource().Attributes(), attribute.String("service.name", "openshift-router"))
+
+	root := spans[1]
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", root.SpanContext().TraceID().String())
+	assert.Equal(t, "53995c3f42cd8ad8", root.SpanContext().SpanID().String())
+	assert.False(t, root.Parent().IsValid())
+	assert.Equal(t, codes.Unset, root.Status().Code)
+}
+
+// TestListen tests that the access logs are received on a unix datagram
+// socket.
+func TestListen(t *testing.T) {
+	address := t.TempDir() + "/tracing.sock"
+	exporter, recorder := newTestExporter(Options{AccessLogAddress: address})
+	require.NoError(t, exporter.Listen())
+	defer exporter.Close()
+
+	conn, err := net.Dial("unixgram", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(accessLogLine("200", parentTraceParent+"|"+routerTraceParent)))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(recorder.Spans()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}